@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/charlie0129/batt/pkg/adaptive"
+	"github.com/charlie0129/batt/pkg/compatibility"
+)
+
+func NewAdaptiveCommand() *cobra.Command {
+	cmd := newEnableDisableCommand(
+		"adaptive",
+		"Set whether to charge to 100% just before you usually unplug",
+		`Set whether to charge to 100% just before you usually unplug.
+
+batt learns when you usually unplug on each day of the week from long charging sessions, such as overnight. With adaptive charging enabled, the battery is held at the charge limit while plugged in, and is allowed to charge to 100% shortly before the predicted unplug time, similar to Optimized Battery Charging in macOS.
+
+Predictions need at least 3 unplugs on the same weekday. Unplug times are learned even while adaptive charging is disabled.`,
+		func() (string, error) { return apiClient.SetAdaptiveCharging(true) },
+		func() (string, error) { return apiClient.SetAdaptiveCharging(false) },
+	)
+	cmd.AddCommand(
+		newAdaptiveStatusCommand(),
+		newAdaptiveResetCommand(),
+	)
+	return annotateCapability(cmd, compatibility.FeatureChargingControl)
+}
+
+func newAdaptiveStatusCommand() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show what adaptive charging has learned",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			st, err := apiClient.GetAdaptiveStatus()
+			if err != nil {
+				return fmt.Errorf("failed to get adaptive charging status: %w", err)
+			}
+
+			if jsonOutput {
+				b, err := json.MarshalIndent(st, "", "  ")
+				if err != nil {
+					return err
+				}
+				cmd.Println(string(b))
+				return nil
+			}
+
+			printAdaptiveStatus(cmd, st)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output status in JSON format")
+
+	return cmd
+}
+
+func newAdaptiveResetCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reset",
+		Short: "Forget all learned unplug times",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ret, err := apiClient.ResetAdaptiveModel()
+			if err != nil {
+				return fmt.Errorf("failed to reset adaptive charging: %w", err)
+			}
+			if ret != "" {
+				logrus.Infof("daemon responded: %s", ret)
+			}
+			logrus.Info("successfully reset adaptive charging")
+			return nil
+		},
+	}
+}
+
+func printAdaptiveStatus(cmd *cobra.Command, st *adaptive.Status) {
+	cmd.Println("Adaptive charging: " + bool2Text(st.Enabled))
+	if st.Enabled {
+		cmd.Println("  Topping up to 100%: " + bool2Text(st.ToppingUp))
+	}
+	if st.PluggedInSince != nil {
+		cmd.Printf("  Plugged in since: %s\n", bold("%s", st.PluggedInSince.Local().Format(time.DateTime)))
+	}
+	if st.NextUnplug != nil {
+		cmd.Printf("  Predicted unplug: %s\n", bold("%s", st.NextUnplug.Local().Format("Mon 15:04")))
+		cmd.Printf("  Top-up starts: %s\n", bold("%s", st.TopUpAt.Local().Format("Mon 15:04")))
+	} else {
+		cmd.Println("  Predicted unplug: " + bold("not enough data yet"))
+	}
+
+	cmd.Println()
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DAY\tUNPLUGS\tTYPICAL UNPLUG")
+	for _, d := range st.Weekdays {
+		typical := d.Typical
+		if typical == "" {
+			typical = "-"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", d.Day, d.Samples, typical)
+	}
+	_ = w.Flush()
+}
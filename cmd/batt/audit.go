@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charlie0129/batt/pkg/audit"
+)
+
+func NewAuditCommand() *cobra.Command {
+	var (
+		since      string
+		limit      int
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:     "audit",
+		Short:   "Show changes made to batt settings",
+		GroupID: gAdvanced,
+		Long: `Show the audit log of configuration and control changes.
+
+Every change to the config, the power adapter and calibration is recorded with who made it: the uid of the API caller, or the scheduler, calibration, a timer running out, MQTT or the daemon itself.`,
+		Example: `  batt audit                   (the last 100 changes)
+  batt audit --since 1w
+  batt audit --limit 0 --json  (everything still on disk)`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var from time.Time
+			if since != "" {
+				d, err := parseDuration(since)
+				if err != nil {
+					return err
+				}
+				if d <= 0 {
+					return fmt.Errorf("--since must be positive")
+				}
+				from = time.Now().Add(-d)
+			}
+			if limit < 0 {
+				return fmt.Errorf("--limit must not be negative")
+			}
+
+			entries, err := apiClient.GetAudit(from, limit)
+			if err != nil {
+				return fmt.Errorf("failed to get audit log: %w", err)
+			}
+
+			switch {
+			case jsonOutput:
+				b, err := json.MarshalIndent(entries, "", "  ")
+				if err != nil {
+					return err
+				}
+				cmd.Println(string(b))
+			case len(entries) == 0:
+				cmd.Println("No changes recorded.")
+			default:
+				printAudit(cmd, entries)
+			}
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&since, "since", "", "Only show changes in this period, e.g. 2h, 1d or 1w")
+	f.IntVar(&limit, "limit", 100, "Show at most this many of the newest changes, 0 for all")
+	f.BoolVar(&jsonOutput, "json", false, "Output the audit log in JSON format")
+
+	return cmd
+}
+
+func printAudit(cmd *cobra.Command, entries []audit.Entry) {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTOR\tFIELD\tOLD\tNEW")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Local().Format(time.DateTime), e.Actor, e.Field, auditValue(e.Old), auditValue(e.New))
+	}
+	_ = w.Flush()
+}
+
+// auditValue prints a recorded value, with strings unquoted and null as "-".
+func auditValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return "-"
+	}
+	return string(raw)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charlie0129/batt/pkg/config"
+)
+
+func NewConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "config",
+		Short:   "Manage the config file",
+		GroupID: gAdvanced,
+		Long: `Manage the config file.
+
+These commands work on the config file directly (see --config), so they also work while the daemon is not running.`,
+	}
+
+	cmd.AddCommand(
+		newConfigMigrateCommand(),
+		newConfigConvertCommand(),
+		newConfigValidateCommand(),
+	)
+
+	return cmd
+}
+
+func newConfigMigrateCommand() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the config file to the current format",
+		Long: fmt.Sprintf(`Upgrade the config file to the current format, version %d.
+
+The daemon does this by itself when it loads an older config file. The old file is kept next to it, e.g. as batt.json.v0.bak for a file from version 0.`, config.CurrentVersion),
+		Example: `  batt config migrate --dry-run  (show what would change)
+  sudo batt config migrate`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			old, err := os.ReadFile(configPath)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+			format := config.FormatOf(configPath)
+			c, from, err := config.Unmarshal(format, old)
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s: %w", configPath, err)
+			}
+			if from >= config.CurrentVersion {
+				cmd.Printf("%s is already at version %d.\n", configPath, from)
+				return nil
+			}
+
+			b, err := config.Marshal(format, c, old)
+			if err != nil {
+				return err
+			}
+			cmd.Printf("Migrating %s from version %d to %d:\n", configPath, from, config.CurrentVersion)
+			for _, m := range config.PendingMigrations(from) {
+				cmd.Printf("  %d -> %d: %s\n", m.From, m.From+1, m.Description)
+			}
+			cmd.Println()
+			cmd.Print(unifiedDiff(configPath, configPath, string(old), string(b)))
+
+			if dryRun {
+				return nil
+			}
+			if err := config.WriteMigrated(configPath, old, c, from); err != nil {
+				if errors.Is(err, fs.ErrPermission) {
+					return fmt.Errorf("failed to write %s, are you root? %w", configPath, err)
+				}
+				return err
+			}
+			cmd.Printf("\nDone. The old file is kept in %s.\n", config.MigrationBackupPath(configPath, from))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show the changes, do not write the file")
+
+	return cmd
+}
+
+func newConfigConvertCommand() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "convert INPUT [OUTPUT]",
+		Short: "Convert a config file to another format",
+		Long: `Convert a config file between JSON, YAML and TOML.
+
+The formats are told by the file extensions: .json, .yaml or .yml, and .toml. Without OUTPUT, or with "-", the result is printed in the format given by --format. The result is in the current format version, and if OUTPUT exists in the same format, its comments are kept where possible.
+
+To let the daemon use the new file, run it with --config, e.g. "batt daemon --config /etc/batt.yaml".`,
+		Example: `  batt config convert /etc/batt.json /etc/batt.yaml
+  batt config convert /etc/batt.json --format toml`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input, output := args[0], "-"
+			if len(args) == 2 {
+				output = args[1]
+			}
+
+			var to config.Format
+			if format != "" {
+				var err error
+				if to, err = config.ParseFormat(format); err != nil {
+					return err
+				}
+			} else if output != "-" {
+				to = config.FormatOf(output)
+			} else {
+				return fmt.Errorf("--format is required when printing the result")
+			}
+
+			b, err := os.ReadFile(input)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+			c, _, err := config.Unmarshal(config.FormatOf(input), b)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", input, err)
+			}
+
+			var prev []byte
+			if output != "-" {
+				prev, _ = os.ReadFile(output)
+			}
+			out, err := config.Marshal(to, c, prev)
+			if err != nil {
+				return err
+			}
+
+			if output == "-" {
+				cmd.Print(string(out))
+				return nil
+			}
+			if err := config.WriteFile(output, out, c); err != nil {
+				return fmt.Errorf("failed to write %s: %w", output, err)
+			}
+			cmd.Printf("Converted %s to %s (%s).\n", input, output, to)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "", "Output format: json, yaml or toml. By default, told by the extension of OUTPUT")
+
+	return cmd
+}
+
+func newConfigValidateCommand() *cobra.Command {
+	var strict bool
+
+	cmd := &cobra.Command{
+		Use:   "validate [FILE]",
+		Short: "Check a config file for invalid settings",
+		Long: `Check a config file for invalid settings, e.g. in CI for configs you manage.
+
+FILE defaults to the config file (see --config). Every problem is printed as "FILE: PATH: REASON" and the command fails if there are any. With --strict, which is the default, keys that are not settings, like misspelled ones, are problems too.
+
+The daemon checks the config file the same way when it reloads it on SIGHUP, and keeps the current config if the file is not valid.`,
+		Example: `  batt config validate
+  batt config validate ./batt.yaml --strict=false`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := configPath
+			if len(args) == 1 {
+				path = args[0]
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+			format := config.FormatOf(path)
+			c, _, err := config.Unmarshal(format, b)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+
+			var errs []config.FieldError
+			if strict {
+				if errs, err = config.UnknownFields(format, b); err != nil {
+					return fmt.Errorf("failed to parse %s: %w", path, err)
+				}
+			}
+			errs = append(errs, c.Validate()...)
+
+			if len(errs) == 0 {
+				cmd.Printf("%s is valid.\n", path)
+				return nil
+			}
+			for _, e := range errs {
+				cmd.PrintErrf("%s: %s\n", path, e)
+			}
+			if len(errs) == 1 {
+				return fmt.Errorf("%s has 1 problem", path)
+			}
+			return fmt.Errorf("%s has %d problems", path, len(errs))
+		},
+	}
+
+	cmd.Flags().BoolVar(&strict, "strict", true, "Also report keys that are not settings")
+
+	return cmd
+}
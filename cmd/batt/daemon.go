@@ -1,16 +1,26 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/charlie0129/batt/pkg/config"
 	"github.com/charlie0129/batt/pkg/daemon"
+	"github.com/charlie0129/batt/pkg/sysfs"
 	"github.com/charlie0129/batt/pkg/version"
 )
 
 var (
 	// alwaysAllowNonRootAccess indicates whether to always allow non-root users to access the batt daemon.
 	alwaysAllowNonRootAccess = false
+	// sysfsRoot is where the daemon looks for power supplies on Linux.
+	sysfsRoot = sysfs.DefaultRoot
+	// smcTracePath is where the daemon records SMC calls, if set.
+	smcTracePath = ""
+	// overrides holds the flags that override settings, by JSON name.
+	overrides map[string]*string
 )
 
 // NewDaemonCommand .
@@ -20,19 +30,43 @@ func NewDaemonCommand() *cobra.Command {
 		Hidden:  true,
 		Short:   "Run batt daemon in the foreground",
 		GroupID: gAdvanced,
-		RunE: func(_ *cobra.Command, _ []string) error {
+		Long: fmt.Sprintf(`Run batt daemon in the foreground.
+
+Every setting of the config file can be overridden by an environment variable and by a flag, e.g. "limit" by %s=70 and by --%s=70. Flags win over environment variables, which win over the config file. Overrides are not saved to the config file. Booleans are true or false, strings and times are taken as they are, and lists and objects are JSON.`, config.EnvName("limit"), config.FlagName("limit")),
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			values := map[string]string{}
+			for field, v := range overrides {
+				if cmd.Flags().Changed(config.FlagName(field)) {
+					values[field] = *v
+				}
+			}
+			flags, err := config.ParseSettings(values, func(field string) string { return "--" + config.FlagName(field) })
+			if err != nil {
+				return err
+			}
+
 			logrus.WithFields(logrus.Fields{
 				"version": version.Version,
 				"commit":  version.GitCommit,
 			}).Info("batt daemon starting")
-			return daemon.Run(configPath, unixSocketPath, alwaysAllowNonRootAccess)
+			return daemon.Run(configPath, unixSocketPath, alwaysAllowNonRootAccess, sysfsRoot, smcTracePath, flags)
 		},
 	}
 
 	f := cmd.Flags()
 
+	overrides = map[string]*string{}
+	for _, field := range config.OverridableSettings() {
+		overrides[field] = f.String(config.FlagName(field), "",
+			fmt.Sprintf("Override %q of the config file, like %s.", field, config.EnvName(field)))
+	}
+
 	f.BoolVar(&alwaysAllowNonRootAccess, "always-allow-non-root-access", false,
 		"Always allow non-root users to access the daemon.")
+	f.StringVar(&sysfsRoot, "sysfs-root", sysfsRoot,
+		"Directory containing the power_supply class (Linux only).")
+	f.StringVar(&smcTracePath, "smc-trace", "",
+		"Record every SMC read and write to this file, e.g. to attach to a bug report (macOS only).")
 
 	return cmd
 }
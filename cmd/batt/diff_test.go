@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "{\n  \"limit\": 80,\n  \"lowerLimit\": 75,\n  \"preventIdleSleep\": true\n}\n"
+	b := "{\n  \"version\": 2,\n  \"limit\": 80,\n  \"preventIdleSleep\": true,\n  \"lowerLimitDelta\": 5\n}\n"
+	want := `--- a
++++ b
+@@ -1,5 +1,6 @@
+ {
++  "version": 2,
+   "limit": 80,
+-  "lowerLimit": 75,
+-  "preventIdleSleep": true
++  "preventIdleSleep": true,
++  "lowerLimitDelta": 5
+ }
+`
+	if got := unifiedDiff("a", "b", a, b); got != want {
+		t.Fatalf("unifiedDiff() =\n%s\nwant:\n%s", got, want)
+	}
+	if got := unifiedDiff("a", "b", a, a); got != "" {
+		t.Fatalf("unifiedDiff() of equal files = %q", got)
+	}
+
+	// Changes far apart get separate hunks.
+	long := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	changed := "1\nx\n3\n4\n5\n6\n7\n8\n9\ny\n"
+	want = `--- a
++++ b
+@@ -1,5 +1,5 @@
+ 1
+-2
++x
+ 3
+ 4
+ 5
+@@ -7,4 +7,4 @@
+ 7
+ 8
+ 9
+-10
++y
+`
+	if got := unifiedDiff("a", "b", long, changed); got != want {
+		t.Fatalf("unifiedDiff() =\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charlie0129/batt/pkg/api"
+)
+
+func NewExplainCommand() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:     "explain",
+		Short:   "Explain why the battery is charging or not",
+		GroupID: gBasic,
+		Long: `Explain why the battery is charging or not right now.
+
+batt checks the battery every 10 seconds and decides whether it may charge. This shows the rules that applied the last time, in order, with the numbers they used: the charge limits and where they come from, calibration, temporary disables, the power adapter timer, the temperature guard and missed checks while the Mac was asleep.
+
+For example, with an upper limit of 85% and a lower limit of 80%, a battery at 83% does not charge until it drops below 80%.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			e, err := apiClient.GetExplanation()
+			if err != nil {
+				return fmt.Errorf("failed to get explanation: %w", err)
+			}
+
+			if jsonOutput {
+				b, err := json.MarshalIndent(e, "", "  ")
+				if err != nil {
+					return err
+				}
+				cmd.Println(string(b))
+				return nil
+			}
+
+			printExplanation(cmd, e)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the explanation in JSON format")
+
+	return cmd
+}
+
+func printExplanation(cmd *cobra.Command, e *api.Explanation) {
+	cmd.Printf("Battery: %s, plugged in %s, charging %s\n", bold("%d%%", e.Charge), bool2Text(e.PluggedIn), bool2Text(e.Charging))
+	cmd.Printf("Limits: %s to %s (%s mode)\n", bold("%d%%", e.Lower), bold("%d%%", e.Upper), e.Mode)
+	cmd.Printf("Checked: %s (%s ago)\n", e.Time.Local().Format(time.DateTime), time.Since(e.Time).Round(time.Second))
+
+	cmd.Println()
+	cmd.Println("Reasoning:")
+	for i, s := range e.Steps {
+		msg := explanationSentence(s.Message)
+		if s.Rule == e.Rule {
+			msg = bold("%s", msg)
+		}
+		cmd.Printf("  %d. %s\n", i+1, msg)
+	}
+}
+
+// explanationSentence capitalizes a step of an explanation and ends it with
+// a period.
+func explanationSentence(msg string) string {
+	if msg == "" {
+		return msg
+	}
+	return strings.ToUpper(msg[:1]) + msg[1:] + "."
+}
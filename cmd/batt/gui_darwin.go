@@ -0,0 +1,17 @@
+//go:build darwin
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/charlie0129/batt/pkg/gui"
+)
+
+func newGUICommand() *cobra.Command {
+	return gui.NewGUICommand("")
+}
+
+func runGUI(unixSocketPath string) {
+	gui.Run(unixSocketPath)
+}
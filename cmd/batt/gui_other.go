@@ -0,0 +1,27 @@
+//go:build !darwin
+
+package main
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var errGUIUnsupported = errors.New("the batt GUI is only available on macOS")
+
+func newGUICommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    "gui",
+		Short:  "Start the batt GUI (debug)",
+		Hidden: true,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return errGUIUnsupported
+		},
+	}
+}
+
+func runGUI(string) {
+	logrus.Fatal(errGUIUnsupported)
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"testing"
 	"time"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/config"
 )
 
 func TestParseDuration(t *testing.T) {
@@ -44,3 +47,52 @@ func TestParseDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestSparkline(t *testing.T) {
+	if got := sparklineFixed([]float64{0, 50, 100, 120, -5}, 0, 100); got != "▁▅██▁" {
+		t.Fatalf("sparklineFixed() = %q", got)
+	}
+	if got := sparkline([]float64{3, 3, 3}); got != "▁▁▁" {
+		t.Fatalf("sparkline() of a flat series = %q, want lowest blocks", got)
+	}
+}
+
+func TestAutoHistoryStep(t *testing.T) {
+	tests := map[time.Duration]time.Duration{
+		24 * time.Hour:     30 * time.Minute,
+		7 * 24 * time.Hour: 3*time.Hour + 30*time.Minute,
+		10 * time.Minute:   time.Minute,
+	}
+	for in, want := range tests {
+		if got := autoHistoryStep(in); got != want {
+			t.Fatalf("autoHistoryStep(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestApplyLimitProfile(t *testing.T) {
+	limit, delta := 80, 5
+	raw := &config.RawFileConfig{
+		Limit:           &limit,
+		LowerLimitDelta: &delta,
+		LimitProfiles: []config.LimitProfile{
+			{Name: "desk", Limit: 60, Schedule: "0 9 * * MON-FRI", Duration: "9h"},
+		},
+	}
+	if p := activeLimitProfile(&api.Config{RawFileConfig: *raw}); p != nil {
+		t.Fatalf("activeLimitProfile() = %+v, want nil", p)
+	}
+
+	active := "desk"
+	p := activeLimitProfile(&api.Config{RawFileConfig: *raw, ActiveLimitProfile: &active})
+	if p == nil || p.Name != "desk" {
+		t.Fatalf("activeLimitProfile() = %+v, want desk", p)
+	}
+	cfg := config.NewFileFromConfig(applyLimitProfile(raw, p), "")
+	if cfg.UpperLimit() != 60 || cfg.LowerLimit() != 55 {
+		t.Fatalf("limits = %d/%d, want 60/55", cfg.UpperLimit(), cfg.LowerLimit())
+	}
+	if *raw.Limit != 80 {
+		t.Fatalf("applyLimitProfile() modified the original config")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charlie0129/batt/pkg/history"
+)
+
+// historyPoints is how many rows or sparkline cells an automatic step aims for.
+const historyPoints = 48
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+func NewHistoryCommand() *cobra.Command {
+	var (
+		since      string
+		stepFlag   string
+		sparkline  bool
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:     "history",
+		Short:   "Show battery history",
+		GroupID: gBasic,
+		Long: `Show battery charge, power and charging state recorded by the daemon.
+
+The daemon samples the battery every few seconds and keeps a day of raw samples, plus older data averaged into 10-minute buckets.`,
+		Example: `  batt history                 (last 24 hours as a table)
+  batt history --since 1w --sparkline
+  batt history --since 2h --step 5m`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			d, err := parseDuration(since)
+			if err != nil {
+				return err
+			}
+			if d <= 0 {
+				return fmt.Errorf("--since must be positive")
+			}
+			step := autoHistoryStep(d)
+			if stepFlag != "" {
+				step, err = parseDuration(stepFlag)
+				if err != nil {
+					return err
+				}
+			}
+
+			to := time.Now()
+			samples, err := apiClient.GetHistory(to.Add(-d), to, step)
+			if err != nil {
+				return fmt.Errorf("failed to get battery history: %w", err)
+			}
+
+			switch {
+			case jsonOutput:
+				b, err := json.MarshalIndent(samples, "", "  ")
+				if err != nil {
+					return err
+				}
+				cmd.Println(string(b))
+			case len(samples) == 0:
+				cmd.Println("No battery history recorded in this period.")
+			case sparkline:
+				printHistorySparklines(cmd, samples)
+			default:
+				printHistoryTable(cmd, samples)
+			}
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&since, "since", "24h", "How far back to show, e.g. 2h, 1d or 1w")
+	f.StringVar(&stepFlag, "step", "", "Aggregate samples into buckets of this size (default: about 48 buckets)")
+	f.BoolVar(&sparkline, "sparkline", false, "Print sparklines instead of a table")
+	f.BoolVar(&jsonOutput, "json", false, "Output history in JSON format")
+
+	return cmd
+}
+
+// autoHistoryStep picks a step that yields about historyPoints buckets,
+// rounded to whole minutes.
+func autoHistoryStep(d time.Duration) time.Duration {
+	step := (d / historyPoints).Round(time.Minute)
+	if step < time.Minute {
+		step = time.Minute
+	}
+	return step
+}
+
+func printHistoryTable(cmd *cobra.Command, samples []history.Sample) {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCHARGE\tPLUGGED IN\tCHARGING\tADAPTER\tSYSTEM\tBATTERY")
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s\t%d%%\t%s\t%s\t%s\t%.1f W\t%.1f W\n",
+			s.Time().Format("Jan 02 15:04"),
+			s.Charge,
+			bool2Text(s.PluggedIn),
+			bool2Text(s.Charging),
+			bool2Text(s.AdapterEnabled),
+			s.SystemPower,
+			s.BatteryPower,
+		)
+	}
+	_ = w.Flush()
+}
+
+func printHistorySparklines(cmd *cobra.Command, samples []history.Sample) {
+	charge := make([]float64, len(samples))
+	system := make([]float64, len(samples))
+	battery := make([]float64, len(samples))
+	plugged := make([]float64, len(samples))
+	for i, s := range samples {
+		charge[i] = float64(s.Charge)
+		system[i] = s.SystemPower
+		battery[i] = s.BatteryPower
+		if s.PluggedIn {
+			plugged[i] = 1
+		}
+	}
+
+	first, last := samples[0].Time(), samples[len(samples)-1].Time()
+	cmd.Printf("%s → %s\n\n", first.Format("Jan 02 15:04"), last.Format("Jan 02 15:04"))
+	cmd.Printf("Charge      %s  %s\n", sparklineFixed(charge, 0, 100), bold("%d%% → %d%%", samples[0].Charge, samples[len(samples)-1].Charge))
+	cmd.Printf("Plugged in  %s\n", sparklineFixed(plugged, 0, 1))
+	lo, hi := minMax(system)
+	cmd.Printf("System      %s  %s\n", sparkline(system), bold("%.1f–%.1f W", lo, hi))
+	lo, hi = minMax(battery)
+	cmd.Printf("Battery     %s  %s\n", sparkline(battery), bold("%.1f–%.1f W", lo, hi))
+}
+
+// sparkline scales values between their own minimum and maximum.
+func sparkline(values []float64) string {
+	lo, hi := minMax(values)
+	return sparklineFixed(values, lo, hi)
+}
+
+// sparklineFixed scales values between lo and hi.
+func sparklineFixed(values []float64, lo, hi float64) string {
+	var b strings.Builder
+	for _, v := range values {
+		idx := 0
+		if hi > lo {
+			idx = int(math.Round((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1)))
+		}
+		idx = max(0, min(idx, len(sparkBlocks)-1))
+		b.WriteRune(sparkBlocks[idx])
+	}
+	return b.String()
+}
+
+func minMax(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values[1:] {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	return lo, hi
+}
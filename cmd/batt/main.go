@@ -16,7 +16,6 @@ import (
 
 	"github.com/charlie0129/batt/pkg/client"
 	"github.com/charlie0129/batt/pkg/compatibility"
-	"github.com/charlie0129/batt/pkg/gui"
 	"github.com/charlie0129/batt/pkg/utils/osver"
 )
 
@@ -104,6 +103,7 @@ func handleCmdError(err error) {
 		fmt.Fprintln(os.Stderr, "\nError: Permission Denied")
 		fmt.Fprintln(os.Stderr, "  - Try running the command again with 'sudo'")
 		fmt.Fprintln(os.Stderr, "  - Or reinstall the daemon with the '--allow-non-root-access' flag to grant permissions to your user")
+		fmt.Fprintln(os.Stderr, "  - Or ask an administrator to add your user to \"access\" in the daemon config")
 	}
 }
 
@@ -115,7 +115,7 @@ func main() {
 	}
 	runtime.LockOSThread()
 
-	if !osver.IsAtLeast(11, 0, 0) {
+	if runtime.GOOS == "darwin" && !osver.IsAtLeast(11, 0, 0) {
 		fmt.Fprintln(os.Stderr, "batt requires macOS 11.0 or later")
 		os.Exit(1)
 	}
@@ -190,7 +190,7 @@ Report issues: https://github.com/charlie0129/batt/issues`,
 
 	if os.Getenv("BATT_RUN_GUI") != "" || path.Base(os.Args[0]) == "batt-gui" {
 		cmd.Run = func(_ *cobra.Command, _ []string) {
-			gui.Run(unixSocketPath)
+			runGUI(unixSocketPath)
 		}
 	}
 
@@ -202,7 +202,7 @@ Report issues: https://github.com/charlie0129/batt/issues`,
 
 	globalFlags := cmd.PersistentFlags()
 	globalFlags.StringVarP(&logLevel, "log-level", "l", logLevel, "log level (trace, debug, info, warn, error, fatal, panic)")
-	globalFlags.StringVar(&configPath, "config", configPath, "config file path")
+	globalFlags.StringVar(&configPath, "config", configPath, "config file path, in JSON, YAML (.yaml, .yml) or TOML (.toml)")
 	globalFlags.StringVar(&unixSocketPath, "daemon-socket", unixSocketPath, "batt daemon unix socket path")
 	globalFlags.StringVar(&pprofAddr, "pprof", pprofAddr, "enable pprof HTTP server on the specified address (e.g., localhost:6060)")
 
@@ -221,7 +221,13 @@ Report issues: https://github.com/charlie0129/batt/issues`,
 		NewSetDisableChargingPreSleepCommand(),
 		NewSetPreventIdleSleepCommand(),
 		NewSetPreventSystemSleepCommand(),
+		NewAdaptiveCommand(),
 		NewStatusCommand(),
+		NewExplainCommand(),
+		NewHistoryCommand(),
+		NewAuditCommand(),
+		NewWebhooksCommand(),
+		NewConfigCommand(),
 		NewCalibrationCommand(),
 		NewAdapterCommand(),
 		NewLowerLimitDeltaCommand(),
@@ -229,7 +235,8 @@ Report issues: https://github.com/charlie0129/batt/issues`,
 		NewInstallCommand(),
 		NewUninstallCommand(),
 		NewScheduleCommand(),
-		gui.NewGUICommand(""),
+		NewSimulateCommand(),
+		newGUICommand(),
 	)
 
 	return cmd
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/daemon"
+	"github.com/charlie0129/batt/pkg/simulator"
+)
+
+func NewSimulateCommand() *cobra.Command {
+	var (
+		scenarioPath string
+		jsonOutput   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:     "simulate",
+		Short:   "Simulate charge control on a virtual battery",
+		GroupID: gAdvanced,
+		Long: `Run the charge limits and calibration of a config against a simulated battery, and print how the charge changes.
+
+The scenario, a YAML file, describes the battery, when it is plugged in and when calibrations start. The maintain loop and calibration of the daemon run on a virtual clock, so a simulated day takes a moment. The timeline shows every change of charging, power and calibration phase, and the state every hour in between.
+
+Nothing on this Mac is changed and the daemon does not need to be running. The config file is only read.`,
+		Example: `  batt simulate --config ./batt.json --scenario ./workday.yaml
+  batt simulate --scenario ./workday.yaml --json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if scenarioPath == "" {
+				return fmt.Errorf("--scenario is required")
+			}
+			sc, err := simulator.LoadScenario(scenarioPath)
+			if err != nil {
+				return err
+			}
+			c, err := readSimulatedConfig(configPath)
+			if err != nil {
+				return err
+			}
+
+			// The maintain loop logs what it does, which the timeline shows
+			// already, with wall-clock times.
+			if !cmd.Flag("log-level").Changed {
+				logrus.SetLevel(logrus.WarnLevel)
+			}
+			timeline, err := daemon.Simulate(c, sc)
+			if err != nil {
+				return fmt.Errorf("failed to simulate: %w", err)
+			}
+
+			if jsonOutput {
+				b, err := json.MarshalIndent(timeline, "", "  ")
+				if err != nil {
+					return err
+				}
+				cmd.Println(string(b))
+				return nil
+			}
+			printSimulation(cmd, timeline)
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&scenarioPath, "scenario", "", "Scenario file to simulate")
+	f.BoolVar(&jsonOutput, "json", false, "Output the timeline in JSON format")
+
+	return cmd
+}
+
+// readSimulatedConfig reads the config file without migrating it, like
+// loading it in the daemon would. A missing file is the default config.
+func readSimulatedConfig(path string) (config.Config, error) {
+	c := &config.RawFileConfig{}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		logrus.Warnf("%s does not exist, simulating the default config", path)
+	case err != nil:
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	default:
+		if c, _, err = config.Unmarshal(config.FormatOf(path), b); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if errs := c.Validate(); len(errs) > 0 {
+			return nil, fmt.Errorf("%s is not valid: %w", path, config.FieldErrors(errs))
+		}
+	}
+	return config.NewFileFromConfig(c, ""), nil
+}
+
+func printSimulation(cmd *cobra.Command, timeline []simulator.Sample) {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCHARGE\tPLUGGED IN\tCHARGING\tADAPTER\tCALIBRATION\tEVENTS")
+	for _, s := range timeline {
+		phase := string(s.Phase)
+		if s.Phase == calibration.PhaseIdle {
+			phase = "-"
+		}
+		fmt.Fprintf(w, "%s\t%.1f%%\t%s\t%s\t%s\t%s\t%s\n",
+			s.Time.Format("Jan 02 15:04:05"),
+			s.Charge,
+			bool2Text(s.PluggedIn),
+			bool2Text(s.Charging),
+			bool2Text(s.AdapterEnabled),
+			phase,
+			strings.Join(s.Events, ", "),
+		)
+	}
+	_ = w.Flush()
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 
+	"github.com/charlie0129/batt/pkg/api"
 	"github.com/charlie0129/batt/pkg/calibration"
 	"github.com/charlie0129/batt/pkg/compatibility"
 	"github.com/charlie0129/batt/pkg/config"
@@ -19,7 +20,7 @@ type statusData struct {
 	adapter       bool
 	currentCharge int
 	batteryInfo   *powerinfo.Battery
-	config        *config.RawFileConfig
+	config        *api.Config
 	capabilities  compatibility.Capabilities
 }
 
@@ -110,6 +111,33 @@ func fetchStatusData() (*statusData, error) {
 	}, nil
 }
 
+// activeLimitProfile returns the limit profile the daemon reports as active.
+func activeLimitProfile(raw *api.Config) *config.LimitProfile {
+	if raw.ActiveLimitProfile == nil {
+		return nil
+	}
+	for i := range raw.LimitProfiles {
+		if raw.LimitProfiles[i].Name == *raw.ActiveLimitProfile {
+			return &raw.LimitProfiles[i]
+		}
+	}
+	return nil
+}
+
+// applyLimitProfile returns a copy of raw whose limit is the profile's, so the
+// status shows the limits the daemon enforces. The lower limit delta is kept.
+func applyLimitProfile(raw *config.RawFileConfig, profile *config.LimitProfile) *config.RawFileConfig {
+	if profile == nil {
+		return raw
+	}
+	c := config.NewFileFromConfig(raw, "")
+	delta := min(c.UpperLimit()-c.LowerLimit(), profile.Limit)
+	applied := *raw
+	applied.Limit = &profile.Limit
+	applied.LowerLimitDelta = &delta
+	return &applied
+}
+
 //nolint:gocyclo
 func NewStatusCommand() *cobra.Command {
 	var jsonOutput bool
@@ -126,7 +154,8 @@ func NewStatusCommand() *cobra.Command {
 				return err
 			}
 
-			cfg := config.NewFileFromConfig(data.config, "")
+			profile := activeLimitProfile(data.config)
+			cfg := config.NewFileFromConfig(applyLimitProfile(&data.config.RawFileConfig, profile), "")
 
 			if jsonOutput {
 				return printStatusJSON(cmd, data, cfg)
@@ -181,6 +210,8 @@ func NewStatusCommand() *cobra.Command {
 
 			cmd.Println()
 
+			tr, trErr := apiClient.GetTelemetry(false, true)
+
 			// Battery Info.
 			cmd.Println(bold("Battery status:"))
 
@@ -228,11 +259,17 @@ func NewStatusCommand() *cobra.Command {
 			}
 			cmd.Printf("  Charge rate: %s\n", rateStr)
 			cmd.Printf("  Voltage: %s\n", bold("%.2f V", data.batteryInfo.DesignVoltage))
+			if trErr == nil && tr.Temperature != nil {
+				printTemperature(cmd, tr.Temperature)
+			}
 
 			cmd.Println()
 
 			// Config.
 			cmd.Println(bold("Battery configuration:"))
+			if profile != nil {
+				cmd.Printf("  Limit profile: %s\n", bold("%s", profile.Name))
+			}
 			if cfg.UpperLimit() < 100 {
 				cmd.Printf("  Upper limit: %s\n", bold("%d%%", cfg.UpperLimit()))
 				cmd.Printf("  Lower limit: %s\n", bold("%d%%", cfg.LowerLimit()))
@@ -273,8 +310,7 @@ func NewStatusCommand() *cobra.Command {
 
 			cmd.Println()
 
-			tr, err := apiClient.GetTelemetry(false, true)
-			if data.capabilities.Calibration && err == nil && tr.Calibration != nil {
+			if data.capabilities.Calibration && trErr == nil && tr.Calibration != nil {
 				cmd.Println(bold("Calibration status:"))
 				cmd.Printf("  Phase: %s\n", bold("%s", string(tr.Calibration.Phase)))
 				if tr.Calibration.Phase != calibration.PhaseIdle {
@@ -298,6 +334,24 @@ func NewStatusCommand() *cobra.Command {
 	return cmd
 }
 
+func printTemperature(cmd *cobra.Command, t *powerinfo.Temperature) {
+	if t.Battery > 0 {
+		cmd.Printf("  Temperature: %s\n", bold("%.1f °C", t.Battery))
+	}
+	g := t.Guard
+	if !g.Enabled {
+		return
+	}
+	switch {
+	case !g.Active:
+		cmd.Printf("  Temperature guard: %s (at %.1f °C)\n", bold("standby"), g.MaxTemperature)
+	case g.Limit > 0:
+		cmd.Printf("  Temperature guard: %s, limiting charge to %d%% until %.1f °C\n", color.New(color.Bold, color.FgRed).Sprint("active"), g.Limit, g.ResumeTemperature)
+	default:
+		cmd.Printf("  Temperature guard: %s, charging paused until %.1f °C\n", color.New(color.Bold, color.FgRed).Sprint("active"), g.ResumeTemperature)
+	}
+}
+
 func bool2Text(b bool) string {
 	if b {
 		return color.New(color.Bold, color.FgGreen).Sprint("✔")
@@ -35,11 +35,15 @@ type statusBatteryJSON struct {
 	FullCapacityMah      int     `json:"fullCapacityMah"`
 	ChargeRateWatts      float64 `json:"chargeRateWatts"`
 	VoltageVolts         float64 `json:"voltageVolts"`
+	// TemperatureCelsius is omitted when the sensor is unavailable.
+	TemperatureCelsius *float64                    `json:"temperatureCelsius,omitempty"`
+	TemperatureGuard   *powerinfo.TemperatureGuard `json:"temperatureGuard,omitempty"`
 }
 
 type statusConfigJSON struct {
 	Enabled                 bool                 `json:"enabled"`
 	UpperLimitPercent       int                  `json:"upperLimitPercent"`
+	LimitProfile            string               `json:"limitProfile,omitempty"`
 	LowerLimitPercent       int                  `json:"lowerLimitPercent"`
 	PreventIdleSleep        bool                 `json:"preventIdleSleep"`
 	DisableChargingPreSleep bool                 `json:"disableChargingPreSleep"`
@@ -136,8 +140,19 @@ func printStatusJSON(cmd *cobra.Command, data *statusData, cfg *config.File) err
 		},
 		Compatibility: data.capabilities,
 	}
+	if p := activeLimitProfile(data.config); p != nil {
+		out.Configuration.LimitProfile = p.Name
+	}
 
 	tr, err := apiClient.GetTelemetry(false, true)
+	if err == nil && tr.Temperature != nil {
+		if t := tr.Temperature.Battery; t > 0 {
+			out.Battery.TemperatureCelsius = &t
+		}
+		if tr.Temperature.Guard.Enabled {
+			out.Battery.TemperatureGuard = &tr.Temperature.Guard
+		}
+	}
 	if data.capabilities.Calibration && err == nil && tr.Calibration != nil {
 		cal := tr.Calibration
 
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charlie0129/batt/pkg/webhook"
+)
+
+func NewWebhooksCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "webhooks",
+		Short:   "Manage webhooks that receive daemon events",
+		GroupID: gAdvanced,
+		Long: `Manage webhooks that receive daemon events.
+
+Webhooks are configured in the "webhooks" section of the config file, or through PUT /webhooks. Every event is POSTed as JSON to each webhook. Deliveries are signed with HMAC-SHA256 if the webhook has a secret, and retried with exponential backoff until they succeed.`,
+	}
+
+	cmd.AddCommand(newWebhooksListCommand())
+
+	return cmd
+}
+
+func newWebhooksListCommand() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List webhooks and their delivery status",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			st, err := apiClient.GetWebhooks()
+			if err != nil {
+				return fmt.Errorf("failed to get webhooks: %w", err)
+			}
+
+			if jsonOutput {
+				b, err := json.MarshalIndent(st, "", "  ")
+				if err != nil {
+					return err
+				}
+				cmd.Println(string(b))
+				return nil
+			}
+
+			printWebhooks(cmd, st)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output webhooks in JSON format")
+
+	return cmd
+}
+
+func printWebhooks(cmd *cobra.Command, st []webhook.Status) {
+	if len(st) == 0 {
+		cmd.Println("No webhooks configured.")
+		return
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "URL\tEVENTS\tSIGNED\tPENDING\tDELIVERED\tFAILED\tLAST DELIVERED\tLAST ERROR")
+	for _, s := range st {
+		events := "all"
+		if len(s.Events) > 0 {
+			events = strings.Join(s.Events, ",")
+		}
+		lastDelivered := "-"
+		if s.LastDelivered != nil {
+			lastDelivered = s.LastDelivered.Local().Format(time.DateTime)
+		}
+		lastError := s.LastError
+		if lastError == "" {
+			lastError = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%d\t%d\t%s\t%s\n",
+			s.URL, events, s.Signed, s.Pending, s.Delivered, s.Failed+s.Dropped, lastDelivered, lastError)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,137 @@
+// Package adaptive learns when the user usually unplugs and decides when to
+// top up the battery to 100% so it is full just before that, similar to
+// Optimized Battery Charging in macOS.
+//
+// The package is pure: callers feed it plug state and the current time and
+// persist the Model themselves.
+package adaptive
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// Options tune learning and planning.
+type Options struct {
+	// MinSession is the shortest plug session that counts as an unplug
+	// observation. Short top-ups during the day are ignored.
+	MinSession time.Duration
+	// MaxSamples is how many recent unplug times are kept per weekday.
+	MaxSamples int
+	// MinSamples is how many unplug times a weekday needs before it is
+	// predicted.
+	MinSamples int
+	// MinutesPerPercent estimates how long charging 1% takes.
+	MinutesPerPercent float64
+	// Margin is added to the estimated charging time.
+	Margin time.Duration
+	// Grace keeps the top-up going for a while after the predicted unplug
+	// time before giving up for the day.
+	Grace time.Duration
+}
+
+var DefaultOptions = Options{
+	MinSession:        3 * time.Hour,
+	MaxSamples:        6,
+	MinSamples:        3,
+	MinutesPerPercent: 1.5,
+	Margin:            30 * time.Minute,
+	Grace:             time.Hour,
+}
+
+// Model holds the learned unplug times.
+type Model struct {
+	// Unplugs holds recent unplug times per weekday, indexed by
+	// time.Weekday, as minutes after local midnight. Oldest first.
+	Unplugs [7][]int `json:"unplugs"`
+	// PluggedAt is when the current plug session started, zero when
+	// unplugged.
+	PluggedAt time.Time `json:"pluggedAt,omitempty"`
+}
+
+// Update feeds the current plug state. It starts a session when the device
+// is plugged in and records an unplug observation when a long enough session
+// ends. It reports whether the model changed.
+func (m *Model) Update(now time.Time, pluggedIn bool, opts Options) bool {
+	switch {
+	case pluggedIn && m.PluggedAt.IsZero():
+		m.PluggedAt = now
+		return true
+	case !pluggedIn && !m.PluggedAt.IsZero():
+		if now.Sub(m.PluggedAt) >= opts.MinSession {
+			m.observe(now, opts)
+		}
+		m.PluggedAt = time.Time{}
+		return true
+	}
+	return false
+}
+
+func (m *Model) observe(unpluggedAt time.Time, opts Options) {
+	day := unpluggedAt.Weekday()
+	m.Unplugs[day] = append(m.Unplugs[day], unpluggedAt.Hour()*60+unpluggedAt.Minute())
+	if n := len(m.Unplugs[day]); n > opts.MaxSamples {
+		m.Unplugs[day] = slices.Clone(m.Unplugs[day][n-opts.MaxSamples:])
+	}
+}
+
+// Typical returns the median unplug time of day, in minutes after midnight,
+// or false if the weekday has too few observations.
+func (m *Model) Typical(day time.Weekday, opts Options) (int, bool) {
+	samples := m.Unplugs[day]
+	if len(samples) == 0 || len(samples) < opts.MinSamples {
+		return 0, false
+	}
+	sorted := slices.Sorted(slices.Values(samples))
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid], true
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2, true
+}
+
+// NextUnplug returns the next predicted unplug time whose grace period has
+// not yet passed, looking up to a week ahead.
+func (m *Model) NextUnplug(now time.Time, opts Options) (time.Time, bool) {
+	y, mo, d := now.Date()
+	for offset := 0; offset <= 7; offset++ {
+		midnight := time.Date(y, mo, d+offset, 0, 0, 0, 0, now.Location())
+		minutes, ok := m.Typical(midnight.Weekday(), opts)
+		if !ok {
+			continue
+		}
+		t := time.Date(y, mo, d+offset, minutes/60, minutes%60, 0, 0, now.Location())
+		if t.Add(opts.Grace).After(now) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Decision is the result of Plan.
+type Decision struct {
+	// TopUp reports whether the battery should charge to 100% now.
+	TopUp bool
+	// NextUnplug is the predicted unplug time, zero when unknown.
+	NextUnplug time.Time
+	// TopUpAt is when topping up starts, zero when unknown.
+	TopUpAt time.Time
+}
+
+// Plan decides whether to top up while plugged in and holding at limit. The
+// top-up starts early enough to charge from limit to 100% before the
+// predicted unplug and lasts until the grace period after it.
+func Plan(m *Model, now time.Time, limit int, opts Options) Decision {
+	next, ok := m.NextUnplug(now, opts)
+	if !ok {
+		return Decision{}
+	}
+	lead := opts.Margin + time.Duration(math.Ceil(float64(max(100-limit, 0))*opts.MinutesPerPercent))*time.Minute
+	at := next.Add(-lead)
+	return Decision{
+		TopUp:      !now.Before(at) && now.Before(next.Add(opts.Grace)) && limit < 100,
+		NextUnplug: next,
+		TopUpAt:    at,
+	}
+}
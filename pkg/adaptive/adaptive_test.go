@@ -0,0 +1,109 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+)
+
+// 2026-10-19 is a Monday.
+func monday(hour, minute int) time.Time {
+	return time.Date(2026, time.October, 19, hour, minute, 0, 0, time.UTC)
+}
+
+// learnMondays records overnight sessions ending at the given times on
+// consecutive Mondays before 2026-10-19.
+func learnMondays(m *Model, times ...[2]int) {
+	for i, hm := range times {
+		unplug := monday(hm[0], hm[1]).AddDate(0, 0, -7*(len(times)-i))
+		m.Update(unplug.Add(-10*time.Hour), true, DefaultOptions)
+		m.Update(unplug, false, DefaultOptions)
+	}
+}
+
+func TestUpdateIgnoresShortSessions(t *testing.T) {
+	var m Model
+	if !m.Update(monday(7, 0), true, DefaultOptions) {
+		t.Fatal("plugging in must start a session")
+	}
+	if m.Update(monday(7, 30), true, DefaultOptions) {
+		t.Fatal("staying plugged in must not change the model")
+	}
+	m.Update(monday(8, 0), false, DefaultOptions)
+	if len(m.Unplugs[time.Monday]) != 0 {
+		t.Fatalf("a 1h session was recorded: %v", m.Unplugs)
+	}
+	if !m.PluggedAt.IsZero() {
+		t.Fatal("unplugging must end the session")
+	}
+
+	m.Update(monday(12, 0), true, DefaultOptions)
+	m.Update(monday(16, 5), false, DefaultOptions)
+	if got := m.Unplugs[time.Monday]; len(got) != 1 || got[0] != 16*60+5 {
+		t.Fatalf("Unplugs[Monday] = %v, want [965]", got)
+	}
+}
+
+func TestUpdateKeepsRecentSamples(t *testing.T) {
+	var m Model
+	for i := range DefaultOptions.MaxSamples + 2 {
+		learnMondays(&m, [2]int{7, i})
+	}
+	got := m.Unplugs[time.Monday]
+	if len(got) != DefaultOptions.MaxSamples || got[0] != 7*60+2 {
+		t.Fatalf("Unplugs[Monday] = %v, want the %d most recent", got, DefaultOptions.MaxSamples)
+	}
+}
+
+func TestTypical(t *testing.T) {
+	var m Model
+	learnMondays(&m, [2]int{7, 30}, [2]int{8, 0})
+	if _, ok := m.Typical(time.Monday, DefaultOptions); ok {
+		t.Fatal("two samples must not be enough for a prediction")
+	}
+	learnMondays(&m, [2]int{11, 0})
+	if got, ok := m.Typical(time.Monday, DefaultOptions); !ok || got != 8*60 {
+		t.Fatalf("Typical() = %d, %v; want the median 480", got, ok)
+	}
+	learnMondays(&m, [2]int{7, 40})
+	if got, _ := m.Typical(time.Monday, DefaultOptions); got != 7*60+50 {
+		t.Fatalf("Typical() = %d, want 470", got)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	var m Model
+	learnMondays(&m, [2]int{7, 30}, [2]int{7, 30}, [2]int{7, 30})
+
+	// From 80%: 30 minutes of charging plus a 30 minute margin.
+	tests := []struct {
+		name  string
+		now   time.Time
+		limit int
+		topUp bool
+	}{
+		{name: "overnight", now: monday(3, 0), limit: 80, topUp: false},
+		{name: "just before top-up", now: monday(6, 29), limit: 80, topUp: false},
+		{name: "top-up starts", now: monday(6, 30), limit: 80, topUp: true},
+		{name: "after predicted unplug", now: monday(8, 0), limit: 80, topUp: true},
+		{name: "grace period over", now: monday(8, 30), limit: 80, topUp: false},
+		{name: "limit disabled", now: monday(7, 0), limit: 100, topUp: false},
+		{name: "lower limit starts earlier", now: monday(6, 0), limit: 60, topUp: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Plan(&m, tt.now, tt.limit, DefaultOptions)
+			if d.TopUp != tt.topUp {
+				t.Fatalf("Plan() = %+v, want TopUp %v", d, tt.topUp)
+			}
+		})
+	}
+
+	d := Plan(&m, monday(8, 30), 80, DefaultOptions)
+	if want := monday(7, 30).AddDate(0, 0, 7); !d.NextUnplug.Equal(want) {
+		t.Fatalf("NextUnplug = %s, want next Monday %s", d.NextUnplug, want)
+	}
+
+	if d := Plan(&Model{}, monday(7, 0), 80, DefaultOptions); d.TopUp || !d.NextUnplug.IsZero() {
+		t.Fatalf("Plan() without a model = %+v", d)
+	}
+}
@@ -0,0 +1,37 @@
+package adaptive
+
+import "time"
+
+// Status is the adaptive charging state reported by the daemon.
+type Status struct {
+	Enabled   bool `json:"enabled"`
+	ToppingUp bool `json:"toppingUp"`
+	// PluggedInSince is when the current plug session started.
+	PluggedInSince *time.Time `json:"pluggedInSince,omitempty"`
+	NextUnplug     *time.Time `json:"nextUnplug,omitempty"`
+	TopUpAt        *time.Time `json:"topUpAt,omitempty"`
+	Weekdays       []Weekday  `json:"weekdays"`
+}
+
+// Weekday summarizes what was learned for one day of the week.
+type Weekday struct {
+	Day     string `json:"day"`
+	Samples int    `json:"samples"`
+	// Typical is the predicted unplug time as "15:04", empty until there
+	// are enough samples.
+	Typical string `json:"typical,omitempty"`
+}
+
+// Weekdays summarizes the model, starting on Monday.
+func (m *Model) Weekdays(opts Options) []Weekday {
+	days := make([]Weekday, 0, 7)
+	for i := range 7 {
+		day := time.Weekday((i + 1) % 7)
+		w := Weekday{Day: day.String(), Samples: len(m.Unplugs[day])}
+		if minutes, ok := m.Typical(day, opts); ok {
+			w.Typical = time.Date(0, 1, 1, minutes/60, minutes%60, 0, 0, time.UTC).Format("15:04")
+		}
+		days = append(days, w)
+	}
+	return days
+}
@@ -0,0 +1,111 @@
+// Package api defines the wire types of the daemon's versioned HTTP API,
+// served under /v1 on the daemon socket.
+package api
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode identifies the kind of an Error. Clients should branch on the
+// code, not on the HTTP status or the message.
+type ErrorCode string
+
+const (
+	// CodeInvalidArgument means the request was malformed or a value was out
+	// of range.
+	CodeInvalidArgument ErrorCode = "invalid_argument"
+	// CodeCapabilityMissing means this Mac does not support the feature the
+	// request needs.
+	CodeCapabilityMissing ErrorCode = "capability_missing"
+	// CodeCalibrationConflict means a calibration, or a temporary disable that
+	// blocks calibration, is in the way.
+	CodeCalibrationConflict ErrorCode = "calibration_conflict"
+	// CodeConflict means the request conflicts with the current state.
+	CodeConflict ErrorCode = "conflict"
+	// CodePreconditionFailed means the If-Match header of the request does
+	// not match the current config revision.
+	CodePreconditionFailed ErrorCode = "precondition_failed"
+	// CodePermissionDenied means the access control list in the daemon
+	// config does not allow the caller to make the request.
+	CodePermissionDenied ErrorCode = "permission_denied"
+	// CodeNotFound means the route or resource does not exist.
+	CodeNotFound ErrorCode = "not_found"
+	// CodeUnavailable means the daemon cannot serve the request right now.
+	CodeUnavailable ErrorCode = "unavailable"
+	// CodeInternal is used for everything else, such as SMC or disk errors.
+	CodeInternal ErrorCode = "internal"
+)
+
+// HTTPStatus returns the status code /v1 responds with for c.
+func (c ErrorCode) HTTPStatus() int {
+	switch c {
+	case CodeInvalidArgument:
+		return http.StatusBadRequest
+	case CodeCapabilityMissing:
+		return http.StatusNotImplemented
+	case CodeCalibrationConflict, CodeConflict:
+		return http.StatusConflict
+	case CodePreconditionFailed:
+		return http.StatusPreconditionFailed
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is the body of every failed /v1 response.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// Details holds machine-readable context, such as the feature that is
+	// missing or the accepted range of a value.
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Errorf returns an Error with code and a formatted message.
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// WithDetail sets a detail and returns e.
+func (e *Error) WithDetail(key string, value any) *Error {
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return string(e.Code)
+	}
+	return e.Message
+}
+
+// Is reports whether target is an *Error with the same code, so
+// errors.Is(err, api.ErrCalibrationConflict) matches any calibration
+// conflict regardless of its message.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Sentinels to match errors against with errors.Is.
+var (
+	ErrInvalidArgument     = &Error{Code: CodeInvalidArgument}
+	ErrCapabilityMissing   = &Error{Code: CodeCapabilityMissing}
+	ErrCalibrationConflict = &Error{Code: CodeCalibrationConflict}
+	ErrConflict            = &Error{Code: CodeConflict}
+	ErrPreconditionFailed  = &Error{Code: CodePreconditionFailed}
+	ErrPermissionDenied    = &Error{Code: CodePermissionDenied}
+	ErrNotFound            = &Error{Code: CodeNotFound}
+	ErrUnavailable         = &Error{Code: CodeUnavailable}
+	ErrInternal            = &Error{Code: CodeInternal}
+)
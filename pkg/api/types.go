@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/powerinfo"
+)
+
+// Version is the /v1/version resource.
+type Version struct {
+	Version string `json:"version"`
+}
+
+// Config is the /v1/config resource: the config with defaults filled in and
+// secrets redacted, and what the daemon makes of it.
+type Config struct {
+	config.RawFileConfig
+	// ActiveLimitProfile is the name of the limit profile in effect, if any.
+	// It is ignored in PATCH requests.
+	ActiveLimitProfile *string `json:"activeLimitProfile,omitempty"`
+}
+
+// ConfigSetting is a setting in the response of GET /v1/config?explain=1,
+// which maps the JSON name of every setting to its effective value and
+// where that comes from.
+type ConfigSetting struct {
+	// Value is the effective value, null for settings that are off.
+	Value json.RawMessage `json:"value"`
+	// Source is the layer of the config the value comes from. It is empty
+	// for values the daemon adds, like activeLimitProfile of Config.
+	Source config.Source `json:"source,omitempty"`
+	// Name is the environment variable or flag that set the value.
+	Name string `json:"name,omitempty"`
+}
+
+// Limit is the /v1/limit resource.
+type Limit struct {
+	Upper int `json:"upper"`
+	Lower int `json:"lower"`
+	// EffectiveUpper and EffectiveLower are the limits enforced right now,
+	// after limit profiles, adaptive charging and the temperature guard.
+	EffectiveUpper int `json:"effectiveUpper"`
+	EffectiveLower int `json:"effectiveLower"`
+	// DisabledUntil is set while batt is temporarily disabled. The upper
+	// limit goes back to RestoreLimit then.
+	DisabledUntil *time.Time `json:"disabledUntil,omitempty"`
+	RestoreLimit  int        `json:"restoreLimit,omitempty"`
+	// Message describes the effect of a change, for display to the user.
+	Message string `json:"message,omitempty"`
+}
+
+// SetLimitRequest is the body of PUT /v1/limit.
+type SetLimitRequest struct {
+	Upper int `json:"upper"`
+}
+
+// SetLowerLimitDeltaRequest is the body of PUT /v1/limit/lower-delta.
+type SetLowerLimitDeltaRequest struct {
+	Delta int `json:"delta"`
+}
+
+// DurationRequest is the body of requests that take a duration, such as
+// POST /v1/limit/disable. Duration uses Go syntax, for example "1h30m".
+type DurationRequest struct {
+	Duration string `json:"duration"`
+}
+
+// Adapter is the /v1/adapter resource.
+type Adapter struct {
+	Enabled bool `json:"enabled"`
+	// DisabledUntil is set while the adapter is temporarily disabled.
+	DisabledUntil *time.Time `json:"disabledUntil,omitempty"`
+	Message       string     `json:"message,omitempty"`
+}
+
+// SetAdapterRequest is the body of PUT /v1/adapter.
+type SetAdapterRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Battery is the /v1/battery resource.
+type Battery struct {
+	Charge    int  `json:"charge"`
+	PluggedIn bool `json:"pluggedIn"`
+	// Charging is whether charging is enabled in legacy charge-control mode,
+	// and whether the battery is charging otherwise.
+	Charging               bool               `json:"charging"`
+	ChargingControlCapable bool               `json:"chargingControlCapable"`
+	Info                   *powerinfo.Battery `json:"info,omitempty"`
+}
+
+// Telemetry is the /v1/telemetry resource. Parts that were excluded with
+// a query parameter or are unavailable are omitted.
+type Telemetry struct {
+	Power       *powerinfo.PowerTelemetry `json:"power,omitempty"`
+	Calibration *calibration.Status       `json:"calibration,omitempty"`
+	Temperature *powerinfo.Temperature    `json:"temperature,omitempty"`
+}
+
+// Settings is the /v1/settings resource. PUT /v1/settings only changes the
+// fields that are set.
+type Settings struct {
+	PreventIdleSleep        *bool                      `json:"preventIdleSleep,omitempty"`
+	DisableChargingPreSleep *bool                      `json:"disableChargingPreSleep,omitempty"`
+	PreventSystemSleep      *bool                      `json:"preventSystemSleep,omitempty"`
+	ControlMagSafeLED       *config.ControlMagSafeMode `json:"controlMagSafeLED,omitempty"`
+	AdaptiveCharging        *bool                      `json:"adaptiveCharging,omitempty"`
+	Message                 string                     `json:"message,omitempty"`
+}
+
+// CalibrationSettings is the /v1/calibration/settings resource. PUT only
+// changes the fields that are set.
+type CalibrationSettings struct {
+	DischargeThreshold  *int   `json:"dischargeThreshold,omitempty"`
+	HoldDurationMinutes *int   `json:"holdDurationMinutes,omitempty"`
+	Message             string `json:"message,omitempty"`
+}
+
+// Schedule is the /v1/calibration/schedule resource. An empty Cron means no
+// calibration is scheduled.
+type Schedule struct {
+	Cron     string      `json:"cron"`
+	NextRuns []time.Time `json:"nextRuns,omitempty"`
+}
+
+// Rule names a rule the maintain loop applies when it decides whether the
+// battery may charge.
+type Rule string
+
+const (
+	// RuleBelowLowerLimit means the charge is below the lower limit, so
+	// charging is enabled.
+	RuleBelowLowerLimit Rule = "below_lower_limit"
+	// RuleAboveUpperLimit means the charge has reached the upper limit, so
+	// charging is disabled.
+	RuleAboveUpperLimit Rule = "above_upper_limit"
+	// RuleHysteresis means the charge is between the lower and upper limit,
+	// so charging is left as it is.
+	RuleHysteresis Rule = "hysteresis"
+	// RuleMissedLoops means too few maintain loops ran recently, usually
+	// because the Mac was asleep, so charging is not enabled (#123).
+	RuleMissedLoops Rule = "missed_loops"
+	// RuleCalibration means a calibration controls charging.
+	RuleCalibration Rule = "calibration"
+	// RuleTemporaryDisable means the charge limit is disabled for a while.
+	RuleTemporaryDisable Rule = "temporary_disable"
+	// RuleAdapterTimer means the power adapter is disabled for a while.
+	RuleAdapterTimer Rule = "adapter_timer"
+	// RuleFirmware means the firmware enforces the charge limit.
+	RuleFirmware Rule = "firmware"
+	// RuleNoLimit means the upper limit is 100%, so charging is not limited.
+	RuleNoLimit Rule = "no_limit"
+	// RuleLimitProfile means a limit profile replaces the upper limit.
+	RuleLimitProfile Rule = "limit_profile"
+	// RuleAdaptive means adaptive charging tops up to 100%.
+	RuleAdaptive Rule = "adaptive"
+	// RuleTemperature means the battery is too hot to charge normally.
+	RuleTemperature Rule = "temperature"
+	// RuleUnplugged means the power adapter is not plugged in.
+	RuleUnplugged Rule = "unplugged"
+	// RuleUnsupported means this Mac does not support charge control.
+	RuleUnsupported Rule = "unsupported"
+	// RuleError means the maintain loop failed before it decided.
+	RuleError Rule = "error"
+)
+
+// Explanation is the /v1/explain resource. It tells why the last maintain
+// loop left charging enabled or disabled.
+type Explanation struct {
+	// Time is when the maintain loop ran. It is zero before the first one.
+	Time time.Time                       `json:"time"`
+	Mode compatibility.ChargeControlMode `json:"mode"`
+	// Charge, PluggedIn and Charging are as seen by the loop in legacy
+	// charge-control mode. The loop does not read them in firmware mode, so
+	// they are read when the explanation is requested instead. Charging is
+	// whether charging is enabled after the loop in legacy mode, and whether
+	// the battery is charging otherwise.
+	Charge    int  `json:"charge"`
+	PluggedIn bool `json:"pluggedIn"`
+	Charging  bool `json:"charging"`
+	// Upper and Lower are the limits the loop enforced.
+	Upper int `json:"upper"`
+	Lower int `json:"lower"`
+	// Rule is the rule that decided.
+	Rule Rule `json:"rule"`
+	// Steps are the rules that applied, in the order they were considered.
+	Steps []ExplanationStep `json:"steps"`
+}
+
+// ExplanationStep is a rule that applied in an Explanation.
+type ExplanationStep struct {
+	Rule    Rule   `json:"rule"`
+	Message string `json:"message"`
+}
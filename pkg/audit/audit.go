@@ -0,0 +1,214 @@
+// Package audit implements the daemon's audit log of configuration and
+// control changes.
+//
+// Entries are appended as JSON lines to a single file. When the file grows
+// beyond its size budget it is rotated to path.1, path.1 to path.2 and so
+// on, and the oldest file is deleted.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Actor identifies who made a change.
+type Actor string
+
+const (
+	// ActorDaemon is the daemon itself, e.g. turning off features this Mac
+	// does not support at startup.
+	ActorDaemon Actor = "daemon"
+	// ActorTimer is a temporary disable running out.
+	ActorTimer Actor = "timer"
+	// ActorScheduler is the calibration scheduler.
+	ActorScheduler Actor = "scheduler"
+	// ActorCalibration is a calibration run, e.g. restoring the limits when
+	// it finishes.
+	ActorCalibration Actor = "calibration"
+	// ActorMQTT is a command received over MQTT.
+	ActorMQTT Actor = "mqtt"
+	// ActorAPI is an API caller whose credentials are unknown.
+	ActorAPI Actor = "api"
+)
+
+// User returns the actor for an API caller.
+func User(uid uint32) Actor {
+	return Actor("uid:" + strconv.FormatUint(uint64(uid), 10))
+}
+
+// Entry records a change of one value. Old and New are JSON; null means
+// unset.
+type Entry struct {
+	Time  time.Time       `json:"time"`
+	Actor Actor           `json:"actor"`
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
+}
+
+// Options controls the size of the log.
+type Options struct {
+	// MaxBytes is the file size that triggers a rotation.
+	MaxBytes int64
+	// MaxBackups is the number of rotated files to keep.
+	MaxBackups int
+}
+
+// DefaultOptions keeps up to 5 MiB of entries.
+var DefaultOptions = Options{
+	MaxBytes:   1 << 20,
+	MaxBackups: 4,
+}
+
+// Log is an append-only, rotated audit file.
+type Log struct {
+	path string
+	opts Options
+
+	mu   sync.Mutex
+	size int64
+}
+
+// Open opens the audit log at path, creating its directory if needed.
+// Zero fields in opts are taken from DefaultOptions.
+func Open(path string, opts Options) (*Log, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultOptions.MaxBytes
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = DefaultOptions.MaxBackups
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create audit directory: %w", err)
+	}
+
+	l := &Log{path: path, opts: opts}
+	fi, err := os.Stat(path)
+	switch {
+	case err == nil:
+		l.size = fi.Size()
+	case os.IsNotExist(err):
+	default:
+		return nil, fmt.Errorf("stat audit log: %w", err)
+	}
+	return l, nil
+}
+
+// Path returns the location of the current audit file.
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append writes an entry to the end of the log, rotating it first if it
+// has outgrown its budget.
+func (l *Log) Append(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(b)) > l.opts.MaxBytes {
+		if err := l.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	n, err := f.Write(b)
+	l.size += int64(n)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("append audit entry: %w", err)
+	}
+	return nil
+}
+
+func (l *Log) backup(i int) string {
+	return l.path + "." + strconv.Itoa(i)
+}
+
+func (l *Log) rotateLocked() error {
+	if err := os.Remove(l.backup(l.opts.MaxBackups)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove oldest audit log: %w", err)
+	}
+	for i := l.opts.MaxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backup(i), l.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+	if err := os.Rename(l.path, l.backup(1)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotate audit log: %w", err)
+	}
+	l.size = 0
+	return nil
+}
+
+// Query returns entries at or after since in chronological order, at most
+// limit of the newest ones if limit is positive. A zero since returns all
+// entries still on disk.
+func (l *Log) Query(since time.Time, limit int) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []Entry
+	for i := l.opts.MaxBackups; i >= 0; i-- {
+		path := l.path
+		if i > 0 {
+			path = l.backup(i)
+		}
+		if err := readFile(path, func(e Entry) {
+			if !e.Time.Before(since) {
+				entries = append(entries, e)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+// readFile calls fn for each entry in path. A missing file has no entries
+// and malformed lines, e.g. one cut short by a crash, are skipped.
+func readFile(path string, fn func(Entry)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		fn(e)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func openLog(t *testing.T, opts Options) *Log {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "audit", "batt.audit.jsonl"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func entry(i int) Entry {
+	return Entry{
+		Time:  time.Unix(1_700_000_000+int64(i), 0).UTC(),
+		Actor: User(501),
+		Field: "limit",
+		Old:   json.RawMessage(strconv.Itoa(i)),
+		New:   json.RawMessage(strconv.Itoa(i + 1)),
+	}
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	l := openLog(t, Options{})
+	for i := range 5 {
+		if err := l.Append(entry(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := l.Query(time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 5 || got[0].Actor != "uid:501" || string(got[4].New) != "5" {
+		t.Fatalf("unexpected entries: %+v", got)
+	}
+
+	got, err = l.Query(entry(2).Time, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || string(got[0].Old) != "3" || string(got[1].Old) != "4" {
+		t.Fatalf("unexpected filtered entries: %+v", got)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	size := int64(len(mustMarshal(t, entry(0))) + 1)
+	l := openLog(t, Options{MaxBytes: 3 * size, MaxBackups: 2})
+	for i := range 10 {
+		if err := l.Append(entry(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{l.Path(), l.Path() + ".1", l.Path() + ".2"} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(l.Path() + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("too many backups: %v", err)
+	}
+
+	got, err := l.Query(time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries 0-2 were rotated out; 3-5 and 6-8 are in the backups.
+	if len(got) != 7 || string(got[0].Old) != "3" || string(got[6].Old) != "9" {
+		t.Fatalf("unexpected entries after rotation: %+v", got)
+	}
+
+	// The size of an existing file is picked up on reopen.
+	l, err = Open(l.Path(), l.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(mustMarshal(t, entry(9))) + 1); l.size != want {
+		t.Fatalf("size = %d, want %d", l.size, want)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
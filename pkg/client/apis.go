@@ -4,8 +4,8 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -13,120 +13,186 @@ import (
 	pkgerrors "github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
-	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/adaptive"
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
 	"github.com/charlie0129/batt/pkg/compatibility"
 	"github.com/charlie0129/batt/pkg/config"
 	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/history"
 	"github.com/charlie0129/batt/pkg/powerinfo"
+	"github.com/charlie0129/batt/pkg/webhook"
 )
 
 func (c *Client) SetLimit(l int) (string, error) {
-	return c.Put("/limit", strconv.Itoa(l))
+	var ret api.Limit
+	err := c.do("PUT", "/v1/limit", api.SetLimitRequest{Upper: l}, &ret)
+	if isLegacyDaemon(err) {
+		return c.sendLegacy("PUT", "/limit", strconv.Itoa(l))
+	}
+	return ret.Message, err
 }
 
 func (c *Client) DisableFor(d time.Duration) (string, error) {
-	return c.Put("/disable", strconv.Quote(d.String()))
+	var ret api.Limit
+	err := c.do("POST", "/v1/limit/disable", api.DurationRequest{Duration: d.String()}, &ret)
+	if isLegacyDaemon(err) {
+		return c.sendLegacy("PUT", "/disable", strconv.Quote(d.String()))
+	}
+	return ret.Message, err
 }
 
 func (c *Client) SetAdapter(enabled bool) (string, error) {
-	return c.Put("/adapter", strconv.FormatBool(enabled))
+	var ret api.Adapter
+	err := c.do("PUT", "/v1/adapter", api.SetAdapterRequest{Enabled: enabled}, &ret)
+	if isLegacyDaemon(err) {
+		return c.sendLegacy("PUT", "/adapter", strconv.FormatBool(enabled))
+	}
+	return ret.Message, err
 }
 
 func (c *Client) DisableAdapterFor(d time.Duration) (string, error) {
-	return c.Put("/adapter/disable", strconv.Quote(d.String()))
+	var ret api.Adapter
+	err := c.do("POST", "/v1/adapter/disable", api.DurationRequest{Duration: d.String()}, &ret)
+	if isLegacyDaemon(err) {
+		return c.sendLegacy("PUT", "/adapter/disable", strconv.Quote(d.String()))
+	}
+	return ret.Message, err
 }
 
 func (c *Client) GetAdapter() (bool, error) {
-	ret, err := c.Get("/adapter")
+	var ret api.Adapter
+	err := c.do("GET", "/v1/adapter", nil, &ret)
+	if isLegacyDaemon(err) {
+		ret.Enabled, err = c.getLegacyBool("/adapter")
+	}
 	if err != nil {
 		return false, pkgerrors.Wrapf(err, "failed to get power adapter status")
 	}
-	return parseBoolResponse(ret)
+	return ret.Enabled, nil
 }
 
 func (c *Client) SetLowerLimitDelta(delta int) (string, error) {
-	return c.Put("/lower-limit-delta", strconv.Itoa(delta))
+	var ret api.Limit
+	err := c.do("PUT", "/v1/limit/lower-delta", api.SetLowerLimitDeltaRequest{Delta: delta}, &ret)
+	if isLegacyDaemon(err) {
+		return c.sendLegacy("PUT", "/lower-limit-delta", strconv.Itoa(delta))
+	}
+	return ret.Message, err
+}
+
+// GetLimit returns the configured and effective charge limits.
+func (c *Client) GetLimit() (*api.Limit, error) {
+	ret := &api.Limit{}
+	err := c.do("GET", "/v1/limit", nil, ret)
+	if isLegacyDaemon(err) {
+		ret, err = c.getLegacyLimit()
+	}
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to get limit")
+	}
+	return ret, nil
+}
+
+// UpdateSettings changes the settings that are set in s and returns the
+// result.
+func (c *Client) UpdateSettings(s api.Settings) (*api.Settings, error) {
+	var ret api.Settings
+	if err := c.do("PUT", "/v1/settings", s, &ret); err != nil {
+		if isLegacyDaemon(err) {
+			return c.updateLegacySettings(s)
+		}
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) updateSettings(s api.Settings) (string, error) {
+	ret, err := c.UpdateSettings(s)
+	if err != nil {
+		return "", err
+	}
+	return ret.Message, nil
 }
 
 func (c *Client) SetPreventIdleSleep(enabled bool) (string, error) {
-	return c.Put("/prevent-idle-sleep", strconv.FormatBool(enabled))
+	return c.updateSettings(api.Settings{PreventIdleSleep: &enabled})
 }
 
 func (c *Client) SetDisableChargingPreSleep(enabled bool) (string, error) {
-	return c.Put("/disable-charging-pre-sleep", strconv.FormatBool(enabled))
+	return c.updateSettings(api.Settings{DisableChargingPreSleep: &enabled})
 }
 
 func (c *Client) SetPreventSystemSleep(enabled bool) (string, error) {
-	return c.Put("/prevent-system-sleep", strconv.FormatBool(enabled))
+	return c.updateSettings(api.Settings{PreventSystemSleep: &enabled})
 }
 
 func (c *Client) SetControlMagSafeLED(mode config.ControlMagSafeMode) (string, error) {
-	payload, err := json.Marshal(mode)
+	return c.updateSettings(api.Settings{ControlMagSafeLED: &mode})
+}
+
+// GetBattery returns the battery state in one request.
+func (c *Client) GetBattery() (*api.Battery, error) {
+	ret := &api.Battery{}
+	err := c.do("GET", "/v1/battery", nil, ret)
+	if isLegacyDaemon(err) {
+		ret, err = c.getLegacyBattery()
+	}
 	if err != nil {
-		return "", err
+		return nil, pkgerrors.Wrapf(err, "failed to get battery state")
 	}
-	return c.Put("/magsafe-led", string(payload))
+	return ret, nil
 }
 
 func (c *Client) GetCharging() (bool, error) {
-	ret, err := c.Get("/charging")
+	b, err := c.GetBattery()
 	if err != nil {
 		return false, pkgerrors.Wrapf(err, "failed to get charging status")
 	}
-	return parseBoolResponse(ret)
+	return b.Charging, nil
 }
 
 func (c *Client) GetPluggedIn() (bool, error) {
-	ret, err := c.Get("/plugged-in")
+	b, err := c.GetBattery()
 	if err != nil {
 		return false, pkgerrors.Wrapf(err, "failed to check if you are plugged in")
 	}
-	return parseBoolResponse(ret)
+	return b.PluggedIn, nil
 }
 
 func (c *Client) GetCurrentCharge() (int, error) {
-	ret, err := c.Get("/current-charge")
+	b, err := c.GetBattery()
 	if err != nil {
 		return 0, pkgerrors.Wrapf(err, "failed to get current charge")
 	}
-	currentCharge, err := strconv.Atoi(ret)
-	if err != nil {
-		return 0, pkgerrors.Wrapf(err, "failed to unmarshal current charge")
-	}
-	return currentCharge, nil
+	return b.Charge, nil
 }
 
 func (c *Client) GetBatteryInfo() (*powerinfo.Battery, error) {
-	ret, err := c.Get("/battery-info")
+	b, err := c.GetBattery()
 	if err != nil {
 		return nil, pkgerrors.Wrapf(err, "failed to get battery info")
 	}
-
-	var bat powerinfo.Battery
-	if err := json.Unmarshal([]byte(ret), &bat); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal battery info: %w", err)
+	if b.Info == nil {
+		return nil, pkgerrors.New("battery info is not available")
 	}
-
-	return &bat, nil
+	return b.Info, nil
 }
 
 func (c *Client) GetChargingControlCapable() (bool, error) {
-	ret, err := c.Get("/charging-control-capable")
+	b, err := c.GetBattery()
 	if err != nil {
 		return false, pkgerrors.Wrapf(err, "failed to get charging control capability")
 	}
-
-	capable, err := strconv.ParseBool(ret)
-	if err != nil {
-		return false, pkgerrors.Wrapf(err, "failed to parse charging control capability response")
-	}
-
-	return capable, nil
+	return b.ChargingControlCapable, nil
 }
 
 // GetCompatibility returns detailed hardware-dependent daemon capabilities.
 func (c *Client) GetCompatibility() (*compatibility.Capabilities, error) {
-	ret, err := c.Get("/compatibility")
+	ret, err := c.Get("/v1/compatibility")
+	if isLegacyDaemon(err) {
+		ret, err = c.Get("/compatibility")
+	}
 	if err != nil {
 		return nil, pkgerrors.Wrap(err, "failed to get compatibility")
 	}
@@ -137,48 +203,87 @@ func (c *Client) GetCompatibility() (*compatibility.Capabilities, error) {
 	return &capabilities, nil
 }
 
-func (c *Client) GetConfig() (*config.RawFileConfig, error) {
-	ret, err := c.Get("/config")
+func (c *Client) GetConfig() (*api.Config, error) {
+	conf, _, err := c.GetConfigWithETag()
+	return conf, err
+}
+
+// GetConfigWithETag returns the config and the ETag of its revision, to be
+// passed to PatchConfig.
+func (c *Client) GetConfigWithETag() (*api.Config, string, error) {
+	ret, header, err := c.send("GET", "/v1/config", "", nil)
+	if isLegacyDaemon(err) {
+		// Older daemons do not support PatchConfig either, so there is no
+		// ETag.
+		conf, err := c.getLegacyConfig()
+		if err != nil {
+			return nil, "", pkgerrors.Wrapf(err, "failed to get config")
+		}
+		return conf, "", nil
+	}
 	if err != nil {
-		return nil, pkgerrors.Wrapf(err, "failed to get config")
+		return nil, "", pkgerrors.Wrapf(err, "failed to get config")
 	}
 
-	var conf config.RawFileConfig
+	var conf api.Config
 	if err := json.Unmarshal([]byte(ret), &conf); err != nil {
-		return nil, pkgerrors.Wrapf(err, "failed to unmarshal config")
+		return nil, "", pkgerrors.Wrapf(err, "failed to unmarshal config")
 	}
 
-	return &conf, nil
+	return &conf, header.Get("ETag"), nil
 }
 
-func (c *Client) GetVersion() (string, error) {
-	ret, err := c.Get("/version")
+// PatchConfig sets the fields of patch that are not nil, all at once. If etag
+// is not empty and the config has changed since it was read, nothing is
+// changed and ErrPreconditionFailed is returned. It returns the resulting
+// config and its ETag.
+func (c *Client) PatchConfig(patch *config.RawFileConfig, etag string) (*api.Config, string, error) {
+	b, err := json.Marshal(patch)
 	if err != nil {
+		return nil, "", pkgerrors.Wrapf(err, "failed to marshal config")
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+
+	ret, header, err := c.send("PATCH", "/v1/config", string(b), header)
+	if err != nil {
+		return nil, "", pkgerrors.Wrapf(err, "failed to patch config")
+	}
+
+	var conf api.Config
+	if err := json.Unmarshal([]byte(ret), &conf); err != nil {
+		return nil, "", pkgerrors.Wrapf(err, "failed to unmarshal config")
+	}
+
+	return &conf, header.Get("ETag"), nil
+}
+
+func (c *Client) GetVersion() (string, error) {
+	var ret api.Version
+	if err := c.do("GET", "/v1/version", nil, &ret); err != nil {
+		if isLegacyDaemon(err) {
+			return c.getLegacyVersion()
+		}
 		return "", pkgerrors.Wrapf(err, "failed to get version")
 	}
-	// Remove "" around JSON string. I don't want to use a JSON decoder just for this.
-	ret = ret[1 : len(ret)-1] // remove the surrounding quotes
-	return ret, nil
+	return ret.Version, nil
 }
 
 func (c *Client) GetPowerTelemetry() (*powerinfo.PowerTelemetry, error) {
-	ret, err := c.Get("/power-telemetry")
+	tr, err := c.GetTelemetry(true, false)
 	if err != nil {
 		return nil, pkgerrors.Wrapf(err, "failed to get power telemetry")
 	}
-
-	var info powerinfo.PowerTelemetry
-	if err := json.Unmarshal([]byte(ret), &info); err != nil {
-		return nil, pkgerrors.Wrapf(err, "failed to unmarshal power telemetry")
+	if tr.Power == nil {
+		return nil, pkgerrors.New("power telemetry is not available")
 	}
-	return &info, nil
+	return tr.Power, nil
 }
 
 // TelemetryResponse represents unified telemetry returned from the daemon.
-type TelemetryResponse struct {
-	Power       *powerinfo.PowerTelemetry `json:"power,omitempty"`
-	Calibration *calibration.Status       `json:"calibration,omitempty"`
-}
+type TelemetryResponse = api.Telemetry
 
 // GetTelemetry fetches unified telemetry; set power or calibration to false to exclude.
 func (c *Client) GetTelemetry(includePower, includeCalibration bool) (*TelemetryResponse, error) {
@@ -193,7 +298,10 @@ func (c *Client) GetTelemetry(includePower, includeCalibration bool) (*Telemetry
 	if len(q) > 0 {
 		q = "?" + q[:len(q)-1]
 	}
-	ret, err := c.Get("/telemetry" + q)
+	ret, err := c.Get("/v1/telemetry" + q)
+	if isLegacyDaemon(err) {
+		ret, err = c.Get("/telemetry" + q)
+	}
 	if err != nil {
 		return nil, pkgerrors.Wrapf(err, "failed to get unified telemetry")
 	}
@@ -204,19 +312,21 @@ func (c *Client) GetTelemetry(includePower, includeCalibration bool) (*Telemetry
 	return &tr, nil
 }
 
-// SubscribeEvents connects to /event and streams SSE events.
+// SubscribeEvents connects to /v1/events, or /event on older daemons, and
+// streams SSE events.
 // It will auto-reconnect until ctx is canceled. Returned channel is closed on ctx.Done().
 func (c *Client) SubscribeEvents(ctx context.Context) <-chan events.Event {
 	ch := make(chan events.Event, 32)
 	go func() {
 		defer close(ch)
 		retry := 3 * time.Second
+		path := "/v1/events"
 		for {
 			if ctx.Err() != nil {
 				return
 			}
 
-			req, err := http.NewRequestWithContext(ctx, "GET", "http://unix/event", nil)
+			req, err := http.NewRequestWithContext(ctx, "GET", "http://unix"+path, nil)
 			if err != nil {
 				logrus.WithError(err).Warn("SSE request build failed; retrying")
 				select {
@@ -236,6 +346,11 @@ func (c *Client) SubscribeEvents(ctx context.Context) <-chan events.Event {
 				}
 				continue
 			}
+			if resp.StatusCode == http.StatusNotFound && path != "/event" {
+				_ = resp.Body.Close()
+				path = "/event"
+				continue
+			}
 
 			reader := bufio.NewReader(resp.Body)
 			var curName string
@@ -306,58 +421,156 @@ func (c *Client) SubscribeEvents(ctx context.Context) <-chan events.Event {
 	return ch
 }
 
-func (c *Client) StartCalibration() (string, error) {
-	return c.Send("POST", "/calibration/start", "")
-}
-
-func (c *Client) PauseCalibration() (string, error) { return c.Send("POST", "/calibration/pause", "") }
-func (c *Client) ResumeCalibration() (string, error) {
-	return c.Send("POST", "/calibration/resume", "")
-}
-func (c *Client) CancelCalibration() (string, error) {
-	return c.Send("POST", "/calibration/cancel", "")
+func (c *Client) calibrationAction(action string) (string, error) {
+	err := c.do("POST", "/v1/calibration/"+action, nil, nil)
+	if isLegacyDaemon(err) {
+		_, err = c.Send("POST", "/calibration/"+action, "")
+	}
+	return "", err
 }
 
-type scheduleResponse struct {
-	OK       bool        `json:"ok"`
-	NextRuns []time.Time `json:"next_runs"`
-}
+func (c *Client) StartCalibration() (string, error)  { return c.calibrationAction("start") }
+func (c *Client) PauseCalibration() (string, error)  { return c.calibrationAction("pause") }
+func (c *Client) ResumeCalibration() (string, error) { return c.calibrationAction("resume") }
+func (c *Client) CancelCalibration() (string, error) { return c.calibrationAction("cancel") }
 
 func (c *Client) Schedule(cronExpr string) ([]time.Time, error) {
-	body, err := c.Put("/schedule", strconv.Quote(cronExpr))
-	if err != nil {
+	var ret api.Schedule
+	if err := c.do("PUT", "/v1/calibration/schedule", api.Schedule{Cron: cronExpr}, &ret); err != nil {
+		if isLegacyDaemon(err) {
+			return c.legacySchedule(cronExpr)
+		}
 		return nil, pkgerrors.Wrapf(err, "failed to set cron expression")
 	}
-	var resp scheduleResponse
-	if err := json.Unmarshal([]byte(body), &resp); err != nil {
-		return nil, pkgerrors.Wrapf(err, "failed to read next runs")
-	}
-	return resp.NextRuns, nil
+	return ret.NextRuns, nil
 }
 
 func (c *Client) PostponeSchedule(d time.Duration) (string, error) {
-	return c.Put("/schedule/postpone", strconv.Quote(d.String()))
+	err := c.do("POST", "/v1/calibration/schedule/postpone", api.DurationRequest{Duration: d.String()}, nil)
+	if isLegacyDaemon(err) {
+		_, err = c.Put("/schedule/postpone", strconv.Quote(d.String()))
+	}
+	return "", err
 }
 
 func (c *Client) SkipSchedule() (string, error) {
-	return c.Put("/schedule/skip", "")
+	err := c.do("POST", "/v1/calibration/schedule/skip", nil, nil)
+	if isLegacyDaemon(err) {
+		_, err = c.Put("/schedule/skip", "")
+	}
+	return "", err
+}
+
+func (c *Client) setCalibrationSettings(s api.CalibrationSettings) (string, error) {
+	var ret api.CalibrationSettings
+	err := c.do("PUT", "/v1/calibration/settings", s, &ret)
+	if isLegacyDaemon(err) {
+		return c.setLegacyCalibrationSettings(s)
+	}
+	return ret.Message, err
 }
 
 func (c *Client) SetCalibrationDischargeThreshold(threshold int) (string, error) {
-	return c.Put("/calibration/discharge-threshold", strconv.Itoa(threshold))
+	return c.setCalibrationSettings(api.CalibrationSettings{DischargeThreshold: &threshold})
 }
 
 func (c *Client) SetCalibrationHoldDurationMinutes(minutes int) (string, error) {
-	return c.Put("/calibration/hold-duration", strconv.Itoa(minutes))
+	return c.setCalibrationSettings(api.CalibrationSettings{HoldDurationMinutes: &minutes})
+}
+
+// GetHistory returns recorded battery history between from and to. Zero
+// times use the daemon defaults (the last 24 hours). If step is positive,
+// samples are aggregated into buckets of that size.
+func (c *Client) GetHistory(from, to time.Time, step time.Duration) ([]history.Sample, error) {
+	q := url.Values{}
+	if !from.IsZero() {
+		q.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.Format(time.RFC3339))
+	}
+	if step > 0 {
+		q.Set("step", step.String())
+	}
+	path := "/v1/history"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	ret, err := c.Get(path)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to get battery history")
+	}
+	var samples []history.Sample
+	if err := json.Unmarshal([]byte(ret), &samples); err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to unmarshal battery history")
+	}
+	return samples, nil
+}
+
+// GetAudit returns audit entries recorded at or after since, at most limit
+// of the newest ones. A zero since or limit does not restrict the entries.
+func (c *Client) GetAudit(since time.Time, limit int) ([]audit.Entry, error) {
+	q := url.Values{}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339))
+	}
+	q.Set("limit", strconv.Itoa(limit))
+	path := "/v1/audit?" + q.Encode()
+
+	var entries []audit.Entry
+	if err := c.do("GET", path, nil, &entries); err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to get audit log")
+	}
+	return entries, nil
 }
 
-func parseBoolResponse(resp string) (bool, error) {
-	switch resp {
-	case "true":
-		return true, nil
-	case "false":
-		return false, nil
-	default:
-		return false, pkgerrors.Errorf("unexpected response: %s", resp)
+// GetExplanation returns why the last maintain loop left charging enabled or
+// disabled.
+func (c *Client) GetExplanation() (*api.Explanation, error) {
+	var e api.Explanation
+	if err := c.do("GET", "/v1/explain", nil, &e); err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to get explanation")
 	}
+	return &e, nil
+}
+
+func (c *Client) GetAdaptiveStatus() (*adaptive.Status, error) {
+	ret, err := c.Get("/v1/adaptive")
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to get adaptive charging status")
+	}
+
+	var st adaptive.Status
+	if err := json.Unmarshal([]byte(ret), &st); err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to unmarshal adaptive charging status")
+	}
+
+	return &st, nil
+}
+
+func (c *Client) SetAdaptiveCharging(enabled bool) (string, error) {
+	return c.updateSettings(api.Settings{AdaptiveCharging: &enabled})
+}
+
+func (c *Client) ResetAdaptiveModel() (string, error) {
+	return "", c.do("POST", "/v1/adaptive/reset", nil, nil)
+}
+
+func (c *Client) GetWebhooks() ([]webhook.Status, error) {
+	ret, err := c.Get("/v1/webhooks")
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to get webhooks")
+	}
+
+	var st []webhook.Status
+	if err := json.Unmarshal([]byte(ret), &st); err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to unmarshal webhooks")
+	}
+
+	return st, nil
+}
+
+func (c *Client) SetWebhooks(webhooks []config.Webhook) (string, error) {
+	return "", c.do("PUT", "/v1/webhooks", webhooks, nil)
 }
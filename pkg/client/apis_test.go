@@ -1,12 +1,14 @@
 package client
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/charlie0129/batt/pkg/api"
 	"github.com/charlie0129/batt/pkg/compatibility"
 )
 
@@ -18,34 +20,38 @@ func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error)
 
 func TestDisableAdapterFor(t *testing.T) {
 	client := &Client{httpClient: &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
-		if request.Method != http.MethodPut {
-			t.Fatalf("method = %q, want PUT", request.Method)
+		if request.Method != http.MethodPost {
+			t.Fatalf("method = %q, want POST", request.Method)
 		}
-		if request.URL.Path != "/adapter/disable" {
-			t.Fatalf("path = %q, want /adapter/disable", request.URL.Path)
+		if request.URL.Path != "/v1/adapter/disable" {
+			t.Fatalf("path = %q, want /v1/adapter/disable", request.URL.Path)
 		}
 		body, err := io.ReadAll(request.Body)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if got := string(body); got != `"2h0m0s"` {
-			t.Fatalf("body = %q, want quoted duration", got)
+		if got := string(body); got != `{"duration":"2h0m0s"}` {
+			t.Fatalf("body = %q, want duration request", got)
 		}
 		return &http.Response{
-			StatusCode: http.StatusCreated,
-			Body:       io.NopCloser(strings.NewReader(`"ok"`)),
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"enabled":false,"message":"adapter disabled"}`)),
 			Header:     make(http.Header),
 		}, nil
 	})}}
 
-	if _, err := client.DisableAdapterFor(2 * time.Hour); err != nil {
+	msg, err := client.DisableAdapterFor(2 * time.Hour)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if msg != "adapter disabled" {
+		t.Fatalf("message = %q", msg)
+	}
 }
 
 func TestGetCompatibility(t *testing.T) {
 	client := &Client{httpClient: &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
-		if request.URL.Path != "/compatibility" {
+		if request.URL.Path != "/v1/compatibility" {
 			t.Fatalf("path = %q", request.URL.Path)
 		}
 		return &http.Response{
@@ -70,3 +76,136 @@ func TestGetCompatibility(t *testing.T) {
 		t.Fatalf("unexpected compatibility: %+v", got)
 	}
 }
+
+func TestGetHistory(t *testing.T) {
+	from := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	client := &Client{httpClient: &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		if request.URL.Path != "/v1/history" {
+			t.Fatalf("path = %q", request.URL.Path)
+		}
+		q := request.URL.Query()
+		if q.Get("from") != "2025-01-02T03:04:05Z" || q.Has("to") || q.Get("step") != "10m0s" {
+			t.Fatalf("unexpected query: %s", request.URL.RawQuery)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`[{"ts":1735787045,"charge":80,"pluggedIn":true,"charging":false,"adapterEnabled":true,"systemPower":7.5,"batteryPower":0}]`)),
+			Header:     make(http.Header),
+		}, nil
+	})}}
+
+	got, err := client.GetHistory(from, time.Time{}, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Charge != 80 || !got[0].PluggedIn || got[0].SystemPower != 7.5 {
+		t.Fatalf("unexpected history: %+v", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		status int
+		body   string
+		want   error
+		msg    string
+	}{
+		{"envelope", http.StatusConflict, `{"code":"calibration_conflict","message":"calibration in progress"}`, ErrCalibrationConflict, "calibration in progress"},
+		{"capability", http.StatusNotImplemented, `{"code":"capability_missing","message":"not supported","details":{"feature":"calibration"}}`, ErrCapabilityMissing, "not supported"},
+		{"permission", http.StatusForbidden, `{"code":"permission_denied","message":"uid 501 is not allowed to change settings"}`, ErrPermissionDenied, "uid 501 is not allowed to change settings"},
+		{"legacy", http.StatusBadRequest, `"limit must be between 10 and 100"`, ErrInvalidArgument, "limit must be between 10 and 100"},
+		{"precondition", http.StatusPreconditionFailed, `{"code":"precondition_failed","message":"the config has changed since it was read","details":{"etag":"\"x-2\""}}`, ErrPreconditionFailed, "the config has changed since it was read"},
+		{"legacy conflict", http.StatusConflict, `"charging control is not supported"`, ErrConflict, "charging control is not supported"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{httpClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: tt.status,
+					Body:       io.NopCloser(strings.NewReader(tt.body)),
+					Header:     make(http.Header),
+				}, nil
+			})}}
+
+			_, err := client.SetLimit(50)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			var e *api.Error
+			if !errors.As(err, &e) || e.Message != tt.msg {
+				t.Fatalf("err = %#v, want message %q", err, tt.msg)
+			}
+		})
+	}
+}
+
+// legacyDaemon answers like a daemon that predates /v1: 404 for every route
+// not in routes.
+func legacyDaemon(t *testing.T, routes map[string]func(body string) (int, string)) *Client {
+	t.Helper()
+	return &Client{httpClient: &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		status, resp := http.StatusNotFound, "404 page not found"
+		if route, ok := routes[request.Method+" "+request.URL.Path]; ok {
+			var body []byte
+			if request.Body != nil {
+				var err error
+				if body, err = io.ReadAll(request.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			status, resp = route(string(body))
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(strings.NewReader(resp)),
+			Header:     make(http.Header),
+		}, nil
+	})}}
+}
+
+func TestLegacyDaemonFallback(t *testing.T) {
+	reply := func(status int, body string) func(string) (int, string) {
+		return func(string) (int, string) { return status, body }
+	}
+	var limitBody string
+	client := legacyDaemon(t, map[string]func(string) (int, string){
+		"PUT /limit": func(body string) (int, string) {
+			limitBody = body
+			return http.StatusCreated, `"set upper/lower charging limit to 70%/68%"`
+		},
+		"GET /version":                  reply(http.StatusOK, `"v0.5.0"`),
+		"GET /config":                   reply(http.StatusOK, `{"limit":70,"lowerLimitDelta":2,"preventIdleSleep":true}`),
+		"GET /current-charge":           reply(http.StatusOK, `65`),
+		"GET /plugged-in":               reply(http.StatusOK, `true`),
+		"GET /charging-control-capable": reply(http.StatusOK, `true`),
+		"GET /battery-info":             reply(http.StatusOK, `{"State":1,"DesignCapacity":5000}`),
+		"GET /charging":                 reply(http.StatusConflict, `"direct charging state is not available in firmware charge-control mode"`),
+		"PUT /schedule":                 reply(http.StatusOK, `{"ok":true,"next_runs":["2026-11-01T10:00:00Z"]}`),
+	})
+
+	msg, err := client.SetLimit(70)
+	if err != nil || msg != "set upper/lower charging limit to 70%/68%" || limitBody != "70" {
+		t.Fatalf("SetLimit = %q, %v with body %q", msg, err, limitBody)
+	}
+	if v, err := client.GetVersion(); err != nil || v != "v0.5.0" {
+		t.Fatalf("GetVersion = %q, %v", v, err)
+	}
+	if l, err := client.GetLimit(); err != nil || l.Upper != 70 || l.Lower != 68 {
+		t.Fatalf("GetLimit = %+v, %v", l, err)
+	}
+	if conf, etag, err := client.GetConfigWithETag(); err != nil || etag != "" || !*conf.PreventIdleSleep {
+		t.Fatalf("GetConfigWithETag = %+v, %q, %v", conf, etag, err)
+	}
+	b, err := client.GetBattery()
+	if err != nil || b.Charge != 65 || !b.PluggedIn || !b.Charging || b.Info == nil {
+		t.Fatalf("GetBattery = %+v, %v", b, err)
+	}
+	if runs, err := client.Schedule("0 10 1 * *"); err != nil || len(runs) != 1 {
+		t.Fatalf("Schedule = %v, %v", runs, err)
+	}
+
+	// Routes older daemons never had still fail.
+	if _, err := client.GetExplanation(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetExplanation err = %v, want ErrNotFound", err)
+	}
+}
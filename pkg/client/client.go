@@ -4,6 +4,7 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -55,6 +56,13 @@ func NewClientWithTimeout(socketPath string, timeout time.Duration) *Client {
 
 // Send is a method for sending a request to the batt daemon
 func (c *Client) Send(method string, path string, data string) (string, error) {
+	body, _, err := c.send(method, path, data, nil)
+	return body, err
+}
+
+// send sends a request with extra headers and returns the response body and
+// headers.
+func (c *Client) send(method string, path string, data string, header http.Header) (string, http.Header, error) {
 	logrus.WithFields(logrus.Fields{
 		"method": method,
 		"path":   path,
@@ -66,23 +74,27 @@ func (c *Client) Send(method string, path string, data string) (string, error) {
 	var err error
 	url := "http://unix" + path
 
+	var body io.Reader
 	switch method {
 	case "GET":
-		resp, err = c.httpClient.Get(url)
-	case "POST":
-		resp, err = c.httpClient.Post(url, "application/octet-stream", strings.NewReader(data))
-	case "PUT":
-		req, err2 := http.NewRequest("PUT", url, strings.NewReader(data))
-		if err2 != nil {
-			return "", fmt.Errorf("failed to create request: %w", err2)
-		}
-		resp, err = c.httpClient.Do(req)
+	case "POST", "PUT", "PATCH":
+		body = strings.NewReader(data)
 	default:
-		return "", fmt.Errorf("unknown method: %s", method)
+		return "", nil, fmt.Errorf("unknown method: %s", method)
 	}
-
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err = c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return "", nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
 	defer func() {
@@ -93,20 +105,43 @@ func (c *Client) Send(method string, path string, data string) (string, error) {
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return "", nil, fmt.Errorf("failed to read response body: %w", err)
 	}
-	body := string(b)
 
 	// To handle the case where the daemon is so outdated that it doesn't know about a certain path.
 	if resp.StatusCode == http.StatusNotFound {
-		return "", ErrNotFound
+		return "", resp.Header, ErrNotFound
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return "", fmt.Errorf("got %d: %s", resp.StatusCode, body)
+		return "", resp.Header, decodeError(resp.StatusCode, b)
 	}
 
-	return body, nil
+	return string(b), resp.Header, nil
+}
+
+// do sends in as JSON, if not nil, and decodes the response into out, if
+// not nil.
+func (c *Client) do(method, path string, in, out any) error {
+	var data string
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+	ret, err := c.Send(method, path, data)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(ret), out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
 }
 
 // Get is a method for sending a GET request to the batt daemon
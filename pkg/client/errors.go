@@ -1,14 +1,61 @@
 package client
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/charlie0129/batt/pkg/api"
+)
 
 var (
 	// ErrDaemonNotRunning is returned when the daemon is not running
 	ErrDaemonNotRunning = errors.New("daemon not running")
 
-	// ErrPermissionDenied is returned when the user does not have permission to perform the requested action
-	ErrPermissionDenied = errors.New("permission denied")
+	// ErrPermissionDenied is returned when the user does not have permission to perform the requested action,
+	// either because the socket is not accessible or because the daemon denied the request
+	ErrPermissionDenied = &api.Error{Code: api.CodePermissionDenied, Message: "permission denied"}
 
 	// ErrNotFound is returned when 404 is returned from the daemon
 	ErrNotFound = errors.New("404 not found")
 )
+
+// Errors reported by the daemon are *api.Error. Match them with errors.Is,
+// for example errors.Is(err, ErrCalibrationConflict).
+var (
+	ErrInvalidArgument     = api.ErrInvalidArgument
+	ErrCapabilityMissing   = api.ErrCapabilityMissing
+	ErrCalibrationConflict = api.ErrCalibrationConflict
+	ErrConflict            = api.ErrConflict
+	ErrPreconditionFailed  = api.ErrPreconditionFailed
+	ErrUnavailable         = api.ErrUnavailable
+	ErrInternal            = api.ErrInternal
+)
+
+// decodeError turns an error response into an *api.Error. Legacy routes
+// respond with a bare message, which is classified by its status code.
+func decodeError(status int, body []byte) error {
+	var e api.Error
+	if err := json.Unmarshal(body, &e); err == nil && e.Code != "" {
+		return &e
+	}
+
+	var msg string
+	if err := json.Unmarshal(body, &msg); err != nil {
+		msg = string(body)
+	}
+	code := api.CodeInternal
+	switch status {
+	case http.StatusBadRequest:
+		code = api.CodeInvalidArgument
+	case http.StatusForbidden:
+		code = api.CodePermissionDenied
+	case http.StatusConflict:
+		code = api.CodeConflict
+	case http.StatusPreconditionFailed:
+		code = api.CodePreconditionFailed
+	case http.StatusServiceUnavailable:
+		code = api.CodeUnavailable
+	}
+	return &api.Error{Code: code, Message: msg}
+}
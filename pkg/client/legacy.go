@@ -0,0 +1,203 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/powerinfo"
+)
+
+// A daemon keeps running its old version after an upgrade until it is
+// restarted, and daemons older than /v1 answer 404 there. The methods below
+// do what the /v1 calls do on the unversioned routes those daemons serve.
+
+// isLegacyDaemon reports whether err means the daemon does not serve the
+// /v1 route that was called.
+func isLegacyDaemon(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// sendLegacy sends a request to an unversioned route and returns the bare
+// message it answers with.
+func (c *Client) sendLegacy(method, path, data string) (string, error) {
+	ret, err := c.Send(method, path, data)
+	if err != nil {
+		return "", err
+	}
+	var msg string
+	if err := json.Unmarshal([]byte(ret), &msg); err != nil {
+		return ret, nil
+	}
+	return msg, nil
+}
+
+func (c *Client) putLegacyJSON(path string, v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return c.sendLegacy("PUT", path, string(b))
+}
+
+func (c *Client) getLegacyBool(path string) (bool, error) {
+	ret, err := c.Get(path)
+	if err != nil {
+		return false, err
+	}
+	return parseBoolResponse(ret)
+}
+
+func parseBoolResponse(resp string) (bool, error) {
+	switch resp {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, pkgerrors.Errorf("unexpected response: %s", resp)
+	}
+}
+
+func (c *Client) getLegacyLimit() (*api.Limit, error) {
+	conf, err := c.getLegacyConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Fill in the defaults of the settings older daemons leave out.
+	f := config.NewFileFromConfig(&conf.RawFileConfig, "")
+	l := api.Limit{Upper: f.UpperLimit(), Lower: f.LowerLimit(), DisabledUntil: conf.DisableUntil}
+	l.EffectiveUpper, l.EffectiveLower = l.Upper, l.Lower
+	if conf.PreDisableLimit != nil {
+		l.RestoreLimit = *conf.PreDisableLimit
+	}
+	return &l, nil
+}
+
+func (c *Client) getLegacyConfig() (*api.Config, error) {
+	ret, err := c.Get("/config")
+	if err != nil {
+		return nil, err
+	}
+	var conf api.Config
+	if err := json.Unmarshal([]byte(ret), &conf); err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to unmarshal config")
+	}
+	return &conf, nil
+}
+
+// updateLegacySettings sets each setting in s through its own route.
+func (c *Client) updateLegacySettings(s api.Settings) (*api.Settings, error) {
+	if s.AdaptiveCharging != nil {
+		return nil, ErrNotFound
+	}
+	var msgs []string
+	for _, setting := range []struct {
+		path  string
+		set   bool
+		value any
+	}{
+		{"/prevent-idle-sleep", s.PreventIdleSleep != nil, s.PreventIdleSleep},
+		{"/disable-charging-pre-sleep", s.DisableChargingPreSleep != nil, s.DisableChargingPreSleep},
+		{"/prevent-system-sleep", s.PreventSystemSleep != nil, s.PreventSystemSleep},
+		{"/magsafe-led", s.ControlMagSafeLED != nil, s.ControlMagSafeLED},
+	} {
+		if !setting.set {
+			continue
+		}
+		msg, err := c.putLegacyJSON(setting.path, setting.value)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	s.Message = strings.Join(msgs, "\n")
+	return &s, nil
+}
+
+func (c *Client) setLegacyCalibrationSettings(s api.CalibrationSettings) (string, error) {
+	var msgs []string
+	put := func(path string, v *int) error {
+		if v == nil {
+			return nil
+		}
+		msg, err := c.putLegacyJSON(path, *v)
+		msgs = append(msgs, msg)
+		return err
+	}
+	if err := put("/calibration/discharge-threshold", s.DischargeThreshold); err != nil {
+		return "", err
+	}
+	if err := put("/calibration/hold-duration", s.HoldDurationMinutes); err != nil {
+		return "", err
+	}
+	return strings.Join(msgs, "\n"), nil
+}
+
+func (c *Client) getLegacyBattery() (*api.Battery, error) {
+	ret, err := c.Get("/current-charge")
+	if err != nil {
+		return nil, err
+	}
+	charge, err := strconv.Atoi(ret)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to unmarshal current charge")
+	}
+	b := api.Battery{Charge: charge}
+	if b.PluggedIn, err = c.getLegacyBool("/plugged-in"); err != nil {
+		return nil, err
+	}
+	if b.ChargingControlCapable, err = c.getLegacyBool("/charging-control-capable"); err != nil {
+		return nil, err
+	}
+	if ret, err := c.Get("/battery-info"); err == nil {
+		var info powerinfo.Battery
+		if err := json.Unmarshal([]byte(ret), &info); err == nil {
+			b.Info = &info
+		}
+	}
+
+	charging, err := c.getLegacyBool("/charging")
+	switch {
+	case err == nil:
+		b.Charging = charging
+	case errors.Is(err, ErrConflict) && b.Info != nil:
+		// Outside legacy charge-control mode /charging is refused, and
+		// /v1/battery reports whether the battery is charging instead.
+		b.Charging = b.Info.State == powerinfo.Charging
+	default:
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (c *Client) getLegacyVersion() (string, error) {
+	ret, err := c.sendLegacy("GET", "/version", "")
+	if err != nil {
+		return "", pkgerrors.Wrapf(err, "failed to get version")
+	}
+	return ret, nil
+}
+
+// legacyScheduleResponse is the response of PUT /schedule.
+type legacyScheduleResponse struct {
+	NextRuns []time.Time `json:"next_runs"`
+}
+
+func (c *Client) legacySchedule(cronExpr string) ([]time.Time, error) {
+	ret, err := c.Put("/schedule", strconv.Quote(cronExpr))
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to set cron expression")
+	}
+	var resp legacyScheduleResponse
+	if err := json.Unmarshal([]byte(ret), &resp); err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to read next runs")
+	}
+	return resp.NextRuns, nil
+}
@@ -0,0 +1,126 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when it is told to. Its timers fire when
+// the time is moved past their deadline.
+type Fake struct {
+	mu      sync.Mutex
+	changed *sync.Cond // signaled when a timer is started
+	now     time.Time
+	timers  []*fakeTimer
+}
+
+// NewFake returns a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now returns the time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTimer returns a timer that fires once the clock has moved by d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to now and fires the timers due by then, earliest
+// first. The clock never moves back.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if now.After(f.now) {
+		f.now = now
+	}
+	due := slices.DeleteFunc(slices.Clone(f.timers), func(t *fakeTimer) bool { return t.deadline.After(f.now) })
+	slices.SortStableFunc(due, func(a, b *fakeTimer) int { return a.deadline.Compare(b.deadline) })
+	for _, t := range due {
+		t.fire()
+	}
+}
+
+// WaitForTimers blocks until n timers are waiting to fire. Tests use it to
+// know that a goroutine is waiting for the clock before moving it.
+func (f *Fake) WaitForTimers(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.timers) < n {
+		f.changed.Wait()
+	}
+}
+
+// WaitForTimer blocks until a timer is waiting to fire at deadline.
+func (f *Fake) WaitForTimer(deadline time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for !slices.ContainsFunc(f.timers, func(t *fakeTimer) bool { return t.deadline.Equal(deadline) }) {
+		f.changed.Wait()
+	}
+}
+
+type fakeTimer struct {
+	f        *Fake
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.stop()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	active := t.stop()
+	t.deadline = t.f.now.Add(d)
+	if d <= 0 {
+		t.fire()
+		return active
+	}
+	t.f.timers = append(t.f.timers, t)
+	t.f.changed.Broadcast()
+	return active
+}
+
+// stop removes the timer from the clock and drops a time it has sent but
+// that was not received, like time.Timer does. f.mu must be held.
+func (t *fakeTimer) stop() bool {
+	select {
+	case <-t.c:
+	default:
+	}
+	n := len(t.f.timers)
+	t.f.timers = slices.DeleteFunc(t.f.timers, func(other *fakeTimer) bool { return other == t })
+	return len(t.f.timers) < n
+}
+
+// fire sends the deadline and removes the timer from the clock. f.mu must be
+// held.
+func (t *fakeTimer) fire() {
+	t.f.timers = slices.DeleteFunc(t.f.timers, func(other *fakeTimer) bool { return other == t })
+	select {
+	case t.c <- t.deadline:
+	default:
+	}
+}
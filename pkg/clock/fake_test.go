@@ -0,0 +1,62 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTimers(t *testing.T) {
+	start := time.Date(2026, 7, 20, 9, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	late, early := f.NewTimer(2*time.Minute), f.NewTimer(time.Minute)
+	stopped := f.NewTimer(time.Minute)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop() did not report the timer as active once")
+	}
+
+	f.Advance(30 * time.Second)
+	select {
+	case <-early.C():
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+
+	f.Advance(time.Hour)
+	if got := f.Now(); !got.Equal(start.Add(time.Hour + 30*time.Second)) {
+		t.Fatalf("Now() = %s", got)
+	}
+	if got := <-early.C(); !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("early timer fired at %s", got)
+	}
+	if got := <-late.C(); !got.Equal(start.Add(2 * time.Minute)) {
+		t.Fatalf("late timer fired at %s", got)
+	}
+	select {
+	case <-stopped.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	// A reset drops the time that was sent but not received.
+	f.Advance(time.Minute)
+	if late.Reset(time.Second) {
+		t.Fatal("Reset() reported a fired timer as active")
+	}
+	late.Reset(0)
+	if got := <-late.C(); !got.Equal(f.Now()) {
+		t.Fatalf("timer reset to 0 fired at %s, want now", got)
+	}
+}
+
+func TestFakeWaitForTimers(t *testing.T) {
+	f := NewFake(time.Now())
+	fired := make(chan time.Time)
+	go func() {
+		fired <- <-f.NewTimer(time.Second).C()
+	}()
+
+	f.WaitForTimers(1)
+	f.WaitForTimer(f.Now().Add(time.Second))
+	f.Advance(time.Second)
+	<-fired
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of a config file.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// Formats lists the supported config file formats.
+var Formats = []Format{FormatJSON, FormatYAML, FormatTOML}
+
+// FormatOf returns the format of the config file at path by its extension:
+// .yaml or .yml for YAML, .toml for TOML and JSON for anything else.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// ParseFormat parses the name of a format, as used in file extensions.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.TrimPrefix(strings.ToLower(s), ".")); f {
+	case FormatJSON, FormatYAML, FormatTOML:
+		return f, nil
+	case "yml":
+		return FormatYAML, nil
+	}
+	return "", fmt.Errorf("unknown config format %q, must be one of %v", s, Formats)
+}
+
+// codec converts config files in one format from and to JSON, so that
+// RawFileConfig and its JSON field names stay the only definition of the
+// config, and migrations work on every format.
+type codec interface {
+	// toJSON converts the content of a config file to JSON. It returns nil
+	// for a file without settings.
+	toJSON(b []byte) ([]byte, error)
+	// fromJSON converts JSON to the content of a config file. prev is the
+	// current content of the file, if any, whose comments are kept where
+	// possible.
+	fromJSON(b, prev []byte) ([]byte, error)
+}
+
+var codecs = map[Format]codec{
+	FormatJSON: jsonCodec{},
+	FormatYAML: yamlCodec{},
+	FormatTOML: tomlCodec{},
+}
+
+// Unmarshal decodes the content of a config file in format f and migrates
+// it to CurrentVersion. It returns the config and the version b had.
+func Unmarshal(f Format, b []byte) (*RawFileConfig, int, error) {
+	j, err := codecs[f].toJSON(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	return Migrate(j)
+}
+
+// Marshal encodes c as the content of a config file in format f. The
+// comments in prev, the current content of the file, are kept where
+// possible.
+func Marshal(f Format, c *RawFileConfig, prev []byte) ([]byte, error) {
+	b, err := Encode(c)
+	if err != nil {
+		return nil, err
+	}
+	return codecs[f].fromJSON(b, prev)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) toJSON(b []byte) ([]byte, error) { return b, nil }
+
+// JSON has no comments to keep.
+func (jsonCodec) fromJSON(b, _ []byte) ([]byte, error) { return b, nil }
+
+type yamlCodec struct{}
+
+func (yamlCodec) toJSON(b []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func (yamlCodec) fromJSON(b, prev []byte) ([]byte, error) {
+	// JSON is YAML, so this keeps the order of the fields.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	blockStyle(&doc)
+
+	var old yaml.Node
+	if len(prev) > 0 && yaml.Unmarshal(prev, &old) == nil {
+		copyComments(&doc, &old)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle turns the flow style and quoted strings of a YAML tree parsed
+// from JSON into the usual block style. Strings are still quoted where they
+// would be read as another type.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// copyComments copies the comments of the nodes in src to the nodes at the
+// same place in dst.
+func copyComments(dst, src *yaml.Node) {
+	dst.HeadComment, dst.LineComment, dst.FootComment = src.HeadComment, src.LineComment, src.FootComment
+	if dst.Kind != src.Kind {
+		return
+	}
+	switch dst.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for i := range min(len(dst.Content), len(src.Content)) {
+			copyComments(dst.Content[i], src.Content[i])
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			for j := 0; j+1 < len(src.Content); j += 2 {
+				if dst.Content[i].Value == src.Content[j].Value {
+					copyComments(dst.Content[i], src.Content[j])
+					copyComments(dst.Content[i+1], src.Content[j+1])
+					break
+				}
+			}
+		}
+	}
+}
+
+type tomlCodec struct{}
+
+func (tomlCodec) toJSON(b []byte) ([]byte, error) {
+	var v map[string]any
+	if err := toml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func (tomlCodec) fromJSON(b, prev []byte) ([]byte, error) {
+	v, err := decodeOrdered(b)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := v.(object)
+	if !ok {
+		return nil, fmt.Errorf("config is not an object")
+	}
+	w := &tomlWriter{comments: tomlComments(prev)}
+	w.table(nil, "", root)
+	if c := w.comments[tomlFootKey]; c != "" {
+		w.buf.WriteString("\n" + c)
+	}
+	return w.buf.Bytes(), nil
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/utils/ptr"
+)
+
+// fullConfig sets every field of RawFileConfig that is stored in the file.
+func fullConfig() *RawFileConfig {
+	return &RawFileConfig{
+		Version:                        ptr.To(CurrentVersion),
+		Limit:                          ptr.To(70),
+		PreventIdleSleep:               ptr.To(false),
+		DisableChargingPreSleep:        ptr.To(true),
+		PreventSystemSleep:             ptr.To(true),
+		AllowNonRootAccess:             ptr.To(false),
+		LowerLimitDelta:                ptr.To(5),
+		ControlMagSafeLED:              ptr.To(ControlMagSafeModeAlwaysOff),
+		CalibrationDischargeThreshold:  ptr.To(20),
+		CalibrationHoldDurationMinutes: ptr.To(90),
+		Cron:                           ptr.To("0 10 1 * *"),
+		DisableUntil:                   ptr.To(time.Date(2026, time.July, 21, 12, 30, 0, 0, time.UTC)),
+		PreDisableLimit:                ptr.To(80),
+		AdapterDisableUntil:            ptr.To(time.Date(2026, time.July, 21, 13, 0, 0, 0, time.FixedZone("UTC+8", 8*60*60))),
+		MetricsPort:                    ptr.To(9101),
+		LimitProfiles: []LimitProfile{
+			{Name: "desk", Limit: 60, Schedule: "0 9 * * MON-FRI", Duration: "9h"},
+			{Name: "travel", Limit: 100, Schedule: "0 18 * * SUN", Duration: "3h"},
+		},
+		AdaptiveCharging:       ptr.To(true),
+		MaxChargingTemperature: ptr.To(40.5),
+		TemperatureHysteresis:  ptr.To(3.0),
+		TemperatureGuardLimit:  ptr.To(50),
+		Hooks: map[string][]string{
+			"charging.limit_reached": {`osascript -e 'display notification "Charge limit reached" with title "batt"'`},
+			"power.unplugged":        {"/usr/local/bin/a", "/usr/local/bin/b # not a comment"},
+		},
+		Webhooks: []Webhook{
+			{URL: "https://example.com/hook?a=1&b=2", Secret: "s3cret", Events: []string{"power.plugged"}},
+			{URL: "http://localhost:8123/api/webhook/batt"},
+		},
+		MQTT: &MQTT{Broker: "tls://broker:8883", Username: "batt", Password: "p#ss\"word", DisableDiscovery: true},
+		Access: &Access{
+			Read:   AccessRule{Users: []string{"*"}},
+			Mutate: AccessRule{Users: []string{"alice", "501"}, Groups: []string{"admin"}},
+		},
+	}
+}
+
+func TestFormatsRoundTrip(t *testing.T) {
+	want, err := Encode(fullConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range Formats {
+		t.Run(string(f), func(t *testing.T) {
+			b, err := Marshal(f, fullConfig(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, from, err := Unmarshal(f, b)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v, content:\n%s", err, b)
+			}
+			if from != CurrentVersion {
+				t.Fatalf("Unmarshal() found version %d", from)
+			}
+			got, err := Encode(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Fatalf("round trip through %s:\n%s\ngave:\n%s\nwant:\n%s", f, b, got, want)
+			}
+
+			// Files without settings are the empty config.
+			c, from, err = Unmarshal(f, nil)
+			if err != nil || from != CurrentVersion || !isEmpty(c) {
+				t.Fatalf("Unmarshal() of an empty file = %+v, %d, %v", c, from, err)
+			}
+		})
+	}
+}
+
+func isEmpty(c *RawFileConfig) bool {
+	b, _ := Encode(c)
+	empty, _ := Encode(&RawFileConfig{})
+	return string(b) == string(empty)
+}
+
+func TestFormatsKeepComments(t *testing.T) {
+	for _, tt := range []struct {
+		format   Format
+		prev     string
+		comments []string
+	}{
+		{
+			format: FormatYAML,
+			prev: `# batt config, managed by my dotfiles
+
+# Keep the battery healthy.
+limit: 80 # percent
+mqtt:
+  # The broker in the closet.
+  broker: tcp://broker:1883
+webhooks:
+  - url: https://example.com/hook # primary
+`,
+			comments: []string{"# batt config, managed by my dotfiles", "# Keep the battery healthy.", "# percent", "# The broker in the closet.", "# primary"},
+		},
+		{
+			format: FormatTOML,
+			prev: `# batt config, managed by my dotfiles
+
+# Keep the battery healthy.
+limit = 80
+
+# The broker in the closet.
+[mqtt]
+# Plain TCP on the LAN.
+broker = "tcp://broker:1883"
+
+# The primary webhook.
+[[webhooks]]
+url = "https://example.com/hook"
+
+# The end.
+`,
+			comments: []string{"# batt config, managed by my dotfiles", "# Keep the battery healthy.", "# The broker in the closet.", "# Plain TCP on the LAN.", "# The primary webhook.", "# The end."},
+		},
+	} {
+		t.Run(string(tt.format), func(t *testing.T) {
+			c, _, err := Unmarshal(tt.format, []byte(tt.prev))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Limit = ptr.To(70)
+			c.LowerLimitDelta = ptr.To(5)
+
+			b, err := Marshal(tt.format, c, []byte(tt.prev))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, comment := range tt.comments {
+				if !strings.Contains(string(b), comment) {
+					t.Errorf("comment %q lost:\n%s", comment, b)
+				}
+			}
+			got, _, err := Unmarshal(tt.format, b)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v, content:\n%s", err, b)
+			}
+			if *got.Limit != 70 || *got.LowerLimitDelta != 5 || got.MQTT.Broker != "tcp://broker:1883" || len(got.Webhooks) != 1 {
+				t.Fatalf("config after saving with comments = %+v", got)
+			}
+		})
+	}
+}
+
+func TestNewFileFormats(t *testing.T) {
+	for _, name := range []string{"batt.json", "batt.yaml", "batt.yml", "batt.toml", "batt.conf"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			configured, err := NewFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := configured.SetUpperLimit(70); err != nil {
+				t.Fatal(err)
+			}
+			configured.SetWebhooks(fullConfig().Webhooks)
+			if err := configured.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := Unmarshal(FormatOf(path), b); err != nil {
+				t.Fatalf("file is not %s: %v\n%s", FormatOf(path), err, b)
+			}
+			reloaded, err := NewFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reloaded.UpperLimit() != 70 || len(reloaded.Webhooks()) != 2 {
+				t.Fatalf("reloaded limit = %d, webhooks = %v", reloaded.UpperLimit(), reloaded.Webhooks())
+			}
+		})
+	}
+}
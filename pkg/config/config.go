@@ -20,9 +20,21 @@ type Config interface {
 	DisableUntil() time.Time
 	PreDisableLimit() int
 	AdapterDisableUntil() time.Time
+	MetricsPort() int
+	LimitProfiles() []LimitProfile
+	AdaptiveCharging() bool
+	MaxChargingTemperature() float64
+	TemperatureHysteresis() float64
+	TemperatureGuardLimit() int
+	Hooks() map[string][]string
+	Webhooks() []Webhook
+	MQTT() MQTT
+	Access() *Access
 
-	SetUpperLimit(int)
-	SetLowerLimit(int)
+	// SetUpperLimit, SetLowerLimit and SetDisableTimer return an error and
+	// change nothing if the value is out of range.
+	SetUpperLimit(int) error
+	SetLowerLimit(int) error
 	SetPreventIdleSleep(bool)
 	SetDisableChargingPreSleep(bool)
 	SetPreventSystemSleep(bool)
@@ -31,14 +43,26 @@ type Config interface {
 	SetCron(string)
 	SetCalibrationDischargeThreshold(int)
 	SetCalibrationHoldDurationMinutes(int)
-	SetDisableTimer(time.Time, int)
+	SetDisableTimer(time.Time, int) error
 	ClearDisableTimer()
 	SetAdapterDisableTimer(time.Time)
+	SetAdaptiveCharging(bool)
+	SetWebhooks([]Webhook)
 	ClearAdapterDisableTimer()
 
+	// Revision increases whenever the configuration changes.
+	Revision() uint64
+	// Raw returns a copy of the configuration without defaults, and its
+	// revision.
+	Raw() (*RawFileConfig, uint64)
+	// CompareAndSwap replaces the configuration with c if it is still at
+	// revision, and reports whether it did.
+	CompareAndSwap(revision uint64, c *RawFileConfig) bool
+
 	LogrusFields() logrus.Fields
 
-	// Load reads the configuration from the source.
+	// Load reads the configuration from the source. It keeps the current
+	// configuration if the new one is not valid.
 	Load() error
 	// Save saves the configuration to the source.
 	Save() error
@@ -2,15 +2,19 @@ package config
 
 import (
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
-	"strings"
+	"reflect"
+	"slices"
 	"sync"
 	"time"
 
 	pkgerrors "github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/charlie0129/batt/pkg/persist"
 	"github.com/charlie0129/batt/pkg/utils/ptr"
 )
 
@@ -44,6 +48,10 @@ var (
 		// explicitly enables this feature. In the future, we might add a check
 		// that disables this feature if the Mac does not have a MagSafe LED.
 		ControlMagSafeLED: ptr.To(ControlMagSafeModeDisabled),
+
+		AdaptiveCharging: ptr.To(false),
+
+		TemperatureHysteresis: ptr.To(3.0),
 	}
 )
 
@@ -53,12 +61,27 @@ type File struct {
 	c        *RawFileConfig
 	mu       *sync.RWMutex
 	filepath string
+	// revision counts changes to c, including loads.
+	revision uint64
+
+	// diskMu guards disk, and is held while f reads or writes its file.
+	diskMu sync.Mutex
+	disk   diskState
+	// readOpts are used for every load of the file.
+	readOpts persist.ReadOptions
 }
 
 func NewFile(configPath string) (*File, error) {
+	return NewFileWithOptions(configPath, persist.ReadOptions{})
+}
+
+// NewFileWithOptions is NewFile, with opts used whenever the file is loaded,
+// including reloads.
+func NewFileWithOptions(configPath string, opts persist.ReadOptions) (*File, error) {
 	f := &File{
 		filepath: configPath,
 		mu:       &sync.RWMutex{},
+		readOpts: opts,
 	}
 	err := f.Load()
 	if err != nil {
@@ -82,20 +105,12 @@ func NewFileFromConfig(c *RawFileConfig, configPath string) *File {
 	return f
 }
 
+// UnmarshalJSON also accepts the booleans of old config files. Unknown modes
+// are kept as they are, for Validate to report.
 func (c *ControlMagSafeMode) UnmarshalJSON(data []byte) error {
 	var s string
 	if err := json.Unmarshal(data, &s); err == nil {
-		switch s {
-		case ctrlMagSafeModeEnabledStr:
-			*c = ControlMagSafeModeEnabled
-		case ctrlMagSafeModeDisabledStr:
-			*c = ControlMagSafeModeDisabled
-		case ctrlMagSafeModeAlwaysOffStr:
-			*c = ControlMagSafeModeAlwaysOff
-		default:
-			logrus.Warnf("invalid ControlMagSafeMode %q, falling back to %q", s, ControlMagSafeModeDisabled)
-			*c = ControlMagSafeModeDisabled
-		}
+		*c = ControlMagSafeMode(s)
 		return nil
 	}
 	var b bool
@@ -107,10 +122,66 @@ func (c *ControlMagSafeMode) UnmarshalJSON(data []byte) error {
 		}
 		return nil
 	}
-	return nil
+	return fmt.Errorf("invalid ControlMagSafeMode %s: expected a string", data)
+}
+
+// LimitProfile is a named charge limit that applies during recurring time
+// windows. Each window starts at a time matched by Schedule, a cron
+// expression such as "0 9 * * MON-FRI", and lasts for Duration, e.g. "9h".
+type LimitProfile struct {
+	Name     string `json:"name"`
+	Limit    int    `json:"limit"`
+	Schedule string `json:"schedule"`
+	Duration string `json:"duration"`
+}
+
+// Webhook is an HTTP endpoint that receives daemon events as JSON POSTs.
+// Deliveries are signed with Secret if set. Events limits the endpoint to
+// these event names; empty means all.
+type Webhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// Access restricts which local users may use the daemon API. Read grants
+// the read-only endpoints and Mutate grants all of them. root and the user
+// running the daemon always have full access. Without Access, anyone who
+// can open the socket has full access.
+type Access struct {
+	Read   AccessRule `json:"read"`
+	Mutate AccessRule `json:"mutate"`
+}
+
+// AccessRule matches users by name or uid and groups by name or gid. "*"
+// in Users matches everyone.
+type AccessRule struct {
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// MQTT configures publishing battery state to an MQTT broker, e.g. for Home
+// Assistant. It is disabled when Broker is empty.
+type MQTT struct {
+	// Broker is "host:port", "tcp://host:port" or "tls://host:port".
+	Broker   string `json:"broker"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// ClientID defaults to "batt-<hostname>".
+	ClientID string `json:"clientId,omitempty"`
+	// TopicPrefix defaults to "batt/<hostname>".
+	TopicPrefix string `json:"topicPrefix,omitempty"`
+	// DiscoveryPrefix is the Home Assistant discovery prefix, by default
+	// "homeassistant".
+	DiscoveryPrefix  string `json:"discoveryPrefix,omitempty"`
+	DisableDiscovery bool   `json:"disableDiscovery,omitempty"`
 }
 
 type RawFileConfig struct {
+	// Version is the version of the file format, see CurrentVersion. Older
+	// files are migrated when they are loaded.
+	Version *int `json:"version,omitempty"`
+
 	Limit                   *int                `json:"limit,omitempty"`
 	PreventIdleSleep        *bool               `json:"preventIdleSleep,omitempty"`
 	DisableChargingPreSleep *bool               `json:"disableChargingPreSleep,omitempty"`
@@ -127,6 +198,84 @@ type RawFileConfig struct {
 	PreDisableLimit *int       `json:"preDisableLimit,omitempty"`
 
 	AdapterDisableUntil *time.Time `json:"adapterDisableUntil,omitempty"`
+
+	// MetricsPort additionally serves /metrics on this localhost TCP port.
+	MetricsPort *int `json:"metricsPort,omitempty"`
+
+	// LimitProfiles override Limit while one of their windows is active.
+	// The first matching profile wins.
+	LimitProfiles []LimitProfile `json:"limitProfiles,omitempty"`
+
+	// AdaptiveCharging tops the battery up to 100% shortly before the
+	// learned unplug time.
+	AdaptiveCharging *bool `json:"adaptiveCharging,omitempty"`
+
+	// MaxChargingTemperature enables the temperature guard. Above this
+	// battery temperature (°C), charging is paused or limited to
+	// TemperatureGuardLimit until the battery has cooled down by
+	// TemperatureHysteresis.
+	MaxChargingTemperature *float64 `json:"maxChargingTemperature,omitempty"`
+	TemperatureHysteresis  *float64 `json:"temperatureHysteresis,omitempty"`
+	TemperatureGuardLimit  *int     `json:"temperatureGuardLimit,omitempty"`
+
+	// Hooks maps event names (see pkg/events) to shell commands the daemon
+	// runs when the event is published.
+	Hooks map[string][]string `json:"hooks,omitempty"`
+
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+
+	MQTT *MQTT `json:"mqtt,omitempty"`
+
+	Access *Access `json:"access,omitempty"`
+}
+
+// DeepCopy returns a copy of c that shares no memory with it.
+func (c *RawFileConfig) DeepCopy() *RawFileConfig {
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(pkgerrors.Wrap(err, "failed to copy config"))
+	}
+	out := &RawFileConfig{}
+	if err := json.Unmarshal(b, out); err != nil {
+		panic(pkgerrors.Wrap(err, "failed to copy config"))
+	}
+	return out
+}
+
+// HasSecrets reports whether c has settings that other users must not read:
+// webhook secrets and the MQTT password.
+func (c *RawFileConfig) HasSecrets() bool {
+	if c.MQTT != nil && c.MQTT.Password != "" {
+		return true
+	}
+	for _, w := range c.Webhooks {
+		if w.Secret != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteFile writes b, the encoded c, to the config file at path with
+// persist.WriteFile. If c has secrets, the file and its backup are only
+// accessible by their owner.
+func WriteFile(path string, b []byte, c *RawFileConfig) error {
+	if c.HasSecrets() {
+		return persist.WritePrivateFile(path, b)
+	}
+	return persist.WriteFile(path, b, 0644)
+}
+
+// Merge sets every field of c that is set in patch. Lists and maps are
+// replaced as a whole, so an empty list in patch clears the one in c.
+func (c *RawFileConfig) Merge(patch *RawFileConfig) {
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(patch).Elem()
+	for i := range src.NumField() {
+		if !src.Field(i).IsNil() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
 }
 
 func NewRawFileConfigFromConfig(c Config) (*RawFileConfig, error) {
@@ -143,6 +292,7 @@ func NewRawFileConfigFromConfig(c Config) (*RawFileConfig, error) {
 		LowerLimitDelta:         ptr.To(c.UpperLimit() - c.LowerLimit()),
 		ControlMagSafeLED:       ptr.To(c.ControlMagSafeLED()),
 		Cron:                    ptr.To(c.Cron()),
+		AdaptiveCharging:        ptr.To(c.AdaptiveCharging()),
 	}
 
 	if until := c.DisableUntil(); !until.IsZero() {
@@ -152,6 +302,29 @@ func NewRawFileConfigFromConfig(c Config) (*RawFileConfig, error) {
 	if until := c.AdapterDisableUntil(); !until.IsZero() {
 		rawConfig.AdapterDisableUntil = ptr.To(until)
 	}
+	if port := c.MetricsPort(); port != 0 {
+		rawConfig.MetricsPort = ptr.To(port)
+	}
+	if profiles := c.LimitProfiles(); len(profiles) > 0 {
+		rawConfig.LimitProfiles = profiles
+	}
+	if hooks := c.Hooks(); len(hooks) > 0 {
+		rawConfig.Hooks = hooks
+	}
+	if webhooks := c.Webhooks(); len(webhooks) > 0 {
+		rawConfig.Webhooks = webhooks
+	}
+	if m := c.MQTT(); m.Broker != "" {
+		rawConfig.MQTT = &m
+	}
+	rawConfig.Access = c.Access()
+	if temp := c.MaxChargingTemperature(); temp > 0 {
+		rawConfig.MaxChargingTemperature = ptr.To(temp)
+		rawConfig.TemperatureHysteresis = ptr.To(c.TemperatureHysteresis())
+		if limit := c.TemperatureGuardLimit(); limit > 0 {
+			rawConfig.TemperatureGuardLimit = ptr.To(limit)
+		}
+	}
 
 	return rawConfig, nil
 }
@@ -270,6 +443,8 @@ func (f *File) AllowNonRootAccess() bool {
 	return allowNonRootAccess
 }
 
+// ControlMagSafeLED returns the MagSafe LED mode. Unknown modes, which
+// Validate reports, mean the default.
 func (f *File) ControlMagSafeLED() ControlMagSafeMode {
 	if f.c == nil {
 		panic("config is nil")
@@ -280,7 +455,7 @@ func (f *File) ControlMagSafeLED() ControlMagSafeMode {
 
 	var ControlMagSafeLED ControlMagSafeMode
 
-	if f.c.ControlMagSafeLED != nil {
+	if f.c.ControlMagSafeLED != nil && f.c.ControlMagSafeLED.Valid() {
 		ControlMagSafeLED = *f.c.ControlMagSafeLED
 	} else {
 		ControlMagSafeLED = *defaultFileConfig.ControlMagSafeLED
@@ -332,34 +507,59 @@ func (f *File) CalibrationHoldDurationMinutes() int {
 	return val
 }
 
-func (f *File) SetUpperLimit(i int) {
+// SetUpperLimit sets the upper limit, keeping the gap to the lower limit. It
+// returns an error and changes nothing if the lower limit would be negative.
+func (f *File) SetUpperLimit(i int) error {
 	if f.c == nil {
 		panic("config is nil")
 	}
 
-	delta := f.UpperLimit() - f.LowerLimit()
+	if err := checkUpperLimit(i, f.UpperLimit()-f.LowerLimit()); err != nil {
+		return err
+	}
+	f.setUpperLimit(i)
+	return nil
+}
+
+func checkUpperLimit(i, delta int) error {
 	if i > 100 || i-delta < 0 {
-		panic("upper limit must be between 0 and 100 and greater than lower limit")
+		return fmt.Errorf("upper limit must be at most 100 and at least the lower limit delta %d, got %d", delta, i)
 	}
+	return nil
+}
 
+func (f *File) setUpperLimit(i int) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 	f.c.Limit = &i
 }
 
-func (f *File) SetLowerLimit(i int) {
+// SetLowerLimit sets the lower limit by the gap to the upper limit. It
+// returns an error and changes nothing unless 0 <= i < the upper limit.
+func (f *File) SetLowerLimit(i int) error {
 	if f.c == nil {
 		panic("config is nil")
 	}
 
-	if i < 0 || i >= f.UpperLimit() {
-		panic("lower limit must be between 0 and upper limit")
+	if err := checkLowerLimit(i, f.UpperLimit()); err != nil {
+		return err
 	}
+	f.setLowerLimitDelta(f.UpperLimit() - i)
+	return nil
+}
 
-	delta := f.UpperLimit() - i
+func checkLowerLimit(i, upper int) error {
+	if i < 0 || i >= upper {
+		return fmt.Errorf("lower limit must be at least 0 and less than the upper limit %d, got %d", upper, i)
+	}
+	return nil
+}
 
+func (f *File) setLowerLimitDelta(delta int) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 	f.c.LowerLimitDelta = &delta
 }
 
@@ -370,6 +570,7 @@ func (f *File) SetPreventIdleSleep(b bool) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 	f.c.PreventIdleSleep = &b
 }
 
@@ -380,6 +581,7 @@ func (f *File) SetDisableChargingPreSleep(b bool) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 	f.c.DisableChargingPreSleep = &b
 }
 
@@ -390,6 +592,7 @@ func (f *File) SetPreventSystemSleep(b bool) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 	f.c.PreventSystemSleep = &b
 }
 
@@ -400,6 +603,7 @@ func (f *File) SetAllowNonRootAccess(b bool) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 
 	f.c.AllowNonRootAccess = &b
 }
@@ -411,6 +615,7 @@ func (f *File) SetControlMagSafeLED(mode ControlMagSafeMode) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 
 	f.c.ControlMagSafeLED = ptr.To(mode)
 }
@@ -439,6 +644,7 @@ func (f *File) SetCron(cron string) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 
 	f.c.Cron = ptr.To(cron)
 }
@@ -450,6 +656,7 @@ func (f *File) SetCalibrationDischargeThreshold(i int) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 
 	f.c.CalibrationDischargeThreshold = &i
 }
@@ -461,6 +668,7 @@ func (f *File) SetCalibrationHoldDurationMinutes(i int) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 
 	f.c.CalibrationHoldDurationMinutes = &i
 }
@@ -496,25 +704,28 @@ func (f *File) PreDisableLimit() int {
 }
 
 // SetDisableTimer records that the upper limit must be restored to prevLimit
-// at the given time. Use ClearDisableTimer to drop it.
-func (f *File) SetDisableTimer(until time.Time, prevLimit int) {
+// at the given time. Use ClearDisableTimer to drop it. It returns an error
+// and changes nothing if until is zero or prevLimit is not a valid limit.
+func (f *File) SetDisableTimer(until time.Time, prevLimit int) error {
 	if f.c == nil {
 		panic("config is nil")
 	}
 
 	if until.IsZero() {
-		panic("disable deadline must not be zero")
+		return errors.New("disable deadline must not be zero")
 	}
 
 	if prevLimit < 10 || prevLimit > 100 {
-		panic("limit to restore must be between 10 and 100")
+		return fmt.Errorf("limit to restore must be between 10 and 100, got %d", prevLimit)
 	}
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 
 	f.c.DisableUntil = ptr.To(until)
 	f.c.PreDisableLimit = ptr.To(prevLimit)
+	return nil
 }
 
 func (f *File) ClearDisableTimer() {
@@ -524,6 +735,7 @@ func (f *File) ClearDisableTimer() {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 
 	f.c.DisableUntil = nil
 	f.c.PreDisableLimit = nil
@@ -556,6 +768,7 @@ func (f *File) SetAdapterDisableTimer(until time.Time) {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 	f.c.AdapterDisableUntil = ptr.To(until)
 }
 
@@ -566,51 +779,324 @@ func (f *File) ClearAdapterDisableTimer() {
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.revision++
 	f.c.AdapterDisableUntil = nil
 }
 
+// MetricsPort returns the localhost TCP port /metrics is served on, or 0 if
+// it is only served on the unix socket. Out-of-range values are treated as 0.
+func (f *File) MetricsPort() int {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.c.MetricsPort == nil {
+		return 0
+	}
+	val := *f.c.MetricsPort
+	if val < 1 || val > 65535 {
+		return 0
+	}
+	return val
+}
+
+// LimitProfiles returns a copy of the configured limit profiles.
+func (f *File) LimitProfiles() []LimitProfile {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.c.LimitProfiles) == 0 {
+		return nil
+	}
+	profiles := make([]LimitProfile, len(f.c.LimitProfiles))
+	copy(profiles, f.c.LimitProfiles)
+	return profiles
+}
+
+func (f *File) AdaptiveCharging() bool {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var adaptiveCharging bool
+
+	if f.c.AdaptiveCharging != nil {
+		adaptiveCharging = *f.c.AdaptiveCharging
+	} else {
+		adaptiveCharging = *defaultFileConfig.AdaptiveCharging
+	}
+
+	return adaptiveCharging
+}
+
+func (f *File) SetAdaptiveCharging(b bool) {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revision++
+	f.c.AdaptiveCharging = &b
+}
+
+// MaxChargingTemperature returns the battery temperature (°C) above which the
+// temperature guard activates, or 0 if the guard is disabled.
+func (f *File) MaxChargingTemperature() float64 {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.c.MaxChargingTemperature == nil || *f.c.MaxChargingTemperature < 0 {
+		return 0
+	}
+	return *f.c.MaxChargingTemperature
+}
+
+// TemperatureHysteresis returns how many degrees the battery must cool down
+// below MaxChargingTemperature before the guard releases.
+func (f *File) TemperatureHysteresis() float64 {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var hysteresis float64
+
+	if f.c.TemperatureHysteresis != nil && *f.c.TemperatureHysteresis > 0 {
+		hysteresis = *f.c.TemperatureHysteresis
+	} else {
+		hysteresis = *defaultFileConfig.TemperatureHysteresis
+	}
+
+	return hysteresis
+}
+
+// TemperatureGuardLimit returns the charge limit enforced while the
+// temperature guard is active, or 0 to pause charging instead.
+func (f *File) TemperatureGuardLimit() int {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.c.TemperatureGuardLimit == nil {
+		return 0
+	}
+	limit := *f.c.TemperatureGuardLimit
+	if limit < 10 || limit > 100 {
+		return 0
+	}
+	return limit
+}
+
+// Hooks returns a copy of the configured event hooks.
+func (f *File) Hooks() map[string][]string {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.c.Hooks) == 0 {
+		return nil
+	}
+	hooks := make(map[string][]string, len(f.c.Hooks))
+	for event, commands := range f.c.Hooks {
+		hooks[event] = slices.Clone(commands)
+	}
+	return hooks
+}
+
+// Webhooks returns a copy of the configured webhooks.
+func (f *File) Webhooks() []Webhook {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.c.Webhooks) == 0 {
+		return nil
+	}
+	webhooks := make([]Webhook, len(f.c.Webhooks))
+	for i, w := range f.c.Webhooks {
+		w.Events = slices.Clone(w.Events)
+		webhooks[i] = w
+	}
+	return webhooks
+}
+
+func (f *File) SetWebhooks(webhooks []Webhook) {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revision++
+	f.c.Webhooks = slices.Clone(webhooks)
+}
+
+// MQTT returns the MQTT settings. Defaults that depend on the host name are
+// left to the daemon.
+func (f *File) MQTT() MQTT {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.c.MQTT == nil {
+		return MQTT{}
+	}
+	m := *f.c.MQTT
+	if m.DiscoveryPrefix == "" {
+		m.DiscoveryPrefix = "homeassistant"
+	}
+	return m
+}
+
+// Access returns a copy of the access control list, or nil if there is
+// none.
+func (f *File) Access() *Access {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.c.Access == nil {
+		return nil
+	}
+	a := *f.c.Access
+	for _, r := range []*AccessRule{&a.Read, &a.Mutate} {
+		r.Users = slices.Clone(r.Users)
+		r.Groups = slices.Clone(r.Groups)
+	}
+	return &a
+}
+
+// Revision returns a number that increases whenever the configuration
+// changes, either through a setter or by loading it again.
+func (f *File) Revision() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.revision
+}
+
+// Raw returns a deep copy of the configuration as read from the file,
+// without defaults, and its revision.
+func (f *File) Raw() (*RawFileConfig, uint64) {
+	if f.c == nil {
+		panic("config is nil")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.c.DeepCopy(), f.revision
+}
+
+// CompareAndSwap replaces the configuration with a copy of c if it is still
+// at revision, and reports whether it did. Use it with Raw to change several
+// fields at once without losing concurrent changes.
+func (f *File) CompareAndSwap(revision uint64, c *RawFileConfig) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.revision != revision {
+		return false
+	}
+	f.c = c.DeepCopy()
+	f.revision++
+	return true
+}
+
+// Load reads the config file, migrating it if it is from an older version.
+// A missing file is the empty config. Once a config is loaded, an invalid
+// file does not replace it: Load returns an error wrapping FieldErrors
+// instead.
 func (f *File) Load() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	fp, err := os.Open(f.filepath)
+	f.diskMu.Lock()
+	defer f.diskMu.Unlock()
+	// Changes from here on are picked up by the next load.
+	f.remember()
+
+	var (
+		conf *RawFileConfig
+		old  []byte
+		from int
+	)
+	err := persist.ReadFile(f.filepath, func(b []byte) error {
+		// An empty file gives the empty config.
+		var err error
+		conf, from, err = Unmarshal(FormatOf(f.filepath), b)
+		old = b
+		return err
+	}, f.readOpts)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			// If the file does not exist, return the empty config.
 			// Do not make f.c a nil.
 			f.c = &RawFileConfig{}
+			f.revision++
 			return nil
 		}
-		return pkgerrors.Wrapf(err, "failed to open file %s", f.filepath)
-	}
-	defer func(fp *os.File) {
-		err := fp.Close()
-		if err != nil {
-			logrus.Warnf("failed to close file %s", f.filepath)
+		return pkgerrors.Wrapf(err, "failed to load config from file %s", f.filepath)
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		// Keep a working config rather than applying a broken one. There is
+		// none on the first load, which takes the file as it is so that
+		// the daemon can start.
+		if f.c != nil {
+			return pkgerrors.Wrapf(FieldErrors(errs), "config file %s is not valid, keeping the current config", f.filepath)
+		}
+		for _, e := range errs {
+			logrus.WithField("path", e.Path).Warnf("invalid setting in config file %s: %s", f.filepath, e.Reason)
 		}
-	}(fp)
-
-	// Since we want to tell if the file is empty, using json.Decoder will
-	// not work.
-	b, err := io.ReadAll(fp)
-	if err != nil {
-		return pkgerrors.Wrapf(err, "failed to read file %s", f.filepath)
-	}
-	configString := string(b)
-
-	if strings.TrimSpace(configString) == "" {
-		// If the file is empty, return the empty config.
-		// Do not make f.c a nil.
-		f.c = &RawFileConfig{}
-		return nil
 	}
-
-	conf := RawFileConfig{}
-	err = json.Unmarshal(b, &conf)
-	if err != nil {
-		return pkgerrors.Wrapf(err, "failed to unmarshal config from file %s", f.filepath)
+	if from < CurrentVersion {
+		backup := MigrationBackupPath(f.filepath, from)
+		if err := WriteMigrated(f.filepath, old, conf, from); err != nil {
+			logrus.WithError(err).Warn("failed to write migrated config, it will be migrated again on the next load")
+		} else {
+			f.remember()
+			logrus.WithFields(logrus.Fields{
+				"from":   from,
+				"to":     CurrentVersion,
+				"backup": backup,
+			}).Info("migrated config")
+		}
+	} else if from > CurrentVersion {
+		logrus.Warnf("config file %s is version %d, newer than the supported version %d; unknown settings are ignored", f.filepath, from, CurrentVersion)
 	}
-	f.c = &conf
+	f.c = conf
+	f.revision++
 
 	return nil
 }
@@ -623,23 +1109,19 @@ func (f *File) Save() error {
 		return pkgerrors.New("config is nil")
 	}
 
-	fp, err := os.OpenFile(f.filepath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	// Keep the comments of YAML and TOML files.
+	prev, _ := os.ReadFile(f.filepath)
+	b, err := Marshal(FormatOf(f.filepath), f.c, prev)
 	if err != nil {
-		return pkgerrors.Wrapf(err, "failed to open file %s", f.filepath)
+		return pkgerrors.Wrapf(err, "failed to encode config to file %s", f.filepath)
 	}
-	defer func(fp *os.File) {
-		err := fp.Close()
-		if err != nil {
-			logrus.Warnf("failed to close file %s", f.filepath)
-		}
-	}(fp)
-
-	enc := json.NewEncoder(fp)
-	enc.SetIndent("", "  ")
-	err = enc.Encode(f.c)
+	f.diskMu.Lock()
+	defer f.diskMu.Unlock()
+	err = WriteFile(f.filepath, b, f.c)
 	if err != nil {
-		return pkgerrors.Wrapf(err, "failed to encode config to file %s", f.filepath)
+		return pkgerrors.Wrapf(err, "failed to save config to file %s", f.filepath)
 	}
+	f.remember()
 
 	return nil
 }
@@ -657,5 +1139,13 @@ func (f *File) LogrusFields() logrus.Fields {
 		"preventSystemSleep":      f.PreventSystemSleep(),
 		"allowNonRootAccess":      f.AllowNonRootAccess(),
 		"controlMagsafeLed":       f.ControlMagSafeLED(),
+		"metricsPort":             f.MetricsPort(),
+		"limitProfiles":           len(f.LimitProfiles()),
+		"adaptiveCharging":        f.AdaptiveCharging(),
+		"maxChargingTemperature":  f.MaxChargingTemperature(),
+		"hooks":                   len(f.Hooks()),
+		"webhooks":                len(f.Webhooks()),
+		"mqttBroker":              f.MQTT().Broker,
+		"accessControl":           f.Access() != nil,
 	}
 }
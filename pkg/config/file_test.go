@@ -1,9 +1,13 @@
 package config
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/charlie0129/batt/pkg/persist"
+	"github.com/charlie0129/batt/pkg/utils/ptr"
 )
 
 func TestAdapterDisableTimerPersists(t *testing.T) {
@@ -38,3 +42,133 @@ func TestAdapterDisableTimerPersists(t *testing.T) {
 		t.Fatalf("AdapterDisableUntil() after clear = %s, want zero", got)
 	}
 }
+
+func TestMetricsPort(t *testing.T) {
+	tests := []struct {
+		port int
+		want int
+	}{
+		{port: 9101, want: 9101},
+		{port: 0, want: 0},
+		{port: 70000, want: 0},
+	}
+	for _, tt := range tests {
+		c := NewFileFromConfig(&RawFileConfig{MetricsPort: &tt.port}, "")
+		if got := c.MetricsPort(); got != tt.want {
+			t.Errorf("MetricsPort() with %d = %d, want %d", tt.port, got, tt.want)
+		}
+	}
+
+	raw, err := NewRawFileConfigFromConfig(NewFileFromConfig(&RawFileConfig{}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.MetricsPort != nil {
+		t.Fatalf("MetricsPort = %d, want it omitted when disabled", *raw.MetricsPort)
+	}
+}
+
+func TestRevisionCompareAndSwap(t *testing.T) {
+	configured := NewFileFromConfig(&RawFileConfig{
+		Limit:         ptr.To(80),
+		LimitProfiles: []LimitProfile{{Name: "work", Limit: 60, Schedule: "0 9 * * 1-5", Duration: "8h"}},
+	}, "")
+
+	raw, rev := configured.Raw()
+	raw.Merge(&RawFileConfig{Limit: ptr.To(70), LimitProfiles: []LimitProfile{}})
+	if configured.UpperLimit() != 80 {
+		t.Fatal("Raw() did not return a copy")
+	}
+
+	configured.SetPreventIdleSleep(false)
+	if configured.Revision() == rev {
+		t.Fatal("revision did not change after a setter")
+	}
+	if configured.CompareAndSwap(rev, raw) {
+		t.Fatal("CompareAndSwap succeeded with a stale revision")
+	}
+
+	raw, rev = configured.Raw()
+	raw.Merge(&RawFileConfig{Limit: ptr.To(70), LimitProfiles: []LimitProfile{}})
+	if !configured.CompareAndSwap(rev, raw) {
+		t.Fatal("CompareAndSwap failed with the current revision")
+	}
+	if configured.UpperLimit() != 70 || len(configured.LimitProfiles()) != 0 || configured.PreventIdleSleep() {
+		t.Fatalf("limit = %d, profiles = %v, preventIdleSleep = %t after CompareAndSwap",
+			configured.UpperLimit(), configured.LimitProfiles(), configured.PreventIdleSleep())
+	}
+}
+
+func TestSettersRejectOutOfRange(t *testing.T) {
+	configured := NewFileFromConfig(&RawFileConfig{Limit: ptr.To(80), LowerLimitDelta: ptr.To(60)}, "")
+	rev := configured.Revision()
+
+	if err := configured.SetUpperLimit(50); err == nil {
+		t.Fatal("SetUpperLimit(50) with a delta of 60 succeeded")
+	}
+	if err := configured.SetUpperLimit(101); err == nil {
+		t.Fatal("SetUpperLimit(101) succeeded")
+	}
+	if err := configured.SetLowerLimit(80); err == nil {
+		t.Fatal("SetLowerLimit at the upper limit succeeded")
+	}
+	if err := configured.SetDisableTimer(time.Time{}, 80); err == nil {
+		t.Fatal("SetDisableTimer with a zero deadline succeeded")
+	}
+	if err := configured.SetDisableTimer(time.Now(), 5); err == nil {
+		t.Fatal("SetDisableTimer with a limit of 5 succeeded")
+	}
+	if configured.Revision() != rev || configured.UpperLimit() != 80 || configured.LowerLimit() != 20 || !configured.DisableUntil().IsZero() {
+		t.Fatalf("config changed by rejected setters: revision %d -> %d, limits %d/%d",
+			rev, configured.Revision(), configured.UpperLimit(), configured.LowerLimit())
+	}
+}
+
+func TestSaveSecretsPrivately(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batt.json")
+	configured := NewFileFromConfig(&RawFileConfig{Limit: ptr.To(70)}, path)
+	if err := configured.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0644 {
+		t.Fatalf("config without secrets: %v, %v", fi, err)
+	}
+
+	configured.SetWebhooks([]Webhook{{URL: "https://example.com", Secret: "s"}})
+	if err := configured.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, path + persist.BackupSuffix} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Fatalf("%s has permissions %o with a webhook secret, want 600", p, perm)
+		}
+	}
+
+	if !(&RawFileConfig{MQTT: &MQTT{Broker: "b", Password: "p"}}).HasSecrets() {
+		t.Fatal("the MQTT password is not a secret")
+	}
+}
+
+func TestLoadFallsBackToBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batt.json")
+	configured := NewFileFromConfig(&RawFileConfig{Limit: ptr.To(70)}, path)
+	if err := configured.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a write torn by a power loss.
+	if err := os.WriteFile(path, []byte(`{"limit": 6`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.UpperLimit() != 70 {
+		t.Fatalf("UpperLimit() = %d, want 70 from the backup", reloaded.UpperLimit())
+	}
+}
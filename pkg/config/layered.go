@@ -0,0 +1,423 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Source is the layer of a Layered config a value comes from.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// EnvPrefix starts the names of the environment variables that override
+// settings, see EnvName.
+const EnvPrefix = "BATT_"
+
+// Origin tells where the effective value of a setting comes from.
+type Origin struct {
+	Source Source `json:"source"`
+	// Name is the environment variable or flag that set the value.
+	Name string `json:"name,omitempty"`
+}
+
+// notOverridable are the fields of RawFileConfig that are not settings,
+// or are state the daemon keeps in the file.
+var notOverridable = []string{"version", "disableUntil", "preDisableLimit", "adapterDisableUntil"}
+
+// OverridableSettings returns the JSON names of the settings that
+// environment variables and flags can override.
+func OverridableSettings() []string {
+	var names []string
+	t := reflect.TypeFor[RawFileConfig]()
+	for i := range t.NumField() {
+		if name := jsonName(t.Field(i)); !slices.Contains(notOverridable, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// EnvName returns the environment variable that overrides the setting with
+// the JSON name field, e.g. BATT_PREVENT_IDLE_SLEEP for preventIdleSleep.
+func EnvName(field string) string {
+	return EnvPrefix + strings.ToUpper(strings.Join(words(field), "_"))
+}
+
+// FlagName returns the flag of "batt daemon" that overrides the setting
+// with the JSON name field, without dashes, e.g. prevent-idle-sleep for
+// preventIdleSleep.
+func FlagName(field string) string {
+	return strings.ToLower(strings.Join(words(field), "-"))
+}
+
+// words splits a camel case name into words, keeping acronyms together:
+// controlMagSafeLED is control, Mag, Safe, LED.
+func words(name string) []string {
+	var (
+		ws    []string
+		start int
+	)
+	r := []rune(name)
+	for i := 1; i < len(r); i++ {
+		upper := unicode.IsUpper(r[i])
+		if upper && (!unicode.IsUpper(r[i-1]) || i+1 < len(r) && unicode.IsLower(r[i+1])) {
+			ws = append(ws, string(r[start:i]))
+			start = i
+		}
+	}
+	return append(ws, string(r[start:]))
+}
+
+// ParseSettings decodes settings given as text, keyed by their JSON names,
+// as they come from environment variables or flags. Strings and times are
+// taken as they are, booleans as understood by strconv.ParseBool, and
+// everything else as JSON. name returns what set a setting, for errors.
+func ParseSettings(values map[string]string, name func(field string) string) (*RawFileConfig, error) {
+	c := &RawFileConfig{}
+	v := reflect.ValueOf(c).Elem()
+	for i := range v.NumField() {
+		field := jsonName(v.Type().Field(i))
+		s, ok := values[field]
+		if !ok {
+			continue
+		}
+		if slices.Contains(notOverridable, field) {
+			return nil, fmt.Errorf("%s: %s cannot be overridden", name(field), field)
+		}
+		if err := parseSetting(v.Field(i), s); err != nil {
+			return nil, fmt.Errorf("%s: invalid value %q: %w", name(field), s, err)
+		}
+	}
+	for field := range values {
+		if _, ok := jsonField(v.Type(), field); !ok {
+			return nil, fmt.Errorf("%s: unknown setting %q", name(field), field)
+		}
+	}
+	return c, nil
+}
+
+func parseSetting(v reflect.Value, s string) error {
+	t := v.Type()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	b := []byte(s)
+	switch {
+	case t.Kind() == reflect.String, t == reflect.TypeFor[time.Time]():
+		b, _ = json.Marshal(s)
+	case t.Kind() == reflect.Bool:
+		on, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		b, _ = json.Marshal(on)
+	}
+	return json.Unmarshal(b, v.Addr().Interface())
+}
+
+// FromEnv returns the settings overridden by environment variables, see
+// EnvName. lookup is usually os.LookupEnv.
+func FromEnv(lookup func(string) (string, bool)) (*RawFileConfig, error) {
+	values := map[string]string{}
+	for _, field := range OverridableSettings() {
+		if s, ok := lookup(EnvName(field)); ok {
+			values[field] = s
+		}
+	}
+	return ParseSettings(values, EnvName)
+}
+
+var _ Config = &Layered{}
+
+// Layered is a Config made of layers: the defaults, the config file,
+// environment variables and command-line flags. Every set field of a layer
+// overrides the layers before it. Changes go to the file layer, which is the
+// only one that is loaded and saved; the other layers are fixed.
+type Layered struct {
+	file       *File
+	env, flags *RawFileConfig
+
+	mu sync.Mutex
+	// effective has the layers merged, as of file revision revision.
+	effective *File
+	revision  uint64
+}
+
+// NewLayered returns the Layered config of file, overridden by env and
+// flags. Both may be nil.
+func NewLayered(file *File, env, flags *RawFileConfig) *Layered {
+	if env == nil {
+		env = &RawFileConfig{}
+	}
+	if flags == nil {
+		flags = &RawFileConfig{}
+	}
+	return &Layered{file: file, env: env, flags: flags}
+}
+
+// Explain returns the origin of every setting by its JSON name, which is
+// the file or the defaults.
+func (f *File) Explain() map[string]Origin {
+	return NewLayered(f, nil, nil).Explain()
+}
+
+// merged returns the layers merged into a File, for the getters.
+func (l *Layered) merged() *File {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rev := l.file.Revision(); l.effective == nil || rev != l.revision {
+		c, rev := l.raw()
+		l.effective, l.revision = NewFileFromConfig(c, ""), rev
+	}
+	return l.effective
+}
+
+// raw returns the layers merged, without defaults, and the file revision.
+func (l *Layered) raw() (*RawFileConfig, uint64) {
+	c, rev := l.file.Raw()
+	overlay(c, l.env.DeepCopy())
+	overlay(c, l.flags.DeepCopy())
+	return c, rev
+}
+
+// overlay sets the fields of c that are set in over.
+func overlay(c, over *RawFileConfig) {
+	dst, src := reflect.ValueOf(c).Elem(), reflect.ValueOf(over).Elem()
+	for i := range src.NumField() {
+		if !src.Field(i).IsNil() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+// Explain returns the origin of every setting by its JSON name.
+func (l *Layered) Explain() map[string]Origin {
+	c, _ := l.file.Raw()
+	origins := map[string]Origin{}
+	t := reflect.TypeFor[RawFileConfig]()
+	for i := range t.NumField() {
+		field := jsonName(t.Field(i))
+		switch {
+		case field == "version":
+			continue
+		case isSet(l.flags, field):
+			origins[field] = Origin{Source: SourceFlag, Name: "--" + FlagName(field)}
+		case isSet(l.env, field):
+			origins[field] = Origin{Source: SourceEnv, Name: EnvName(field)}
+		case isSet(c, field):
+			origins[field] = Origin{Source: SourceFile}
+		default:
+			origins[field] = Origin{Source: SourceDefault}
+		}
+	}
+	return origins
+}
+
+func isSet(c *RawFileConfig, field string) bool {
+	v := reflect.ValueOf(c).Elem()
+	f, ok := jsonField(v.Type(), field)
+	return ok && !v.FieldByIndex(f.Index).IsNil()
+}
+
+// ValidateOverrides validates the effective config like
+// RawFileConfig.Validate, and returns an error for the invalid settings
+// that environment variables or flags override. Problems of the file are
+// left to whoever loads it.
+func (l *Layered) ValidateOverrides() error {
+	c, _ := l.raw()
+	var problems []string
+	for _, e := range c.Validate() {
+		// The setting of limitProfiles[0].name is limitProfiles.
+		field := e.Path
+		if i := strings.IndexAny(field, ".["); i >= 0 {
+			field = field[:i]
+		}
+		switch {
+		case isSet(l.flags, field):
+			problems = append(problems, "--"+FlagName(field)+": "+e.Error())
+		case isSet(l.env, field):
+			problems = append(problems, EnvName(field)+": "+e.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config override: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// changeFile warns when a change to the file layer has no effect, because
+// the setting is overridden.
+func (l *Layered) changeFile(field string) {
+	if by := l.overriddenBy(field); by != "" {
+		logrus.Warnf("%s is overridden by %s, the change is saved to the config file but has no effect", field, by)
+	}
+}
+
+// overriddenBy returns the flag or environment variable that overrides field,
+// or "" if the file decides it.
+func (l *Layered) overriddenBy(field string) string {
+	switch {
+	case isSet(l.flags, field):
+		return "--" + FlagName(field)
+	case isSet(l.env, field):
+		return EnvName(field)
+	}
+	return ""
+}
+
+func (l *Layered) UpperLimit() int               { return l.merged().UpperLimit() }
+func (l *Layered) LowerLimit() int               { return l.merged().LowerLimit() }
+func (l *Layered) PreventIdleSleep() bool        { return l.merged().PreventIdleSleep() }
+func (l *Layered) DisableChargingPreSleep() bool { return l.merged().DisableChargingPreSleep() }
+func (l *Layered) PreventSystemSleep() bool      { return l.merged().PreventSystemSleep() }
+func (l *Layered) AllowNonRootAccess() bool      { return l.merged().AllowNonRootAccess() }
+func (l *Layered) ControlMagSafeLED() ControlMagSafeMode {
+	return l.merged().ControlMagSafeLED()
+}
+func (l *Layered) CalibrationDischargeThreshold() int {
+	return l.merged().CalibrationDischargeThreshold()
+}
+func (l *Layered) CalibrationHoldDurationMinutes() int {
+	return l.merged().CalibrationHoldDurationMinutes()
+}
+func (l *Layered) Cron() string                    { return l.merged().Cron() }
+func (l *Layered) DisableUntil() time.Time         { return l.merged().DisableUntil() }
+func (l *Layered) PreDisableLimit() int            { return l.merged().PreDisableLimit() }
+func (l *Layered) AdapterDisableUntil() time.Time  { return l.merged().AdapterDisableUntil() }
+func (l *Layered) MetricsPort() int                { return l.merged().MetricsPort() }
+func (l *Layered) LimitProfiles() []LimitProfile   { return l.merged().LimitProfiles() }
+func (l *Layered) AdaptiveCharging() bool          { return l.merged().AdaptiveCharging() }
+func (l *Layered) MaxChargingTemperature() float64 { return l.merged().MaxChargingTemperature() }
+func (l *Layered) TemperatureHysteresis() float64  { return l.merged().TemperatureHysteresis() }
+func (l *Layered) TemperatureGuardLimit() int      { return l.merged().TemperatureGuardLimit() }
+func (l *Layered) Hooks() map[string][]string      { return l.merged().Hooks() }
+func (l *Layered) Webhooks() []Webhook             { return l.merged().Webhooks() }
+func (l *Layered) MQTT() MQTT                      { return l.merged().MQTT() }
+func (l *Layered) Access() *Access                 { return l.merged().Access() }
+
+// SetUpperLimit sets the limit in the file layer. It must fit the
+// lowerLimitDelta of the file, which may differ from the effective one if it
+// is overridden.
+func (l *Layered) SetUpperLimit(i int) error {
+	if err := l.file.SetUpperLimit(i); err != nil {
+		if by := l.overriddenBy("lowerLimitDelta"); by != "" {
+			return fmt.Errorf("%w in the config file, where lowerLimitDelta is not overridden by %s", err, by)
+		}
+		return err
+	}
+	l.changeFile("limit")
+	return nil
+}
+
+// SetLowerLimit keeps the gap between the effective upper limit and i in
+// the file layer. The gap must also fit the limit of the file.
+func (l *Layered) SetLowerLimit(i int) error {
+	upper := l.UpperLimit()
+	if err := checkLowerLimit(i, upper); err != nil {
+		return err
+	}
+	if err := checkUpperLimit(l.file.UpperLimit(), upper-i); err != nil {
+		return fmt.Errorf("lower limit delta %d does not fit the limit %d in the config file, which %s overrides", upper-i, l.file.UpperLimit(), l.overriddenBy("limit"))
+	}
+	l.changeFile("lowerLimitDelta")
+	l.file.setLowerLimitDelta(upper - i)
+	return nil
+}
+
+func (l *Layered) SetPreventIdleSleep(b bool) {
+	l.changeFile("preventIdleSleep")
+	l.file.SetPreventIdleSleep(b)
+}
+
+func (l *Layered) SetDisableChargingPreSleep(b bool) {
+	l.changeFile("disableChargingPreSleep")
+	l.file.SetDisableChargingPreSleep(b)
+}
+
+func (l *Layered) SetPreventSystemSleep(b bool) {
+	l.changeFile("preventSystemSleep")
+	l.file.SetPreventSystemSleep(b)
+}
+
+func (l *Layered) SetAllowNonRootAccess(b bool) {
+	l.changeFile("allowNonRootAccess")
+	l.file.SetAllowNonRootAccess(b)
+}
+
+func (l *Layered) SetControlMagSafeLED(m ControlMagSafeMode) {
+	l.changeFile("controlMagSafeLED")
+	l.file.SetControlMagSafeLED(m)
+}
+
+func (l *Layered) SetCron(s string) {
+	l.changeFile("cron")
+	l.file.SetCron(s)
+}
+
+func (l *Layered) SetCalibrationDischargeThreshold(i int) {
+	l.changeFile("calibrationDischargeThreshold")
+	l.file.SetCalibrationDischargeThreshold(i)
+}
+
+func (l *Layered) SetCalibrationHoldDurationMinutes(i int) {
+	l.changeFile("calibrationHoldDurationMinutes")
+	l.file.SetCalibrationHoldDurationMinutes(i)
+}
+
+func (l *Layered) SetDisableTimer(until time.Time, preDisableLimit int) error {
+	return l.file.SetDisableTimer(until, preDisableLimit)
+}
+
+func (l *Layered) ClearDisableTimer() { l.file.ClearDisableTimer() }
+
+func (l *Layered) SetAdapterDisableTimer(until time.Time) {
+	l.file.SetAdapterDisableTimer(until)
+}
+
+func (l *Layered) ClearAdapterDisableTimer() { l.file.ClearAdapterDisableTimer() }
+
+func (l *Layered) SetAdaptiveCharging(b bool) {
+	l.changeFile("adaptiveCharging")
+	l.file.SetAdaptiveCharging(b)
+}
+
+func (l *Layered) SetWebhooks(w []Webhook) {
+	l.changeFile("webhooks")
+	l.file.SetWebhooks(w)
+}
+
+// Revision is the revision of the file layer, the only one that changes.
+func (l *Layered) Revision() uint64 { return l.file.Revision() }
+
+// Raw returns the file layer, see File.Raw.
+func (l *Layered) Raw() (*RawFileConfig, uint64) { return l.file.Raw() }
+
+// CompareAndSwap replaces the file layer, see File.CompareAndSwap.
+func (l *Layered) CompareAndSwap(revision uint64, c *RawFileConfig) bool {
+	return l.file.CompareAndSwap(revision, c)
+}
+
+func (l *Layered) LogrusFields() logrus.Fields { return l.merged().LogrusFields() }
+
+// Load reloads the file layer.
+func (l *Layered) Load() error { return l.file.Load() }
+
+// Save saves the file layer. Overrides are not written to the file.
+func (l *Layered) Save() error { return l.file.Save() }
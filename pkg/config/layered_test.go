@@ -0,0 +1,178 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/utils/ptr"
+)
+
+func TestOverrideNames(t *testing.T) {
+	for _, tt := range []struct {
+		field, env, flag string
+	}{
+		{"limit", "BATT_LIMIT", "limit"},
+		{"preventIdleSleep", "BATT_PREVENT_IDLE_SLEEP", "prevent-idle-sleep"},
+		{"controlMagSafeLED", "BATT_CONTROL_MAG_SAFE_LED", "control-mag-safe-led"},
+		{"mqtt", "BATT_MQTT", "mqtt"},
+	} {
+		if got := EnvName(tt.field); got != tt.env {
+			t.Errorf("EnvName(%q) = %q, want %q", tt.field, got, tt.env)
+		}
+		if got := FlagName(tt.field); got != tt.flag {
+			t.Errorf("FlagName(%q) = %q, want %q", tt.field, got, tt.flag)
+		}
+	}
+}
+
+func TestParseSettings(t *testing.T) {
+	c, err := ParseSettings(map[string]string{
+		"limit":             "60",
+		"preventIdleSleep":  "0",
+		"controlMagSafeLED": "always-off",
+		"cron":              "0 10 1 * *",
+		"hooks":             `{"power.plugged": ["true"]}`,
+	}, EnvName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c.Limit != 60 || *c.PreventIdleSleep || *c.ControlMagSafeLED != ControlMagSafeModeAlwaysOff ||
+		*c.Cron != "0 10 1 * *" || len(c.Hooks["power.plugged"]) != 1 {
+		t.Fatalf("ParseSettings() = %+v", c)
+	}
+	if c.LowerLimitDelta != nil || c.Webhooks != nil {
+		t.Fatalf("ParseSettings() set settings that were not given: %+v", c)
+	}
+
+	for _, tt := range []struct {
+		values map[string]string
+		want   string
+	}{
+		{map[string]string{"limit": "sixty"}, "BATT_LIMIT"},
+		{map[string]string{"preventIdleSleep": "maybe"}, "BATT_PREVENT_IDLE_SLEEP"},
+		{map[string]string{"disableUntil": "2026-07-21T12:30:00Z"}, "cannot be overridden"},
+		{map[string]string{"limt": "60"}, "unknown setting"},
+	} {
+		if _, err := ParseSettings(tt.values, EnvName); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseSettings(%v) error = %v, want one with %q", tt.values, err, tt.want)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	env := map[string]string{"BATT_LIMIT": "50", "BATT_METRICS_PORT": "9101", "HOME": "/root"}
+	c, err := FromEnv(func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c.Limit != 50 || *c.MetricsPort != 9101 || c.PreventIdleSleep != nil {
+		t.Fatalf("FromEnv() = %+v", c)
+	}
+}
+
+func TestLayered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batt.json")
+	if err := os.WriteFile(path, []byte(`{"limit": 70, "cron": "0 10 1 * *", "preventIdleSleep": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &RawFileConfig{Limit: ptr.To(60), PreventIdleSleep: ptr.To(false)}
+	flags := &RawFileConfig{Limit: ptr.To(50)}
+	l := NewLayered(file, env, flags)
+
+	if l.UpperLimit() != 50 || l.PreventIdleSleep() || l.Cron() != "0 10 1 * *" || l.MetricsPort() != 0 {
+		t.Fatalf("UpperLimit() = %d, PreventIdleSleep() = %t, Cron() = %q", l.UpperLimit(), l.PreventIdleSleep(), l.Cron())
+	}
+
+	origins := l.Explain()
+	for field, want := range map[string]Origin{
+		"limit":            {Source: SourceFlag, Name: "--limit"},
+		"preventIdleSleep": {Source: SourceEnv, Name: "BATT_PREVENT_IDLE_SLEEP"},
+		"cron":             {Source: SourceFile},
+		"metricsPort":      {Source: SourceDefault},
+	} {
+		if origins[field] != want {
+			t.Errorf("Explain()[%q] = %+v, want %+v", field, origins[field], want)
+		}
+	}
+	if _, ok := origins["version"]; ok {
+		t.Errorf("Explain() has version")
+	}
+
+	// Changes go to the file layer and show unless they are overridden.
+	l.SetCron("0 0 * * *")
+	if err := l.SetUpperLimit(90); err != nil {
+		t.Fatal(err)
+	}
+	if l.Cron() != "0 0 * * *" || l.UpperLimit() != 50 || file.UpperLimit() != 90 {
+		t.Fatalf("after changes: Cron() = %q, UpperLimit() = %d, file limit = %d", l.Cron(), l.UpperLimit(), file.UpperLimit())
+	}
+	if err := l.SetLowerLimit(45); err != nil {
+		t.Fatal(err)
+	}
+	if l.LowerLimit() != 45 {
+		t.Fatalf("LowerLimit() = %d, want 45", l.LowerLimit())
+	}
+
+	if err := l.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.UpperLimit() != 90 || !saved.PreventIdleSleep() || saved.Cron() != "0 0 * * *" {
+		t.Fatalf("saved file has limit %d, preventIdleSleep %t, cron %q", saved.UpperLimit(), saved.PreventIdleSleep(), saved.Cron())
+	}
+}
+
+func TestLayeredLimitsFitTheFile(t *testing.T) {
+	file := NewFileFromConfig(&RawFileConfig{Limit: ptr.To(80), LowerLimitDelta: ptr.To(60)}, "")
+	l := NewLayered(file, &RawFileConfig{LowerLimitDelta: ptr.To(2)}, nil)
+
+	// 50 is fine with the effective delta of 2, not with 60 in the file.
+	err := l.SetUpperLimit(50)
+	if err == nil || !strings.Contains(err.Error(), "BATT_LOWER_LIMIT_DELTA") {
+		t.Fatalf("SetUpperLimit(50) = %v, want an error naming the override", err)
+	}
+	if file.UpperLimit() != 80 || l.UpperLimit() != 80 {
+		t.Fatalf("limit = %d in the file, %d effective after a rejected change", file.UpperLimit(), l.UpperLimit())
+	}
+	if err := l.SetUpperLimit(70); err != nil {
+		t.Fatal(err)
+	}
+
+	file = NewFileFromConfig(&RawFileConfig{Limit: ptr.To(30)}, "")
+	l = NewLayered(file, nil, &RawFileConfig{Limit: ptr.To(90)})
+	if err := l.SetLowerLimit(20); err == nil {
+		t.Fatal("SetLowerLimit(20) succeeded with a delta of 70 and a limit of 30 in the file")
+	}
+	if err := l.SetLowerLimit(80); err != nil || l.LowerLimit() != 80 {
+		t.Fatalf("SetLowerLimit(80) = %v, lower limit %d", err, l.LowerLimit())
+	}
+}
+
+func TestValidateOverrides(t *testing.T) {
+	file := NewFileFromConfig(&RawFileConfig{Cron: ptr.To("daily")}, "")
+	if err := NewLayered(file, &RawFileConfig{Limit: ptr.To(60)}, nil).ValidateOverrides(); err != nil {
+		t.Fatalf("ValidateOverrides() = %v, problems of the file are not overrides", err)
+	}
+
+	err := NewLayered(file, &RawFileConfig{Limit: ptr.To(160)}, &RawFileConfig{
+		LimitProfiles: []LimitProfile{{Name: "desk", Limit: 60, Schedule: "weekdays", Duration: "9h"}},
+	}).ValidateOverrides()
+	if err == nil || !strings.Contains(err.Error(), "BATT_LIMIT: limit:") || !strings.Contains(err.Error(), "--limit-profiles: limitProfiles[0].schedule:") {
+		t.Fatalf("ValidateOverrides() = %v", err)
+	}
+	if strings.Contains(err.Error(), "daily") {
+		t.Fatalf("ValidateOverrides() reported a problem of the file: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/charlie0129/batt/pkg/persist"
+	"github.com/charlie0129/batt/pkg/utils/ptr"
+)
+
+// CurrentVersion is the version of the config file format written by this
+// build. Files without a version are version 0.
+const CurrentVersion = 2
+
+// Migration upgrades a config file from version From to From+1. It works on
+// the top-level JSON fields, so it can read shapes RawFileConfig no longer
+// has.
+type Migration struct {
+	From        int
+	Description string
+	Migrate     func(doc map[string]json.RawMessage) error
+}
+
+// migrations is the registry of migrations, ordered by From. The last one
+// upgrades to CurrentVersion.
+var migrations = []Migration{
+	{
+		From:        0,
+		Description: `replace the absolute "lowerLimit" with "lowerLimitDelta"`,
+		Migrate:     migrateLowerLimit,
+	},
+	{
+		From:        1,
+		Description: `replace boolean "controlMagSafeLED" values with "enabled" or "disabled"`,
+		Migrate:     migrateMagSafeBool,
+	},
+}
+
+// PendingMigrations returns the migrations that upgrade a config file from
+// version from to CurrentVersion.
+func PendingMigrations(from int) []Migration {
+	if from < 0 || from >= len(migrations) {
+		return nil
+	}
+	return migrations[from:]
+}
+
+// Migrate decodes the content of a config file, upgrading it to
+// CurrentVersion one migration at a time. It returns the config and the
+// version b had. Files from a newer version of batt are decoded as they are.
+func Migrate(b []byte) (*RawFileConfig, int, error) {
+	b, from, err := migrateJSON(b)
+	if err != nil {
+		return nil, from, err
+	}
+
+	c := &RawFileConfig{}
+	if b == nil {
+		return c, from, nil
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, from, err
+	}
+	if from < CurrentVersion {
+		c.Version = ptr.To(CurrentVersion)
+	}
+	return c, from, nil
+}
+
+// migrateJSON upgrades the JSON content of a config file to CurrentVersion
+// and returns it with the version b had. It returns nil for a file without
+// settings.
+func migrateJSON(b []byte) ([]byte, int, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, CurrentVersion, nil
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, 0, err
+	}
+	from := 0
+	if v, ok := doc["version"]; ok {
+		if err := json.Unmarshal(v, &from); err != nil || from < 0 {
+			return nil, 0, fmt.Errorf("invalid version %s", v)
+		}
+	}
+
+	for _, m := range PendingMigrations(from) {
+		if err := m.Migrate(doc); err != nil {
+			return nil, from, pkgerrors.Wrapf(err, "failed to migrate config from version %d to %d", m.From, m.From+1)
+		}
+	}
+	b, err := json.Marshal(doc)
+	return b, from, err
+}
+
+// Encode returns the content of a config file for c at CurrentVersion.
+func Encode(c *RawFileConfig) ([]byte, error) {
+	out := *c
+	if out.Version == nil || *out.Version < CurrentVersion {
+		out.Version = ptr.To(CurrentVersion)
+	}
+	b, err := json.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// MigrationBackupPath is where WriteMigrated keeps the content of the config
+// file at path from before migrating it from version from.
+func MigrationBackupPath(path string, from int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, from)
+}
+
+// WriteMigrated replaces the config file at path, whose content at version
+// from was old, with c. old is kept at MigrationBackupPath first.
+func WriteMigrated(path string, old []byte, c *RawFileConfig, from int) error {
+	b, err := Marshal(FormatOf(path), c, old)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to encode config")
+	}
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	if c.HasSecrets() {
+		perm &^= 0077
+	}
+	backup := MigrationBackupPath(path, from)
+	if err := persist.Replace(backup, old, perm); err != nil {
+		return pkgerrors.Wrapf(err, "failed to back up config to %s", backup)
+	}
+	return WriteFile(path, b, c)
+}
+
+// migrateLowerLimit replaces the absolute lower limit of batt before
+// lowerLimitDelta with the distance to the upper limit.
+func migrateLowerLimit(doc map[string]json.RawMessage) error {
+	raw, ok := doc["lowerLimit"]
+	if !ok {
+		return nil
+	}
+	delete(doc, "lowerLimit")
+	if _, ok := doc["lowerLimitDelta"]; ok {
+		return nil
+	}
+
+	var lower int
+	if err := json.Unmarshal(raw, &lower); err != nil {
+		return pkgerrors.Wrapf(err, "invalid lowerLimit %s", raw)
+	}
+	upper := *defaultFileConfig.Limit
+	if v, ok := doc["limit"]; ok {
+		if err := json.Unmarshal(v, &upper); err != nil {
+			return pkgerrors.Wrapf(err, "invalid limit %s", v)
+		}
+	}
+	doc["lowerLimitDelta"] = json.RawMessage(fmt.Sprint(max(upper-lower, 0)))
+	return nil
+}
+
+// migrateMagSafeBool replaces the boolean controlMagSafeLED of batt before
+// the always-off mode.
+func migrateMagSafeBool(doc map[string]json.RawMessage) error {
+	var mode ControlMagSafeMode
+	switch string(doc["controlMagSafeLED"]) {
+	case "true":
+		mode = ControlMagSafeModeEnabled
+	case "false":
+		mode = ControlMagSafeModeDisabled
+	default:
+		// Not set, or already a mode.
+		return nil
+	}
+	b, err := json.Marshal(mode)
+	if err != nil {
+		return err
+	}
+	doc["controlMagSafeLED"] = b
+	return nil
+}
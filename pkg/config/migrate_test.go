@@ -0,0 +1,122 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestMigrations migrates every testdata/migrations/v<N>-*.json file, which
+// is at version N, and compares the result to the .golden file next to it.
+// Run with -update to regenerate the golden files.
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		if m.From != i {
+			t.Fatalf("migration %d upgrades from version %d, want %d", i, m.From, i)
+		}
+	}
+	if len(migrations) != CurrentVersion {
+		t.Fatalf("%d migrations, want %d to reach CurrentVersion", len(migrations), CurrentVersion)
+	}
+
+	inputs, err := filepath.Glob(filepath.Join("testdata", "migrations", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tested := map[int]bool{}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			var version int
+			if _, err := fmt.Sscanf(name, "v%d-", &version); err != nil {
+				t.Fatalf("file name does not start with the version: %v", err)
+			}
+			tested[version] = true
+
+			b, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, from, err := Migrate(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from != version {
+				t.Fatalf("Migrate() found version %d, want %d", from, version)
+			}
+			got, err := Encode(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := strings.TrimSuffix(input, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Fatalf("migrated config:\n%s\nwant:\n%s", got, want)
+			}
+
+			// Migrating again changes nothing.
+			c, from, err = Migrate(got)
+			if err != nil || from != CurrentVersion {
+				t.Fatalf("Migrate() of the migrated config = version %d, %v", from, err)
+			}
+			if again, _ := Encode(c); string(again) != string(got) {
+				t.Fatalf("migrating again gave:\n%s", again)
+			}
+		})
+	}
+	for _, m := range migrations {
+		if !tested[m.From] {
+			t.Errorf("no golden test for the migration from version %d", m.From)
+		}
+	}
+}
+
+func TestLoadMigratesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batt.json")
+	old := []byte(`{"limit": 90, "lowerLimit": 80, "controlMagSafeLED": false}`)
+	if err := os.WriteFile(path, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configured, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured.LowerLimit() != 80 || configured.ControlMagSafeLED() != ControlMagSafeModeDisabled {
+		t.Fatalf("LowerLimit() = %d, ControlMagSafeLED() = %s", configured.LowerLimit(), configured.ControlMagSafeLED())
+	}
+
+	if b, err := os.ReadFile(MigrationBackupPath(path, 0)); err != nil || string(b) != string(old) {
+		t.Fatalf("backup = %s, %v, want the old file", b, err)
+	}
+	_, from, err := Migrate(mustReadFile(t, path))
+	if err != nil || from != CurrentVersion {
+		t.Fatalf("file on disk is version %d (%v), want %d", from, err, CurrentVersion)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("mode = %v (%v), want 0600 kept", fi.Mode(), err)
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,313 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// object is a JSON object with its fields in order.
+type object []field
+
+type field struct {
+	key   string
+	value any
+}
+
+// decodeOrdered decodes JSON like json.Unmarshal into an any, but keeps the
+// order of the fields of objects and the text of numbers.
+func decodeOrdered(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return decodeOrderedValue(dec)
+}
+
+func decodeOrderedValue(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		o := object{}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, field{k.(string), v})
+		}
+		_, err := dec.Token()
+		return o, err
+	case json.Delim('['):
+		a := []any{}
+		for dec.More() {
+			v, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err := dec.Token()
+		return a, err
+	}
+	return t, nil
+}
+
+// Keys of the comments at the top and at the bottom of a TOML file.
+const (
+	tomlHeadKey = "\x00head"
+	tomlFootKey = "\x00foot"
+)
+
+// tomlWriter writes TOML. Comments holds the comment lines to write above
+// keys and table headers, see tomlComments.
+type tomlWriter struct {
+	buf      bytes.Buffer
+	comments map[string]string
+}
+
+// table writes the fields of o, which are in the table named by header:
+// first the values, then the tables and arrays of tables. ckey identifies
+// the table for comments.
+func (w *tomlWriter) table(header []string, ckey string, o object) {
+	if header == nil {
+		w.comment(tomlHeadKey)
+		if w.buf.Len() > 0 {
+			w.buf.WriteByte('\n')
+		}
+	}
+
+	for _, f := range o {
+		if f.value == nil || isTOMLTable(f.value) || isTOMLTableArray(f.value) {
+			continue
+		}
+		w.comment(joinKey(ckey, tomlKey(f.key)))
+		fmt.Fprintf(&w.buf, "%s = %s\n", tomlKey(f.key), tomlValue(f.value))
+	}
+
+	for _, f := range o {
+		h := append(slices.Clone(header), tomlKey(f.key))
+		k := joinKey(ckey, tomlKey(f.key))
+		switch v := f.value.(type) {
+		case object:
+			// A table with only sub-tables is defined by their headers.
+			if slices.ContainsFunc(v, func(f field) bool { return !isTOMLTable(f.value) }) || len(v) == 0 {
+				w.header("["+strings.Join(h, ".")+"]", "["+k+"]")
+			}
+			w.table(h, k, v)
+		case []any:
+			if !isTOMLTableArray(v) {
+				continue
+			}
+			for i, e := range v {
+				ek := fmt.Sprintf("%s[%d]", k, i)
+				w.header("[["+strings.Join(h, ".")+"]]", "["+ek+"]")
+				w.table(h, ek, e.(object))
+			}
+		}
+	}
+}
+
+func (w *tomlWriter) header(header, ckey string) {
+	if w.buf.Len() > 0 {
+		w.buf.WriteByte('\n')
+	}
+	w.comment(ckey)
+	w.buf.WriteString(header + "\n")
+}
+
+func (w *tomlWriter) comment(ckey string) {
+	if c := w.comments[ckey]; c != "" {
+		w.buf.WriteString(c)
+	}
+}
+
+func isTOMLTable(v any) bool {
+	_, ok := v.(object)
+	return ok
+}
+
+// isTOMLTableArray reports whether v is written as an array of tables,
+// rather than inline.
+func isTOMLTableArray(v any) bool {
+	a, ok := v.([]any)
+	return ok && len(a) > 0 && !slices.ContainsFunc(a, func(e any) bool { return !isTOMLTable(e) })
+}
+
+func tomlValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return tomlString(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		var values []string
+		for _, e := range v {
+			if e != nil {
+				values = append(values, tomlValue(e))
+			}
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	case object:
+		var values []string
+		for _, f := range v {
+			if f.value != nil {
+				values = append(values, tomlKey(f.key)+" = "+tomlValue(f.value))
+			}
+		}
+		if len(values) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(values, ", ") + " }"
+	}
+	panic(fmt.Sprintf("unexpected JSON value %T", v))
+}
+
+// tomlString quotes s as a TOML basic string. The escapes of JSON strings
+// are all valid in TOML.
+func tomlString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+var bareTOMLKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(k string) string {
+	if bareTOMLKey.MatchString(k) {
+		return k
+	}
+	return tomlString(k)
+}
+
+func joinKey(prefix, k string) string {
+	if prefix == "" {
+		return k
+	}
+	return prefix + "." + k
+}
+
+// tomlComments collects the comment lines in a TOML file by what follows
+// them: a key or a table header. Comments separated from the first key or
+// header by an empty line are kept at the top, and comments at the end of the
+// file at the bottom. Comments at the end of a line are not kept.
+func tomlComments(b []byte) map[string]string {
+	comments := map[string]string{}
+	var (
+		pending    []string
+		table      string
+		tables     = map[string]int{}
+		hasContent bool
+	)
+	attach := func(ckey string) {
+		if len(pending) > 0 {
+			comments[ckey] = strings.Join(pending, "\n") + "\n"
+		}
+		pending = nil
+		hasContent = true
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(string(b), " \t\r\n"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#"):
+			pending = append(pending, line)
+		case line == "":
+			if !hasContent && len(pending) > 0 {
+				attach(tomlHeadKey)
+				hasContent = false
+			}
+			pending = nil
+		case strings.HasPrefix(line, "[["):
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, "[["), "]]")
+			name = normalizeTOMLKey(name)
+			table = fmt.Sprintf("%s[%d]", name, tables[name])
+			tables[name]++
+			attach("[" + table + "]")
+		case strings.HasPrefix(line, "["):
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, "["), "]")
+			table = normalizeTOMLKey(name)
+			attach("[" + table + "]")
+		default:
+			if key, ok := cutTOMLKey(line); ok {
+				attach(joinKey(table, normalizeTOMLKey(key)))
+			} else {
+				// Inside a multi-line value.
+				pending = nil
+			}
+		}
+	}
+	if len(pending) > 0 {
+		comments[tomlFootKey] = strings.Join(pending, "\n") + "\n"
+	}
+	return comments
+}
+
+// cutTOMLKey returns the key of a "key = value" line.
+func cutTOMLKey(line string) (string, bool) {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '=':
+			return line[:i], true
+		}
+	}
+	return "", false
+}
+
+// normalizeTOMLKey formats a dotted TOML key the way tomlWriter does.
+func normalizeTOMLKey(k string) string {
+	var (
+		parts []string
+		part  strings.Builder
+		quote rune
+	)
+	flush := func() {
+		p := strings.TrimSpace(part.String())
+		switch {
+		case strings.HasPrefix(p, `"`):
+			if s, err := strconv.Unquote(p); err == nil {
+				p = s
+			}
+		case strings.HasPrefix(p, "'"):
+			p = strings.Trim(p, "'")
+		}
+		parts = append(parts, tomlKey(p))
+		part.Reset()
+	}
+	for _, r := range k {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '.':
+			flush()
+			continue
+		}
+		part.WriteRune(r)
+	}
+	flush()
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/charlie0129/batt/pkg/endpoint"
+	"github.com/charlie0129/batt/pkg/events"
+)
+
+// CronParser parses the schedules in the config: standard cron expressions
+// with optional seconds and descriptors such as "@daily".
+var CronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// FieldError is a problem with one setting of a config.
+type FieldError struct {
+	// Path is where the setting is in the JSON config, e.g. "limit",
+	// "limitProfiles[0].schedule" or `hooks["power.plugged"]`.
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Reason
+}
+
+// FieldErrors is the error for a config with invalid settings.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	s := make([]string, len(e))
+	for i, fe := range e {
+		s[i] = fe.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Valid reports whether m is one of the known modes.
+func (m ControlMagSafeMode) Valid() bool {
+	switch m {
+	case ControlMagSafeModeEnabled, ControlMagSafeModeDisabled, ControlMagSafeModeAlwaysOff:
+		return true
+	}
+	return false
+}
+
+// Validate checks the settings of c and returns a FieldError for every
+// invalid one. Settings that are not set are valid; their defaults are used
+// where a rule depends on them.
+func (c *RawFileConfig) Validate() []FieldError {
+	var errs []FieldError
+	add := func(path, format string, args ...any) {
+		errs = append(errs, FieldError{Path: path, Reason: fmt.Sprintf(format, args...)})
+	}
+	between := func(path string, v *int, lo, hi int) {
+		if v != nil && (*v < lo || *v > hi) {
+			add(path, "must be between %d and %d, got %d", lo, hi, *v)
+		}
+	}
+
+	between("limit", c.Limit, 10, 100)
+	if d := c.LowerLimitDelta; d != nil {
+		limit := *defaultFileConfig.Limit
+		if c.Limit != nil {
+			limit = *c.Limit
+		}
+		if *d < 0 {
+			add("lowerLimitDelta", "must not be negative, got %d", *d)
+		} else if limit >= 10 && limit <= 100 && limit-*d < 10 {
+			add("lowerLimitDelta", "lower limit (limit - lowerLimitDelta) must be at least 10, got %d", limit-*d)
+		}
+	}
+	if m := c.ControlMagSafeLED; m != nil && !m.Valid() {
+		add("controlMagSafeLED", "must be %q, %q or %q, got %q", ControlMagSafeModeEnabled, ControlMagSafeModeDisabled, ControlMagSafeModeAlwaysOff, *m)
+	}
+	between("calibrationDischargeThreshold", c.CalibrationDischargeThreshold, 10, 50)
+	between("calibrationHoldDurationMinutes", c.CalibrationHoldDurationMinutes, 10, 24*60)
+	if cr := c.Cron; cr != nil && *cr != "" {
+		if _, err := CronParser.Parse(*cr); err != nil {
+			add("cron", "invalid cron expression: %v", err)
+		}
+	}
+	between("preDisableLimit", c.PreDisableLimit, 10, 100)
+	between("metricsPort", c.MetricsPort, 0, 65535)
+
+	names := map[string]int{}
+	for i, p := range c.LimitProfiles {
+		path := fmt.Sprintf("limitProfiles[%d]", i)
+		if p.Name == "" {
+			add(path+".name", "must not be empty")
+		} else if j, ok := names[p.Name]; ok {
+			add(path+".name", "duplicate of limitProfiles[%d]", j)
+		} else {
+			names[p.Name] = i
+		}
+		between(path+".limit", &p.Limit, 10, 100)
+		if _, err := CronParser.Parse(p.Schedule); err != nil {
+			add(path+".schedule", "invalid cron expression: %v", err)
+		}
+		if d, err := time.ParseDuration(p.Duration); err != nil || d <= 0 {
+			add(path+".duration", "must be a positive duration such as 9h, got %q", p.Duration)
+		}
+	}
+
+	if t := c.MaxChargingTemperature; t != nil && *t < 0 {
+		add("maxChargingTemperature", "must not be negative, got %g", *t)
+	}
+	if h := c.TemperatureHysteresis; h != nil && *h < 0 {
+		add("temperatureHysteresis", "must not be negative, got %g", *h)
+	}
+	if l := c.TemperatureGuardLimit; l != nil && *l != 0 && (*l < 10 || *l > 100) {
+		add("temperatureGuardLimit", "must be 0 or between 10 and 100, got %d", *l)
+	}
+
+	for _, event := range sortedKeys(c.Hooks) {
+		if !slices.Contains(events.Names, event) {
+			add(fmt.Sprintf("hooks[%q]", event), "unknown event")
+		}
+	}
+	for i, w := range c.Webhooks {
+		path := fmt.Sprintf("webhooks[%d]", i)
+		if err := endpoint.ValidateWebhookURL(w.URL); err != nil {
+			add(path+".url", "%v", err)
+		}
+		for j, event := range w.Events {
+			if !slices.Contains(events.Names, event) {
+				add(fmt.Sprintf("%s.events[%d]", path, j), "unknown event %q", event)
+			}
+		}
+	}
+	if m := c.MQTT; m != nil && m.Broker != "" {
+		if _, _, err := endpoint.ParseMQTTBroker(m.Broker); err != nil {
+			add("mqtt.broker", "%v", err)
+		}
+	}
+	if a := c.Access; a != nil {
+		for _, r := range []struct {
+			name string
+			rule AccessRule
+		}{{"read", a.Read}, {"mutate", a.Mutate}} {
+			for i, u := range r.rule.Users {
+				if strings.TrimSpace(u) == "" {
+					add(fmt.Sprintf("access.%s.users[%d]", r.name, i), "must not be empty")
+				}
+			}
+			for i, g := range r.rule.Groups {
+				if strings.TrimSpace(g) == "" {
+					add(fmt.Sprintf("access.%s.groups[%d]", r.name, i), "must not be empty")
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// UnknownFields returns a FieldError for every key in the content of a
+// config file in format f that is not a setting, such as a misspelled one.
+// encoding/json ignores such keys, and matches keys case-insensitively;
+// UnknownFields also reports keys that only differ in case from a setting.
+func UnknownFields(f Format, b []byte) ([]FieldError, error) {
+	j, err := codecs[f].toJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	j, _, err = migrateJSON(j)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	v, err := decodeOrdered(j)
+	if err != nil {
+		return nil, err
+	}
+	return unknownFields("", v, reflect.TypeFor[RawFileConfig]()), nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+func unknownFields(path string, v any, t reflect.Type) []FieldError {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Types that decode themselves, like time.Time, have no fields.
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+
+	var errs []FieldError
+	switch v := v.(type) {
+	case object:
+		switch t.Kind() {
+		case reflect.Struct:
+			for _, f := range v {
+				fieldPath := f.key
+				if path != "" {
+					fieldPath = path + "." + f.key
+				}
+				sf, ok := jsonField(t, f.key)
+				switch {
+				case ok:
+					errs = append(errs, unknownFields(fieldPath, f.value, sf.Type)...)
+				case sf.Name != "":
+					errs = append(errs, FieldError{Path: fieldPath, Reason: fmt.Sprintf("unknown setting, did you mean %q?", jsonName(sf))})
+				default:
+					errs = append(errs, FieldError{Path: fieldPath, Reason: "unknown setting"})
+				}
+			}
+		case reflect.Map:
+			for _, f := range v {
+				errs = append(errs, unknownFields(fmt.Sprintf("%s[%q]", path, f.key), f.value, t.Elem())...)
+			}
+		}
+	case []any:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, e := range v {
+				errs = append(errs, unknownFields(fmt.Sprintf("%s[%d]", path, i), e, t.Elem())...)
+			}
+		}
+	}
+	return errs
+}
+
+// jsonField returns the field of struct type t with the JSON name key. If
+// there is none, it returns a field whose name only differs in case, with
+// ok false.
+func jsonField(t reflect.Type, key string) (f reflect.StructField, ok bool) {
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() || sf.Tag.Get("json") == "-" {
+			continue
+		}
+		switch name := jsonName(sf); {
+		case name == key:
+			return sf, true
+		case strings.EqualFold(name, key):
+			f = sf
+		}
+	}
+	return f, false
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/utils/ptr"
+)
+
+func TestValidate(t *testing.T) {
+	if errs := fullConfig().Validate(); len(errs) > 0 {
+		t.Fatalf("Validate() of a valid config = %v", errs)
+	}
+	if errs := (&RawFileConfig{}).Validate(); len(errs) > 0 {
+		t.Fatalf("Validate() of the empty config = %v", errs)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		c     RawFileConfig
+		paths []string
+	}{
+		{"limit", RawFileConfig{Limit: ptr.To(101)}, []string{"limit"}},
+		{"lower limit", RawFileConfig{Limit: ptr.To(60), LowerLimitDelta: ptr.To(55)}, []string{"lowerLimitDelta"}},
+		{"lower limit of the default limit", RawFileConfig{LowerLimitDelta: ptr.To(75)}, []string{"lowerLimitDelta"}},
+		{"negative delta", RawFileConfig{LowerLimitDelta: ptr.To(-1)}, []string{"lowerLimitDelta"}},
+		{"magsafe", RawFileConfig{ControlMagSafeLED: ptr.To(ControlMagSafeMode("blink"))}, []string{"controlMagSafeLED"}},
+		{"calibration", RawFileConfig{CalibrationDischargeThreshold: ptr.To(5), CalibrationHoldDurationMinutes: ptr.To(2000)}, []string{"calibrationDischargeThreshold", "calibrationHoldDurationMinutes"}},
+		{"cron", RawFileConfig{Cron: ptr.To("every day")}, []string{"cron"}},
+		{"metrics port", RawFileConfig{MetricsPort: ptr.To(70000)}, []string{"metricsPort"}},
+		{"profiles", RawFileConfig{LimitProfiles: []LimitProfile{
+			{Name: "desk", Limit: 60, Schedule: "0 9 * * MON-FRI", Duration: "9h"},
+			{Name: "desk", Limit: 5, Schedule: "weekdays", Duration: "0s"},
+		}}, []string{"limitProfiles[1].name", "limitProfiles[1].limit", "limitProfiles[1].schedule", "limitProfiles[1].duration"}},
+		{"temperature", RawFileConfig{MaxChargingTemperature: ptr.To(-1.0), TemperatureGuardLimit: ptr.To(5)}, []string{"maxChargingTemperature", "temperatureGuardLimit"}},
+		{"hooks", RawFileConfig{Hooks: map[string][]string{"power.plugged": {"true"}, "power.pluged": {"true"}}}, []string{`hooks["power.pluged"]`}},
+		{"webhooks", RawFileConfig{Webhooks: []Webhook{{URL: "ftp://example.com"}, {URL: "https://example.com", Events: []string{"nope"}}}}, []string{"webhooks[0].url", "webhooks[1].events[0]"}},
+		{"mqtt", RawFileConfig{MQTT: &MQTT{Broker: "http://broker"}}, []string{"mqtt.broker"}},
+		{"access", RawFileConfig{Access: &Access{Mutate: AccessRule{Groups: []string{"admin", ""}}}}, []string{"access.mutate.groups[1]"}},
+		// Load warns about a newer version and ignores what it does not know.
+		{"newer version", RawFileConfig{Version: ptr.To(CurrentVersion + 1)}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			for _, e := range tt.c.Validate() {
+				if e.Reason == "" {
+					t.Errorf("%s has no reason", e.Path)
+				}
+				paths = append(paths, e.Path)
+			}
+			if !slices.Equal(paths, tt.paths) {
+				t.Fatalf("Validate() reported %v, want %v", paths, tt.paths)
+			}
+		})
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	for _, tt := range []struct {
+		format Format
+		in     string
+		want   []FieldError
+	}{
+		{FormatJSON, `{"limit": 80, "hooks": {"power.plugged": ["true"]}, "disableUntil": "2026-07-21T12:30:00Z"}`, nil},
+		{FormatJSON, "", nil},
+		{FormatJSON, `{"limt": 80, "Cron": "@daily", "mqtt": {"broker": "b", "pasword": "p"}, "limitProfiles": [{"name": "a"}, {"name": "b", "days": 5}]}`, []FieldError{
+			{"Cron", `unknown setting, did you mean "cron"?`},
+			{"limitProfiles[1].days", "unknown setting"},
+			{"limt", "unknown setting"},
+			{"mqtt.pasword", "unknown setting"},
+		}},
+		// Keys that migrations replace are known.
+		{FormatJSON, `{"lowerLimit": 70}`, nil},
+		{FormatYAML, "webhooks:\n  - url: https://example.com\n    event: [power.plugged]\n", []FieldError{{"webhooks[0].event", "unknown setting"}}},
+		{FormatTOML, "[access.read]\nuser = [\"*\"]\n", []FieldError{{"access.read.user", "unknown setting"}}},
+	} {
+		got, err := UnknownFields(tt.format, []byte(tt.in))
+		if err != nil {
+			t.Fatalf("UnknownFields(%s) error = %v", tt.in, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("UnknownFields(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadKeepsValidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batt.json")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first load takes an invalid file, so that the daemon can start.
+	write(`{"limit": 70, "controlMagSafeLED": "blink"}`)
+	configured, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured.UpperLimit() != 70 || configured.ControlMagSafeLED() != ControlMagSafeModeDisabled {
+		t.Fatalf("UpperLimit() = %d, ControlMagSafeLED() = %s", configured.UpperLimit(), configured.ControlMagSafeLED())
+	}
+
+	write(`{"limit": 60}`)
+	if err := configured.Load(); err != nil || configured.UpperLimit() != 60 {
+		t.Fatalf("Load() = %v, UpperLimit() = %d", err, configured.UpperLimit())
+	}
+
+	write(`{"limit": 160, "cron": "daily"}`)
+	rev := configured.Revision()
+	err = configured.Load()
+	var errs FieldErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Load() of an invalid file = %v", err)
+	}
+	if configured.UpperLimit() != 60 || configured.Revision() != rev {
+		t.Fatalf("invalid file was applied: UpperLimit() = %d", configured.UpperLimit())
+	}
+}
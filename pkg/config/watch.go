@@ -0,0 +1,57 @@
+package config
+
+import (
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// diskState identifies the content of a config file.
+type diskState struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// remember records the current state of the config file, as the last one f
+// has seen. Call it with f.diskMu held.
+func (f *File) remember() {
+	f.disk = diskState{}
+	fi, err := os.Stat(f.filepath)
+	if err != nil {
+		return
+	}
+	b, err := os.ReadFile(f.filepath)
+	if err != nil {
+		return
+	}
+	f.disk = diskState{modTime: fi.ModTime(), size: int64(len(b)), sum: sha256.Sum256(b)}
+}
+
+// Modified reports whether the config file has changed since f last loaded
+// or saved it, i.e. whether it needs to be loaded again. Writes that leave
+// the content as it was are not changes, so f's own saves never are. A
+// deleted file is not a change either: f keeps its config until there is a
+// file again.
+func (f *File) Modified() bool {
+	f.diskMu.Lock()
+	defer f.diskMu.Unlock()
+
+	fi, err := os.Stat(f.filepath)
+	if err != nil {
+		return false
+	}
+	if fi.ModTime().Equal(f.disk.modTime) && fi.Size() == f.disk.size {
+		return false
+	}
+	b, err := os.ReadFile(f.filepath)
+	if err != nil {
+		return false
+	}
+	if sha256.Sum256(b) != f.disk.sum {
+		return true
+	}
+	// Touched, or written with the same content.
+	f.disk.modTime, f.disk.size = fi.ModTime(), int64(len(b))
+	return false
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModified(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batt.yaml")
+	configured, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured.Modified() {
+		t.Fatal("Modified() without a file")
+	}
+
+	if err := configured.SetUpperLimit(70); err != nil {
+		t.Fatal(err)
+	}
+	if err := configured.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if configured.Modified() {
+		t.Fatal("Modified() after Save()")
+	}
+
+	// Touching the file does not change it.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if configured.Modified() {
+		t.Fatal("Modified() after touching the file")
+	}
+
+	if err := os.WriteFile(path, []byte("limit: 60\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !configured.Modified() {
+		t.Fatal("Modified() = false after the file was written")
+	}
+	if err := configured.Load(); err != nil || configured.UpperLimit() != 60 {
+		t.Fatalf("Load() = %v, UpperLimit() = %d", err, configured.UpperLimit())
+	}
+	if configured.Modified() {
+		t.Fatal("Modified() after Load()")
+	}
+
+	// Deleting the file keeps the config.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if configured.Modified() {
+		t.Fatal("Modified() after deleting the file")
+	}
+}
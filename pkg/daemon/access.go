@@ -0,0 +1,179 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/config"
+)
+
+// peerCred identifies the process on the other end of the daemon socket.
+type peerCred struct {
+	uid uint32
+	gid uint32
+	// groups are supplementary groups, if the platform reports them.
+	groups []uint32
+}
+
+type peerCredKey struct{}
+
+// connContext stores the peer credentials of unix socket connections in
+// their context, for authorize to check.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	cred, err := peerCredentials(uc)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to read peer credentials")
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// authorize enforces the access control list in the config. GET requests
+// need read access and everything else needs mutate access.
+func (d *Daemon) authorize(c *gin.Context) {
+	acl := d.conf.Access()
+	if acl == nil {
+		return
+	}
+
+	mutating := c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead
+	cred, ok := c.Request.Context().Value(peerCredKey{}).(peerCred)
+	if !ok {
+		logrus.WithFields(logrus.Fields{
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+		}).Warn("denied API request from a caller with unknown credentials")
+		abortWithError(c, api.Errorf(api.CodePermissionDenied, "permission denied: unknown caller"))
+		return
+	}
+	if cred.allowed(acl, mutating) {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"uid":    cred.uid,
+		"gid":    cred.gid,
+		"method": c.Request.Method,
+		"path":   c.Request.URL.Path,
+	}).Warn("denied API request")
+	action := "read daemon state"
+	if mutating {
+		action = "change settings"
+	}
+	abortWithError(c, api.Errorf(api.CodePermissionDenied, "permission denied: uid %d is not allowed to %s", cred.uid, action).
+		WithDetail("uid", cred.uid))
+}
+
+// callerPrivileged reports whether the caller of c is root or the user
+// running the daemon. Callers with unknown credentials are not.
+func callerPrivileged(c *gin.Context) bool {
+	cred, ok := c.Request.Context().Value(peerCredKey{}).(peerCred)
+	return ok && cred.privileged()
+}
+
+// privileged reports whether p is root or the user running the daemon, who
+// always have full access.
+func (p peerCred) privileged() bool {
+	return p.uid == 0 || int(p.uid) == os.Getuid()
+}
+
+func (p peerCred) allowed(acl *config.Access, mutating bool) bool {
+	if p.privileged() {
+		return true
+	}
+	if p.matches(acl.Mutate) {
+		return true
+	}
+	return !mutating && p.matches(acl.Read)
+}
+
+func (p peerCred) matches(rule config.AccessRule) bool {
+	for _, name := range rule.Users {
+		if name == "*" {
+			return true
+		}
+		if uid, ok := lookupID(name, user.Lookup, func(u *user.User) string { return u.Uid }); ok && uid == p.uid {
+			return true
+		}
+	}
+	if len(rule.Groups) == 0 {
+		return false
+	}
+	groups := p.allGroups()
+	for _, name := range rule.Groups {
+		if gid, ok := lookupID(name, user.LookupGroup, func(g *user.Group) string { return g.Gid }); ok && slices.Contains(groups, gid) {
+			return true
+		}
+	}
+	return false
+}
+
+// allGroups returns the primary and supplementary groups of p. Platforms
+// that only report the primary group are completed from the user database.
+func (p peerCred) allGroups() []uint32 {
+	groups := append([]uint32{p.gid}, p.groups...)
+	u, err := user.LookupId(strconv.FormatUint(uint64(p.uid), 10))
+	if err != nil {
+		return groups
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return groups
+	}
+	for _, id := range ids {
+		if gid, err := strconv.ParseUint(id, 10, 32); err == nil && !slices.Contains(groups, uint32(gid)) {
+			groups = append(groups, uint32(gid))
+		}
+	}
+	return groups
+}
+
+// lookupID resolves a user or group given by name or numeric ID.
+func lookupID[T any](name string, lookup func(string) (T, error), id func(T) string) (uint32, bool) {
+	if n, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(n), true
+	}
+	v, err := lookup(name)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(id(v), 10, 32)
+	return uint32(n), err == nil
+}
+
+// checkAccess warns about users and groups in the access control list that
+// do not exist.
+func (d *Daemon) checkAccess() {
+	acl := d.conf.Access()
+	if acl == nil {
+		return
+	}
+	for _, rule := range []config.AccessRule{acl.Read, acl.Mutate} {
+		for _, name := range rule.Users {
+			if name == "*" {
+				continue
+			}
+			if _, ok := lookupID(name, user.Lookup, func(u *user.User) string { return u.Uid }); !ok {
+				logrus.Warnf("access: unknown user %q", name)
+			}
+		}
+		for _, name := range rule.Groups {
+			if _, ok := lookupID(name, user.LookupGroup, func(g *user.Group) string { return g.Gid }); !ok {
+				logrus.Warnf("access: unknown group %q", name)
+			}
+		}
+	}
+}
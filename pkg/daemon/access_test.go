@@ -0,0 +1,113 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/config"
+)
+
+func TestAuthorize(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78, access: &config.Access{
+		Read:   config.AccessRule{Users: []string{"*"}},
+		Mutate: config.AccessRule{Users: []string{"4242"}, Groups: []string{"4343"}},
+	}})
+
+	self := uint32(os.Getuid())
+	for _, tt := range []struct {
+		name   string
+		cred   *peerCred
+		method string
+		want   int
+	}{
+		{"read by anyone", &peerCred{uid: 1000, gid: 1000}, http.MethodGet, http.StatusOK},
+		{"mutate by a stranger", &peerCred{uid: 1000, gid: 1000}, http.MethodPut, http.StatusForbidden},
+		{"mutate by an allowed user", &peerCred{uid: 4242, gid: 1000}, http.MethodPut, http.StatusOK},
+		{"mutate by an allowed group", &peerCred{uid: 1000, gid: 1000, groups: []uint32{4343}}, http.MethodPut, http.StatusOK},
+		{"mutate by root", &peerCred{uid: 0, gid: 0}, http.MethodPut, http.StatusOK},
+		{"mutate by the daemon user", &peerCred{uid: self, gid: 1000}, http.MethodPut, http.StatusOK},
+		{"unknown caller", nil, http.MethodGet, http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// /v1/nope is not a route, so requests that get through the
+			// ACL end up as a 404.
+			request := httptest.NewRequest(tt.method, "/v1/nope", nil)
+			if tt.cred != nil {
+				request = request.WithContext(context.WithValue(request.Context(), peerCredKey{}, *tt.cred))
+			}
+			response := httptest.NewRecorder()
+			d.router.ServeHTTP(response, request)
+
+			want := tt.want
+			if want == http.StatusOK {
+				want = http.StatusNotFound
+			}
+			if response.Code != want {
+				t.Fatalf("status = %d, want %d; body: %s", response.Code, want, response.Body.String())
+			}
+			if want == http.StatusForbidden {
+				var e api.Error
+				if err := json.Unmarshal(response.Body.Bytes(), &e); err != nil || e.Code != api.CodePermissionDenied {
+					t.Fatalf("body = %s", response.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestConnContextReadsPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("peer credentials are not supported on " + runtime.GOOS)
+	}
+	dir, err := os.MkdirTemp("", "batt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	// Socket paths are short on macOS, so avoid t.TempDir.
+	path := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		ConnContext: connContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cred, ok := r.Context().Value(peerCredKey{}).(peerCred)
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(strconv.FormatUint(uint64(cred.uid), 10)))
+		}),
+	}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var uid int
+	if err := json.NewDecoder(resp.Body).Decode(&uid); err != nil {
+		t.Fatalf("status %d: %v", resp.StatusCode, err)
+	}
+	if uid != os.Getuid() {
+		t.Fatalf("uid = %d, want %d", uid, os.Getuid())
+	}
+}
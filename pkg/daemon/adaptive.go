@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/adaptive"
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/persist"
+)
+
+func (d *Daemon) initAdaptiveModel(path string) {
+	d.adaptiveModelPath = path
+	var m adaptive.Model
+	err := persist.ReadFile(path, func(b []byte) error {
+		m = adaptive.Model{}
+		return json.Unmarshal(b, &m)
+	}, d.restored.readOptions())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		logrus.WithError(err).Warn("failed to load adaptive charging model")
+		return
+	}
+	d.adaptiveModel = &m
+}
+
+// persistAdaptiveModel must be called with adaptiveMu held.
+func (d *Daemon) persistAdaptiveModel() {
+	if d.adaptiveModelPath == "" {
+		return
+	}
+	b, err := json.MarshalIndent(d.adaptiveModel, "", "  ")
+	if err != nil {
+		logrus.WithError(err).Error("marshal adaptive charging model")
+		return
+	}
+	if err := persist.WriteFile(d.adaptiveModelPath, b, 0644); err != nil {
+		logrus.WithError(err).Error("write adaptive charging model")
+	}
+}
+
+// updateAdaptiveCharging learns from the current plug state and decides
+// whether to top up. limit is the upper limit that would otherwise apply.
+// Unplug times are learned even while the mode is disabled, so it is useful
+// as soon as it is enabled.
+func (d *Daemon) updateAdaptiveCharging(now time.Time, limit int) {
+	pluggedIn, err := d.backend.IsPluggedIn()
+	if err != nil {
+		logrus.WithError(err).Debug("skipping adaptive charging update, plug state unavailable")
+		return
+	}
+
+	d.adaptiveMu.Lock()
+	defer d.adaptiveMu.Unlock()
+
+	if d.adaptiveModel.Update(now, pluggedIn, adaptive.DefaultOptions) {
+		d.persistAdaptiveModel()
+	}
+
+	var decision adaptive.Decision
+	if d.conf.AdaptiveCharging() && pluggedIn {
+		decision = adaptive.Plan(d.adaptiveModel, now, limit, adaptive.DefaultOptions)
+	}
+	if decision.TopUp != d.adaptiveToppingUp {
+		if decision.TopUp {
+			logrus.WithField("predictedUnplug", decision.NextUnplug.Format(time.DateTime)).Info("adaptive charging: topping up to 100% before the predicted unplug")
+		} else {
+			logrus.Info("adaptive charging: holding at the charge limit")
+		}
+	}
+	d.adaptiveToppingUp = decision.TopUp
+}
+
+func (d *Daemon) adaptiveChargingToppingUp() bool {
+	d.adaptiveMu.Lock()
+	defer d.adaptiveMu.Unlock()
+	return d.adaptiveToppingUp
+}
+
+func (d *Daemon) getAdaptiveStatus(now time.Time) adaptive.Status {
+	d.adaptiveMu.Lock()
+	defer d.adaptiveMu.Unlock()
+
+	st := adaptive.Status{
+		Enabled:   d.conf.AdaptiveCharging(),
+		ToppingUp: d.adaptiveToppingUp,
+		Weekdays:  d.adaptiveModel.Weekdays(adaptive.DefaultOptions),
+	}
+	if !d.adaptiveModel.PluggedAt.IsZero() {
+		st.PluggedInSince = &d.adaptiveModel.PluggedAt
+	}
+	upper, _ := d.profileLimits()
+	if plan := adaptive.Plan(d.adaptiveModel, now, upper, adaptive.DefaultOptions); !plan.NextUnplug.IsZero() {
+		st.NextUnplug = &plan.NextUnplug
+		st.TopUpAt = &plan.TopUpAt
+	}
+	return st
+}
+
+func (d *Daemon) getAdaptive(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, d.getAdaptiveStatus(d.clock.Now()))
+}
+
+func (d *Daemon) setAdaptive(c *gin.Context) {
+	d.setBoolSetting(c, func(s *api.Settings, v *bool) { s.AdaptiveCharging = v })
+}
+
+// postResetAdaptive forgets all learned unplug times.
+func (d *Daemon) postResetAdaptive(c *gin.Context) {
+	d.resetAdaptiveModel()
+	c.IndentedJSON(http.StatusCreated, "ok")
+}
+
+func (d *Daemon) resetAdaptiveModel() {
+	d.adaptiveMu.Lock()
+	d.adaptiveModel = &adaptive.Model{PluggedAt: d.adaptiveModel.PluggedAt}
+	d.adaptiveToppingUp = false
+	d.persistAdaptiveModel()
+	d.adaptiveMu.Unlock()
+
+	logrus.Info("adaptive charging model reset")
+
+	d.maintainLoopForced()
+}
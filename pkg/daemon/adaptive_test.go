@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/adaptive"
+	"github.com/charlie0129/batt/pkg/compatibility"
+)
+
+func TestAdaptiveChargingTopsUp(t *testing.T) {
+	backend, root := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "80",
+		"BAT0/status":                       "Not charging",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+	modelPath := filepath.Join(t.TempDir(), "batt.adaptive.json")
+
+	mc := &mockConf{upper: 80, lower: 78}
+	d := newTestDaemon(t, backend, mc)
+	d.capabilities = compatibility.Capabilities{
+		ChargingControl:   true,
+		ChargeControlMode: compatibility.ChargeControlFirmware,
+	}
+	d.initAdaptiveModel(modelPath)
+
+	// Three Mondays unplugged at 07:30 after a night on the charger.
+	monday := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.Local)
+	for week := 1; week <= 3; week++ {
+		unplug := monday.AddDate(0, 0, -7*week).Add(7*time.Hour + 30*time.Minute)
+		d.adaptiveModel.Update(unplug.Add(-9*time.Hour), true, adaptive.DefaultOptions)
+		d.adaptiveModel.Update(unplug, false, adaptive.DefaultOptions)
+	}
+
+	topUpAt := monday.Add(6*time.Hour + 30*time.Minute)
+	d.updateAdaptiveCharging(topUpAt, 80)
+	if upper, _ := d.effectiveLimits(); upper != 80 {
+		t.Fatalf("upper limit = %d while adaptive charging is disabled, want 80", upper)
+	}
+
+	mc.adaptiveCharging = true
+	d.updateAdaptiveCharging(topUpAt.Add(-time.Minute), 80)
+	if upper, _ := d.effectiveLimits(); upper != 80 {
+		t.Fatalf("upper limit = %d before the top-up, want 80", upper)
+	}
+	d.updateAdaptiveCharging(topUpAt, 80)
+	if upper, _ := d.effectiveLimits(); upper != 100 {
+		t.Fatalf("upper limit = %d during the top-up, want 100", upper)
+	}
+
+	// Unplugging ends the top-up and persists the session.
+	if err := os.WriteFile(filepath.Join(root, "AC", "online"), []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.updateAdaptiveCharging(topUpAt.Add(time.Hour), 80)
+	if d.adaptiveChargingToppingUp() {
+		t.Fatal("top-up must end when unplugged")
+	}
+	// A damaged model is read back from its backup.
+	if err := os.WriteFile(modelPath, []byte(`{"unplugs":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	restarted := newTestDaemon(t, backend, mc)
+	restarted.initAdaptiveModel(modelPath)
+	if n := len(restarted.adaptiveModel.Unplugs[time.Monday]); n != 3 {
+		t.Fatalf("restored model has %d Monday samples, want 3", n)
+	}
+
+	recorder := httptest.NewRecorder()
+	d.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/adaptive", nil))
+	var st adaptive.Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if !st.Enabled || st.Weekdays[0].Day != "Monday" || st.Weekdays[0].Samples != 3 || st.Weekdays[0].Typical != "07:30" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	recorder = httptest.NewRecorder()
+	d.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/adaptive/reset", nil))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("reset status = %d; body=%s", recorder.Code, recorder.Body.String())
+	}
+	if n := len(d.adaptiveModel.Unplugs[time.Monday]); n != 0 {
+		t.Fatalf("reset kept %d Monday samples", n)
+	}
+}
@@ -0,0 +1,236 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
+	"github.com/charlie0129/batt/pkg/config"
+)
+
+const defaultAuditLimit = 100
+
+func (d *Daemon) initAuditLog(path string) {
+	l, err := audit.Open(path, audit.DefaultOptions)
+	if err != nil {
+		logrus.WithError(err).Error("failed to open audit log, changes will not be audited")
+		return
+	}
+	d.auditLog = l
+}
+
+// recordAudit appends a change of field from old to new made by a. Nothing
+// is recorded if the value did not change.
+func (d *Daemon) recordAudit(a audit.Actor, field string, old, new any) {
+	if d.auditLog == nil || reflect.DeepEqual(old, new) {
+		return
+	}
+	e := audit.Entry{Time: d.clock.Now(), Actor: a, Field: field}
+	var err error
+	if e.Old, err = json.Marshal(auditValue(old)); err == nil {
+		e.New, err = json.Marshal(auditValue(new))
+	}
+	if err == nil {
+		err = d.auditLog.Append(e)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("field", field).Warn("failed to record audit entry")
+	}
+}
+
+// auditValue records unset times as null.
+func auditValue(v any) any {
+	if t, ok := v.(time.Time); ok && t.IsZero() {
+		return nil
+	}
+	return v
+}
+
+// requestActor identifies the caller of an API request by its uid.
+func requestActor(c *gin.Context) audit.Actor {
+	if cred, ok := c.Request.Context().Value(peerCredKey{}).(peerCred); ok {
+		return audit.User(cred.uid)
+	}
+	return audit.ActorAPI
+}
+
+// auditingConfig records every change made through it as made by actor.
+type auditingConfig struct {
+	config.Config
+	d     *Daemon
+	actor audit.Actor
+}
+
+// auditing returns c with its changes attributed to a in the audit log.
+func (d *Daemon) auditing(c config.Config, a audit.Actor) config.Config {
+	return &auditingConfig{Config: c, d: d, actor: a}
+}
+
+func (c *auditingConfig) SetUpperLimit(v int) error {
+	old := c.Config.UpperLimit()
+	if err := c.Config.SetUpperLimit(v); err != nil {
+		return err
+	}
+	c.d.recordAudit(c.actor, "limit", old, c.Config.UpperLimit())
+	return nil
+}
+
+func (c *auditingConfig) SetLowerLimit(v int) error {
+	old := c.Config.LowerLimit()
+	if err := c.Config.SetLowerLimit(v); err != nil {
+		return err
+	}
+	c.d.recordAudit(c.actor, "lowerLimit", old, c.Config.LowerLimit())
+	return nil
+}
+
+func (c *auditingConfig) SetPreventIdleSleep(v bool) {
+	old := c.Config.PreventIdleSleep()
+	c.Config.SetPreventIdleSleep(v)
+	c.d.recordAudit(c.actor, "preventIdleSleep", old, v)
+}
+
+func (c *auditingConfig) SetDisableChargingPreSleep(v bool) {
+	old := c.Config.DisableChargingPreSleep()
+	c.Config.SetDisableChargingPreSleep(v)
+	c.d.recordAudit(c.actor, "disableChargingPreSleep", old, v)
+}
+
+func (c *auditingConfig) SetPreventSystemSleep(v bool) {
+	old := c.Config.PreventSystemSleep()
+	c.Config.SetPreventSystemSleep(v)
+	c.d.recordAudit(c.actor, "preventSystemSleep", old, v)
+}
+
+func (c *auditingConfig) SetAllowNonRootAccess(v bool) {
+	old := c.Config.AllowNonRootAccess()
+	c.Config.SetAllowNonRootAccess(v)
+	c.d.recordAudit(c.actor, "allowNonRootAccess", old, v)
+}
+
+func (c *auditingConfig) SetControlMagSafeLED(v config.ControlMagSafeMode) {
+	old := c.Config.ControlMagSafeLED()
+	c.Config.SetControlMagSafeLED(v)
+	c.d.recordAudit(c.actor, "controlMagSafeLED", old, v)
+}
+
+func (c *auditingConfig) SetCron(v string) {
+	old := c.Config.Cron()
+	c.Config.SetCron(v)
+	c.d.recordAudit(c.actor, "cron", old, v)
+}
+
+func (c *auditingConfig) SetCalibrationDischargeThreshold(v int) {
+	old := c.Config.CalibrationDischargeThreshold()
+	c.Config.SetCalibrationDischargeThreshold(v)
+	c.d.recordAudit(c.actor, "calibrationDischargeThreshold", old, c.Config.CalibrationDischargeThreshold())
+}
+
+func (c *auditingConfig) SetCalibrationHoldDurationMinutes(v int) {
+	old := c.Config.CalibrationHoldDurationMinutes()
+	c.Config.SetCalibrationHoldDurationMinutes(v)
+	c.d.recordAudit(c.actor, "calibrationHoldDurationMinutes", old, c.Config.CalibrationHoldDurationMinutes())
+}
+
+func (c *auditingConfig) SetDisableTimer(until time.Time, limit int) error {
+	oldUntil, oldLimit := c.Config.DisableUntil(), c.Config.PreDisableLimit()
+	if err := c.Config.SetDisableTimer(until, limit); err != nil {
+		return err
+	}
+	c.recordDisableTimer(oldUntil, oldLimit)
+	return nil
+}
+
+func (c *auditingConfig) ClearDisableTimer() {
+	oldUntil, oldLimit := c.Config.DisableUntil(), c.Config.PreDisableLimit()
+	c.Config.ClearDisableTimer()
+	c.recordDisableTimer(oldUntil, oldLimit)
+}
+
+func (c *auditingConfig) recordDisableTimer(oldUntil time.Time, oldLimit int) {
+	c.d.recordAudit(c.actor, "disableUntil", oldUntil, c.Config.DisableUntil())
+	c.d.recordAudit(c.actor, "preDisableLimit", oldLimit, c.Config.PreDisableLimit())
+}
+
+func (c *auditingConfig) SetAdapterDisableTimer(until time.Time) {
+	old := c.Config.AdapterDisableUntil()
+	c.Config.SetAdapterDisableTimer(until)
+	c.d.recordAudit(c.actor, "adapterDisableUntil", old, c.Config.AdapterDisableUntil())
+}
+
+func (c *auditingConfig) ClearAdapterDisableTimer() {
+	old := c.Config.AdapterDisableUntil()
+	c.Config.ClearAdapterDisableTimer()
+	c.d.recordAudit(c.actor, "adapterDisableUntil", old, c.Config.AdapterDisableUntil())
+}
+
+func (c *auditingConfig) SetAdaptiveCharging(v bool) {
+	old := c.Config.AdaptiveCharging()
+	c.Config.SetAdaptiveCharging(v)
+	c.d.recordAudit(c.actor, "adaptiveCharging", old, v)
+}
+
+func (c *auditingConfig) SetWebhooks(v []config.Webhook) {
+	old := c.Config.Webhooks()
+	c.Config.SetWebhooks(v)
+	c.d.recordAudit(c.actor, "webhooks", redactWebhooks(old), redactWebhooks(c.Config.Webhooks()))
+}
+
+// redactWebhooks returns a copy of webhooks with their secrets redacted.
+func redactWebhooks(webhooks []config.Webhook) []config.Webhook {
+	webhooks = slices.Clone(webhooks)
+	for i := range webhooks {
+		if webhooks[i].Secret != "" {
+			webhooks[i].Secret = redactedSecret
+		}
+	}
+	return webhooks
+}
+
+// getAudit serves /audit?since=&limit=. It returns the newest limit entries
+// in chronological order, 100 by default or all of them if limit is 0.
+func (d *Daemon) getAudit(c *gin.Context) {
+	if d.auditLog == nil {
+		abortWithError(c, api.Errorf(api.CodeUnavailable, "audit log is not available"))
+		return
+	}
+
+	var since time.Time
+	if v := c.Query("since"); v != "" {
+		t, err := parseHistoryTime(v)
+		if err != nil {
+			abortWithError(c, invalidArgument(err))
+			return
+		}
+		since = t
+	}
+	limit := defaultAuditLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			abortWithError(c, api.Errorf(api.CodeInvalidArgument, "invalid limit %q: expected a non-negative integer", v))
+			return
+		}
+		limit = n
+	}
+
+	entries, err := d.auditLog.Query(since, limit)
+	if err != nil {
+		logrus.Errorf("getAudit failed: %v", err)
+		abortWithError(c, err)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	c.IndentedJSON(http.StatusOK, entries)
+}
@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/compatibility"
+)
+
+func TestAuditRecordsChanges(t *testing.T) {
+	backend, _ := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "60",
+		"BAT0/status":                       "Charging",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+	configured := &mockConf{upper: 80, lower: 78}
+	d := newTestDaemon(t, backend, configured)
+	d.capabilities = compatibility.Capabilities{ChargingControl: true, ChargeControlMode: compatibility.ChargeControlFirmware}
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
+	d.calibrationStatePath = ""
+	var err error
+	d.auditLog, err = audit.Open(filepath.Join(t.TempDir(), "batt.audit.jsonl"), audit.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "/v1/limit", strings.NewReader(`{"upper":70}`))
+	request = request.WithContext(context.WithValue(request.Context(), peerCredKey{}, peerCred{uid: 501, gid: 20}))
+	response := httptest.NewRecorder()
+	d.router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d; body: %s", response.Code, response.Body.String())
+	}
+
+	// Rejected changes are not recorded.
+	decodeAPIError(t, serveV1(t, d, http.MethodPut, "/v1/limit", `{"upper":5}`), http.StatusBadRequest, api.CodeInvalidArgument)
+
+	now := time.Now()
+	until := now.Add(-time.Minute)
+	configured.disableUntil = until
+	configured.preDisableLimit = 75
+	if !d.restoreDisabledLimit(now) {
+		t.Fatal("temporary disable was not restored")
+	}
+
+	response = serveV1(t, d, http.MethodGet, "/v1/audit", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d; body: %s", response.Code, response.Body.String())
+	}
+	var entries []audit.Entry
+	if err := json.Unmarshal(response.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+
+	type change struct {
+		actor    audit.Actor
+		field    string
+		old, new string
+	}
+	untilJSON, _ := json.Marshal(until)
+	var got []change
+	for _, e := range entries {
+		got = append(got, change{e.Actor, e.Field, string(e.Old), string(e.New)})
+	}
+	want := []change{
+		{"uid:501", "limit", "80", "70"},
+		{audit.ActorTimer, "disableUntil", string(untilJSON), "null"},
+		{audit.ActorTimer, "preDisableLimit", "75", "0"},
+		{audit.ActorTimer, "limit", "70", "75"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	response = serveV1(t, d, http.MethodGet, "/v1/audit?limit=1", "")
+	if err := json.Unmarshal(response.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Field != "limit" || entries[0].Actor != audit.ActorTimer {
+		t.Fatalf("limited entries = %+v", entries)
+	}
+	decodeAPIError(t, serveV1(t, d, http.MethodGet, "/v1/audit?limit=x", ""), http.StatusBadRequest, api.CodeInvalidArgument)
+}
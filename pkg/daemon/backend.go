@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"errors"
+
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/powerinfo"
+	"github.com/charlie0129/batt/pkg/smc"
+	"github.com/charlie0129/batt/pkg/sysfs"
+)
+
+// ChargeBackend is the hardware the daemon reads battery state from and
+// enforces charge limits with. Methods that a backend cannot support return
+// an error; detectCapabilities keeps the daemon from calling them.
+type ChargeBackend interface {
+	Close() error
+
+	ChargeControlMode() compatibility.ChargeControlMode
+	IsChargingControlCapable() bool
+	IsAdapterControlCapable() bool
+	CheckMagSafeExistence() bool
+
+	GetBatteryCharge() (int, error)
+	IsPluggedIn() (bool, error)
+
+	// Legacy (direct) charging control.
+	IsChargingEnabled() (bool, error)
+	EnableCharging() error
+	DisableCharging() error
+
+	// Firmware-managed charge limits.
+	EnsureFirmwareChargeLimit(lower, upper int) (bool, error)
+	EnsureFirmwareChargeLimitDisabled() (bool, error)
+	ResetChargeControl() error
+
+	IsAdapterEnabled() (bool, error)
+	EnableAdapter() error
+	DisableAdapter() error
+
+	GetMagSafeLedState() (smc.MagSafeLedState, error)
+	SetMagSafeLedState(state smc.MagSafeLedState) error
+	DisableMagSafeLed() error
+	SetMagSafeCharging(charging bool) error
+}
+
+// errorCounter is implemented by backends that count failed hardware reads
+// and writes.
+type errorCounter interface {
+	ErrorCounts() (reads, writes uint64)
+}
+
+// powerInfoReader is implemented by backends that can report battery info
+// and power telemetry themselves. Others fall back to the platform source.
+type powerInfoReader interface {
+	BatteryInfo() (*powerinfo.Battery, error)
+	PowerTelemetry() (*powerinfo.PowerTelemetry, error)
+}
+
+// temperatureReader is implemented by backends that can read the battery
+// temperature.
+type temperatureReader interface {
+	GetBatteryTemperature() (float64, error)
+}
+
+var (
+	_ ChargeBackend     = (*smc.AppleSMC)(nil)
+	_ ChargeBackend     = (*sysfs.PowerSupply)(nil)
+	_ powerInfoReader   = (*sysfs.PowerSupply)(nil)
+	_ errorCounter      = (*smc.AppleSMC)(nil)
+	_ errorCounter      = (*sysfs.PowerSupply)(nil)
+	_ temperatureReader = (*smc.AppleSMC)(nil)
+	_ temperatureReader = (*sysfs.PowerSupply)(nil)
+)
+
+func (d *Daemon) readBatteryInfo() (*powerinfo.Battery, error) {
+	if r, ok := d.backend.(powerInfoReader); ok {
+		return r.BatteryInfo()
+	}
+	return platformBatteryInfo()
+}
+
+func (d *Daemon) readPowerTelemetry() (*powerinfo.PowerTelemetry, error) {
+	if r, ok := d.backend.(powerInfoReader); ok {
+		return r.PowerTelemetry()
+	}
+	return platformPowerTelemetry()
+}
+
+var errTemperatureUnsupported = errors.New("battery temperature is not supported by this backend")
+
+func (d *Daemon) readBatteryTemperature() (float64, error) {
+	if r, ok := d.backend.(temperatureReader); ok {
+		return r.GetBatteryTemperature()
+	}
+	return 0, errTemperatureUnsupported
+}
+
+// readCharging reports whether the battery is charging. In legacy mode batt
+// decides whether to charge, otherwise the battery is asked what it is doing.
+func (d *Daemon) readCharging() (bool, error) {
+	if d.capabilities.ChargeControlMode == compatibility.ChargeControlLegacy {
+		return d.backend.IsChargingEnabled()
+	}
+	info, err := d.readBatteryInfo()
+	if err != nil {
+		return false, err
+	}
+	return info.State == powerinfo.Charging, nil
+}
@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charlie0129/batt/pkg/smc"
+)
+
+// openChargeBackend opens the Apple SMC. If smcTracePath is not empty, every
+// SMC call is recorded to that file, see smc.AppleSMC.Record. sysfsRoot is
+// unused on macOS.
+func openChargeBackend(_, smcTracePath string) (ChargeBackend, error) {
+	conn := smc.New()
+	if smcTracePath != "" {
+		f, err := os.OpenFile(smcTracePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open SMC trace: %w", err)
+		}
+		conn.Record(f)
+	}
+	if err := conn.Open(); err != nil {
+		return nil, fmt.Errorf("open Apple SMC: %w", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,21 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/charlie0129/batt/pkg/sysfs"
+)
+
+// openChargeBackend opens the power_supply class below sysfsRoot. There is
+// no SMC to trace on Linux.
+func openChargeBackend(sysfsRoot, smcTracePath string) (ChargeBackend, error) {
+	if smcTracePath != "" {
+		return nil, errors.New("SMC traces are only available on macOS")
+	}
+	ps := sysfs.New(sysfsRoot)
+	if err := ps.Open(); err != nil {
+		return nil, fmt.Errorf("open power supplies in sysfs: %w", err)
+	}
+	return ps, nil
+}
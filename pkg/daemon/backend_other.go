@@ -0,0 +1,12 @@
+//go:build !darwin && !linux
+
+package daemon
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func openChargeBackend(_, _ string) (ChargeBackend, error) {
+	return nil, fmt.Errorf("no charge backend available on %s", runtime.GOOS)
+}
@@ -0,0 +1,84 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/powerinfo"
+	"github.com/charlie0129/batt/pkg/sysfs"
+)
+
+// newFakeSysfsBackend opens a sysfs backend on a temporary power_supply tree.
+// Keys are paths relative to the root, e.g. "BAT0/capacity".
+func newFakeSysfsBackend(t *testing.T, files map[string]string) (*sysfs.PowerSupply, string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend := sysfs.New(root)
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return backend, root
+}
+
+func TestSysfsBackendMaintainsThresholds(t *testing.T) {
+	backend, root := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                       "65",
+		"BAT0/status":                         "Charging",
+		"BAT0/charge_control_start_threshold": "0",
+		"BAT0/charge_control_end_threshold":   "100",
+		"AC/online":                           "1",
+	})
+	readThreshold := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(root, "BAT0", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(b))
+	}
+
+	d := newTestDaemon(t, backend, &mockConf{upper: 80, lower: 75})
+
+	if !d.capabilities.ChargingControl || d.capabilities.ChargeControlMode != compatibility.ChargeControlFirmware {
+		t.Fatalf("unexpected charge control capability: %+v", d.capabilities)
+	}
+	if d.capabilities.SleepHooks || d.capabilities.MagSafeLED || d.capabilities.AdapterControl || d.capabilities.Calibration {
+		t.Fatalf("unexpected sysfs capabilities: %+v", d.capabilities)
+	}
+
+	if !d.maintainLoopForced() {
+		t.Fatal("sysfs maintain loop failed")
+	}
+	if start, end := readThreshold(sysfs.StartThresholdFile), readThreshold(sysfs.EndThresholdFile); start != "75" || end != "80" {
+		t.Fatalf("thresholds = %s/%s, want 75/80", start, end)
+	}
+
+	if err := d.conf.SetUpperLimit(100); err != nil {
+		t.Fatal(err)
+	}
+	if !d.maintainLoopForced() {
+		t.Fatal("sysfs disable loop failed")
+	}
+	if start, end := readThreshold(sysfs.StartThresholdFile), readThreshold(sysfs.EndThresholdFile); start != "0" || end != "100" {
+		t.Fatalf("thresholds = %s/%s, want 0/100", start, end)
+	}
+
+	info, err := d.readBatteryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != powerinfo.Charging {
+		t.Fatalf("battery state = %d, want charging", info.State)
+	}
+}
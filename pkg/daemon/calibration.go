@@ -2,86 +2,76 @@ package daemon
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"sync"
+	"io/fs"
 	"time"
 
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
 	"github.com/charlie0129/batt/pkg/calibration"
 	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
 	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/persist"
 )
 
-// smc accessors (function vars) for test seam; default to smcConn methods.
-var (
-	smcGetBatteryCharge     = func() (int, error) { return smcConn.GetBatteryCharge() }
-	smcIsChargingEnabled    = func() (bool, error) { return smcConn.IsChargingEnabled() }
-	smcEnableCharging       = func() error { return smcConn.EnableCharging() }
-	smcDisableCharging      = func() error { return smcConn.DisableCharging() }
-	smcIsAdapterEnabled     = func() (bool, error) { return smcConn.IsAdapterEnabled() }
-	smcEnableAdapter        = func() error { return smcConn.EnableAdapter() }
-	smcDisableAdapter       = func() error { return smcConn.DisableAdapter() }
-	smcIsPluggedIn          = func() (bool, error) { return smcConn.IsPluggedIn() }
-	preventCalibrationSleep = PreventCalibrationSleep
-	allowCalibrationSleep   = AllowCalibrationSleep
-)
-
-func calibrationSessionActive() bool {
-	return calibrationState.Phase != calibration.PhaseIdle && calibrationState.Phase != calibration.PhaseError
+func (d *Daemon) calibrationSessionActive() bool {
+	return d.calibrationState.Phase != calibration.PhaseIdle && d.calibrationState.Phase != calibration.PhaseError
 }
 
 // calibrationOwnsChargeLimit reports whether a calibration still has to write
 // back the charge limit it snapshotted. It is broader than
 // calibrationSessionActive: a failed calibration keeps its snapshot until it is
 // cancelled, and cancelling restores it.
-func calibrationOwnsChargeLimit() bool {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
+func (d *Daemon) calibrationOwnsChargeLimit() bool {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
 
-	return calibrationState.Phase != calibration.PhaseIdle
+	return d.calibrationState.Phase != calibration.PhaseIdle
 }
 
-func releaseCalibrationSleepAssertion() {
-	if err := allowCalibrationSleep(); err != nil {
+func (d *Daemon) releaseCalibrationSleepAssertion() {
+	if err := d.allowCalibrationSleep(); err != nil {
 		logrus.WithError(err).Error("failed to release calibration sleep assertion")
 	}
 }
 
-func restoreCalibrationSleepAssertion() {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	if !calibrationSessionActive() {
+func (d *Daemon) restoreCalibrationSleepAssertion() {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	if !d.calibrationSessionActive() {
 		return
 	}
-	if err := preventCalibrationSleep(); err != nil {
+	if err := d.preventCalibrationSleep(); err != nil {
 		logrus.WithError(err).Error("failed to restore calibration sleep assertion")
 	}
 }
 
-func calibrationNeedsMaintainLoop() bool {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	return calibrationSessionActive() && !calibrationState.Paused
+func (d *Daemon) calibrationNeedsMaintainLoop() bool {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	return d.calibrationSessionActive() && !d.calibrationState.Paused
 }
 
-func enableChargingForCalibration() error {
-	if capabilities.ChargeControlMode == compatibility.ChargeControlFirmware {
-		_, err := smcConn.EnsureFirmwareChargeLimitDisabled()
+func (d *Daemon) enableChargingForCalibration() error {
+	if d.capabilities.ChargeControlMode == compatibility.ChargeControlFirmware {
+		_, err := d.backend.EnsureFirmwareChargeLimitDisabled()
 		return err
 	}
-	return smcEnableCharging()
+	return d.backend.EnableCharging()
 }
 
-func restoreChargeControlAfterCalibration(st *calibration.State) {
-	if capabilities.ChargeControlMode == compatibility.ChargeControlFirmware {
+func (d *Daemon) restoreChargeControlAfterCalibration(st *calibration.State) {
+	if d.capabilities.ChargeControlMode == compatibility.ChargeControlFirmware {
 		var err error
 		if st.SnapshotMaintain {
-			_, err = smcConn.EnsureFirmwareChargeLimit(st.SnapshotLowerLimit, st.SnapshotUpperLimit)
+			_, err = d.backend.EnsureFirmwareChargeLimit(st.SnapshotLowerLimit, st.SnapshotUpperLimit)
 		} else {
-			_, err = smcConn.EnsureFirmwareChargeLimitDisabled()
+			_, err = d.backend.EnsureFirmwareChargeLimitDisabled()
 		}
 		if err != nil {
 			logrus.WithError(err).Error("failed to restore firmware charge limit after calibration")
@@ -90,76 +80,65 @@ func restoreChargeControlAfterCalibration(st *calibration.State) {
 	}
 
 	if st.SnapshotChargingOn {
-		_ = smcEnableCharging()
+		_ = d.backend.EnableCharging()
 	} else {
-		_ = smcDisableCharging()
+		_ = d.backend.DisableCharging()
 	}
 }
 
-var (
-	// chargeControlTransitionMu serializes admission of calibration and temporary
-	// charge/adapter disable operations so concurrent requests cannot both pass
-	// their conflict checks before either operation persists its state.
-	chargeControlTransitionMu = &sync.Mutex{}
-	calibrationMu             = &sync.Mutex{}
-	calibrationState          = &calibration.State{Phase: calibration.PhaseIdle}
-	calibrationStatePath      = "" // set during daemon Run? Could derive from config path + suffix.
-)
-
-func initCalibrationState(path string) {
-	calibrationStatePath = path
+func (d *Daemon) initCalibrationState(path string) {
+	d.calibrationStatePath = path
 	// Try load existing state
-	b, err := os.ReadFile(path)
+	var st calibration.State
+	err := persist.ReadFile(path, func(b []byte) error {
+		st = calibration.State{}
+		return json.Unmarshal(b, &st)
+	}, d.restored.readOptions())
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			return
 		}
-		logrus.WithError(err).Warn("failed to read calibration state")
-		return
-	}
-	var st calibration.State
-	if err := json.Unmarshal(b, &st); err != nil {
-		logrus.WithError(err).Warn("failed to unmarshal calibration state")
+		logrus.WithError(err).Warn("failed to load calibration state")
 		return
 	}
 	// On restart, mark paused (safety) if mid-flow
 	if st.Phase != calibration.PhaseIdle && st.Phase != calibration.PhaseRestore && st.Phase != calibration.PhaseError {
 		st.Paused = true
 	}
-	calibrationState = &st
+	d.calibrationState = &st
 }
 
-func persistCalibrationState() {
-	if calibrationStatePath == "" {
+func (d *Daemon) persistCalibrationState() {
+	if d.calibrationStatePath == "" {
 		return
 	}
-	b, err := json.MarshalIndent(calibrationState, "", "  ")
+	b, err := json.MarshalIndent(d.calibrationState, "", "  ")
 	if err != nil {
 		logrus.WithError(err).Error("marshal calibration state")
 		return
 	}
-	if err := os.WriteFile(calibrationStatePath, b, 0644); err != nil {
+	if err := persist.WriteFile(d.calibrationStatePath, b, 0644); err != nil {
 		logrus.WithError(err).Error("write calibration state")
 	}
 }
 
-func startCalibration(threshold, holdMinutes int) error {
-	chargeControlTransitionMu.Lock()
-	defer chargeControlTransitionMu.Unlock()
+func (d *Daemon) startCalibration(a audit.Actor, threshold, holdMinutes int) error {
+	d.chargeControlTransitionMu.Lock()
+	defer d.chargeControlTransitionMu.Unlock()
 
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
 
-	if calibrationState.Phase != calibration.PhaseIdle && calibrationState.Phase != calibration.PhaseError {
+	if d.calibrationState.Phase != calibration.PhaseIdle && d.calibrationState.Phase != calibration.PhaseError {
 		return ErrCalibrationInProgress
 	}
-	if !conf.DisableUntil().IsZero() {
+	if !d.conf.DisableUntil().IsZero() {
 		return ErrTemporaryDisableInProgress
 	}
-	if !conf.AdapterDisableUntil().IsZero() {
+	if !d.conf.AdapterDisableUntil().IsZero() {
 		return ErrTemporaryAdapterDisableInProgress
 	}
-	if err := preventCalibrationSleep(); err != nil {
+	if err := d.preventCalibrationSleep(); err != nil {
 		return fmt.Errorf("prevent sleep during calibration: %w", err)
 	}
 
@@ -175,25 +154,26 @@ func startCalibration(threshold, holdMinutes int) error {
 	if holdMinutes > 24*60 {
 		holdMinutes = 24 * 60
 	}
-	upper := conf.UpperLimit()
-	lower := conf.LowerLimit()
+	upper := d.conf.UpperLimit()
+	lower := d.conf.LowerLimit()
 	chargingEnabled := true
-	if capabilities.ChargeControlMode != compatibility.ChargeControlFirmware {
-		chargingEnabled, _ = smcIsChargingEnabled()
+	if d.capabilities.ChargeControlMode != compatibility.ChargeControlFirmware {
+		chargingEnabled, _ = d.backend.IsChargingEnabled()
 	}
-	adapterEnabled, _ := smcIsAdapterEnabled()
+	adapterEnabled, _ := d.backend.IsAdapterEnabled()
 
-	if sseHub != nil {
-		sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+	if d.hub != nil {
+		d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 			Action:  string(calibration.ActionStart),
 			Message: fmt.Sprintf("Start calibration: discharging to %d%%", threshold),
-			Ts:      time.Now().Unix(),
+			Ts:      d.clock.Now().Unix(),
 		})
 	}
 
-	calibrationState = &calibration.State{
+	d.recordAudit(a, "calibration", d.calibrationState.Phase, calibration.PhaseDischarge)
+	d.calibrationState = &calibration.State{
 		Phase:              calibration.PhaseDischarge,
-		StartedAt:          time.Now(),
+		StartedAt:          d.clock.Now(),
 		Paused:             false,
 		SnapshotUpperLimit: upper,
 		SnapshotLowerLimit: lower,
@@ -204,7 +184,7 @@ func startCalibration(threshold, holdMinutes int) error {
 		HoldMinutes:        holdMinutes,
 	}
 
-	persistCalibrationState()
+	d.persistCalibrationState()
 
 	return nil
 }
@@ -226,16 +206,14 @@ type calibrationStatus struct {
 	phase  calibration.Phase
 }
 
-var lastCalibrationStatus calibrationStatus
-
 // applyCalibrationWithinLoop advances calibration phases using a provided charge reading.
 // Returns true if calibration is active (non-idle & non-error & not paused).
 //
 //nolint:gocyclo
-func applyCalibrationWithinLoop(charge int) bool {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	st := calibrationState
+func (d *Daemon) applyCalibrationWithinLoop(charge int) bool {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	st := d.calibrationState
 	prevPhase := st.Phase
 	if st.Phase == calibration.PhaseIdle || st.Phase == calibration.PhaseError || st.Paused {
 		return false
@@ -247,9 +225,9 @@ func applyCalibrationWithinLoop(charge int) bool {
 		"operation": "calibration",
 	})
 	// Throttle debug logs to changes only.
-	if lastCalibrationStatus.charge != charge || lastCalibrationStatus.phase != st.Phase {
-		lastCalibrationStatus.charge = charge
-		lastCalibrationStatus.phase = st.Phase
+	if d.lastCalibrationStatus.charge != charge || d.lastCalibrationStatus.phase != st.Phase {
+		d.lastCalibrationStatus.charge = charge
+		d.lastCalibrationStatus.phase = st.Phase
 		log.Debug("calibration loop")
 	}
 
@@ -260,24 +238,29 @@ func applyCalibrationWithinLoop(charge int) bool {
 			st.Phase = calibration.PhaseCharge
 			logrus.Info("discharge phase complete. starting charge phase")
 			logrus.Info("enabling adapter")
-			if err := smcEnableAdapter(); err != nil {
+			if err := d.backend.EnableAdapter(); err != nil {
 				st.LastError = err.Error()
 				st.Phase = calibration.PhaseError
 				break
 			}
 			logrus.Info("enabling charging")
-			if err := enableChargingForCalibration(); err != nil {
+			if err := d.enableChargingForCalibration(); err != nil {
 				st.LastError = err.Error()
 				st.Phase = calibration.PhaseError
 				break
 			}
-			conf.SetUpperLimit(100)
-			if err := conf.Save(); err != nil {
+			cfg := d.auditing(d.conf, audit.ActorCalibration)
+			if err := cfg.SetUpperLimit(100); err != nil {
+				st.LastError = err.Error()
+				st.Phase = calibration.PhaseError
+				break
+			}
+			if err := cfg.Save(); err != nil {
 				st.LastError = err.Error()
 				st.Phase = calibration.PhaseError
 			}
 		} else {
-			adapterEnabled, err := smcIsAdapterEnabled()
+			adapterEnabled, err := d.backend.IsAdapterEnabled()
 			if err != nil {
 				logrus.WithError(err).Error("failed to check adapter state during discharge phase")
 
@@ -287,7 +270,7 @@ func applyCalibrationWithinLoop(charge int) bool {
 			}
 			if adapterEnabled {
 				log.Info("disabling adapter to allow discharge")
-				err := smcDisableAdapter()
+				err := d.backend.DisableAdapter()
 				if err != nil {
 					logrus.WithError(err).Error("failed to disable adapter during discharge phase")
 
@@ -300,15 +283,15 @@ func applyCalibrationWithinLoop(charge int) bool {
 		if charge >= 100 {
 			logrus.WithField("holdDuration", time.Duration(st.HoldMinutes)*time.Minute).Info("charge phase complete. starting hold phase")
 			st.Phase = calibration.PhaseHold
-			st.HoldEndTime = time.Now().Add(time.Duration(st.HoldMinutes) * time.Minute)
+			st.HoldEndTime = d.clock.Now().Add(time.Duration(st.HoldMinutes) * time.Minute)
 		}
 	case calibration.PhaseHold:
-		if time.Now().After(st.HoldEndTime) {
+		if d.clock.Now().After(st.HoldEndTime) {
 			logrus.Info("hold phase complete. starting post-hold phase, draining to previous limits")
 			// Begin post-hold discharge back to previous upper limit (if snapshot < 100) or current configured upper.
 			st.Phase = calibration.PhasePostHold
 			// Ensure charging disabled to allow discharge.
-			err := smcDisableAdapter()
+			err := d.backend.DisableAdapter()
 			if err != nil {
 				logrus.WithError(err).Error("failed to disable adapter during hold phase")
 			}
@@ -318,7 +301,7 @@ func applyCalibrationWithinLoop(charge int) bool {
 		// Using snapshotUpperLimit ensures we settle exactly back to prior maintain level before restoring limits & adapter/charging flags.
 		target := st.SnapshotUpperLimit
 		if target <= 20 || target > 100 { // sanity fallback
-			target = conf.UpperLimit()
+			target = d.conf.UpperLimit()
 		}
 		if charge <= target {
 			logrus.Info("post-hold phase complete. starting restore phase")
@@ -331,29 +314,27 @@ func applyCalibrationWithinLoop(charge int) bool {
 			"isCharging":       st.SnapshotChargingOn,
 			"isAdapterEnabled": st.SnapshotAdapterOn,
 		}).Info("restoring previous battery config and finishing calibration")
-		conf.SetUpperLimit(st.SnapshotUpperLimit)
-		conf.SetLowerLimit(st.SnapshotLowerLimit)
-		if err := conf.Save(); err != nil {
+		if err := restoreLimits(d.auditing(d.conf, audit.ActorCalibration), st); err != nil {
 			st.LastError = err.Error()
 			st.Phase = calibration.PhaseError
 			break
 		}
-		restoreChargeControlAfterCalibration(st)
+		d.restoreChargeControlAfterCalibration(st)
 		if st.SnapshotAdapterOn {
-			_ = smcEnableAdapter()
+			_ = d.backend.EnableAdapter()
 		} else {
-			_ = smcDisableAdapter()
+			_ = d.backend.DisableAdapter()
 		}
 		st.Phase = calibration.PhaseIdle
 	}
-	persistCalibrationState()
+	d.persistCalibrationState()
 	if st.Phase == calibration.PhaseIdle || st.Phase == calibration.PhaseError {
-		releaseCalibrationSleepAssertion()
+		d.releaseCalibrationSleepAssertion()
 	}
 
 	// Broadcast phase change if any
-	if sseHub != nil && st.Phase != prevPhase {
-		sseHub.Publish(events.CalibrationPhase, events.CalibrationPhaseEvent{
+	if d.hub != nil && st.Phase != prevPhase {
+		d.hub.Publish(events.CalibrationPhase, events.CalibrationPhaseEvent{
 			From: string(prevPhase),
 			To:   string(st.Phase),
 			Message: func() string {
@@ -368,13 +349,13 @@ func applyCalibrationWithinLoop(charge int) bool {
 				case calibration.PhasePostHold:
 					return fmt.Sprintf("Discharging to restore limits to %d%%", st.SnapshotUpperLimit)
 				case calibration.PhaseRestore:
-					return fmt.Sprintf("Calibration completed in %s", formatDuration(time.Since(st.StartedAt)))
+					return fmt.Sprintf("Calibration completed in %s", formatDuration(d.clock.Now().Sub(st.StartedAt)))
 				case calibration.PhaseError:
 					return st.LastError
 				}
 				return ""
 			}(),
-			Ts: time.Now().Unix(),
+			Ts: d.clock.Now().Unix(),
 		})
 
 		logrus.WithField("event", events.CalibrationPhase).Debug("new event")
@@ -382,91 +363,105 @@ func applyCalibrationWithinLoop(charge int) bool {
 	return true
 }
 
-func pauseCalibration() error {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	if calibrationState.Phase == calibration.PhaseIdle {
+func (d *Daemon) pauseCalibration(a audit.Actor) error {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	if d.calibrationState.Phase == calibration.PhaseIdle {
 		return ErrCalibrationNotRunning
 	}
-	if !calibrationState.Paused {
-		calibrationState.Paused = true
-		calibrationState.PauseStartedAt = time.Now()
+	if !d.calibrationState.Paused {
+		d.recordAudit(a, "calibrationPaused", false, true)
+		d.calibrationState.Paused = true
+		d.calibrationState.PauseStartedAt = d.clock.Now()
 
-		if sseHub != nil {
-			sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+		if d.hub != nil {
+			d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 				Action:  string(calibration.ActionPause),
-				Message: fmt.Sprintf("Calibration paused at phase %s", calibrationState.Phase),
-				Ts:      time.Now().Unix(),
+				Message: fmt.Sprintf("Calibration paused at phase %s", d.calibrationState.Phase),
+				Ts:      d.clock.Now().Unix(),
 			})
 		}
 
-		persistCalibrationState()
+		d.persistCalibrationState()
 	}
 
 	return nil
 }
 
-func resumeCalibration() error {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	if calibrationState.Phase == calibration.PhaseIdle {
+func (d *Daemon) resumeCalibration(a audit.Actor) error {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	if d.calibrationState.Phase == calibration.PhaseIdle {
 		return ErrCalibrationNotRunning
 	}
-	if !calibrationState.Paused {
+	if !d.calibrationState.Paused {
 		return nil
 	}
-	if calibrationState.Phase == calibration.PhaseHold && !calibrationState.PauseStartedAt.IsZero() {
-		pausedDur := time.Since(calibrationState.PauseStartedAt)
-		calibrationState.HoldEndTime = calibrationState.HoldEndTime.Add(pausedDur)
+	if d.calibrationState.Phase == calibration.PhaseHold && !d.calibrationState.PauseStartedAt.IsZero() {
+		pausedDur := d.clock.Now().Sub(d.calibrationState.PauseStartedAt)
+		d.calibrationState.HoldEndTime = d.calibrationState.HoldEndTime.Add(pausedDur)
 	}
 
-	if sseHub != nil {
-		sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+	if d.hub != nil {
+		d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 			Action:  string(calibration.ActionResume),
-			Message: fmt.Sprintf("Calibration resumed (paused at %s)", calibrationState.PauseStartedAt.Format("Jan _2 15:04")),
-			Ts:      time.Now().Unix(),
+			Message: fmt.Sprintf("Calibration resumed (paused at %s)", d.calibrationState.PauseStartedAt.Format("Jan _2 15:04")),
+			Ts:      d.clock.Now().Unix(),
 		})
 	}
 
-	calibrationState.Paused = false
-	calibrationState.PauseStartedAt = time.Time{}
+	d.recordAudit(a, "calibrationPaused", true, false)
+	d.calibrationState.Paused = false
+	d.calibrationState.PauseStartedAt = time.Time{}
 
-	persistCalibrationState()
+	d.persistCalibrationState()
 	return nil
 }
 
-func cancelCalibration() error {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	if calibrationState.Phase == calibration.PhaseIdle {
+// restoreLimits sets the limits calibration snapshotted back and saves them.
+func restoreLimits(cfg config.Config, st *calibration.State) error {
+	if err := cfg.SetUpperLimit(st.SnapshotUpperLimit); err != nil {
+		return err
+	}
+	if err := cfg.SetLowerLimit(st.SnapshotLowerLimit); err != nil {
+		return err
+	}
+	return cfg.Save()
+}
+
+func (d *Daemon) cancelCalibration(a audit.Actor) error {
+	cfg := d.auditing(d.conf, a)
+
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	if d.calibrationState.Phase == calibration.PhaseIdle {
 		return ErrCalibrationNotRunning
 	}
 
-	st := calibrationState
-	conf.SetUpperLimit(st.SnapshotUpperLimit)
-	conf.SetLowerLimit(st.SnapshotLowerLimit)
-	if err := conf.Save(); err != nil {
-		logrus.WithError(err).Warn("failed to save config while canceling calibration")
+	st := d.calibrationState
+	if err := restoreLimits(cfg, st); err != nil {
+		logrus.WithError(err).Warn("failed to restore limits while canceling calibration")
 	}
 
-	restoreChargeControlAfterCalibration(st)
+	d.restoreChargeControlAfterCalibration(st)
 	if st.SnapshotAdapterOn {
-		_ = smcEnableAdapter()
+		_ = d.backend.EnableAdapter()
 	} else {
-		_ = smcDisableAdapter()
+		_ = d.backend.DisableAdapter()
 	}
 
-	if sseHub != nil {
-		sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+	if d.hub != nil {
+		d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 			Action:  string(calibration.ActionCancel),
 			Message: fmt.Sprintf("Calibration canceled at phase %s and restored to previous state", st.Phase),
-			Ts:      time.Now().Unix(),
+			Ts:      d.clock.Now().Unix(),
 		})
 	}
+	d.recordAudit(a, "calibration", st.Phase, calibration.PhaseIdle)
 
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	persistCalibrationState()
-	releaseCalibrationSleepAssertion()
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
+	d.persistCalibrationState()
+	d.releaseCalibrationSleepAssertion()
 	return nil
 }
 
@@ -477,52 +472,53 @@ func cancelCalibration() error {
 //
 // This is used when disabling batt (limit=100%) to ensure calibration is not left
 // in a non-idle state.
-func cancelCalibrationNoRestoreNoError() {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	if calibrationState.Phase == calibration.PhaseIdle {
+func (d *Daemon) cancelCalibrationNoRestoreNoError() {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	if d.calibrationState.Phase == calibration.PhaseIdle {
 		return
 	}
 
-	st := calibrationState
+	st := d.calibrationState
 	if st.SnapshotAdapterOn {
-		_ = smcEnableAdapter()
+		_ = d.backend.EnableAdapter()
 	} else {
-		_ = smcDisableAdapter()
+		_ = d.backend.DisableAdapter()
 	}
 
-	if sseHub != nil {
-		sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+	if d.hub != nil {
+		d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 			Action:  string(calibration.ActionCancel),
-			Message: fmt.Sprintf("Calibration cancelled because at phase %s", calibrationState.Phase),
-			Ts:      time.Now().Unix(),
+			Message: fmt.Sprintf("Calibration cancelled because at phase %s", d.calibrationState.Phase),
+			Ts:      d.clock.Now().Unix(),
 		})
 	}
+	d.recordAudit(audit.ActorDaemon, "calibration", st.Phase, calibration.PhaseIdle)
 
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	persistCalibrationState()
-	releaseCalibrationSleepAssertion()
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
+	d.persistCalibrationState()
+	d.releaseCalibrationSleepAssertion()
 }
 
-func getCalibrationStatus() *calibration.Status {
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	st := calibrationState
-	charge, _ := smcGetBatteryCharge()
+func (d *Daemon) getCalibrationStatus() *calibration.Status {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	st := d.calibrationState
+	charge, _ := d.backend.GetBatteryCharge()
 	if charge < 0 {
 		charge = 0
 	} else if charge > 100 {
 		charge = 100
 	}
-	plugged, _ := smcIsPluggedIn()
+	plugged, _ := d.backend.IsPluggedIn()
 	remain := 0
 	if st.Phase == calibration.PhaseHold && !st.HoldEndTime.IsZero() {
 		effectiveEnd := st.HoldEndTime
 		if st.Paused && !st.PauseStartedAt.IsZero() {
-			effectiveEnd = effectiveEnd.Add(time.Since(st.PauseStartedAt))
+			effectiveEnd = effectiveEnd.Add(d.clock.Now().Sub(st.PauseStartedAt))
 		}
-		if time.Until(effectiveEnd) > 0 {
-			remain = int(time.Until(effectiveEnd).Seconds())
+		if effectiveEnd.Sub(d.clock.Now()) > 0 {
+			remain = int(effectiveEnd.Sub(d.clock.Now()).Seconds())
 		}
 	}
 	msg := st.LastError
@@ -532,11 +528,11 @@ func getCalibrationStatus() *calibration.Status {
 		if st.SnapshotUpperLimit > 0 && st.SnapshotUpperLimit <= 100 {
 			target = st.SnapshotUpperLimit
 		} else {
-			target = conf.UpperLimit()
+			target = d.conf.UpperLimit()
 		}
 	}
 
-	next, running := scheduler.Status()
+	next, running := d.scheduler.Status()
 	if !running {
 		next = time.Time{}
 	}
@@ -553,25 +549,27 @@ func getCalibrationStatus() *calibration.Status {
 }
 
 // schedule sets the cron expression for scheduled calibrations and returns the next run times.
-func schedule(cronExpr string) ([]time.Time, error) {
+func (d *Daemon) schedule(a audit.Actor, cronExpr string) ([]time.Time, error) {
+	cfg := d.auditing(d.conf, a)
+
 	if cronExpr == "" {
-		prevCron := conf.Cron()
+		prevCron := d.conf.Cron()
 		if prevCron == "" {
 			// Already disabled
 			return nil, nil
 		}
 
-		conf.SetCron("")
-		if err := conf.Save(); err != nil {
+		cfg.SetCron("")
+		if err := cfg.Save(); err != nil {
 			logrus.WithError(err).Error("failed to save config")
 			return nil, fmt.Errorf("failed to save config: %w", err)
 		}
-		scheduler.Stop()
-		if sseHub != nil {
-			sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+		d.scheduler.Stop()
+		if d.hub != nil {
+			d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 				Action:  string(calibration.ActionScheduleDisable),
 				Message: "Calibration schedule disabled",
-				Ts:      time.Now().Unix(),
+				Ts:      d.clock.Now().Unix(),
 			})
 		}
 		return nil, nil
@@ -581,68 +579,75 @@ func schedule(cronExpr string) ([]time.Time, error) {
 	parser := cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
 	sched, err := parser.Parse(cronExpr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid cron expression: %w", err)
+		return nil, api.Errorf(api.CodeInvalidArgument, "invalid cron expression: %v", err)
 	}
 
-	conf.SetCron(cronExpr)
-	if err := conf.Save(); err != nil {
+	cfg.SetCron(cronExpr)
+	if err := cfg.Save(); err != nil {
 		logrus.WithError(err).Error("failed to save config")
 		return nil, fmt.Errorf("failed to save config: %w", err)
 	}
 
-	if err := scheduler.Schedule(cronExpr); err != nil {
+	if err := d.scheduler.Schedule(cronExpr); err != nil {
 		logrus.WithError(err).Error("failed to schedule calibration")
 		return nil, err
 	}
-	scheduler.Start()
+	d.scheduler.Start()
 
 	// generate three next run times for response
 	nextRuns := []time.Time{}
-	now := time.Now()
+	now := d.clock.Now()
 	for range 3 {
 		next := sched.Next(now)
 		nextRuns = append(nextRuns, next)
 		now = next
 	}
 
-	if sseHub != nil {
-		sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+	if d.hub != nil {
+		d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 			Action:  string(calibration.ActionSchedule),
 			Message: fmt.Sprintf("Calibration scheduled at %s", nextRuns[0].Format("Jan _2 15:04")), // TODO: use cron descriptor
-			Ts:      time.Now().Unix(),
+			Ts:      d.clock.Now().Unix(),
 		})
 	}
 
 	return nextRuns, nil
 }
 
-func postpone(duration time.Duration) error {
-	if err := scheduler.Postpone(duration); err != nil {
+func (d *Daemon) postpone(a audit.Actor, duration time.Duration) error {
+	prev, _ := d.scheduler.Status()
+	if err := d.scheduler.Postpone(duration); err != nil {
 		logrus.WithError(err).Error("failed to postpone calibration")
 		return err
 	}
+	// The scheduler moves the run asynchronously, to the same time Postpone
+	// validated.
+	d.recordAudit(a, "nextCalibration", prev, prev.Add(duration).Truncate(time.Second))
 
-	if sseHub != nil {
-		sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+	if d.hub != nil {
+		d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 			Action:  string(calibration.ActionSchedulePostpone),
 			Message: fmt.Sprintf("Calibration postponed for %s", duration.String()),
-			Ts:      time.Now().Unix(),
+			Ts:      d.clock.Now().Unix(),
 		})
 	}
 	return nil
 }
 
-func skipNextSchedule() error {
-	if err := scheduler.Skip(); err != nil {
+func (d *Daemon) skipNextSchedule(a audit.Actor) error {
+	prev, _ := d.scheduler.Status()
+	if err := d.scheduler.Skip(); err != nil {
 		logrus.WithError(err).Error("failed to skip next scheduled calibration")
 		return err
 	}
+	next, _ := d.scheduler.Status()
+	d.recordAudit(a, "nextCalibration", prev, next)
 
-	if sseHub != nil {
-		sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+	if d.hub != nil {
+		d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 			Action:  string(calibration.ActionScheduleSkip),
 			Message: "Calibration skipped",
-			Ts:      time.Now().Unix(),
+			Ts:      d.clock.Now().Unix(),
 		})
 	}
 	return nil
@@ -7,14 +7,15 @@ import (
 	"github.com/charlie0129/gosmc"
 	"github.com/sirupsen/logrus"
 
+	"github.com/charlie0129/batt/pkg/audit"
 	"github.com/charlie0129/batt/pkg/calibration"
 	"github.com/charlie0129/batt/pkg/compatibility"
 	"github.com/charlie0129/batt/pkg/config"
 	"github.com/charlie0129/batt/pkg/smc"
 )
 
-// NOTE: These tests are simplified and mock minimal parts; smcConn and conf must be
-// initialized externally for full integration. Here we focus on state transitions logic.
+// NOTE: These tests are simplified and mock minimal parts. Here we focus on
+// state transitions logic.
 
 // mockConf implements the subset of Config used in calibration for test.
 type mockConf struct {
@@ -23,6 +24,14 @@ type mockConf struct {
 	disableUntil        time.Time
 	preDisableLimit     int
 	adapterDisableUntil time.Time
+	limitProfiles       []config.LimitProfile
+	adaptiveCharging    bool
+	maxTemperature      float64
+	guardLimit          int
+	hooks               map[string][]string
+	webhooks            []config.Webhook
+	mqtt                config.MQTT
+	access              *config.Access
 }
 
 func (m *mockConf) UpperLimit() int               { return m.upper }
@@ -38,8 +47,8 @@ func (m *mockConf) CalibrationDischargeThreshold() int             { return 15 }
 func (m *mockConf) CalibrationHoldDurationMinutes() int            { return 1 }
 func (m *mockConf) SetCalibrationDischargeThreshold(int)           {}
 func (m *mockConf) SetCalibrationHoldDurationMinutes(int)          {}
-func (m *mockConf) SetUpperLimit(i int)                            { m.upper = i }
-func (m *mockConf) SetLowerLimit(i int)                            { m.lower = i }
+func (m *mockConf) SetUpperLimit(i int) error                      { m.upper = i; return nil }
+func (m *mockConf) SetLowerLimit(i int) error                      { m.lower = i; return nil }
 func (m *mockConf) SetPreventIdleSleep(bool)                       {}
 func (m *mockConf) SetDisableChargingPreSleep(bool)                {}
 func (m *mockConf) SetPreventSystemSleep(bool)                     {}
@@ -52,9 +61,10 @@ func (m *mockConf) Cron() string                                   { return "" }
 func (m *mockConf) SetCron(string)                                 {}
 func (m *mockConf) DisableUntil() time.Time                        { return m.disableUntil }
 func (m *mockConf) PreDisableLimit() int                           { return m.preDisableLimit }
-func (m *mockConf) SetDisableTimer(until time.Time, prevLimit int) {
+func (m *mockConf) SetDisableTimer(until time.Time, prevLimit int) error {
 	m.disableUntil = until
 	m.preDisableLimit = prevLimit
+	return nil
 }
 func (m *mockConf) ClearDisableTimer() {
 	m.disableUntil = time.Time{}
@@ -65,9 +75,31 @@ func (m *mockConf) SetAdapterDisableTimer(until time.Time) {
 	m.adapterDisableUntil = until
 }
 func (m *mockConf) ClearAdapterDisableTimer() { m.adapterDisableUntil = time.Time{} }
+func (m *mockConf) MetricsPort() int          { return 0 }
+func (m *mockConf) LimitProfiles() []config.LimitProfile {
+	return m.limitProfiles
+}
+func (m *mockConf) AdaptiveCharging() bool          { return m.adaptiveCharging }
+func (m *mockConf) SetAdaptiveCharging(b bool)      { m.adaptiveCharging = b }
+func (m *mockConf) MaxChargingTemperature() float64 { return m.maxTemperature }
+func (m *mockConf) TemperatureHysteresis() float64  { return 3 }
+func (m *mockConf) TemperatureGuardLimit() int      { return m.guardLimit }
+func (m *mockConf) Hooks() map[string][]string      { return m.hooks }
+func (m *mockConf) Webhooks() []config.Webhook      { return m.webhooks }
+func (m *mockConf) SetWebhooks(w []config.Webhook)  { m.webhooks = w }
+func (m *mockConf) MQTT() config.MQTT               { return m.mqtt }
+func (m *mockConf) Access() *config.Access          { return m.access }
+func (m *mockConf) Revision() uint64                { return 0 }
+func (m *mockConf) Raw() (*config.RawFileConfig, uint64) {
+	raw, _ := config.NewRawFileConfigFromConfig(m)
+	return raw, 0
+}
+func (m *mockConf) CompareAndSwap(uint64, *config.RawFileConfig) bool { return false }
 
-// Fake smcConn implementation.
+// fakeSMC is a legacy charge backend with a battery that only changes when
+// a test says so.
 type fakeSMC struct {
+	ChargeBackend
 	charge   int
 	charging bool
 	adapter  bool
@@ -77,34 +109,32 @@ func newFakeSMC(c, ch int, adapter bool) *fakeSMC {
 	return &fakeSMC{charge: c, charging: ch != 0, adapter: adapter}
 }
 
-func (f *fakeSMC) inject() {
-	smcGetBatteryCharge = func() (int, error) { return f.charge, nil }
-	smcIsChargingEnabled = func() (bool, error) { return f.charging, nil }
-	smcEnableCharging = func() error { f.charging = true; return nil }
-	smcDisableCharging = func() error { f.charging = false; return nil }
-	smcIsAdapterEnabled = func() (bool, error) { return f.adapter, nil }
-	smcEnableAdapter = func() error { f.adapter = true; return nil }
-	smcDisableAdapter = func() error { f.adapter = false; return nil }
-	smcIsPluggedIn = func() (bool, error) { return f.adapter, nil }
+func (f *fakeSMC) ChargeControlMode() compatibility.ChargeControlMode {
+	return compatibility.ChargeControlLegacy
 }
+func (f *fakeSMC) IsAdapterControlCapable() bool    { return true }
+func (f *fakeSMC) CheckMagSafeExistence() bool      { return false }
+func (f *fakeSMC) GetBatteryCharge() (int, error)   { return f.charge, nil }
+func (f *fakeSMC) IsChargingEnabled() (bool, error) { return f.charging, nil }
+func (f *fakeSMC) EnableCharging() error            { f.charging = true; return nil }
+func (f *fakeSMC) DisableCharging() error           { f.charging = false; return nil }
+func (f *fakeSMC) IsAdapterEnabled() (bool, error)  { return f.adapter, nil }
+func (f *fakeSMC) EnableAdapter() error             { f.adapter = true; return nil }
+func (f *fakeSMC) DisableAdapter() error            { f.adapter = false; return nil }
+func (f *fakeSMC) IsPluggedIn() (bool, error)       { return f.adapter, nil }
 
 type calibrationSleepCalls struct {
 	prevent int
 	allow   int
 }
 
-func stubCalibrationSleep(t *testing.T) *calibrationSleepCalls {
-	t.Helper()
-	previousPrevent, previousAllow := preventCalibrationSleep, allowCalibrationSleep
-	t.Cleanup(func() {
-		preventCalibrationSleep, allowCalibrationSleep = previousPrevent, previousAllow
-	})
+func stubCalibrationSleep(d *Daemon) *calibrationSleepCalls {
 	calls := &calibrationSleepCalls{}
-	preventCalibrationSleep = func() error {
+	d.preventCalibrationSleep = func() error {
 		calls.prevent++
 		return nil
 	}
-	allowCalibrationSleep = func() error {
+	d.allowCalibrationSleep = func() error {
 		calls.allow++
 		return nil
 	}
@@ -112,26 +142,19 @@ func stubCalibrationSleep(t *testing.T) *calibrationSleepCalls {
 }
 
 func TestStartCalibrationRejectsTemporaryDisable(t *testing.T) {
-	previousConf, previousState, previousStatePath := conf, calibrationState, calibrationStatePath
-	t.Cleanup(func() {
-		conf, calibrationState, calibrationStatePath = previousConf, previousState, previousStatePath
-	})
-
-	sleepCalls := stubCalibrationSleep(t)
-	conf = &mockConf{
+	d := newTestDaemon(t, newFakeSMC(50, 0, true), &mockConf{
 		upper:           100,
 		lower:           78,
 		disableUntil:    time.Now().Add(time.Hour),
 		preDisableLimit: 80,
-	}
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	calibrationStatePath = ""
+	})
+	sleepCalls := stubCalibrationSleep(d)
 
-	if err := startCalibration(15, 10); err != ErrTemporaryDisableInProgress {
+	if err := d.startCalibration(audit.ActorAPI, 15, 10); err != ErrTemporaryDisableInProgress {
 		t.Fatalf("startCalibration() error = %v, want %v", err, ErrTemporaryDisableInProgress)
 	}
-	if calibrationState.Phase != calibration.PhaseIdle {
-		t.Fatalf("phase = %s, want idle", calibrationState.Phase)
+	if d.calibrationState.Phase != calibration.PhaseIdle {
+		t.Fatalf("phase = %s, want idle", d.calibrationState.Phase)
 	}
 	if sleepCalls.prevent != 0 {
 		t.Fatal("rejected calibration acquired a sleep assertion")
@@ -139,21 +162,14 @@ func TestStartCalibrationRejectsTemporaryDisable(t *testing.T) {
 }
 
 func TestStartCalibrationRejectsTemporaryAdapterDisable(t *testing.T) {
-	previousConf, previousState, previousStatePath := conf, calibrationState, calibrationStatePath
-	t.Cleanup(func() {
-		conf, calibrationState, calibrationStatePath = previousConf, previousState, previousStatePath
-	})
-
-	sleepCalls := stubCalibrationSleep(t)
-	conf = &mockConf{
+	d := newTestDaemon(t, newFakeSMC(50, 0, true), &mockConf{
 		upper:               80,
 		lower:               78,
 		adapterDisableUntil: time.Now().Add(time.Hour),
-	}
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	calibrationStatePath = ""
+	})
+	sleepCalls := stubCalibrationSleep(d)
 
-	if err := startCalibration(15, 10); err != ErrTemporaryAdapterDisableInProgress {
+	if err := d.startCalibration(audit.ActorAPI, 15, 10); err != ErrTemporaryAdapterDisableInProgress {
 		t.Fatalf("startCalibration() error = %v, want %v", err, ErrTemporaryAdapterDisableInProgress)
 	}
 	if sleepCalls.prevent != 0 {
@@ -163,34 +179,31 @@ func TestStartCalibrationRejectsTemporaryAdapterDisable(t *testing.T) {
 
 // TestCalibrationFlow simulates the main phase transitions.
 func TestCalibrationFlow(t *testing.T) {
-	sleepCalls := stubCalibrationSleep(t)
-	// Inject mocks
 	fake := newFakeSMC(40, 0, true)
-	fake.inject()
-	conf = &mockConf{upper: 80, lower: 78}
-	calibrationStatePath = "" // disable persistence
+	d, clk := newTestDaemonWithClock(t, fake, &mockConf{upper: 80, lower: 78})
+	sleepCalls := stubCalibrationSleep(d)
 
-	if err := startCalibration(15, 1); err != nil {
+	if err := d.startCalibration(audit.ActorAPI, 15, 1); err != nil {
 		t.Fatalf("startCalibration failed: %v", err)
 	}
-	if calibrationState.Phase != calibration.PhaseDischarge {
-		t.Fatalf("expected discharge phase, got %s", calibrationState.Phase)
+	if d.calibrationState.Phase != calibration.PhaseDischarge {
+		t.Fatalf("expected discharge phase, got %s", d.calibrationState.Phase)
 	}
-	if err := pauseCalibration(); err != nil {
+	if err := d.pauseCalibration(audit.ActorAPI); err != nil {
 		t.Fatal(err)
 	}
 	if sleepCalls.allow != 0 {
 		t.Fatal("pausing calibration released its sleep assertion")
 	}
-	if err := resumeCalibration(); err != nil {
+	if err := d.resumeCalibration(audit.ActorAPI); err != nil {
 		t.Fatal(err)
 	}
 
 	// Move charge below threshold to trigger charging phase
 	fake.charge = 14
-	applyCalibrationWithinLoop(fake.charge)
-	if calibrationState.Phase != calibration.PhaseCharge {
-		t.Fatalf("expected charge phase, got %s", calibrationState.Phase)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if d.calibrationState.Phase != calibration.PhaseCharge {
+		t.Fatalf("expected charge phase, got %s", d.calibrationState.Phase)
 	}
 	if !fake.charging {
 		t.Fatalf("expected charging enabled")
@@ -202,32 +215,32 @@ func TestCalibrationFlow(t *testing.T) {
 
 	// Simulate reaching full
 	fake.charge = 100
-	applyCalibrationWithinLoop(fake.charge)
-	if calibrationState.Phase != calibration.PhaseHold {
-		t.Fatalf("expected hold phase, got %s", calibrationState.Phase)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if d.calibrationState.Phase != calibration.PhaseHold {
+		t.Fatalf("expected hold phase, got %s", d.calibrationState.Phase)
 	}
-	if calibrationState.HoldEndTime.IsZero() {
+	if d.calibrationState.HoldEndTime.IsZero() {
 		t.Fatalf("HoldEndTime should be set")
 	}
 
-	// Fast-forward hold period
-	calibrationState.HoldEndTime = time.Now().Add(-time.Second)
-	applyCalibrationWithinLoop(fake.charge)
-	if calibrationState.Phase != calibration.PhasePostHold {
-		t.Fatalf("expected post-hold discharge phase, got %s", calibrationState.Phase)
+	// Fast-forward the hold period of at least 10 minutes.
+	clk.Advance(10*time.Minute + time.Second)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if d.calibrationState.Phase != calibration.PhasePostHold {
+		t.Fatalf("expected post-hold discharge phase, got %s", d.calibrationState.Phase)
 	}
 
 	// Simulate discharging back down to snapshot upper limit (original upper 80)
 	fake.charge = 80
-	applyCalibrationWithinLoop(fake.charge)
-	if calibrationState.Phase != calibration.PhaseRestore {
-		t.Fatalf("expected restore phase after post-hold discharge, got %s", calibrationState.Phase)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if d.calibrationState.Phase != calibration.PhaseRestore {
+		t.Fatalf("expected restore phase after post-hold discharge, got %s", d.calibrationState.Phase)
 	}
 
 	// Perform restore
-	applyCalibrationWithinLoop(fake.charge)
-	if calibrationState.Phase != calibration.PhaseIdle {
-		t.Fatalf("expected idle at end, got %s", calibrationState.Phase)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if d.calibrationState.Phase != calibration.PhaseIdle {
+		t.Fatalf("expected idle at end, got %s", d.calibrationState.Phase)
 	}
 	if sleepCalls.prevent != 1 || sleepCalls.allow != 1 {
 		t.Fatalf("sleep assertion calls = prevent:%d allow:%d, want 1/1", sleepCalls.prevent, sleepCalls.allow)
@@ -235,7 +248,6 @@ func TestCalibrationFlow(t *testing.T) {
 }
 
 func TestCalibrationFlowWithFirmwareChargeControl(t *testing.T) {
-	sleepCalls := stubCalibrationSleep(t)
 	value := func(key string, dataType gosmc.DataType, data ...byte) gosmc.Value {
 		v, err := gosmc.NewValue(key, dataType, data)
 		if err != nil {
@@ -257,34 +269,19 @@ func TestCalibrationFlowWithFirmwareChargeControl(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	previousSMC, previousConf, previousCapabilities := smcConn, conf, capabilities
-	previousState, previousStatePath := calibrationState, calibrationStatePath
-	previousIsAdapter := smcIsAdapterEnabled
-	previousEnableAdapter, previousDisableAdapter := smcEnableAdapter, smcDisableAdapter
-	t.Cleanup(func() {
-		smcConn, conf, capabilities = previousSMC, previousConf, previousCapabilities
-		calibrationState, calibrationStatePath = previousState, previousStatePath
-		smcIsAdapterEnabled = previousIsAdapter
-		smcEnableAdapter, smcDisableAdapter = previousEnableAdapter, previousDisableAdapter
-	})
-	smcConn = mock
-	conf = &mockConf{upper: 80, lower: 78}
-	capabilities = compatibility.Capabilities{
+	d, clk := newTestDaemonWithClock(t, mock, &mockConf{upper: 80, lower: 78})
+	d.capabilities = compatibility.Capabilities{
 		ChargingControl:   true,
 		ChargeControlMode: compatibility.ChargeControlFirmware,
 		AdapterControl:    true,
 		Calibration:       true,
 	}
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	calibrationStatePath = ""
-	smcIsAdapterEnabled = func() (bool, error) { return mock.IsAdapterEnabled() }
-	smcEnableAdapter = func() error { return mock.EnableAdapter() }
-	smcDisableAdapter = func() error { return mock.DisableAdapter() }
+	sleepCalls := stubCalibrationSleep(d)
 
-	if err := startCalibration(15, 10); err != nil {
+	if err := d.startCalibration(audit.ActorAPI, 15, 10); err != nil {
 		t.Fatal(err)
 	}
-	applyCalibrationWithinLoop(40)
+	d.applyCalibrationWithinLoop(40)
 	adapterEnabled, err := mock.IsAdapterEnabled()
 	if err != nil {
 		t.Fatal(err)
@@ -293,9 +290,9 @@ func TestCalibrationFlowWithFirmwareChargeControl(t *testing.T) {
 		t.Fatal("adapter should be disabled during discharge")
 	}
 
-	applyCalibrationWithinLoop(14)
-	if calibrationState.Phase != calibration.PhaseCharge {
-		t.Fatalf("phase = %s, want charge", calibrationState.Phase)
+	d.applyCalibrationWithinLoop(14)
+	if d.calibrationState.Phase != calibration.PhaseCharge {
+		t.Fatalf("phase = %s, want charge", d.calibrationState.Phase)
 	}
 	firmwareState, err := mock.GetFirmwareChargeLimit()
 	if err != nil {
@@ -305,13 +302,13 @@ func TestCalibrationFlowWithFirmwareChargeControl(t *testing.T) {
 		t.Fatal("firmware charge limit should be inactive while charging to full")
 	}
 
-	applyCalibrationWithinLoop(100)
-	calibrationState.HoldEndTime = time.Now().Add(-time.Second)
-	applyCalibrationWithinLoop(100)
-	applyCalibrationWithinLoop(80)
-	applyCalibrationWithinLoop(80)
-	if calibrationState.Phase != calibration.PhaseIdle {
-		t.Fatalf("phase = %s, want idle", calibrationState.Phase)
+	d.applyCalibrationWithinLoop(100)
+	clk.Advance(10*time.Minute + time.Second)
+	d.applyCalibrationWithinLoop(100)
+	d.applyCalibrationWithinLoop(80)
+	d.applyCalibrationWithinLoop(80)
+	if d.calibrationState.Phase != calibration.PhaseIdle {
+		t.Fatalf("phase = %s, want idle", d.calibrationState.Phase)
 	}
 	firmwareState, err = mock.GetFirmwareChargeLimit()
 	if err != nil {
@@ -324,3 +321,49 @@ func TestCalibrationFlowWithFirmwareChargeControl(t *testing.T) {
 		t.Fatalf("sleep assertion calls = prevent:%d allow:%d, want 1/1", sleepCalls.prevent, sleepCalls.allow)
 	}
 }
+
+func TestCalibrationHoldExpiry(t *testing.T) {
+	fake := newFakeSMC(14, 1, true)
+	d, clk := newTestDaemonWithClock(t, fake, &mockConf{upper: 80, lower: 78})
+	stubCalibrationSleep(d)
+
+	if err := d.startCalibration(audit.ActorAPI, 15, 30); err != nil {
+		t.Fatal(err)
+	}
+	d.applyCalibrationWithinLoop(14)
+	fake.charge = 100
+	d.applyCalibrationWithinLoop(fake.charge)
+	if d.calibrationState.Phase != calibration.PhaseHold {
+		t.Fatalf("phase = %s, want hold", d.calibrationState.Phase)
+	}
+
+	clk.Advance(20 * time.Minute)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if st := d.getCalibrationStatus(); st.Phase != calibration.PhaseHold || st.RemainingHoldSecs != 600 {
+		t.Fatalf("status = %s with %ds left, want hold with 600s left", st.Phase, st.RemainingHoldSecs)
+	}
+
+	// Time spent paused does not count towards the hold.
+	if err := d.pauseCalibration(audit.ActorAPI); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(45 * time.Minute)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if st := d.getCalibrationStatus(); st.Phase != calibration.PhaseHold || st.RemainingHoldSecs != 600 {
+		t.Fatalf("paused status = %s with %ds left, want hold with 600s left", st.Phase, st.RemainingHoldSecs)
+	}
+	if err := d.resumeCalibration(audit.ActorAPI); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(10 * time.Minute)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if d.calibrationState.Phase != calibration.PhaseHold {
+		t.Fatalf("phase = %s at the end of the hold, want hold", d.calibrationState.Phase)
+	}
+	clk.Advance(time.Second)
+	d.applyCalibrationWithinLoop(fake.charge)
+	if d.calibrationState.Phase != calibration.PhasePostHold {
+		t.Fatalf("phase = %s after the hold, want post-hold", d.calibrationState.Phase)
+	}
+}
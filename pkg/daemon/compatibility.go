@@ -1,28 +1,29 @@
 package daemon
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
 	"github.com/charlie0129/batt/pkg/calibration"
 	"github.com/charlie0129/batt/pkg/compatibility"
 	"github.com/charlie0129/batt/pkg/config"
 )
 
-func detectCapabilities() compatibility.Capabilities {
-	mode := smcConn.ChargeControlMode()
+func (d *Daemon) detectCapabilities() compatibility.Capabilities {
+	mode := d.backend.ChargeControlMode()
 	legacy := mode == compatibility.ChargeControlLegacy
-	adapter := smcConn.IsAdapterControlCapable()
+	adapter := d.backend.IsAdapterControlCapable()
 	return compatibility.Capabilities{
 		ChargingControl:   mode != compatibility.ChargeControlUnsupported,
 		ChargeControlMode: mode,
 		SleepHooks:        legacy,
 		// LED state follows batt's direct charging state, which is not
 		// available when the firmware owns charge control.
-		MagSafeLED:     legacy && smcConn.CheckMagSafeExistence(),
+		MagSafeLED:     legacy && d.backend.CheckMagSafeExistence(),
 		AdapterControl: adapter,
 		// Adapter control performs the discharge phases. Both the legacy and
 		// firmware backends can temporarily allow charging to 100%.
@@ -41,83 +42,89 @@ func capabilityLogFields(capabilities compatibility.Capabilities) logrus.Fields
 	}
 }
 
-func disableUnsupportedCalibrationState() {
-	if capabilities.Calibration {
+func (d *Daemon) disableUnsupportedCalibrationState() {
+	if d.capabilities.Calibration {
 		return
 	}
-	calibrationMu.Lock()
-	defer calibrationMu.Unlock()
-	if calibrationState.Phase == calibration.PhaseIdle {
+	d.calibrationMu.Lock()
+	defer d.calibrationMu.Unlock()
+	if d.calibrationState.Phase == calibration.PhaseIdle {
 		return
 	}
-	logrus.WithField("phase", calibrationState.Phase).Info("discarding unsupported persisted calibration state")
-	releaseCalibrationSleepAssertion()
+	logrus.WithField("phase", d.calibrationState.Phase).Info("discarding unsupported persisted calibration state")
+	d.releaseCalibrationSleepAssertion()
 	// Calibration may have temporarily changed the configured limit to 100%.
 	// Restore its saved limits without touching unsupported charging/adapter
 	// keys before discarding the workflow state.
-	if calibrationState.SnapshotUpperLimit >= 10 && calibrationState.SnapshotUpperLimit <= 100 &&
-		calibrationState.SnapshotLowerLimit >= 0 && calibrationState.SnapshotLowerLimit < calibrationState.SnapshotUpperLimit {
-		conf.SetUpperLimit(calibrationState.SnapshotUpperLimit)
-		conf.SetLowerLimit(calibrationState.SnapshotLowerLimit)
-		if err := conf.Save(); err != nil {
+	if d.calibrationState.SnapshotUpperLimit >= 10 && d.calibrationState.SnapshotUpperLimit <= 100 &&
+		d.calibrationState.SnapshotLowerLimit >= 0 && d.calibrationState.SnapshotLowerLimit < d.calibrationState.SnapshotUpperLimit {
+		if err := restoreLimits(d.auditing(d.conf, audit.ActorDaemon), d.calibrationState); err != nil {
 			logrus.WithError(err).Error("failed to restore limits from unsupported calibration state")
 		}
 	}
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	persistCalibrationState()
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
+	d.persistCalibrationState()
 }
 
 // disableUnsupportedConfiguredFeatures prevents settings left behind by an
 // OS/firmware upgrade from activating features that are unsafe on the current
 // hardware. It intentionally persists the disabled values.
-func disableUnsupportedConfiguredFeatures() {
+func (d *Daemon) disableUnsupportedConfiguredFeatures() {
+	cfg := d.auditing(d.conf, audit.ActorDaemon)
 	changed := false
-	if !capabilities.SleepHooks {
-		if conf.PreventIdleSleep() {
-			conf.SetPreventIdleSleep(false)
+	if !d.capabilities.SleepHooks {
+		if d.conf.PreventIdleSleep() {
+			cfg.SetPreventIdleSleep(false)
 			changed = true
 		}
-		if conf.DisableChargingPreSleep() {
-			conf.SetDisableChargingPreSleep(false)
+		if d.conf.DisableChargingPreSleep() {
+			cfg.SetDisableChargingPreSleep(false)
 			changed = true
 		}
-		if conf.PreventSystemSleep() {
-			conf.SetPreventSystemSleep(false)
+		if d.conf.PreventSystemSleep() {
+			cfg.SetPreventSystemSleep(false)
 			changed = true
 		}
 	}
-	if !capabilities.MagSafeLED && conf.ControlMagSafeLED() != config.ControlMagSafeModeDisabled {
-		conf.SetControlMagSafeLED(config.ControlMagSafeModeDisabled)
+	if !d.capabilities.MagSafeLED && d.conf.ControlMagSafeLED() != config.ControlMagSafeModeDisabled {
+		cfg.SetControlMagSafeLED(config.ControlMagSafeModeDisabled)
 		changed = true
 	}
-	if !capabilities.Calibration && conf.Cron() != "" {
-		conf.SetCron("")
+	if !d.capabilities.Calibration && d.conf.Cron() != "" {
+		cfg.SetCron("")
 		changed = true
 	}
-	if !capabilities.AdapterControl && !conf.AdapterDisableUntil().IsZero() {
-		conf.ClearAdapterDisableTimer()
+	if !d.capabilities.AdapterControl && !d.conf.AdapterDisableUntil().IsZero() {
+		cfg.ClearAdapterDisableTimer()
 		changed = true
 	}
 	if !changed {
 		return
 	}
-	if err := conf.Save(); err != nil {
+	if err := cfg.Save(); err != nil {
 		logrus.WithError(err).Error("failed to persist disabled unsupported features")
 		return
 	}
-	logrus.WithFields(capabilityLogFields(capabilities)).Info("disabled unsupported configured features")
+	logrus.WithFields(capabilityLogFields(d.capabilities)).Info("disabled unsupported configured features")
 }
 
-func requireCapability(c *gin.Context, feature compatibility.Feature) bool {
-	if capabilities.Supports(feature) {
-		return true
+// checkCapability returns a capability_missing error unless this Mac
+// supports feature.
+func (d *Daemon) checkCapability(feature compatibility.Feature) error {
+	if d.capabilities.Supports(feature) {
+		return nil
 	}
-	err := fmt.Errorf("%s is not supported on this Mac", feature)
-	c.IndentedJSON(http.StatusConflict, err.Error())
-	_ = c.AbortWithError(http.StatusConflict, err)
-	return false
+	return api.Errorf(api.CodeCapabilityMissing, "%s is not supported on this Mac", feature).WithDetail("feature", feature)
 }
 
-func getCompatibility(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, capabilities)
+func (d *Daemon) requireCapability(c *gin.Context, feature compatibility.Feature) bool {
+	if err := d.checkCapability(feature); err != nil {
+		abortWithError(c, err)
+		return false
+	}
+	return true
+}
+
+func (d *Daemon) getCompatibility(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, d.capabilities)
 }
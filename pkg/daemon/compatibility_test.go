@@ -15,16 +15,15 @@ import (
 )
 
 func TestUnsupportedFeatureRejectedByDaemon(t *testing.T) {
-	previous := capabilities
-	t.Cleanup(func() { capabilities = previous })
-	capabilities = compatibility.Capabilities{
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78})
+	d.capabilities = compatibility.Capabilities{
 		ChargingControl:   true,
 		ChargeControlMode: compatibility.ChargeControlFirmware,
 	}
 
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest(http.MethodPut, "/prevent-idle-sleep", strings.NewReader("true"))
-	setupRoutes().ServeHTTP(recorder, request)
+	d.router.ServeHTTP(recorder, request)
 	if recorder.Code != http.StatusConflict {
 		t.Fatalf("status = %d, want %d; body=%s", recorder.Code, http.StatusConflict, recorder.Body.String())
 	}
@@ -50,10 +49,8 @@ func TestDetectCapabilitiesForFirmwareControl(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = mock.Close() })
 
-	previous := smcConn
-	t.Cleanup(func() { smcConn = previous })
-	smcConn = mock
-	got := detectCapabilities()
+	d := newTestDaemon(t, mock, &mockConf{upper: 80, lower: 78})
+	got := d.detectCapabilities()
 	if !got.ChargingControl || got.ChargeControlMode != compatibility.ChargeControlFirmware {
 		t.Fatalf("unexpected charge control capability: %+v", got)
 	}
@@ -74,14 +71,13 @@ func TestDisableUnsupportedConfiguredFeatures(t *testing.T) {
 	file.SetControlMagSafeLED(config.ControlMagSafeModeEnabled)
 	file.SetCron("0 10 * * 0")
 
-	previousConf, previousCapabilities := conf, capabilities
-	t.Cleanup(func() { conf, capabilities = previousConf, previousCapabilities })
-	conf = file
-	capabilities = compatibility.Capabilities{
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78})
+	d.conf = file
+	d.capabilities = compatibility.Capabilities{
 		ChargingControl:   true,
 		ChargeControlMode: compatibility.ChargeControlFirmware,
 	}
-	disableUnsupportedConfiguredFeatures()
+	d.disableUnsupportedConfiguredFeatures()
 
 	if file.PreventIdleSleep() || file.DisableChargingPreSleep() || file.PreventSystemSleep() {
 		t.Fatal("sleep settings were not disabled")
@@ -108,29 +104,28 @@ func TestDisableUnsupportedCalibrationRestoresLimits(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	file.SetUpperLimit(100)
-	file.SetLowerLimit(98)
+	if err := file.SetUpperLimit(100); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.SetLowerLimit(98); err != nil {
+		t.Fatal(err)
+	}
 
-	previousConf, previousCapabilities := conf, capabilities
-	previousState, previousStatePath := calibrationState, calibrationStatePath
-	t.Cleanup(func() {
-		conf, capabilities = previousConf, previousCapabilities
-		calibrationState, calibrationStatePath = previousState, previousStatePath
-	})
-	conf = file
-	capabilities = compatibility.Capabilities{ChargeControlMode: compatibility.ChargeControlFirmware}
-	calibrationStatePath = ""
-	calibrationState = &calibration.State{
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78})
+	d.conf = file
+	d.capabilities = compatibility.Capabilities{ChargeControlMode: compatibility.ChargeControlFirmware}
+	d.calibrationStatePath = ""
+	d.calibrationState = &calibration.State{
 		Phase:              calibration.PhaseCharge,
 		SnapshotUpperLimit: 80,
 		SnapshotLowerLimit: 78,
 	}
 
-	disableUnsupportedCalibrationState()
+	d.disableUnsupportedCalibrationState()
 	if file.UpperLimit() != 80 || file.LowerLimit() != 78 {
 		t.Fatalf("limits = %d/%d, want 80/78", file.UpperLimit(), file.LowerLimit())
 	}
-	if calibrationState.Phase != calibration.PhaseIdle {
-		t.Fatalf("phase = %s, want idle", calibrationState.Phase)
+	if d.calibrationState.Phase != calibration.PhaseIdle {
+		t.Fatalf("phase = %s, want idle", d.calibrationState.Phase)
 	}
 }
@@ -0,0 +1,263 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
+)
+
+// maxPatchAttempts is how often patchConfig retries when the config changes
+// under it and the caller did not send If-Match.
+const maxPatchAttempts = 3
+
+// patchConfig serves PATCH /v1/config. The fields set in the body replace the
+// ones in the config, all at once and with a single save, or not at all.
+func (d *Daemon) patchConfig(c *gin.Context) {
+	// Clients may send back what they read, activeLimitProfile included.
+	var patch api.Config
+	if !bindJSON(c, &patch) {
+		return
+	}
+
+	before, after, err := d.applyConfigPatch(c, &patch.RawFileConfig)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	d.auditConfigChanges(requestActor(c), before, after)
+	d.reloadChangedConfig(before, after)
+
+	fc, err := d.configResource()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, fc)
+}
+
+// applyConfigPatch merges patch into the config, validates the result and
+// saves it. It returns the config before and after.
+func (d *Daemon) applyConfigPatch(c *gin.Context, patch *config.RawFileConfig) (*config.RawFileConfig, *config.RawFileConfig, error) {
+	// The version is that of the file format. Ignore it so clients can send
+	// back what they read.
+	patch.Version = nil
+
+	d.chargeControlTransitionMu.Lock()
+	defer d.chargeControlTransitionMu.Unlock()
+
+	for range maxPatchAttempts {
+		before, rev := d.conf.Raw()
+		if !d.ifMatch(c, rev) {
+			return nil, nil, d.preconditionFailed(rev)
+		}
+
+		after := before.DeepCopy()
+		after.Merge(patch.DeepCopy())
+		if patch.Webhooks != nil {
+			keepWebhookSecrets(after.Webhooks, before.Webhooks)
+		}
+		if patch.MQTT != nil && patch.MQTT.Password == redactedSecret {
+			after.MQTT.Password = ""
+			if before.MQTT != nil {
+				after.MQTT.Password = before.MQTT.Password
+			}
+		}
+		if err := checkPrivilegedChanges(c, before, after); err != nil {
+			return nil, nil, err
+		}
+		if err := d.validateConfigPatch(before, after); err != nil {
+			return nil, nil, err
+		}
+		// An explicit limit change overrides any pending scheduled re-enabling.
+		if !reflect.DeepEqual(before.Limit, after.Limit) {
+			after.DisableUntil = nil
+			after.PreDisableLimit = nil
+		}
+
+		if !d.conf.CompareAndSwap(rev, after) {
+			continue
+		}
+		if err := d.conf.Save(); err != nil {
+			logrus.Errorf("saveConfig failed: %v", err)
+			return nil, nil, err
+		}
+		logrus.WithField("fields", patchedFields(patch)).Info("patched config")
+		return before, after, nil
+	}
+
+	rev := d.conf.Revision()
+	if c.GetHeader("If-Match") != "" {
+		return nil, nil, d.preconditionFailed(rev)
+	}
+	return nil, nil, api.Errorf(api.CodeConflict, "the config kept changing while it was being updated, try again")
+}
+
+// checkPrivilegedChanges refuses changes to the settings that only root and
+// the user running the daemon may make, unless the caller is one of them.
+// Hooks run as the daemon user, webhooks and MQTT send daemon events to
+// another host, and the others decide who may use the API.
+func checkPrivilegedChanges(c *gin.Context, before, after *config.RawFileConfig) error {
+	if callerPrivileged(c) {
+		return nil
+	}
+	prev, merged := config.NewFileFromConfig(before, ""), config.NewFileFromConfig(after, "")
+	for _, f := range []struct {
+		name          string
+		before, after any
+	}{
+		{"hooks", prev.Hooks(), merged.Hooks()},
+		{"access", prev.Access(), merged.Access()},
+		{"allowNonRootAccess", prev.AllowNonRootAccess(), merged.AllowNonRootAccess()},
+		{"webhooks", prev.Webhooks(), merged.Webhooks()},
+		{"mqtt", prev.MQTT(), merged.MQTT()},
+	} {
+		if !jsonEqual(f.before, f.after) {
+			return api.Errorf(api.CodePermissionDenied, "permission denied: only root can change %s", f.name).WithDetail("field", f.name)
+		}
+	}
+	return nil
+}
+
+// validateConfigPatch checks the change a patch makes to the config, from
+// before to after.
+func (d *Daemon) validateConfigPatch(before, after *config.RawFileConfig) error {
+	invalid := func(field, format string, args ...any) *api.Error {
+		return api.Errorf(api.CodeInvalidArgument, field+": "+format, args...).WithDetail("field", field)
+	}
+
+	// Timers are only changed through /disable and /adapter/disable, but
+	// sending back their current value is fine.
+	for _, f := range []struct {
+		name          string
+		before, after any
+	}{
+		{"disableUntil", before.DisableUntil, after.DisableUntil},
+		{"preDisableLimit", before.PreDisableLimit, after.PreDisableLimit},
+		{"adapterDisableUntil", before.AdapterDisableUntil, after.AdapterDisableUntil},
+	} {
+		if !jsonEqual(f.before, f.after) {
+			return invalid(f.name, "cannot be changed through the config, use the disable endpoints")
+		}
+	}
+
+	// Reject the problems the patch introduces. Ones the config already had
+	// are left alone, so that unrelated fields can still be changed.
+	known := before.Validate()
+	for _, e := range after.Validate() {
+		if !slices.Contains(known, e) {
+			return invalid(e.Path, "%s", e.Reason)
+		}
+	}
+
+	merged := config.NewFileFromConfig(after, "")
+	limitsChanged := !reflect.DeepEqual(before.Limit, after.Limit) || !reflect.DeepEqual(before.LowerLimitDelta, after.LowerLimitDelta)
+
+	// Features this Mac does not support may only be turned off.
+	prev := config.NewFileFromConfig(before, "")
+	turnedOn := func(was, is bool) bool { return is && !was }
+	for _, f := range []struct {
+		feature compatibility.Feature
+		on      bool
+	}{
+		{compatibility.FeatureChargingControl, limitsChanged || turnedOn(prev.AdaptiveCharging(), merged.AdaptiveCharging())},
+		{compatibility.FeatureSleepHooks, turnedOn(prev.PreventIdleSleep(), merged.PreventIdleSleep()) ||
+			turnedOn(prev.DisableChargingPreSleep(), merged.DisableChargingPreSleep()) ||
+			turnedOn(prev.PreventSystemSleep(), merged.PreventSystemSleep())},
+		{compatibility.FeatureMagSafeLED, prev.ControlMagSafeLED() != merged.ControlMagSafeLED() && merged.ControlMagSafeLED() != config.ControlMagSafeModeDisabled},
+		{compatibility.FeatureCalibration, prev.Cron() != merged.Cron() && merged.Cron() != ""},
+	} {
+		if !f.on {
+			continue
+		}
+		if err := d.checkCapability(f.feature); err != nil {
+			return err
+		}
+	}
+
+	if limitsChanged && d.calibrationOwnsChargeLimit() {
+		return ErrCalibrationControlsChargeLimit
+	}
+	return nil
+}
+
+// patchedFields returns the JSON names of the fields set in patch.
+func patchedFields(patch *config.RawFileConfig) []string {
+	var names []string
+	v := reflect.ValueOf(patch).Elem()
+	for i := range v.NumField() {
+		if !v.Field(i).IsNil() {
+			names = append(names, jsonName(v.Type().Field(i)))
+		}
+	}
+	return names
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// auditConfigChanges records every field that differs between before and
+// after, with secrets redacted.
+func (d *Daemon) auditConfigChanges(a audit.Actor, before, after *config.RawFileConfig) {
+	redact := func(c *config.RawFileConfig) *config.RawFileConfig {
+		c = c.DeepCopy()
+		c.Webhooks = redactWebhooks(c.Webhooks)
+		if c.MQTT != nil && c.MQTT.Password != "" {
+			c.MQTT.Password = redactedSecret
+		}
+		return c
+	}
+	b := reflect.ValueOf(redact(before)).Elem()
+	n := reflect.ValueOf(redact(after)).Elem()
+	for i := range b.NumField() {
+		old, _ := json.Marshal(b.Field(i).Interface())
+		new, _ := json.Marshal(n.Field(i).Interface())
+		d.recordAudit(a, jsonName(b.Type().Field(i)), json.RawMessage(old), json.RawMessage(new))
+	}
+}
+
+// jsonEqual reports whether a and b have the same JSON encoding. Unlike
+// reflect.DeepEqual, it treats equal times in different *time.Location
+// values as equal.
+func jsonEqual(a, b any) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
+
+// reloadChangedConfig applies the parts of the config that the daemon only
+// reads on startup or reload.
+func (d *Daemon) reloadChangedConfig(before, after *config.RawFileConfig) {
+	prev, next := config.NewFileFromConfig(before, ""), config.NewFileFromConfig(after, "")
+	if prev.Cron() != next.Cron() {
+		if cr := next.Cron(); cr == "" {
+			d.scheduler.Stop()
+		} else if err := d.scheduler.Schedule(cr); err != nil {
+			logrus.WithError(err).Error("failed to schedule calibration")
+		} else {
+			d.scheduler.Start()
+		}
+	}
+	if !reflect.DeepEqual(prev.Webhooks(), next.Webhooks()) {
+		d.reloadWebhooks()
+	}
+	if prev.MQTT() != next.MQTT() {
+		d.reloadMQTT()
+	}
+	d.maintainLoopForced()
+}
@@ -0,0 +1,203 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/utils/ptr"
+)
+
+func serveWithIfMatch(t *testing.T, d *Daemon, method, path, body, etag string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if etag != "" {
+		request.Header.Set("If-Match", etag)
+	}
+	response := httptest.NewRecorder()
+	d.router.ServeHTTP(response, request)
+	return response
+}
+
+func TestPatchConfig(t *testing.T) {
+	backend, _ := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "60",
+		"BAT0/status":                       "Charging",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+	path := filepath.Join(t.TempDir(), "batt.json")
+	configured := config.NewFileFromConfig(&config.RawFileConfig{
+		Webhooks: []config.Webhook{{URL: "https://example.com/hook", Secret: "s3cret"}},
+	}, path)
+	if err := configured.SetDisableTimer(time.Now().Add(time.Hour), 80); err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDaemon(t, backend, configured)
+	d.capabilities = compatibility.Capabilities{ChargingControl: true, ChargeControlMode: compatibility.ChargeControlFirmware}
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
+	d.calibrationStatePath = ""
+
+	response := serveV1(t, d, http.MethodGet, "/v1/config", "")
+	etag := response.Header().Get("ETag")
+	if response.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET /v1/config = %d with ETag %q", response.Code, etag)
+	}
+
+	// Sending back what was read, with redacted secrets, the active timer
+	// and the active limit profile, changes only the patched fields.
+	var read map[string]any
+	if err := json.Unmarshal(response.Body.Bytes(), &read); err != nil {
+		t.Fatal(err)
+	}
+	read["activeLimitProfile"] = "desk"
+	read["limit"] = 70
+	read["calibrationHoldDurationMinutes"] = 60
+	body, _ := json.Marshal(read)
+	response = serveWithIfMatch(t, d, http.MethodPatch, "/v1/config", string(body), etag)
+	if response.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d; body: %s", response.Code, response.Body.String())
+	}
+	newETag := response.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Fatalf("ETag after PATCH = %q, want a new one", newETag)
+	}
+	if configured.UpperLimit() != 70 || configured.CalibrationHoldDurationMinutes() != 60 {
+		t.Fatalf("limit = %d, hold = %d after PATCH", configured.UpperLimit(), configured.CalibrationHoldDurationMinutes())
+	}
+	if !configured.DisableUntil().IsZero() {
+		t.Fatal("changing the limit did not cancel the temporary disable")
+	}
+	if w := configured.Webhooks(); len(w) != 1 || w[0].Secret != "s3cret" {
+		t.Fatalf("webhooks = %+v, want the secret kept", w)
+	}
+	saved, err := config.NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.UpperLimit() != 70 {
+		t.Fatalf("saved limit = %d, want 70", saved.UpperLimit())
+	}
+
+	// Stale ETags are rejected, on PATCH and on every other mutation.
+	e := decodeAPIError(t, serveWithIfMatch(t, d, http.MethodPatch, "/v1/config", `{"limit":60}`, etag), http.StatusPreconditionFailed, api.CodePreconditionFailed)
+	if e.Details["etag"] != newETag {
+		t.Fatalf("details = %v, want the current ETag %s", e.Details, newETag)
+	}
+	decodeAPIError(t, serveWithIfMatch(t, d, http.MethodPut, "/v1/limit", `{"upper":60}`, etag), http.StatusPreconditionFailed, api.CodePreconditionFailed)
+	if response := serveWithIfMatch(t, d, http.MethodPut, "/limit", "60", etag); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("legacy PUT /limit with a stale ETag = %d", response.Code)
+	}
+	if configured.UpperLimit() != 70 {
+		t.Fatalf("limit = %d after rejected requests, want 70", configured.UpperLimit())
+	}
+
+	// Invalid patches change nothing.
+	rev := configured.Revision()
+	e = decodeAPIError(t, serveV1(t, d, http.MethodPatch, "/v1/config", `{"limit":60,"lowerLimitDelta":55}`), http.StatusBadRequest, api.CodeInvalidArgument)
+	if e.Details["field"] != "lowerLimitDelta" {
+		t.Fatalf("details = %v", e.Details)
+	}
+	e = decodeAPIError(t, serveV1(t, d, http.MethodPatch, "/v1/config", `{"limitProfiles":[{"name":"desk","limit":60,"schedule":"weekdays","duration":"9h"}]}`), http.StatusBadRequest, api.CodeInvalidArgument)
+	if e.Details["field"] != "limitProfiles[0].schedule" {
+		t.Fatalf("details = %v", e.Details)
+	}
+	decodeAPIError(t, serveV1(t, d, http.MethodPatch, "/v1/config", `{"adapterDisableUntil":"2030-01-01T00:00:00Z"}`), http.StatusBadRequest, api.CodeInvalidArgument)
+	decodeAPIError(t, serveV1(t, d, http.MethodPatch, "/v1/config", `{"preventSystemSleep":true}`), http.StatusNotImplemented, api.CodeCapabilityMissing)
+	if configured.Revision() != rev || configured.UpperLimit() != 70 {
+		t.Fatalf("config changed by rejected patches: limit %d", configured.UpperLimit())
+	}
+
+	// A matching ETag lets other mutations through.
+	response = serveWithIfMatch(t, d, http.MethodPut, "/v1/limit", `{"upper":65}`, newETag)
+	if response.Code != http.StatusOK || configured.UpperLimit() != 65 {
+		t.Fatalf("PUT /v1/limit with the current ETag = %d; body: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestPatchPrivilegedConfig(t *testing.T) {
+	configured := config.NewFileFromConfig(&config.RawFileConfig{
+		Hooks:  map[string][]string{"power.plugged": {"true"}},
+		Access: &config.Access{Mutate: config.AccessRule{Users: []string{"4242"}}},
+	}, filepath.Join(t.TempDir(), "batt.json"))
+	d := newTestDaemon(t, newFakeSMC(50, 0, true), configured)
+
+	patch := func(cred peerCred, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPatch, "/v1/config", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), peerCredKey{}, cred))
+		response := httptest.NewRecorder()
+		d.router.ServeHTTP(response, request)
+		return response
+	}
+
+	user := peerCred{uid: 4242, gid: 4242}
+	for _, body := range []string{
+		`{"hooks":{"power.plugged":["id > /tmp/pwned"]}}`,
+		`{"access":{"mutate":{"users":["*"]}}}`,
+		`{"allowNonRootAccess":true}`,
+		`{"webhooks":[{"url":"https://example.com/hook"}]}`,
+		`{"mqtt":{"broker":"tcp://example.com:1883"}}`,
+	} {
+		e := decodeAPIError(t, patch(user, body), http.StatusForbidden, api.CodePermissionDenied)
+		if e.Details["field"] == nil {
+			t.Errorf("PATCH %s: error %+v does not name the field", body, e)
+		}
+	}
+	if hooks := configured.Hooks(); len(hooks["power.plugged"]) != 1 || hooks["power.plugged"][0] != "true" {
+		t.Fatalf("hooks = %v after refused patches", hooks)
+	}
+
+	// Other settings can be changed while sending the privileged ones back
+	// unchanged.
+	if response := patch(user, `{"limit":70,"hooks":{"power.plugged":["true"]}}`); response.Code != http.StatusOK {
+		t.Fatalf("PATCH by a mutate user = %d: %s", response.Code, response.Body.String())
+	}
+	if response := patch(peerCred{uid: 0, gid: 0}, `{"hooks":{"power.plugged":["false"]}}`); response.Code != http.StatusOK || configured.Hooks()["power.plugged"][0] != "false" {
+		t.Fatalf("PATCH by root = %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestGetConfigExplain(t *testing.T) {
+	file := config.NewFileFromConfig(&config.RawFileConfig{
+		Limit: ptr.To(70),
+		Cron:  ptr.To("0 10 1 * *"),
+	}, filepath.Join(t.TempDir(), "batt.json"))
+	d := newTestDaemon(t, newFakeSMC(50, 0, true), config.NewLayered(file, &config.RawFileConfig{Limit: ptr.To(60)}, &config.RawFileConfig{PreventIdleSleep: ptr.To(false)}))
+
+	response := serveV1(t, d, http.MethodGet, "/v1/config?explain=1", "")
+	if response.Code != http.StatusOK || response.Header().Get("ETag") == "" {
+		t.Fatalf("GET /v1/config?explain=1 = %d: %s", response.Code, response.Body.String())
+	}
+	var settings map[string]api.ConfigSetting
+	if err := json.Unmarshal(response.Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]api.ConfigSetting{
+		"limit":            {Value: json.RawMessage("60"), Source: config.SourceEnv, Name: "BATT_LIMIT"},
+		"preventIdleSleep": {Value: json.RawMessage("false"), Source: config.SourceFlag, Name: "--prevent-idle-sleep"},
+		"cron":             {Value: json.RawMessage(`"0 10 1 * *"`), Source: config.SourceFile},
+		"metricsPort":      {Value: json.RawMessage("null"), Source: config.SourceDefault},
+	} {
+		got := settings[name]
+		if string(got.Value) != string(want.Value) || got.Source != want.Source || got.Name != want.Name {
+			t.Errorf("%s = %s from %s %s, want %s from %s %s", name, got.Value, got.Source, got.Name, want.Value, want.Source, want.Name)
+		}
+	}
+
+	// Without explain, the config is as before.
+	response = serveV1(t, d, http.MethodGet, "/v1/config", "")
+	var fc config.RawFileConfig
+	if err := json.Unmarshal(response.Body.Bytes(), &fc); err != nil || *fc.Limit != 60 {
+		t.Fatalf("GET /v1/config = %s, %v", response.Body.String(), err)
+	}
+}
@@ -9,130 +9,220 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 
+	"github.com/charlie0129/batt/pkg/adaptive"
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
 	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/clock"
 	"github.com/charlie0129/batt/pkg/compatibility"
 	"github.com/charlie0129/batt/pkg/config"
 	"github.com/charlie0129/batt/pkg/events"
-	"github.com/charlie0129/batt/pkg/smc"
+	"github.com/charlie0129/batt/pkg/history"
+	"github.com/charlie0129/batt/pkg/webhook"
 )
 
-var (
-	smcConn      *smc.AppleSMC
+// Options are the dependencies of a Daemon.
+type Options struct {
+	// Backend is the hardware to maintain the charge with. The daemon does
+	// not close it.
+	Backend ChargeBackend
+	// Config holds the settings. Changes made through the API are saved to
+	// it.
+	Config config.Config
+	// Clock tells the time, the system clock if nil.
+	Clock clock.Clock
+	// Hub receives the events of the daemon. A new hub is used if nil.
+	Hub *events.EventHub
+	// StateDir is where the calibration state, battery history, audit log,
+	// adaptive charging model and webhook outbox are kept. If it is empty,
+	// they are kept in memory only.
+	StateDir string
+
+	// restored reports files read from their backup. Run sets it to report
+	// the config file too; a new one is used if nil.
+	restored *restoreReporter
+}
+
+// Daemon maintains the charge of a battery and serves the batt API for it.
+// Daemons do not share state, so several of them can run in one process.
+// Sleep notifications and sleep assertions are process-wide, though; see Run.
+type Daemon struct {
+	backend      ChargeBackend
 	conf         config.Config
 	capabilities compatibility.Capabilities
-
-	sseHub    *events.EventHub // global hub instance initialized in Run()
-	scheduler *Scheduler
-)
-
-func setupRoutes() *gin.Engine {
-	gin.SetMode(gin.ReleaseMode)
-
-	// TODO: unify these ugly handlers
-
-	router := gin.New()
-	router.Use(gin.Recovery())
-	router.Use(ginLogger(logrus.StandardLogger()))
-	router.GET("/config", getConfig)
-	router.GET("/limit", getLimit)
-	router.PUT("/limit", setLimit)
-	router.PUT("/disable", setDisableFor)
-	router.PUT("/lower-limit-delta", setLowerLimitDelta)
-	router.PUT("/prevent-idle-sleep", setPreventIdleSleep)
-	router.PUT("/disable-charging-pre-sleep", setDisableChargingPreSleep)
-	router.PUT("/prevent-system-sleep", setPreventSystemSleep)
-	router.PUT("/adapter", setAdapter)
-	router.PUT("/adapter/disable", setAdapterDisableFor)
-	router.GET("/adapter", getAdapter)
-	router.GET("/charging", getCharging)
-	router.GET("/battery-info", getBatteryInfo)
-	router.PUT("/magsafe-led", setControlMagSafeLED)
-	router.GET("/current-charge", getCurrentCharge)
-	router.GET("/plugged-in", getPluggedIn)
-	router.GET("/charging-control-capable", getChargingControlCapable)
-	router.GET("/compatibility", getCompatibility)
-	router.GET("/version", getVersion)
-	// Deprecated
-	router.GET("/power-telemetry", getPowerTelemetry)
-	router.GET("/telemetry", getUnifiedTelemetry)
-	router.GET("/event", getEventStream)
-
-	// Calibration endpoints (status folded into /telemetry)
-	router.POST("/calibration/start", postStartCalibration)
-	router.POST("/calibration/pause", postPauseCalibration)
-	router.POST("/calibration/resume", postResumeCalibration)
-	router.POST("/calibration/cancel", postCancelCalibration)
-	router.PUT("/schedule", setSchedule)
-	router.PUT("/schedule/postpone", postponeSchedule)
-	router.PUT("/schedule/skip", skipSchedule)
-
-	// Calibration settings endpoints
-	router.PUT("/calibration/discharge-threshold", setCalibrationDischargeThreshold)
-	router.PUT("/calibration/hold-duration", setCalibrationHoldDurationMinutes)
-
-	return router
+	clock        clock.Clock
+	hub          *events.EventHub
+	scheduler    *Scheduler
+	router       *gin.Engine
+
+	// restored reports the config and state files read from their backup.
+	restored *restoreReporter
+
+	// preventCalibrationSleep and allowCalibrationSleep hold and release the
+	// sleep assertion of a running calibration.
+	preventCalibrationSleep func() error
+	allowCalibrationSleep   func() error
+
+	maintainLoopMu               sync.Mutex
+	maintainedChargingInProgress bool
+	// wg is used to skip several loops when system woke up or before sleep
+	wg            sync.WaitGroup
+	loopRecorder  *TimeSeriesRecorder
+	lastPrintTime time.Time
+	lastStatus    loopStatus
+	// lastPluggedIn and lastCharging are the states seen by the previous
+	// maintain loop, nil before the first one.
+	lastPluggedIn *bool
+	lastCharging  *bool
+	// maintainLoopRuns counts maintain loop executions.
+	maintainLoopRuns atomic.Uint64
+	// maintainLoopMisses counts periodic loops that found too few recent
+	// loops, usually because the system was asleep.
+	maintainLoopMisses atomic.Uint64
+	// trace is the explanation the running maintain loop builds. It is
+	// guarded by maintainLoopMu.
+	trace         *api.Explanation
+	explanationMu sync.Mutex
+	// explanation is the trace of the last maintain loop, nil before the
+	// first one.
+	explanation *api.Explanation
+
+	// chargeControlTransitionMu serializes admission of calibration and temporary
+	// charge/adapter disable operations so concurrent requests cannot both pass
+	// their conflict checks before either operation persists its state.
+	chargeControlTransitionMu sync.Mutex
+	calibrationMu             sync.Mutex
+	calibrationState          *calibration.State
+	calibrationStatePath      string
+	lastCalibrationStatus     calibrationStatus
+
+	limitProfileMu sync.Mutex
+	// activeLimitProfile is the profile resolved by the last maintain loop,
+	// or nil when the configured limit applies.
+	activeLimitProfile *config.LimitProfile
+
+	adaptiveMu        sync.Mutex
+	adaptiveModel     *adaptive.Model
+	adaptiveModelPath string
+	// adaptiveToppingUp is set by the maintain loop while the battery is
+	// allowed to charge to 100% ahead of the predicted unplug.
+	adaptiveToppingUp bool
+
+	temperatureMu sync.Mutex
+	// batteryTemperature is the last reading, zero when unavailable.
+	batteryTemperature float64
+	// temperatureGuardActive is set by the maintain loop while the battery
+	// is too hot to charge normally.
+	temperatureGuardActive bool
+	// temperatureGuardPaused reports that the guard pauses charging instead
+	// of enforcing temperatureGuardLimit.
+	temperatureGuardPaused bool
+	// temperatureGuardLimit is the upper limit enforced while the guard is
+	// active. When pausing in firmware mode it is the charge at activation.
+	temperatureGuardLimit int
+	temperatureGuardSince time.Time
+
+	// auditLog records configuration and control changes. It is nil when the
+	// log could not be opened, in which case nothing is recorded.
+	auditLog *audit.Log
+	// historyStore keeps battery readings on disk. It is nil when the store could
+	// not be opened, in which case nothing is recorded.
+	historyStore      *history.Store
+	webhookDispatcher *webhook.Dispatcher
+
+	// hookTimeout is how long a hook may run before it is killed.
+	hookTimeout time.Duration
+	// hookSlots limits how many hooks run at the same time. Further hooks
+	// are skipped.
+	hookSlots chan struct{}
+
+	mqttMu sync.Mutex
+	// mqttRunning is the configuration of the running MQTT subsystem.
+	mqttRunning config.MQTT
+	mqttStop    context.CancelFunc
+	// mqttDone is closed when the running session has gone offline.
+	mqttDone chan struct{}
+
+	// etagPrefix tells ETags from different daemon runs apart, since config
+	// revisions start over at every start.
+	etagPrefix string
+	// mutationMu serializes every change of the config: mutating API
+	// requests, config reloads, MQTT commands, the timers, the scheduler and
+	// the maintain loop, which changes the limits during calibration. A
+	// request whose If-Match has been checked cannot be overtaken by another
+	// change. It is taken before any other lock.
+	mutationMu sync.Mutex
 }
 
-func Run(configPath string, unixSocketPath string, allowNonRoot bool) error {
-	var err error
-	conf, err = config.NewFile(configPath)
-	if err != nil {
-		logrus.Fatalf("failed to parse config during startup: %v", err)
+// New returns a daemon for o.Backend. It detects what the hardware supports
+// and loads the state kept in o.StateDir, but leaves charging alone until Run.
+func New(o Options) *Daemon {
+	d := &Daemon{
+		backend:                 o.Backend,
+		conf:                    o.Config,
+		clock:                   o.Clock,
+		hub:                     o.Hub,
+		preventCalibrationSleep: PreventCalibrationSleep,
+		allowCalibrationSleep:   AllowCalibrationSleep,
+		calibrationState:        &calibration.State{Phase: calibration.PhaseIdle},
+		adaptiveModel:           &adaptive.Model{},
+		hookTimeout:             30 * time.Second,
+		hookSlots:               make(chan struct{}, 4),
+		etagPrefix:              strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	if d.clock == nil {
+		d.clock = clock.Real()
+	}
+	d.loopRecorder = NewTimeSeriesRecorder(d.clock, 60)
+	if d.hub == nil {
+		d.hub = events.NewEventHub()
+	}
+	d.restored = o.restored
+	if d.restored == nil {
+		d.restored = &restoreReporter{}
 	}
-	logrus.WithFields(conf.LogrusFields()).Infof("config loaded")
 
-	// Open Apple SMC and detect the charge-control mechanism from key
-	// presence before starting any loop, listener, scheduler, or API server.
-	smcConn = smc.New()
-	if err := smcConn.Open(); err != nil {
-		return fmt.Errorf("open Apple SMC: %w", err)
+	d.capabilities = d.detectCapabilities()
+	logrus.WithFields(capabilityLogFields(d.capabilities)).Info("detected hardware capabilities")
+	var outbox string
+	if o.StateDir != "" {
+		d.initAuditLog(filepath.Join(o.StateDir, "batt.audit.jsonl"))
 	}
-	capabilities = detectCapabilities()
-	logrus.WithFields(capabilityLogFields(capabilities)).Info("detected hardware capabilities")
-	disableUnsupportedConfiguredFeatures()
+	d.disableUnsupportedConfiguredFeatures()
 
 	// Initialize calibration state before the scheduler and main loop can use it.
-	if configPath != "" {
-		dir := filepath.Dir(configPath)
-		initCalibrationState(filepath.Join(dir, "batt.state.json"))
-	} else {
-		initCalibrationState("/etc/batt.state.json")
+	if o.StateDir != "" {
+		d.initCalibrationState(filepath.Join(o.StateDir, "batt.state.json"))
+		d.initHistoryStore(filepath.Join(o.StateDir, "batt.history.jsonl"))
+		d.initAdaptiveModel(filepath.Join(o.StateDir, "batt.adaptive.json"))
+		outbox = filepath.Join(o.StateDir, "batt.webhooks.json")
 	}
-	disableUnsupportedCalibrationState()
-	restoreCalibrationSleepAssertion()
-
-	router := setupRoutes()
-	sseHub = events.NewEventHub()
-
-	// Receive SIGHUP to reload config
-	go func() {
-		sigc := make(chan os.Signal, 1)
-		signal.Notify(sigc, syscall.SIGHUP)
-		for range sigc {
-			err := conf.Load()
-			if err != nil {
-				logrus.Errorf("failed to reload config: %v", err)
-				continue
-			}
-			disableUnsupportedConfiguredFeatures()
-			logrus.Infof("config reloaded")
-		}
-	}()
-
-	scheduler = NewScheduler(
+	d.disableUnsupportedCalibrationState()
+	webhookOpts := webhook.DefaultOptions
+	webhookOpts.Outbox = d.restored.readOptions()
+	d.webhookDispatcher = webhook.NewDispatcher(d.clock, outbox, webhookOpts)
+	d.reloadWebhooks()
+
+	d.scheduler = NewScheduler(
+		d.clock,
 		func() error {
-			threshold := conf.CalibrationDischargeThreshold()
-			hold := conf.CalibrationHoldDurationMinutes()
-			return startCalibration(threshold, hold)
+			d.mutationMu.Lock()
+			defer d.mutationMu.Unlock()
+			threshold := d.conf.CalibrationDischargeThreshold()
+			hold := d.conf.CalibrationHoldDurationMinutes()
+			return d.startCalibration(audit.ActorScheduler, threshold, hold)
 		},
 		func() error {
-			status := getCalibrationStatus()
+			status := d.getCalibrationStatus()
 			if status.Phase != calibration.PhaseIdle {
 				return ErrCalibrationInProgress
 			}
@@ -143,36 +233,206 @@ func Run(configPath string, unixSocketPath string, allowNonRoot bool) error {
 		},
 		func(data any) {
 			runAt := data.(time.Time)
-			sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+			d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 				Action:  string(calibration.ActionScheduleUpComing),
 				Message: fmt.Sprintf("Calibration will start at %s", runAt.Format("Jan _2 15:04")),
-				Ts:      time.Now().Unix(),
+				Ts:      d.clock.Now().Unix(),
 			})
 		},
 		func(data any) {
 			err := data.(error)
-			sseHub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
+			d.hub.Publish(events.CalibrationAction, events.CalibrationActionEvent{
 				Action:  string(calibration.ActionScheduleError),
 				Message: err.Error(),
-				Ts:      time.Now().Unix(),
+				Ts:      d.clock.Now().Unix(),
 			})
 		},
 	)
-	defer scheduler.Stop()
+	d.router = d.setupRoutes()
+	return d
+}
+
+// NewServer returns an HTTP server for the batt API of d. When it serves a
+// unix socket, callers are identified for the access control list.
+func (d *Daemon) NewServer() *http.Server {
+	return &http.Server{
+		Handler:     d.router,
+		ConnContext: connContext,
+	}
+}
+
+func (d *Daemon) setupRoutes() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(ginLogger(logrus.StandardLogger()))
+	router.Use(d.authorize)
+	router.Use(d.checkRevision)
+
+	// Legacy routes. New clients use /v1, see v1.go.
+	router.GET("/config", d.getConfig)
+	router.GET("/limit", d.getLimit)
+	router.PUT("/limit", d.setLimit)
+	router.PUT("/disable", d.setDisableFor)
+	router.PUT("/lower-limit-delta", d.setLowerLimitDelta)
+	router.PUT("/prevent-idle-sleep", d.setPreventIdleSleep)
+	router.PUT("/disable-charging-pre-sleep", d.setDisableChargingPreSleep)
+	router.PUT("/prevent-system-sleep", d.setPreventSystemSleep)
+	router.PUT("/adapter", d.setAdapter)
+	router.PUT("/adapter/disable", d.setAdapterDisableFor)
+	router.GET("/adapter", d.getAdapter)
+	router.GET("/charging", d.getCharging)
+	router.GET("/battery-info", d.getBatteryInfo)
+	router.PUT("/magsafe-led", d.setControlMagSafeLED)
+	router.GET("/current-charge", d.getCurrentCharge)
+	router.GET("/plugged-in", d.getPluggedIn)
+	router.GET("/charging-control-capable", d.getChargingControlCapable)
+	router.GET("/compatibility", d.getCompatibility)
+	router.GET("/version", getVersion)
+	// Deprecated
+	router.GET("/power-telemetry", d.getPowerTelemetry)
+	router.GET("/telemetry", d.getUnifiedTelemetry)
+	router.GET("/event", d.getEventStream)
+	router.GET("/history", d.getHistory)
+	router.GET("/metrics", d.getMetrics)
+	router.GET("/adaptive", d.getAdaptive)
+	router.PUT("/adaptive", d.setAdaptive)
+	router.POST("/adaptive/reset", d.postResetAdaptive)
+	router.GET("/webhooks", d.getWebhooks)
+	router.PUT("/webhooks", d.setWebhooks)
+
+	// Calibration endpoints (status folded into /telemetry)
+	router.POST("/calibration/start", d.postStartCalibration)
+	router.POST("/calibration/pause", d.postPauseCalibration)
+	router.POST("/calibration/resume", d.postResumeCalibration)
+	router.POST("/calibration/cancel", d.postCancelCalibration)
+	router.PUT("/schedule", d.setSchedule)
+	router.PUT("/schedule/postpone", d.postponeSchedule)
+	router.PUT("/schedule/skip", d.skipSchedule)
+
+	// Calibration settings endpoints
+	router.PUT("/calibration/discharge-threshold", d.setCalibrationDischargeThreshold)
+	router.PUT("/calibration/hold-duration", d.setCalibrationHoldDurationMinutes)
+
+	d.setupV1Routes(router)
+
+	router.GET("/openapi.json", getOpenAPI(router))
+
+	return router
+}
+
+// Run maintains the battery charge until ctx is done, and then hands charge
+// control back to the system. Hooks, webhooks, MQTT and the calibration
+// schedule run alongside. If the charge-control mode needs them, d also
+// listens to system sleep notifications, which only one daemon in a process
+// can do.
+func (d *Daemon) Run(ctx context.Context) {
+	d.restoreCalibrationSleepAssertion()
+	d.startHooks(ctx)
+	d.startWebhooks(ctx)
+	d.reloadMQTT()
+	defer d.stopMQTT()
+	d.restored.publish(d.hub)
 
 	// Load persisted schedule from config
-	if cronExpr := conf.Cron(); capabilities.Calibration && cronExpr != "" {
-		if err := scheduler.Schedule(cronExpr); err != nil {
+	if cronExpr := d.conf.Cron(); d.capabilities.Calibration && cronExpr != "" {
+		if err := d.scheduler.Schedule(cronExpr); err != nil {
 			logrus.WithError(err).Warn("failed to restore schedule from config")
 		} else {
-			scheduler.Start()
+			d.scheduler.Start()
 			logrus.WithField("cron", cronExpr).Info("restored schedule from config")
 		}
 	}
+	defer d.scheduler.Stop()
+
+	listeningForSleep := d.capabilities.SleepHooks
+	if listeningForSleep {
+		go func() {
+			if err := listenNotifications(d); err != nil {
+				logrus.Errorf("failed to listen to system sleep notifications: %v", err)
+				os.Exit(1)
+			}
+		}()
+	} else {
+		logrus.Info("system sleep notifications are not needed for this charge-control mode")
+	}
+
+	logrus.Debugln("main loop starts")
+	d.infiniteLoop(ctx)
+
+	if listeningForSleep {
+		logrus.Info("stopping listening notifications")
+		stopListeningNotifications()
+	}
+
+	if err := AllowSleepOnAC(); err != nil {
+		logrus.Errorf("failed to remove PM assertion before exiting: %v", err)
+	}
+	if err := d.allowCalibrationSleep(); err != nil {
+		logrus.Errorf("failed to remove calibration sleep assertion before exiting: %v", err)
+	}
+
+	if d.capabilities.ChargingControl {
+		if err := d.backend.ResetChargeControl(); err != nil {
+			logrus.Errorf("failed to reset charge control before exiting: %v", err)
+		}
+	}
 
-	srv := &http.Server{
-		Handler: router,
+	if d.capabilities.AdapterControl {
+		if err := d.backend.EnableAdapter(); err != nil {
+			logrus.Errorf("failed to re-enable adapter before exiting: %v", err)
+		}
 	}
+}
+
+// Run runs the daemon. The settings in the config file are overridden by
+// environment variables (see config.FromEnv), and then by flags, the
+// settings set in flags. If smcTracePath is not empty, the SMC calls are
+// recorded to it.
+func Run(configPath string, unixSocketPath string, allowNonRoot bool, sysfsRoot string, smcTracePath string, flags *config.RawFileConfig) error {
+	restored := &restoreReporter{}
+	file, err := config.NewFileWithOptions(configPath, restored.readOptions())
+	if err != nil {
+		logrus.Fatalf("failed to parse config during startup: %v", err)
+	}
+	env, err := config.FromEnv(os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	conf := config.NewLayered(file, env, flags)
+	if err := conf.ValidateOverrides(); err != nil {
+		return err
+	}
+	logrus.WithFields(conf.LogrusFields()).Infof("config loaded")
+
+	// Open the charge backend (Apple SMC on macOS, sysfs on Linux) and detect
+	// the charge-control mechanism before starting any loop, listener,
+	// scheduler, or API server.
+	backend, err := openChargeBackend(sysfsRoot, smcTracePath)
+	if err != nil {
+		return err
+	}
+	stateDir := "/etc"
+	if configPath != "" {
+		stateDir = filepath.Dir(configPath)
+	}
+	d := New(Options{Backend: backend, Config: conf, StateDir: stateDir, restored: restored})
+	d.checkAccess()
+
+	// Reload the config on SIGHUP and whenever the file changes.
+	go func() {
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGHUP)
+		for range sigc {
+			d.reloadConfig(configPath, reloadTriggerSignal)
+		}
+	}()
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go d.watchConfigFile(watchCtx, file, configPath)
+
+	srv := d.NewServer()
 
 	// Create the socket to listen on:
 	l, err := net.Listen("unix", unixSocketPath)
@@ -180,7 +440,13 @@ func Run(configPath string, unixSocketPath string, allowNonRoot bool) error {
 		logrus.Fatal(err)
 	}
 
-	if conf.AllowNonRootAccess() || allowNonRoot {
+	if conf.Access() != nil {
+		logrus.Infof("access control is configured, changing permissions of %s to 0777", unixSocketPath)
+		err = os.Chmod(unixSocketPath, 0777)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	} else if conf.AllowNonRootAccess() || allowNonRoot {
 		logrus.Infof("non-root access is allowed, chaning permissions of %s to 0777", unixSocketPath)
 		err = os.Chmod(unixSocketPath, 0777)
 		if err != nil {
@@ -196,24 +462,24 @@ func Run(configPath string, unixSocketPath string, allowNonRoot bool) error {
 		}
 	}()
 
-	listeningForSleep := capabilities.SleepHooks
-	if listeningForSleep {
+	// Optionally serve /metrics over TCP for scrapers that cannot reach the
+	// unix socket. It is bound to localhost only.
+	var metricsSrv *http.Server
+	if port := conf.MetricsPort(); port > 0 {
+		metricsSrv = d.newMetricsServer(port)
 		go func() {
-			if err := listenNotifications(); err != nil {
-				logrus.Errorf("failed to listen to system sleep notifications: %v", err)
-				os.Exit(1)
+			logrus.Infof("metrics server listening on %s", metricsSrv.Addr)
+			if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logrus.Errorf("metrics server failed: %v", err)
 			}
 		}()
-	} else {
-		logrus.Info("system sleep notifications are not needed for this charge-control mode")
 	}
 
+	runCtx, stopRunning := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
 	go func() {
-		logrus.Debugln("main loop starts")
-
-		infiniteLoop()
-
-		logrus.Errorf("main loop exited unexpectedly")
+		defer close(stopped)
+		d.Run(runCtx)
 	}()
 
 	// Handle common process-killing signals, so we can gracefully shut down:
@@ -230,36 +496,20 @@ func Run(configPath string, unixSocketPath string, allowNonRoot bool) error {
 		logrus.Errorf("failed to gracefully shutdown http server, closing it immediately: %v", err)
 		_ = srv.Close()
 	}
-	cancel()
-
-	if listeningForSleep {
-		logrus.Info("stopping listening notifications")
-		stopListeningNotifications()
-	}
-
-	if err := AllowSleepOnAC(); err != nil {
-		logrus.Errorf("failed to remove PM assertion before exiting: %v", err)
-	}
-	if err := AllowCalibrationSleep(); err != nil {
-		logrus.Errorf("failed to remove calibration sleep assertion before exiting: %v", err)
-	}
-
-	if capabilities.ChargingControl {
-		if err := smcConn.ResetChargeControl(); err != nil {
-			logrus.Errorf("failed to reset charge control before exiting: %v", err)
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			_ = metricsSrv.Close()
 		}
 	}
+	cancel()
 
-	if capabilities.AdapterControl {
-		if err := smcConn.EnableAdapter(); err != nil {
-			logrus.Errorf("failed to re-enable adapter before exiting: %v", err)
-		}
-	}
+	stopRunning()
+	<-stopped
 
-	logrus.Info("closing smc connection")
-	err = smcConn.Close()
+	logrus.Info("closing charge backend")
+	err = backend.Close()
 	if err != nil {
-		logrus.Errorf("failed to close smc connection: %v", err)
+		logrus.Errorf("failed to close charge backend: %v", err)
 	}
 
 	logrus.Info("exiting")
@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/audit"
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/clock"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/smc"
+)
+
+// newTestDaemon returns a daemon for backend with the settings of c that
+// keeps its state in memory. A nil backend supports no charge control.
+func newTestDaemon(t *testing.T, backend ChargeBackend, c config.Config) *Daemon {
+	t.Helper()
+	if backend == nil {
+		backend = smc.NewMock(nil)
+	}
+	return New(Options{Backend: backend, Config: c})
+}
+
+// newTestDaemonWithClock is newTestDaemon on a fake clock, which only moves
+// when the test advances it.
+func newTestDaemonWithClock(t *testing.T, backend ChargeBackend, c config.Config) (*Daemon, *clock.Fake) {
+	t.Helper()
+	if backend == nil {
+		backend = smc.NewMock(nil)
+	}
+	clk := clock.NewFake(time.Date(2026, time.July, 20, 9, 0, 0, 0, time.UTC))
+	return New(Options{Backend: backend, Config: c, Clock: clk}), clk
+}
+
+func TestDaemonsAreIndependent(t *testing.T) {
+	t.Parallel()
+	first, second := newFakeSMC(40, 0, true), newFakeSMC(60, 1, true)
+	a := newTestDaemon(t, first, &mockConf{upper: 80, lower: 78})
+	b := newTestDaemon(t, second, &mockConf{upper: 50, lower: 48})
+	stubCalibrationSleep(a)
+	stubCalibrationSleep(b)
+
+	if err := a.startCalibration(audit.ActorAPI, 15, 10); err != nil {
+		t.Fatal(err)
+	}
+	a.applyCalibrationWithinLoop(first.charge)
+	b.maintainLoopInner(true)
+
+	if a.calibrationState.Phase != calibration.PhaseDischarge {
+		t.Fatalf("first daemon phase = %s, want discharge", a.calibrationState.Phase)
+	}
+	if b.calibrationState.Phase != calibration.PhaseIdle {
+		t.Fatalf("second daemon phase = %s, want idle", b.calibrationState.Phase)
+	}
+	if first.adapter || !second.adapter {
+		t.Fatalf("adapters = %t/%t, want only the first one disabled", first.adapter, second.adapter)
+	}
+	// The second battery is above its limit of 50%, the first one is left
+	// to calibration.
+	if second.charging {
+		t.Fatal("second daemon did not disable charging above its limit")
+	}
+	if upper, _ := a.effectiveLimits(); upper != 80 {
+		t.Fatalf("first daemon upper limit = %d, want 80", upper)
+	}
+	if upper, _ := b.effectiveLimits(); upper != 50 {
+		t.Fatalf("second daemon upper limit = %d, want 50", upper)
+	}
+	if a.maintainLoopRuns.Load() != 0 || b.maintainLoopRuns.Load() != 1 {
+		t.Fatalf("maintain loop runs = %d/%d, want 0/1", a.maintainLoopRuns.Load(), b.maintainLoopRuns.Load())
+	}
+}
+
+func TestNewWithClock(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, 7, 20, 9, 0, 0, 0, time.UTC)
+	d := New(Options{
+		Backend: newFakeSMC(50, 0, true),
+		Config:  &mockConf{upper: 80, lower: 78},
+		Clock:   clock.NewFake(now),
+	})
+	if got := d.clock.Now(); !got.Equal(now) {
+		t.Fatalf("clock.Now() = %s, want %s", got, now)
+	}
+	if d.hub == nil || d.scheduler == nil || d.webhookDispatcher == nil {
+		t.Fatal("New() left dependencies unset")
+	}
+}
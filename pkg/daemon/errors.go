@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/charlie0129/batt/pkg/api"
+)
+
+// isV1 reports whether c is a request to the versioned API.
+func isV1(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/v1/")
+}
+
+// toAPIError classifies err. Errors that are not already an *api.Error are
+// calibration conflicts or internal errors.
+func toAPIError(err error) *api.Error {
+	var e *api.Error
+	if errors.As(err, &e) {
+		return e
+	}
+	var ce *calibrationError
+	if errors.As(err, &ce) {
+		return &api.Error{Code: api.CodeCalibrationConflict, Message: err.Error()}
+	}
+	return &api.Error{Code: api.CodeInternal, Message: err.Error()}
+}
+
+// invalidArgument wraps a parse or validation error.
+func invalidArgument(err error) *api.Error {
+	return &api.Error{Code: api.CodeInvalidArgument, Message: err.Error()}
+}
+
+// legacyStatus is the status code the unversioned routes have always used
+// for each kind of error, unless the error says otherwise with
+// withLegacyStatus.
+func legacyStatus(code api.ErrorCode) int {
+	switch code {
+	case api.CodeInvalidArgument, api.CodeCalibrationConflict, api.CodeConflict:
+		return http.StatusBadRequest
+	case api.CodeCapabilityMissing:
+		return http.StatusConflict
+	default:
+		return code.HTTPStatus()
+	}
+}
+
+// legacyStatusError is an error that the unversioned routes answer with
+// status.
+type legacyStatusError struct {
+	error
+	status int
+}
+
+func (e *legacyStatusError) Unwrap() error { return e.error }
+
+// withLegacyStatus makes the unversioned routes answer err with status, for
+// the errors they answered differently than others of the same code.
+func withLegacyStatus(err error, status int) error {
+	return &legacyStatusError{error: err, status: status}
+}
+
+// abortWithError responds with err and aborts the request. /v1 routes get
+// an api.Error body; legacy routes keep their bare message string.
+func abortWithError(c *gin.Context, err error) {
+	e := toAPIError(err)
+	if isV1(c) {
+		c.IndentedJSON(e.Code.HTTPStatus(), e)
+		_ = c.AbortWithError(e.Code.HTTPStatus(), err)
+		return
+	}
+	status := legacyStatus(e.Code)
+	var le *legacyStatusError
+	if errors.As(err, &le) {
+		status = le.status
+	}
+	c.IndentedJSON(status, e.Message)
+	_ = c.AbortWithError(status, err)
+}
+
+// noRoute answers unknown /v1 routes with an api.Error.
+func noRoute(c *gin.Context) {
+	if isV1(c) {
+		abortWithError(c, api.Errorf(api.CodeNotFound, "no route for %s %s", c.Request.Method, c.Request.URL.Path))
+		return
+	}
+	c.String(http.StatusNotFound, "404 page not found")
+}
@@ -0,0 +1,169 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/compatibility"
+)
+
+// calibrationPhaseActions describes what calibration does to the battery in
+// each phase, for explanations.
+var calibrationPhaseActions = map[calibration.Phase]string{
+	calibration.PhaseDischarge: "discharges the battery",
+	calibration.PhaseCharge:    "charges the battery to 100%",
+	calibration.PhaseHold:      "holds the battery at 100%",
+	calibration.PhasePostHold:  "discharges the battery back to the charge limit",
+	calibration.PhaseRestore:   "restores the charge limit",
+}
+
+// startTrace starts the explanation of a maintain loop. d.maintainLoopMu must
+// be held until finishTrace.
+func (d *Daemon) startTrace(now time.Time) {
+	d.trace = &api.Explanation{
+		Time:  now,
+		Mode:  d.capabilities.ChargeControlMode,
+		Steps: []api.ExplanationStep{},
+	}
+}
+
+// finishTrace makes the explanation of the maintain loop the latest one. ok
+// is what the loop returned, which is always false without charge control.
+func (d *Daemon) finishTrace(ok bool) {
+	if !ok && d.trace.Rule != api.RuleUnsupported {
+		d.decide(api.RuleError, "the maintain loop failed, see the daemon log")
+	} else if d.trace.Mode == compatibility.ChargeControlLegacy && !d.trace.PluggedIn {
+		d.explain(api.RuleUnplugged, "the power adapter is not plugged in, so the battery does not charge either way")
+	}
+
+	d.explanationMu.Lock()
+	d.explanation = d.trace
+	d.explanationMu.Unlock()
+	d.trace = nil
+}
+
+// explain adds a step to the explanation of the running maintain loop.
+func (d *Daemon) explain(rule api.Rule, format string, args ...any) {
+	d.trace.Steps = append(d.trace.Steps, api.ExplanationStep{Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// decide adds the step of the rule that decided whether to charge.
+func (d *Daemon) decide(rule api.Rule, format string, args ...any) {
+	d.explain(rule, format, args...)
+	d.trace.Rule = rule
+}
+
+// explainLimits records the enforced limits and the steps that led to them:
+// the timers of batt disable and batt adapter disable, the limit profile,
+// adaptive charging and the temperature guard.
+func (d *Daemon) explainLimits(upper, lower int) {
+	d.trace.Upper, d.trace.Lower = upper, lower
+
+	if until := d.conf.DisableUntil(); !until.IsZero() {
+		d.explain(api.RuleTemporaryDisable, "the charge limit is disabled until %s, then the upper limit goes back to %d%%", until.Format(time.DateTime), d.conf.PreDisableLimit())
+	}
+	if until := d.conf.AdapterDisableUntil(); !until.IsZero() {
+		d.explain(api.RuleAdapterTimer, "the power adapter is disabled until %s, so the Mac runs on battery", until.Format(time.DateTime))
+	}
+	if p := d.enforcedLimitProfile(); p != nil {
+		d.explain(api.RuleLimitProfile, "limit profile %q sets the upper limit to %d%%", p.Name, p.Limit)
+	}
+	if d.adaptiveChargingToppingUp() {
+		d.explain(api.RuleAdaptive, "adaptive charging raises the upper limit to 100%% before you usually unplug")
+	}
+	if limit, ok := d.temperatureGuardCap(); ok && limit == upper {
+		d.explain(api.RuleTemperature, "the battery is too hot, so the upper limit is capped at %d%%", limit)
+	}
+}
+
+// explainCalibration records that calibration decided.
+func (d *Daemon) explainCalibration() {
+	d.calibrationMu.Lock()
+	phase := d.calibrationState.Phase
+	d.calibrationMu.Unlock()
+
+	action, ok := calibrationPhaseActions[phase]
+	if !ok {
+		action = "controls charging"
+	}
+	d.decide(api.RuleCalibration, "calibration is in the %s phase and %s, the charge limits do not apply until it finishes", phase, action)
+}
+
+// explainNoLimit records that charging is not limited because the upper
+// limit is 100%.
+func (d *Daemon) explainNoLimit(what string) {
+	rule := api.RuleNoLimit
+	switch {
+	case !d.conf.DisableUntil().IsZero():
+		rule = api.RuleTemporaryDisable
+	case d.adaptiveChargingToppingUp():
+		rule = api.RuleAdaptive
+	}
+	d.decide(rule, "the upper limit is 100%%, so %s", what)
+}
+
+// explainKeep records why the maintain loop left charging as it was.
+func (d *Daemon) explainKeep(isChargingEnabled bool, batteryCharge, lower, upper int) {
+	switch {
+	case batteryCharge >= upper:
+		d.decide(api.RuleAboveUpperLimit, "the charge %d%% is at or above the upper limit %d%%, so charging stays disabled", batteryCharge, upper)
+	case batteryCharge < lower:
+		d.decide(api.RuleBelowLowerLimit, "the charge %d%% is below the lower limit %d%%, so charging stays enabled until %d%%", batteryCharge, lower, upper)
+	case isChargingEnabled:
+		d.decide(api.RuleHysteresis, "the charge %d%% is between the lower limit %d%% and the upper limit %d%%, charging stays enabled until %d%%", batteryCharge, lower, upper, upper)
+	default:
+		d.decide(api.RuleHysteresis, "the charge %d%% is between the lower limit %d%% and the upper limit %d%%, charging stays disabled until the charge drops below %d%%", batteryCharge, lower, upper, lower)
+	}
+}
+
+// missedLoopsSummary tells how many maintain loops ran recently, for
+// explanations of RuleMissedLoops.
+func (d *Daemon) missedLoopsSummary() string {
+	return fmt.Sprintf("only %d of %d maintain loops ran in the last %s, the Mac was probably asleep",
+		d.loopRecorder.GetRecordsIn(continuousLoopThreshold), int(continuousLoopThreshold/loopInterval), continuousLoopThreshold)
+}
+
+// getExplanation returns the explanation of the last maintain loop, or nil
+// before the first one.
+func (d *Daemon) getExplanation() *api.Explanation {
+	d.explanationMu.Lock()
+	defer d.explanationMu.Unlock()
+	if d.explanation == nil {
+		return nil
+	}
+	e := *d.explanation
+	e.Steps = slices.Clone(e.Steps)
+	return &e
+}
+
+func (d *Daemon) getExplain(c *gin.Context) {
+	e := d.getExplanation()
+	if e == nil {
+		abortWithError(c, api.Errorf(api.CodeUnavailable, "the charge has not been maintained yet"))
+		return
+	}
+
+	if e.Mode != compatibility.ChargeControlLegacy {
+		var err error
+		if e.Charge, err = d.backend.GetBatteryCharge(); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if e.PluggedIn, err = d.backend.IsPluggedIn(); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if e.Charging, err = d.readCharging(); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, e)
+}
@@ -0,0 +1,177 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/compatibility"
+)
+
+func TestMaintainLoopExplanation(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+	tests := []struct {
+		name        string
+		smc         *fakeSMC
+		conf        *mockConf
+		phase       calibration.Phase
+		missedLoops bool
+		wantRule    api.Rule
+		wantSteps   []api.Rule
+		wantMessage string
+		wantCharge  bool
+	}{
+		{
+			name:        "below lower limit",
+			smc:         newFakeSMC(70, 0, true),
+			conf:        &mockConf{upper: 80, lower: 75},
+			wantRule:    api.RuleBelowLowerLimit,
+			wantSteps:   []api.Rule{api.RuleBelowLowerLimit},
+			wantMessage: "the charge 70% is below the lower limit 75%, so charging is enabled",
+			wantCharge:  true,
+		},
+		{
+			name:        "above upper limit",
+			smc:         newFakeSMC(80, 1, true),
+			conf:        &mockConf{upper: 80, lower: 75},
+			wantRule:    api.RuleAboveUpperLimit,
+			wantSteps:   []api.Rule{api.RuleAboveUpperLimit},
+			wantMessage: "the charge 80% has reached the upper limit 80%, so charging is disabled",
+		},
+		{
+			name:        "waiting inside the hysteresis band",
+			smc:         newFakeSMC(83, 0, true),
+			conf:        &mockConf{upper: 85, lower: 80},
+			wantRule:    api.RuleHysteresis,
+			wantSteps:   []api.Rule{api.RuleHysteresis},
+			wantMessage: "charging stays disabled until the charge drops below 80%",
+		},
+		{
+			name:        "charging inside the hysteresis band",
+			smc:         newFakeSMC(83, 1, true),
+			conf:        &mockConf{upper: 85, lower: 80},
+			wantRule:    api.RuleHysteresis,
+			wantSteps:   []api.Rule{api.RuleHysteresis},
+			wantMessage: "charging stays enabled until 85%",
+			wantCharge:  true,
+		},
+		{
+			name:        "missed loops while charging",
+			smc:         newFakeSMC(70, 1, true),
+			conf:        &mockConf{upper: 80, lower: 75},
+			missedLoops: true,
+			wantRule:    api.RuleMissedLoops,
+			wantSteps:   []api.Rule{api.RuleMissedLoops, api.RuleMissedLoops},
+			wantMessage: "only 0 of 8 maintain loops ran in the last 1m20s",
+		},
+		{
+			name:        "calibration",
+			smc:         newFakeSMC(60, 1, true),
+			conf:        &mockConf{upper: 80, lower: 75},
+			phase:       calibration.PhaseCharge,
+			wantRule:    api.RuleCalibration,
+			wantSteps:   []api.Rule{api.RuleCalibration},
+			wantMessage: "calibration is in the ChargeToFull phase",
+			wantCharge:  true,
+		},
+		{
+			name:        "temporary disable",
+			smc:         newFakeSMC(90, 0, true),
+			conf:        &mockConf{upper: 100, lower: 95, disableUntil: later, preDisableLimit: 80},
+			wantRule:    api.RuleTemporaryDisable,
+			wantSteps:   []api.Rule{api.RuleTemporaryDisable, api.RuleTemporaryDisable},
+			wantMessage: "the upper limit goes back to 80%",
+			wantCharge:  true,
+		},
+		{
+			name:        "adapter timer",
+			smc:         newFakeSMC(78, 0, true),
+			conf:        &mockConf{upper: 80, lower: 75, adapterDisableUntil: later},
+			wantRule:    api.RuleHysteresis,
+			wantSteps:   []api.Rule{api.RuleAdapterTimer, api.RuleHysteresis},
+			wantMessage: "so the Mac runs on battery",
+		},
+		{
+			name:        "no limit",
+			smc:         newFakeSMC(90, 1, true),
+			conf:        &mockConf{upper: 100, lower: 95},
+			wantRule:    api.RuleNoLimit,
+			wantSteps:   []api.Rule{api.RuleNoLimit},
+			wantMessage: "the upper limit is 100%, so charging is enabled",
+			wantCharge:  true,
+		},
+		{
+			name:        "unplugged",
+			smc:         newFakeSMC(60, 1, false),
+			conf:        &mockConf{upper: 80, lower: 75},
+			wantRule:    api.RuleBelowLowerLimit,
+			wantSteps:   []api.Rule{api.RuleBelowLowerLimit, api.RuleUnplugged},
+			wantMessage: "the power adapter is not plugged in",
+			wantCharge:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDaemon(t, tt.smc, tt.conf)
+			stubCalibrationSleep(d)
+			if tt.phase != "" {
+				d.calibrationState = &calibration.State{Phase: tt.phase, Threshold: 15}
+			}
+			if !d.maintainLoopInner(!tt.missedLoops) {
+				t.Fatal("maintain loop failed")
+			}
+
+			e := d.getExplanation()
+			var rules []api.Rule
+			var messages []string
+			for _, s := range e.Steps {
+				rules = append(rules, s.Rule)
+				messages = append(messages, s.Message)
+			}
+			if e.Rule != tt.wantRule || !slices.Equal(rules, tt.wantSteps) {
+				t.Fatalf("rule = %q, steps = %q, want %q, %q", e.Rule, rules, tt.wantRule, tt.wantSteps)
+			}
+			if !strings.Contains(strings.Join(messages, "\n"), tt.wantMessage) {
+				t.Fatalf("steps %q do not tell %q", messages, tt.wantMessage)
+			}
+			if e.Charging != tt.wantCharge || e.Charging != tt.smc.charging {
+				t.Fatalf("charging = %t, backend %t, want %t", e.Charging, tt.smc.charging, tt.wantCharge)
+			}
+			if e.Charge != tt.smc.charge || e.Mode != compatibility.ChargeControlLegacy {
+				t.Fatalf("unexpected explanation %+v", e)
+			}
+		})
+	}
+}
+
+func TestGetExplain(t *testing.T) {
+	backend, _ := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "60",
+		"BAT0/status":                       "Not charging",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+	d := newTestDaemon(t, backend, &mockConf{upper: 80, lower: 75})
+
+	decodeAPIError(t, serveV1(t, d, http.MethodGet, "/v1/explain", ""), http.StatusServiceUnavailable, api.CodeUnavailable)
+
+	d.maintainLoopForced()
+	response := serveV1(t, d, http.MethodGet, "/v1/explain", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d; body: %s", response.Code, response.Body.String())
+	}
+	var e api.Explanation
+	if err := json.Unmarshal(response.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	// The firmware rule does not depend on the battery, so the request
+	// reads it for the explanation.
+	if e.Rule != api.RuleFirmware || e.Charge != 60 || !e.PluggedIn || e.Charging || e.Upper != 80 || e.Lower != 75 {
+		t.Fatalf("GET /v1/explain = %+v", e)
+	}
+}
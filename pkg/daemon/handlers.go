@@ -1,92 +1,169 @@
 package daemon
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
-	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 
-	"github.com/peterneutron/powerkit-go/pkg/powerkit"
-
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
 	"github.com/charlie0129/batt/pkg/calibration"
 	"github.com/charlie0129/batt/pkg/compatibility"
 	"github.com/charlie0129/batt/pkg/config"
-	"github.com/charlie0129/batt/pkg/powerinfo"
 	"github.com/charlie0129/batt/pkg/version"
 )
 
-func getConfig(c *gin.Context) {
-	fc, err := config.NewRawFileConfigFromConfig(conf)
+// The handlers below serve the legacy, unversioned routes. They share their
+// logic with the /v1 handlers in v1.go and only keep the old request and
+// response shapes.
+
+func (d *Daemon) getConfig(c *gin.Context) {
+	rev := d.conf.Revision()
+	fc, err := d.configResource()
 	if err != nil {
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
+		return
+	}
+	c.Header("ETag", d.configETag(rev))
+	if explain, _ := strconv.ParseBool(c.Query("explain")); explain {
+		settings, err := d.explainConfig(fc)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, settings)
 		return
 	}
 	c.IndentedJSON(http.StatusOK, fc)
 }
 
-func getLimit(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, conf.UpperLimit())
+// explainer is a config that can tell where its settings come from, like
+// config.Layered.
+type explainer interface {
+	Explain() map[string]config.Origin
 }
 
-func setLimit(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureChargingControl) {
+// explainConfig adds where each setting of the config resource fc comes
+// from, if conf can tell.
+func (d *Daemon) explainConfig(fc *api.Config) (map[string]api.ConfigSetting, error) {
+	b, err := json.Marshal(fc)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+	var origins map[string]config.Origin
+	if e, ok := d.conf.(explainer); ok {
+		origins = e.Explain()
+	}
+	settings := make(map[string]api.ConfigSetting, len(values))
+	for name, v := range values {
+		o := origins[name]
+		settings[name] = api.ConfigSetting{Value: v, Source: o.Source, Name: o.Name}
+	}
+	// Settings that are off, like metricsPort 0, are left out of fc.
+	for name, o := range origins {
+		if _, ok := settings[name]; !ok {
+			settings[name] = api.ConfigSetting{Value: json.RawMessage("null"), Source: o.Source, Name: o.Name}
+		}
+	}
+	return settings, nil
+}
+
+// configResource returns the config as served by /config, with defaults
+// filled in and secrets redacted.
+func (d *Daemon) configResource() (*api.Config, error) {
+	raw, err := config.NewRawFileConfigFromConfig(d.conf)
+	if err != nil {
+		return nil, err
+	}
+	fc := &api.Config{RawFileConfig: *raw}
+	if p := d.enforcedLimitProfile(); p != nil {
+		fc.ActiveLimitProfile = &p.Name
+	}
+	// Webhook secrets and the MQTT password are write-only.
+	fc.Webhooks = redactWebhooks(fc.Webhooks)
+	if fc.MQTT != nil && fc.MQTT.Password != "" {
+		m := *fc.MQTT
+		m.Password = redactedSecret
+		fc.MQTT = &m
+	}
+	return fc, nil
+}
+
+func (d *Daemon) getLimit(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, d.conf.UpperLimit())
+}
+
+func (d *Daemon) setLimit(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureChargingControl) {
 		return
 	}
 	var l int
-	if err := c.BindJSON(&l); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := c.ShouldBindJSON(&l); err != nil {
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	if l < 10 || l > 100 {
-		err := fmt.Errorf("limit must be between 10 and 100, got %d", l)
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	msg, err := d.applyLimit(requestActor(c), l)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	chargeControlTransitionMu.Lock()
-	defer chargeControlTransitionMu.Unlock()
+	c.IndentedJSON(http.StatusCreated, msg)
+}
+
+// applyLimit validates and saves a new upper limit, then runs the maintain
+// loop. It is shared by the HTTP API and MQTT commands.
+func (d *Daemon) applyLimit(a audit.Actor, l int) (string, error) {
+	cfg := d.auditing(d.conf, a)
 
-	if calibrationOwnsChargeLimit() {
-		err := ErrCalibrationControlsChargeLimit
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
+	if l < 10 || l > 100 {
+		return "", api.Errorf(api.CodeInvalidArgument, "limit must be between 10 and 100, got %d", l).
+			WithDetail("min", 10).WithDetail("max", 100)
 	}
 
-	if delta := conf.UpperLimit() - conf.LowerLimit(); l-delta <= 10 {
-		err := fmt.Errorf("upper limit must be greater than lower limit + 10, got %d", l-delta)
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
+	d.chargeControlTransitionMu.Lock()
+	defer d.chargeControlTransitionMu.Unlock()
+
+	if d.calibrationOwnsChargeLimit() {
+		return "", ErrCalibrationControlsChargeLimit
+	}
+
+	if delta := d.conf.UpperLimit() - d.conf.LowerLimit(); l-delta <= 10 {
+		return "", api.Errorf(api.CodeInvalidArgument, "upper limit must be greater than lower limit + 10, got %d", l-delta).
+			WithDetail("min", delta+11)
 	}
 
-	conf.SetUpperLimit(l)
+	if err := cfg.SetUpperLimit(l); err != nil {
+		return "", invalidArgument(err)
+	}
 	// An explicit limit change overrides any pending scheduled re-enabling.
-	conf.ClearDisableTimer()
-	if err := conf.Save(); err != nil {
+	cfg.ClearDisableTimer()
+	if err := cfg.Save(); err != nil {
 		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return "", err
 	}
 
 	logrus.Infof("set charging limit to %d", l)
 
 	var msg string
-	charge, err := smcConn.GetBatteryCharge()
+	charge, err := d.backend.GetBatteryCharge()
 	if err != nil {
-		msg = fmt.Sprintf("set upper/lower charging limit to %d%%/%d%%", conf.UpperLimit(), conf.LowerLimit())
+		msg = fmt.Sprintf("set upper/lower charging limit to %d%%/%d%%", d.conf.UpperLimit(), d.conf.LowerLimit())
 	} else {
-		msg = fmt.Sprintf("set upper/lower charging limit to %d%%/%d%%, current charge: %d%%", conf.UpperLimit(), conf.LowerLimit(), charge)
-		if charge > conf.UpperLimit() {
-			if capabilities.ChargeControlMode == compatibility.ChargeControlFirmware {
+		msg = fmt.Sprintf("set upper/lower charging limit to %d%%/%d%%, current charge: %d%%", d.conf.UpperLimit(), d.conf.LowerLimit(), charge)
+		if charge > d.conf.UpperLimit() {
+			if d.capabilities.ChargeControlMode == compatibility.ChargeControlFirmware {
 				msg += ". Current charge is above the limit; the firmware may use battery power until it falls within the configured range."
 			} else {
 				msg += ". Current charge is above the limit, so your computer will use power from the wall only. Battery charge will remain the same."
@@ -97,11 +174,14 @@ func setLimit(c *gin.Context) {
 	if l >= 100 {
 		msg = "set charging limit to 100%. batt will not control charging anymore."
 	}
+	if p := d.enforcedLimitProfile(); p != nil {
+		msg = strings.TrimSuffix(msg, ".") + fmt.Sprintf(". Limit profile %q (%d%%) is active and takes precedence until its window ends.", p.Name, p.Limit)
+	}
 
 	// Immediate single maintain loop, to avoid waiting for the next loop
-	maintainLoopForced()
+	d.maintainLoopForced()
 
-	c.IndentedJSON(http.StatusCreated, msg)
+	return msg, nil
 }
 
 // resolveDisableLimit returns the limit to restore once a temporary disable
@@ -124,57 +204,75 @@ func resolveDisableLimit(conf config.Config) (int, bool) {
 	return limit, true
 }
 
-func setDisableFor(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureChargingControl) {
+// parseDuration parses a positive duration from a request.
+func parseDuration(raw string) (time.Duration, error) {
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, invalidArgument(err)
+	}
+	if d <= 0 {
+		return 0, api.Errorf(api.CodeInvalidArgument, "duration must be positive, got %s", d)
+	}
+	return d, nil
+}
+
+func (d *Daemon) setDisableFor(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureChargingControl) {
 		return
 	}
 	var raw string
 	if err := c.ShouldBindJSON(&raw); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	d, err := time.ParseDuration(raw)
+	duration, err := parseDuration(raw)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		abortWithError(c, err)
 		return
 	}
 
-	if d <= 0 {
-		err := fmt.Errorf("duration must be positive, got %s", d)
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	prevLimit, until, err := d.applyDisableFor(requestActor(c), duration)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	chargeControlTransitionMu.Lock()
-	defer chargeControlTransitionMu.Unlock()
+	c.IndentedJSON(http.StatusCreated, disableMessage(prevLimit, until))
+}
 
-	if calibrationOwnsChargeLimit() {
-		err := ErrCalibrationControlsChargeLimit
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
+func disableMessage(prevLimit int, until time.Time) string {
+	return fmt.Sprintf("batt disabled, charge limit will be restored to %d%% at %s", prevLimit, until.Format(time.DateTime))
+}
+
+// applyDisableFor disables charge limiting for duration. It returns the limit that
+// will be restored and when.
+func (d *Daemon) applyDisableFor(a audit.Actor, duration time.Duration) (int, time.Time, error) {
+	cfg := d.auditing(d.conf, a)
+
+	d.chargeControlTransitionMu.Lock()
+	defer d.chargeControlTransitionMu.Unlock()
+
+	if d.calibrationOwnsChargeLimit() {
+		return 0, time.Time{}, ErrCalibrationControlsChargeLimit
 	}
 
-	prevLimit, ok := resolveDisableLimit(conf)
+	prevLimit, ok := resolveDisableLimit(d.conf)
 	if !ok {
-		err := fmt.Errorf("batt is already disabled and no previous charge limit is recorded, nothing to restore. Set a limit first with 'batt limit <percentage>'")
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
+		return 0, time.Time{}, api.Errorf(api.CodeConflict, "batt is already disabled and no previous charge limit is recorded, nothing to restore. Set a limit first with 'batt limit <percentage>'")
 	}
 
-	until := time.Now().Add(d).Truncate(time.Second)
-	conf.SetUpperLimit(100)
-	conf.SetDisableTimer(until, prevLimit)
-	if err := conf.Save(); err != nil {
+	until := d.clock.Now().Add(duration).Truncate(time.Second)
+	if err := cfg.SetDisableTimer(until, prevLimit); err != nil {
+		return 0, time.Time{}, invalidArgument(err)
+	}
+	if err := cfg.SetUpperLimit(100); err != nil {
+		cfg.ClearDisableTimer()
+		return 0, time.Time{}, invalidArgument(err)
+	}
+	if err := cfg.Save(); err != nil {
 		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return 0, time.Time{}, err
 	}
 
 	logrus.WithFields(logrus.Fields{
@@ -182,429 +280,416 @@ func setDisableFor(c *gin.Context) {
 		"prevLimit": prevLimit,
 	}).Infof("disabled batt temporarily")
 
-	maintainLoopForced()
+	d.maintainLoopForced()
 
-	c.IndentedJSON(http.StatusCreated, fmt.Sprintf("batt disabled, charge limit will be restored to %d%% at %s", prevLimit, until.Format(time.DateTime)))
+	return prevLimit, until, nil
 }
 
-func setPreventIdleSleep(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureSleepHooks) {
+// setBoolSetting serves a legacy request that changes a single boolean
+// setting.
+func (d *Daemon) setBoolSetting(c *gin.Context, set func(s *api.Settings, v *bool)) {
+	var v bool
+	if err := c.ShouldBindJSON(&v); err != nil {
+		abortWithError(c, invalidArgument(err))
 		return
 	}
-	var p bool
-	if err := c.BindJSON(&p); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	var s api.Settings
+	set(&s, &v)
+	if _, err := d.applySettings(requestActor(c), s); err != nil {
+		abortWithError(c, err)
 		return
 	}
+	c.IndentedJSON(http.StatusCreated, "ok")
+}
 
-	conf.SetPreventIdleSleep(p)
-	if err := conf.Save(); err != nil {
-		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
-	}
+func (d *Daemon) setPreventIdleSleep(c *gin.Context) {
+	d.setBoolSetting(c, func(s *api.Settings, v *bool) { s.PreventIdleSleep = v })
+}
 
-	logrus.Infof("set prevent idle sleep to %t", p)
+func (d *Daemon) setDisableChargingPreSleep(c *gin.Context) {
+	d.setBoolSetting(c, func(s *api.Settings, v *bool) { s.DisableChargingPreSleep = v })
+}
 
-	c.IndentedJSON(http.StatusCreated, "ok")
+func (d *Daemon) setPreventSystemSleep(c *gin.Context) {
+	d.setBoolSetting(c, func(s *api.Settings, v *bool) { s.PreventSystemSleep = v })
 }
 
-func setDisableChargingPreSleep(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureSleepHooks) {
-		return
+func (d *Daemon) getSettings() api.Settings {
+	preventIdleSleep := d.conf.PreventIdleSleep()
+	disableChargingPreSleep := d.conf.DisableChargingPreSleep()
+	preventSystemSleep := d.conf.PreventSystemSleep()
+	controlMagSafeLED := d.conf.ControlMagSafeLED()
+	adaptiveCharging := d.conf.AdaptiveCharging()
+	return api.Settings{
+		PreventIdleSleep:        &preventIdleSleep,
+		DisableChargingPreSleep: &disableChargingPreSleep,
+		PreventSystemSleep:      &preventSystemSleep,
+		ControlMagSafeLED:       &controlMagSafeLED,
+		AdaptiveCharging:        &adaptiveCharging,
 	}
-	var d bool
-	if err := c.BindJSON(&d); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
+}
+
+// applySettings changes the settings that are set in s. Nothing is changed
+// unless every one of them is supported.
+func (d *Daemon) applySettings(a audit.Actor, s api.Settings) (string, error) {
+	cfg := d.auditing(d.conf, a)
+
+	if s.PreventIdleSleep != nil || s.DisableChargingPreSleep != nil || s.PreventSystemSleep != nil {
+		if err := d.checkCapability(compatibility.FeatureSleepHooks); err != nil {
+			return "", err
+		}
+	}
+	if s.ControlMagSafeLED != nil {
+		if m := *s.ControlMagSafeLED; !m.Valid() {
+			return "", api.Errorf(api.CodeInvalidArgument, "invalid MagSafe LED mode %q, must be %q, %q or %q", m, config.ControlMagSafeModeEnabled, config.ControlMagSafeModeDisabled, config.ControlMagSafeModeAlwaysOff)
+		}
+		if err := d.checkCapability(compatibility.FeatureMagSafeLED); err != nil {
+			return "", err
+		}
+		if !d.backend.CheckMagSafeExistence() {
+			logrus.Errorf("setControlMagSafeLED called but there is no MasSafe LED on this device")
+			return "", withLegacyStatus(api.Errorf(api.CodeCapabilityMissing, "there is no MasSafe on this device. You can only enable this setting on a compatible device, e.g. MacBook Pro 14-inch 2021").
+				WithDetail("feature", compatibility.FeatureMagSafeLED), http.StatusInternalServerError)
+		}
+	}
+	if s.AdaptiveCharging != nil {
+		if err := d.checkCapability(compatibility.FeatureChargingControl); err != nil {
+			return "", err
+		}
 	}
 
-	conf.SetDisableChargingPreSleep(d)
-	if err := conf.Save(); err != nil {
+	if s.PreventIdleSleep != nil {
+		cfg.SetPreventIdleSleep(*s.PreventIdleSleep)
+	}
+	if s.DisableChargingPreSleep != nil {
+		cfg.SetDisableChargingPreSleep(*s.DisableChargingPreSleep)
+	}
+	if s.PreventSystemSleep != nil {
+		cfg.SetPreventSystemSleep(*s.PreventSystemSleep)
+	}
+	if s.ControlMagSafeLED != nil {
+		cfg.SetControlMagSafeLED(*s.ControlMagSafeLED)
+	}
+	if s.AdaptiveCharging != nil {
+		cfg.SetAdaptiveCharging(*s.AdaptiveCharging)
+	}
+	if err := cfg.Save(); err != nil {
 		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return "", err
 	}
 
-	logrus.Infof("set disable charging pre sleep to %t", d)
+	var msg string
+	if s.PreventIdleSleep != nil {
+		logrus.Infof("set prevent idle sleep to %t", *s.PreventIdleSleep)
+	}
+	if s.DisableChargingPreSleep != nil {
+		logrus.Infof("set disable charging pre sleep to %t", *s.DisableChargingPreSleep)
+	}
+	if s.PreventSystemSleep != nil {
+		logrus.Infof("set prevent system sleep to %t", *s.PreventSystemSleep)
+	}
+	if s.ControlMagSafeLED != nil {
+		logrus.Infof("set control MagSafe LED to %s", *s.ControlMagSafeLED)
+		msg = fmt.Sprintf("ControlMagSafeLED set to %s. You should be able to see the effect in a few minutes.", *s.ControlMagSafeLED)
+	}
+	if s.AdaptiveCharging != nil {
+		logrus.Infof("set adaptive charging to %t", *s.AdaptiveCharging)
+		d.maintainLoopForced()
+	}
 
-	c.IndentedJSON(http.StatusCreated, "ok")
+	return msg, nil
 }
 
-func setPreventSystemSleep(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureSleepHooks) {
+func (d *Daemon) setAdapter(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureAdapterControl) {
 		return
 	}
-	var p bool
-	if err := c.BindJSON(&p); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	var enabled bool
+	if err := c.ShouldBindJSON(&enabled); err != nil {
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	conf.SetPreventSystemSleep(p)
-	if err := conf.Save(); err != nil {
-		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+	if err := d.applyAdapter(requestActor(c), enabled); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	logrus.Infof("set prevent system sleep to %t", p)
-
 	c.IndentedJSON(http.StatusCreated, "ok")
 }
 
-func setAdapter(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureAdapterControl) {
-		return
-	}
-	var d bool
-	if err := c.BindJSON(&d); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
-	}
+func (d *Daemon) applyAdapter(a audit.Actor, enabled bool) error {
+	cfg := d.auditing(d.conf, a)
 
-	chargeControlTransitionMu.Lock()
-	defer chargeControlTransitionMu.Unlock()
+	d.chargeControlTransitionMu.Lock()
+	defer d.chargeControlTransitionMu.Unlock()
 
-	if calibrationOwnsChargeLimit() {
-		err := ErrCalibrationControlsAdapter
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
+	if d.calibrationOwnsChargeLimit() {
+		return ErrCalibrationControlsAdapter
 	}
 
-	if d {
-		if err := smcEnableAdapter(); err != nil {
+	wasEnabled, _ := d.backend.IsAdapterEnabled()
+	if enabled {
+		if err := d.backend.EnableAdapter(); err != nil {
 			logrus.Errorf("enablePowerAdapter failed: %v", err)
-			c.IndentedJSON(http.StatusInternalServerError, err.Error())
-			_ = c.AbortWithError(http.StatusInternalServerError, err)
-			return
+			return err
 		}
 		logrus.Infof("enabled power adapter")
 	} else {
-		if err := smcDisableAdapter(); err != nil {
+		if err := d.backend.DisableAdapter(); err != nil {
 			logrus.Errorf("disablePowerAdapter failed: %v", err)
-			c.IndentedJSON(http.StatusInternalServerError, err.Error())
-			_ = c.AbortWithError(http.StatusInternalServerError, err)
-			return
+			return err
 		}
 		logrus.Infof("disabled power adapter")
 	}
+	d.recordAudit(a, "adapter", wasEnabled, enabled)
 
 	// An explicit adapter change overrides any pending scheduled enable.
-	conf.ClearAdapterDisableTimer()
-	if err := conf.Save(); err != nil {
+	cfg.ClearAdapterDisableTimer()
+	if err := cfg.Save(); err != nil {
 		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return err
 	}
-
-	c.IndentedJSON(http.StatusCreated, "ok")
+	return nil
 }
 
-func setAdapterDisableFor(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureAdapterControl) {
+func (d *Daemon) setAdapterDisableFor(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureAdapterControl) {
 		return
 	}
 	var raw string
 	if err := c.ShouldBindJSON(&raw); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	d, err := time.ParseDuration(raw)
+	duration, err := parseDuration(raw)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		abortWithError(c, err)
 		return
 	}
-	if d <= 0 {
-		err := fmt.Errorf("duration must be positive, got %s", d)
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+
+	until, err := d.applyAdapterDisableFor(requestActor(c), duration)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	chargeControlTransitionMu.Lock()
-	defer chargeControlTransitionMu.Unlock()
+	c.IndentedJSON(http.StatusCreated, adapterDisableMessage(until))
+}
 
-	if calibrationOwnsChargeLimit() {
-		err := ErrCalibrationControlsAdapter
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
+func adapterDisableMessage(until time.Time) string {
+	return fmt.Sprintf("power adapter disabled, it will be enabled at %s", until.Format(time.DateTime))
+}
+
+// applyAdapterDisableFor disables the power adapter for duration and returns when
+// it will be enabled again.
+func (d *Daemon) applyAdapterDisableFor(a audit.Actor, duration time.Duration) (time.Time, error) {
+	cfg := d.auditing(d.conf, a)
+
+	d.chargeControlTransitionMu.Lock()
+	defer d.chargeControlTransitionMu.Unlock()
+
+	if d.calibrationOwnsChargeLimit() {
+		return time.Time{}, ErrCalibrationControlsAdapter
 	}
 
-	until := time.Now().Add(d).Truncate(time.Second)
+	wasEnabled, _ := d.backend.IsAdapterEnabled()
+	until := d.clock.Now().Add(duration).Truncate(time.Second)
 	// Persist the recovery deadline before cutting power so a daemon crash
 	// cannot leave the adapter disabled without a scheduled enable.
-	conf.SetAdapterDisableTimer(until)
-	if err := conf.Save(); err != nil {
-		conf.ClearAdapterDisableTimer()
+	cfg.SetAdapterDisableTimer(until)
+	if err := cfg.Save(); err != nil {
+		cfg.ClearAdapterDisableTimer()
 		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return time.Time{}, err
 	}
-	if err := smcDisableAdapter(); err != nil {
-		conf.ClearAdapterDisableTimer()
-		if saveErr := conf.Save(); saveErr != nil {
+	if err := d.backend.DisableAdapter(); err != nil {
+		cfg.ClearAdapterDisableTimer()
+		if saveErr := cfg.Save(); saveErr != nil {
 			logrus.Errorf("failed to clear adapter disable timer after SMC error: %v", saveErr)
 		}
 		logrus.Errorf("disablePowerAdapter failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return time.Time{}, err
 	}
+	d.recordAudit(a, "adapter", wasEnabled, false)
 
 	logrus.WithField("until", until.Format(time.DateTime)).Info("disabled power adapter temporarily")
-	c.IndentedJSON(http.StatusCreated, fmt.Sprintf("power adapter disabled, it will be enabled at %s", until.Format(time.DateTime)))
+	return until, nil
 }
 
-func getAdapter(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureAdapterControl) {
+func (d *Daemon) getAdapter(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureAdapterControl) {
 		return
 	}
-	enabled, err := smcIsAdapterEnabled()
+	enabled, err := d.backend.IsAdapterEnabled()
 	if err != nil {
 		logrus.Errorf("getAdapter failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, enabled)
 }
 
-func getCharging(c *gin.Context) {
-	if capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
-		err := fmt.Errorf("direct charging state is not available in %s charge-control mode", capabilities.ChargeControlMode)
-		c.IndentedJSON(http.StatusConflict, err.Error())
-		_ = c.AbortWithError(http.StatusConflict, err)
+func (d *Daemon) getCharging(c *gin.Context) {
+	if d.capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
+		abortWithError(c, withLegacyStatus(api.Errorf(api.CodeConflict, "direct charging state is not available in %s charge-control mode", d.capabilities.ChargeControlMode), http.StatusConflict))
 		return
 	}
-	charging, err := smcConn.IsChargingEnabled()
+	charging, err := d.backend.IsChargingEnabled()
 	if err != nil {
 		logrus.Errorf("getCharging failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, charging)
 }
 
-func getBatteryInfo(c *gin.Context) {
-	// Use powerkit-go to retrieve current system info (IOKit only is sufficient here)
-	info, err := powerkit.GetSystemInfo(powerkit.FetchOptions{QueryIOKit: true, QuerySMC: false})
-	if err != nil || info == nil || info.IOKit == nil {
-		if err == nil {
-			err = errors.New("no IOKit data available")
-		}
+func (d *Daemon) getBatteryInfo(c *gin.Context) {
+	info, err := d.readBatteryInfo()
+	if err != nil {
 		logrus.Errorf("getBatteryInfo failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
-	// Map powerkit-go data to our backwards-compatible Battery structure
-	var state powerinfo.BatteryState
-	switch {
-	case info.IOKit.State.FullyCharged:
-		state = powerinfo.Full
-	case info.IOKit.State.IsCharging:
-		state = powerinfo.Charging
-	default:
-		state = powerinfo.Discharging
-	}
-
-	// Compute charge rate (mW) using native amperage sign from IOKit
-	powerW := info.IOKit.Battery.Voltage * info.IOKit.Battery.Amperage
-	chargeRateMilliW := int(math.Round(powerW * 1000.0))
-
-	resp := powerinfo.Battery{
-		State:          state,
-		DesignCapacity: info.IOKit.Battery.DesignCapacity,
-		MaxCapacity:    info.IOKit.Battery.MaxCapacity,
-		ChargeRate:     chargeRateMilliW,
-		DesignVoltage:  info.IOKit.Battery.Voltage,
-	}
-
-	c.IndentedJSON(http.StatusOK, resp)
+	c.IndentedJSON(http.StatusOK, info)
 }
 
-func setLowerLimitDelta(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureChargingControl) {
+func (d *Daemon) setLowerLimitDelta(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureChargingControl) {
 		return
 	}
-	var d int
-	if err := c.BindJSON(&d); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	var delta int
+	if err := c.ShouldBindJSON(&delta); err != nil {
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	if d < 0 {
-		err := fmt.Errorf("lower limit delta must be positive, got %d", d)
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	msg, err := d.applyLowerLimitDelta(requestActor(c), delta)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	if conf.UpperLimit()-d < 10 {
-		err := fmt.Errorf("lower limit delta must be less than limit - 10, got %d", d)
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
+	c.IndentedJSON(http.StatusCreated, msg)
+}
+
+func (d *Daemon) applyLowerLimitDelta(a audit.Actor, delta int) (string, error) {
+	cfg := d.auditing(d.conf, a)
+
+	if delta < 0 {
+		return "", api.Errorf(api.CodeInvalidArgument, "lower limit delta must be positive, got %d", delta).WithDetail("min", 0)
+	}
+
+	if d.conf.UpperLimit()-delta < 10 {
+		return "", api.Errorf(api.CodeInvalidArgument, "lower limit delta must be less than limit - 10, got %d", delta).
+			WithDetail("max", d.conf.UpperLimit()-10)
 	}
 
-	conf.SetLowerLimit(conf.UpperLimit() - d)
-	if err := conf.Save(); err != nil {
+	if err := cfg.SetLowerLimit(d.conf.UpperLimit() - delta); err != nil {
+		return "", invalidArgument(err)
+	}
+	if err := cfg.Save(); err != nil {
 		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return "", err
 	}
 
-	ret := fmt.Sprintf("set lower limit delta to %d, current upper/lower limit is %d%%/%d%%", d, conf.UpperLimit(), conf.LowerLimit())
+	ret := fmt.Sprintf("set lower limit delta to %d, current upper/lower limit is %d%%/%d%%", delta, d.conf.UpperLimit(), d.conf.LowerLimit())
 	logrus.Info(ret)
-	maintainLoopForced()
+	d.maintainLoopForced()
 
-	c.IndentedJSON(http.StatusCreated, ret)
+	return ret, nil
 }
 
-func setControlMagSafeLED(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureMagSafeLED) {
-		return
-	}
-	// Check if MasSafe is supported first. If not, return error.
-	if !smcConn.CheckMagSafeExistence() {
-		logrus.Errorf("setControlMagSafeLED called but there is no MasSafe LED on this device")
-		err := fmt.Errorf("there is no MasSafe on this device. You can only enable this setting on a compatible device, e.g. MacBook Pro 14-inch 2021")
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+func (d *Daemon) setControlMagSafeLED(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureMagSafeLED) {
 		return
 	}
 
 	var mode config.ControlMagSafeMode
-	if err := c.BindJSON(&mode); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := c.ShouldBindJSON(&mode); err != nil {
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	conf.SetControlMagSafeLED(mode)
-	if err := conf.Save(); err != nil {
-		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+	msg, err := d.applySettings(requestActor(c), api.Settings{ControlMagSafeLED: &mode})
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	logrus.Infof("set control MagSafe LED to %s", mode)
-
-	c.IndentedJSON(http.StatusCreated, fmt.Sprintf("ControlMagSafeLED set to %s. You should be able to see the effect in a few minutes.", mode))
+	c.IndentedJSON(http.StatusCreated, msg)
 }
 
-func getCurrentCharge(c *gin.Context) {
-	charge, err := smcConn.GetBatteryCharge()
+func (d *Daemon) getCurrentCharge(c *gin.Context) {
+	charge, err := d.backend.GetBatteryCharge()
 	if err != nil {
 		logrus.Errorf("getCurrentCharge failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, charge)
 }
 
-func getPluggedIn(c *gin.Context) {
-	pluggedIn, err := smcConn.IsPluggedIn()
+func (d *Daemon) getPluggedIn(c *gin.Context) {
+	pluggedIn, err := d.backend.IsPluggedIn()
 	if err != nil {
 		logrus.Errorf("getCurrentCharge failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, pluggedIn)
 }
 
-func getChargingControlCapable(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, smcConn.IsChargingControlCapable())
+func (d *Daemon) getChargingControlCapable(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, d.backend.IsChargingControlCapable())
 }
 
 func getVersion(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, version.Version)
 }
 
-func getPowerTelemetry(c *gin.Context) {
-	// Use powerkit-go to fetch a snapshot of system power state
+func (d *Daemon) getPowerTelemetry(c *gin.Context) {
 	c.Header("X-Deprecated", "true")
 	c.Header("X-Deprecation-Info", "Use /telemetry?power=1 instead; /power-telemetry will be removed in a future release")
-	info, err := powerkit.GetSystemInfo(powerkit.FetchOptions{QueryIOKit: true, QuerySMC: false})
-	if err != nil || info == nil || info.IOKit == nil {
-		if err == nil {
-			err = errors.New("failed to fetch IOKit power data")
-		}
+	snapshot, err := d.readPowerTelemetry()
+	if err != nil {
 		logrus.Errorf("getPowerTelemetry failed: %v", err)
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	// Build simplified telemetry expected by the GUI
-	var snapshot powerinfo.PowerTelemetry
-	snapshot.Adapter.InputVoltage = info.IOKit.Adapter.InputVoltage
-	snapshot.Adapter.InputAmperage = info.IOKit.Adapter.InputAmperage
-
-	snapshot.Battery.CycleCount = info.IOKit.Battery.CycleCount
-
-	snapshot.Calculations.ACPower = info.IOKit.Calculations.AdapterPower
-	snapshot.Calculations.BatteryPower = info.IOKit.Calculations.BatteryPower
-	snapshot.Calculations.SystemPower = info.IOKit.Calculations.SystemPower
-	snapshot.Calculations.HealthByMaxCapacity = info.IOKit.Calculations.HealthByMaxCapacity
-
 	c.IndentedJSON(http.StatusOK, snapshot)
 }
 
-// Unified telemetry endpoint: /telemetry?power=1&calibration=1 (flags optional; default all)
-func getUnifiedTelemetry(c *gin.Context) {
+// Unified telemetry endpoint: /telemetry?power=1&calibration=1&temperature=1 (flags optional; default all)
+func (d *Daemon) getUnifiedTelemetry(c *gin.Context) {
 	wantPower := c.Query("power") != "0"
 	wantCal := c.Query("calibration") != "0"
 
-	resp := gin.H{}
+	var resp api.Telemetry
 
 	if wantPower {
-		info, err := powerkit.GetSystemInfo(powerkit.FetchOptions{QueryIOKit: true, QuerySMC: false})
-		if err != nil || info == nil || info.IOKit == nil {
-			if err == nil {
-				err = errors.New("failed to fetch IOKit power data")
-			}
+		snapshot, err := d.readPowerTelemetry()
+		if err != nil {
 			logrus.WithError(err).Warn("power telemetry unavailable for unified telemetry")
 		} else {
-			var snapshot powerinfo.PowerTelemetry
-			snapshot.Adapter.InputVoltage = info.IOKit.Adapter.InputVoltage
-			snapshot.Adapter.InputAmperage = info.IOKit.Adapter.InputAmperage
-			snapshot.Battery.CycleCount = info.IOKit.Battery.CycleCount
-			snapshot.Calculations.ACPower = info.IOKit.Calculations.AdapterPower
-			snapshot.Calculations.BatteryPower = info.IOKit.Calculations.BatteryPower
-			snapshot.Calculations.SystemPower = info.IOKit.Calculations.SystemPower
-			snapshot.Calculations.HealthByMaxCapacity = info.IOKit.Calculations.HealthByMaxCapacity
-			resp["power"] = snapshot
+			resp.Power = snapshot
 		}
 	}
 
-	if wantCal && capabilities.Calibration {
-		resp["calibration"] = getCalibrationStatus()
+	if wantCal && d.capabilities.Calibration {
+		resp.Calibration = d.getCalibrationStatus()
+	}
+
+	if c.Query("temperature") != "0" {
+		t := d.getTemperatureStatus()
+		resp.Temperature = &t
 	}
 
 	// Add deprecation header if caller still hitting legacy endpoints (not detectable here), but we can add a generic hint.
@@ -613,7 +698,7 @@ func getUnifiedTelemetry(c *gin.Context) {
 }
 
 // SSE endpoint: streams daemon events (first: calibration phase changes)
-func getEventStream(c *gin.Context) {
+func (d *Daemon) getEventStream(c *gin.Context) {
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
@@ -624,8 +709,8 @@ func getEventStream(c *gin.Context) {
 		return
 	}
 
-	ch := sseHub.Subscribe()
-	defer sseHub.Unsubscribe(ch)
+	ch := d.hub.Subscribe()
+	defer d.hub.Unsubscribe(ch)
 
 	// Notify client that stream is open and suggest retry interval
 	if _, err := c.Writer.WriteString("retry: 10000\n"); err != nil {
@@ -669,72 +754,66 @@ func getEventStream(c *gin.Context) {
 
 // ===== Calibration Handlers =====
 
-func postStartCalibration(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) postStartCalibration(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
 	// Read threshold & hold from current config getters
-	threshold := conf.CalibrationDischargeThreshold()
-	hold := conf.CalibrationHoldDurationMinutes()
-	if err := startCalibration(threshold, hold); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	threshold := d.conf.CalibrationDischargeThreshold()
+	hold := d.conf.CalibrationHoldDurationMinutes()
+	if err := d.startCalibration(requestActor(c), threshold, hold); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusCreated, gin.H{"ok": true})
 }
 
-func postPauseCalibration(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) postPauseCalibration(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
-	if err := pauseCalibration(); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := d.pauseCalibration(requestActor(c)); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusOK, gin.H{"ok": true})
 }
 
-func postResumeCalibration(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) postResumeCalibration(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
-	if err := resumeCalibration(); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := d.resumeCalibration(requestActor(c)); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusOK, gin.H{"ok": true})
 }
 
-func postCancelCalibration(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) postCancelCalibration(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
-	if err := cancelCalibration(); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := d.cancelCalibration(requestActor(c)); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusOK, gin.H{"ok": true})
 }
 
-func setSchedule(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) setSchedule(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
 	var cronExpr string
-	if err := c.BindJSON(&cronExpr); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := c.ShouldBindJSON(&cronExpr); err != nil {
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	nextRuns, err := schedule(cronExpr)
+	nextRuns, err := d.schedule(requestActor(c), cronExpr)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		abortWithError(c, err)
 		return
 	}
 
@@ -746,27 +825,25 @@ func setSchedule(c *gin.Context) {
 	c.IndentedJSON(http.StatusCreated, resp)
 }
 
-func skipSchedule(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) skipSchedule(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
-	if err := skipNextSchedule(); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := d.skipNextSchedule(requestActor(c)); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, gin.H{"ok": true})
 }
 
-func postponeSchedule(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) postponeSchedule(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
 	var raw string
 	if err := c.ShouldBindJSON(&raw); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
@@ -775,94 +852,111 @@ func postponeSchedule(c *gin.Context) {
 		raw = "1h"
 	}
 
-	d, err := time.ParseDuration(raw)
+	duration, err := time.ParseDuration(raw)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	if err := postpone(d); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := d.postpone(requestActor(c), duration); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, gin.H{"ok": true})
 }
 
-func setCalibrationDischargeThreshold(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) setCalibrationDischargeThreshold(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
 	var threshold int
-	if err := c.BindJSON(&threshold); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
-	}
-
-	if threshold < 10 || threshold > 50 {
-		err := fmt.Errorf("calibration discharge threshold must be between 10 and 50, got %d", threshold)
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := c.ShouldBindJSON(&threshold); err != nil {
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	conf.SetCalibrationDischargeThreshold(threshold)
-	if err := conf.Save(); err != nil {
-		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+	msg, err := d.applyCalibrationSettings(requestActor(c), api.CalibrationSettings{DischargeThreshold: &threshold})
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	logrus.Infof("set calibration discharge threshold to %d", threshold)
-
-	// Check if calibration is running
-	st := getCalibrationStatus()
-	msg := fmt.Sprintf("Calibration discharge threshold set to %d%%", threshold)
-	if st.Phase != calibration.PhaseIdle && st.Phase != calibration.PhaseRestore && st.Phase != calibration.PhaseError {
-		msg += ". Note: A calibration is currently in progress. The new threshold will take effect on the next calibration."
-	}
-
 	c.IndentedJSON(http.StatusCreated, msg)
 }
 
-func setCalibrationHoldDurationMinutes(c *gin.Context) {
-	if !requireCapability(c, compatibility.FeatureCalibration) {
+func (d *Daemon) setCalibrationHoldDurationMinutes(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
 		return
 	}
 	var minutes int
-	if err := c.BindJSON(&minutes); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	if err := c.ShouldBindJSON(&minutes); err != nil {
+		abortWithError(c, invalidArgument(err))
 		return
 	}
 
-	if minutes < 10 || minutes > 24*60 {
-		err := fmt.Errorf("calibration hold duration must be between 10 and 1440 minutes (24 hours), got %d", minutes)
-		c.IndentedJSON(http.StatusBadRequest, err.Error())
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+	msg, err := d.applyCalibrationSettings(requestActor(c), api.CalibrationSettings{HoldDurationMinutes: &minutes})
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	conf.SetCalibrationHoldDurationMinutes(minutes)
-	if err := conf.Save(); err != nil {
+	c.IndentedJSON(http.StatusCreated, msg)
+}
+
+func (d *Daemon) getCalibrationSettings() api.CalibrationSettings {
+	threshold := d.conf.CalibrationDischargeThreshold()
+	minutes := d.conf.CalibrationHoldDurationMinutes()
+	return api.CalibrationSettings{DischargeThreshold: &threshold, HoldDurationMinutes: &minutes}
+}
+
+// applyCalibrationSettings changes the calibration settings that are set in
+// s. Nothing is changed unless all of them are valid.
+func (d *Daemon) applyCalibrationSettings(a audit.Actor, s api.CalibrationSettings) (string, error) {
+	cfg := d.auditing(d.conf, a)
+
+	if t := s.DischargeThreshold; t != nil && (*t < 10 || *t > 50) {
+		return "", api.Errorf(api.CodeInvalidArgument, "calibration discharge threshold must be between 10 and 50, got %d", *t).
+			WithDetail("min", 10).WithDetail("max", 50)
+	}
+	if m := s.HoldDurationMinutes; m != nil && (*m < 10 || *m > 24*60) {
+		return "", api.Errorf(api.CodeInvalidArgument, "calibration hold duration must be between 10 and 1440 minutes (24 hours), got %d", *m).
+			WithDetail("min", 10).WithDetail("max", 24*60)
+	}
+
+	if s.DischargeThreshold != nil {
+		cfg.SetCalibrationDischargeThreshold(*s.DischargeThreshold)
+	}
+	if s.HoldDurationMinutes != nil {
+		cfg.SetCalibrationHoldDurationMinutes(*s.HoldDurationMinutes)
+	}
+	if err := cfg.Save(); err != nil {
 		logrus.Errorf("saveConfig failed: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return "", err
 	}
 
-	logrus.Infof("set calibration hold duration to %d minutes", minutes)
+	var msgs []string
+	what := "settings"
+	if s.DischargeThreshold != nil {
+		logrus.Infof("set calibration discharge threshold to %d", *s.DischargeThreshold)
+		msgs = append(msgs, fmt.Sprintf("Calibration discharge threshold set to %d%%", *s.DischargeThreshold))
+		what = "threshold"
+	}
+	if s.HoldDurationMinutes != nil {
+		logrus.Infof("set calibration hold duration to %d minutes", *s.HoldDurationMinutes)
+		msgs = append(msgs, fmt.Sprintf("Calibration hold duration set to %d minutes", *s.HoldDurationMinutes))
+		what = "hold duration"
+	}
+	if len(msgs) > 1 {
+		what = "settings"
+	}
+	msg := strings.Join(msgs, ". ")
 
 	// Check if calibration is running
-	st := getCalibrationStatus()
-	msg := fmt.Sprintf("Calibration hold duration set to %d minutes", minutes)
+	st := d.getCalibrationStatus()
 	if st.Phase != calibration.PhaseIdle && st.Phase != calibration.PhaseRestore && st.Phase != calibration.PhaseError {
-		msg += ". Note: A calibration is currently in progress. The new hold duration will take effect on the next calibration."
+		msg += fmt.Sprintf(". Note: A calibration is currently in progress. The new %s will take effect on the next calibration.", what)
 	}
 
-	c.IndentedJSON(http.StatusCreated, msg)
+	return msg, nil
 }
@@ -1,14 +1,19 @@
 package daemon
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
 	"github.com/charlie0129/batt/pkg/calibration"
 	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/utils/ptr"
 )
 
 func TestResolveDisableLimit(t *testing.T) {
@@ -75,23 +80,16 @@ func TestResolveDisableLimit(t *testing.T) {
 }
 
 func TestSetDisableForRejectsCalibration(t *testing.T) {
-	previousConf, previousCapabilities := conf, capabilities
-	previousState, previousStatePath := calibrationState, calibrationStatePath
-	t.Cleanup(func() {
-		conf, capabilities = previousConf, previousCapabilities
-		calibrationState, calibrationStatePath = previousState, previousStatePath
-	})
-
 	configured := &mockConf{upper: 80, lower: 78}
-	conf = configured
-	capabilities = compatibility.Capabilities{ChargingControl: true}
-	calibrationState = &calibration.State{Phase: calibration.PhaseCharge}
-	calibrationStatePath = ""
+	d := newTestDaemon(t, nil, configured)
+	d.capabilities = compatibility.Capabilities{ChargingControl: true}
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseCharge}
+	d.calibrationStatePath = ""
 
 	request := httptest.NewRequest(http.MethodPut, "/disable", strings.NewReader(`"1h0m0s"`))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
-	setupRoutes().ServeHTTP(response, request)
+	d.router.ServeHTTP(response, request)
 
 	if response.Code != http.StatusBadRequest {
 		t.Fatalf("status = %d, want %d; body: %s", response.Code, http.StatusBadRequest, response.Body.String())
@@ -105,25 +103,18 @@ func TestSetDisableForRejectsCalibration(t *testing.T) {
 }
 
 func TestSetLimitRejectsCalibration(t *testing.T) {
-	previousConf, previousCapabilities := conf, capabilities
-	previousState, previousStatePath := calibrationState, calibrationStatePath
-	t.Cleanup(func() {
-		conf, capabilities = previousConf, previousCapabilities
-		calibrationState, calibrationStatePath = previousState, previousStatePath
-	})
-
 	for _, phase := range []calibration.Phase{calibration.PhaseCharge, calibration.PhaseError} {
 		t.Run(string(phase), func(t *testing.T) {
 			configured := &mockConf{upper: 80, lower: 78}
-			conf = configured
-			capabilities = compatibility.Capabilities{ChargingControl: true}
-			calibrationState = &calibration.State{Phase: phase}
-			calibrationStatePath = ""
+			d := newTestDaemon(t, nil, configured)
+			d.capabilities = compatibility.Capabilities{ChargingControl: true}
+			d.calibrationState = &calibration.State{Phase: phase}
+			d.calibrationStatePath = ""
 
 			request := httptest.NewRequest(http.MethodPut, "/limit", strings.NewReader("90"))
 			request.Header.Set("Content-Type", "application/json")
 			response := httptest.NewRecorder()
-			setupRoutes().ServeHTTP(response, request)
+			d.router.ServeHTTP(response, request)
 
 			if response.Code != http.StatusBadRequest {
 				t.Fatalf("status = %d, want %d; body: %s", response.Code, http.StatusBadRequest, response.Body.String())
@@ -138,35 +129,36 @@ func TestSetLimitRejectsCalibration(t *testing.T) {
 	}
 }
 
-func TestSetAdapterDisableFor(t *testing.T) {
-	previousConf, previousCapabilities := conf, capabilities
-	previousState := calibrationState
-	previousDisable := smcDisableAdapter
-	t.Cleanup(func() {
-		conf, capabilities = previousConf, previousCapabilities
-		calibrationState = previousState
-		smcDisableAdapter = previousDisable
-	})
+func TestSetLimitWithOverriddenDelta(t *testing.T) {
+	file := config.NewFileFromConfig(&config.RawFileConfig{Limit: ptr.To(80), LowerLimitDelta: ptr.To(60)}, "")
+	d := newTestDaemon(t, newFakeSMC(60, 1, true), config.NewLayered(file, &config.RawFileConfig{LowerLimitDelta: ptr.To(2)}, nil))
 
-	configured := &mockConf{upper: 80, lower: 78}
-	conf = configured
-	capabilities = compatibility.Capabilities{AdapterControl: true}
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	disabled := false
-	smcDisableAdapter = func() error {
-		disabled = true
-		return nil
+	// MQTT commands are not behind gin.Recovery, this must not panic.
+	_, err := d.applyLimit(audit.ActorMQTT, 50)
+	var e *api.Error
+	if !errors.As(err, &e) || e.Code != api.CodeInvalidArgument {
+		t.Fatalf("applyLimit(50) = %v, want an invalid argument error", err)
+	}
+	if d.conf.UpperLimit() != 80 {
+		t.Fatalf("upper limit = %d after rejection, want 80", d.conf.UpperLimit())
 	}
+}
+
+func TestSetAdapterDisableFor(t *testing.T) {
+	backend := newFakeSMC(60, 1, true)
+	configured := &mockConf{upper: 80, lower: 78}
+	d := newTestDaemon(t, backend, configured)
+	d.capabilities = compatibility.Capabilities{AdapterControl: true}
 
 	request := httptest.NewRequest(http.MethodPut, "/adapter/disable", strings.NewReader(`"1h0m0s"`))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
-	setupRoutes().ServeHTTP(response, request)
+	d.router.ServeHTTP(response, request)
 
 	if response.Code != http.StatusCreated {
 		t.Fatalf("status = %d, want %d; body: %s", response.Code, http.StatusCreated, response.Body.String())
 	}
-	if !disabled {
+	if backend.adapter {
 		t.Fatal("power adapter was not disabled")
 	}
 	remaining := time.Until(configured.adapterDisableUntil)
@@ -176,29 +168,18 @@ func TestSetAdapterDisableFor(t *testing.T) {
 }
 
 func TestSetAdapterClearsScheduledEnable(t *testing.T) {
-	previousConf, previousCapabilities := conf, capabilities
-	previousState := calibrationState
-	previousEnable := smcEnableAdapter
-	t.Cleanup(func() {
-		conf, capabilities = previousConf, previousCapabilities
-		calibrationState = previousState
-		smcEnableAdapter = previousEnable
-	})
-
 	configured := &mockConf{
 		upper:               80,
 		lower:               78,
 		adapterDisableUntil: time.Now().Add(time.Hour),
 	}
-	conf = configured
-	capabilities = compatibility.Capabilities{AdapterControl: true}
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	smcEnableAdapter = func() error { return nil }
+	d := newTestDaemon(t, newFakeSMC(60, 1, false), configured)
+	d.capabilities = compatibility.Capabilities{AdapterControl: true}
 
 	request := httptest.NewRequest(http.MethodPut, "/adapter", strings.NewReader("true"))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
-	setupRoutes().ServeHTTP(response, request)
+	d.router.ServeHTTP(response, request)
 
 	if response.Code != http.StatusCreated {
 		t.Fatalf("status = %d, want %d; body: %s", response.Code, http.StatusCreated, response.Body.String())
@@ -209,59 +190,39 @@ func TestSetAdapterClearsScheduledEnable(t *testing.T) {
 }
 
 func TestSetAdapterDisableForRejectsCalibration(t *testing.T) {
-	previousConf, previousCapabilities := conf, capabilities
-	previousState := calibrationState
-	previousDisable := smcDisableAdapter
-	t.Cleanup(func() {
-		conf, capabilities = previousConf, previousCapabilities
-		calibrationState = previousState
-		smcDisableAdapter = previousDisable
-	})
-
+	backend := newFakeSMC(60, 1, true)
 	configured := &mockConf{upper: 80, lower: 78}
-	conf = configured
-	capabilities = compatibility.Capabilities{AdapterControl: true}
-	calibrationState = &calibration.State{Phase: calibration.PhaseDischarge}
-	called := false
-	smcDisableAdapter = func() error {
-		called = true
-		return nil
-	}
+	d := newTestDaemon(t, backend, configured)
+	d.capabilities = compatibility.Capabilities{AdapterControl: true}
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseDischarge}
 
 	request := httptest.NewRequest(http.MethodPut, "/adapter/disable", strings.NewReader(`"1h0m0s"`))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
-	setupRoutes().ServeHTTP(response, request)
+	d.router.ServeHTTP(response, request)
 
 	if response.Code != http.StatusBadRequest {
 		t.Fatalf("status = %d, want %d; body: %s", response.Code, http.StatusBadRequest, response.Body.String())
 	}
-	if called || !configured.adapterDisableUntil.IsZero() {
+	if !backend.adapter || !configured.adapterDisableUntil.IsZero() {
 		t.Fatal("rejected temporary adapter disable changed state")
 	}
 }
 
 func TestStartCalibrationRequestRejectsTemporaryDisable(t *testing.T) {
-	previousConf, previousCapabilities := conf, capabilities
-	previousState, previousStatePath := calibrationState, calibrationStatePath
-	t.Cleanup(func() {
-		conf, capabilities = previousConf, previousCapabilities
-		calibrationState, calibrationStatePath = previousState, previousStatePath
-	})
-
-	conf = &mockConf{
+	d := newTestDaemon(t, nil, &mockConf{
 		upper:           100,
 		lower:           78,
 		disableUntil:    time.Now().Add(time.Hour),
 		preDisableLimit: 80,
-	}
-	capabilities = compatibility.Capabilities{Calibration: true}
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	calibrationStatePath = ""
+	})
+	d.capabilities = compatibility.Capabilities{Calibration: true}
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
+	d.calibrationStatePath = ""
 
 	request := httptest.NewRequest(http.MethodPost, "/calibration/start", nil)
 	response := httptest.NewRecorder()
-	setupRoutes().ServeHTTP(response, request)
+	d.router.ServeHTTP(response, request)
 
 	if response.Code != http.StatusBadRequest {
 		t.Fatalf("status = %d, want %d; body: %s", response.Code, http.StatusBadRequest, response.Body.String())
@@ -269,7 +230,7 @@ func TestStartCalibrationRequestRejectsTemporaryDisable(t *testing.T) {
 	if !strings.Contains(response.Body.String(), ErrTemporaryDisableInProgress.Error()) {
 		t.Fatalf("response does not explain conflict: %s", response.Body.String())
 	}
-	if calibrationState.Phase != calibration.PhaseIdle {
-		t.Fatalf("phase = %s, want idle", calibrationState.Phase)
+	if d.calibrationState.Phase != calibration.PhaseIdle {
+		t.Fatalf("phase = %s, want idle", d.calibrationState.Phase)
 	}
 }
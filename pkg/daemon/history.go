@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/history"
+)
+
+const defaultHistoryRange = 24 * time.Hour
+
+func (d *Daemon) initHistoryStore(path string) {
+	s, err := history.Open(path, history.DefaultOptions)
+	if err != nil {
+		logrus.WithError(err).Error("failed to open battery history, history will not be recorded")
+		return
+	}
+	d.historyStore = s
+}
+
+// recordHistory appends the current battery state to the history store.
+func (d *Daemon) recordHistory(now time.Time) {
+	if d.historyStore == nil {
+		return
+	}
+
+	charge, err := d.backend.GetBatteryCharge()
+	if err != nil {
+		logrus.WithError(err).Debug("skipping history sample, battery charge unavailable")
+		return
+	}
+	pluggedIn, err := d.backend.IsPluggedIn()
+	if err != nil {
+		logrus.WithError(err).Debug("skipping history sample, plug state unavailable")
+		return
+	}
+
+	sample := history.Sample{
+		Ts:             now.Unix(),
+		Charge:         charge,
+		PluggedIn:      pluggedIn,
+		AdapterEnabled: true,
+	}
+
+	if charging, err := d.readCharging(); err == nil {
+		sample.Charging = charging
+	}
+	if d.capabilities.AdapterControl {
+		if enabled, err := d.backend.IsAdapterEnabled(); err == nil {
+			sample.AdapterEnabled = enabled
+		}
+	}
+	if telemetry, err := d.readPowerTelemetry(); err == nil {
+		sample.SystemPower = telemetry.Calculations.SystemPower
+		sample.BatteryPower = telemetry.Calculations.BatteryPower
+	}
+
+	if err := d.historyStore.Append(sample); err != nil {
+		logrus.WithError(err).Error("failed to record battery history")
+	}
+}
+
+// parseHistoryTime accepts RFC 3339 timestamps and unix seconds.
+func parseHistoryTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 or unix seconds", s)
+	}
+	return t, nil
+}
+
+// getHistory serves /history?from=&to=&step=. The range defaults to the last
+// 24 hours and step to raw samples.
+func (d *Daemon) getHistory(c *gin.Context) {
+	if d.historyStore == nil {
+		abortWithError(c, api.Errorf(api.CodeUnavailable, "battery history is not available"))
+		return
+	}
+
+	to := d.clock.Now()
+	if v := c.Query("to"); v != "" {
+		t, err := parseHistoryTime(v)
+		if err != nil {
+			abortWithError(c, invalidArgument(err))
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultHistoryRange)
+	if v := c.Query("from"); v != "" {
+		t, err := parseHistoryTime(v)
+		if err != nil {
+			abortWithError(c, invalidArgument(err))
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		err := fmt.Errorf("from (%s) must not be after to (%s)", from.Format(time.RFC3339), to.Format(time.RFC3339))
+		abortWithError(c, invalidArgument(err))
+		return
+	}
+
+	var step time.Duration
+	if v := c.Query("step"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			err = fmt.Errorf("invalid step %q: expected a non-negative duration such as 10m", v)
+			abortWithError(c, invalidArgument(err))
+			return
+		}
+		step = parsed
+	}
+
+	samples, err := d.historyStore.Query(from, to, step)
+	if err != nil {
+		logrus.Errorf("getHistory failed: %v", err)
+		abortWithError(c, err)
+		return
+	}
+	if samples == nil {
+		samples = []history.Sample{}
+	}
+
+	c.IndentedJSON(http.StatusOK, samples)
+}
@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/history"
+)
+
+func TestRecordAndServeHistory(t *testing.T) {
+	backend, _ := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "72",
+		"BAT0/status":                       "Discharging",
+		"BAT0/power_now":                    "9500000",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "0",
+	})
+	store, err := history.Open(filepath.Join(t.TempDir(), "batt.history.jsonl"), history.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDaemon(t, backend, &mockConf{upper: 80, lower: 78})
+	d.historyStore = store
+
+	now := time.Now().Truncate(time.Minute)
+	d.recordHistory(now.Add(-2 * time.Hour))
+	d.recordHistory(now.Add(-10 * time.Second))
+	d.recordHistory(now)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/history?step=1m", nil)
+	d.router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d; body=%s", recorder.Code, recorder.Body.String())
+	}
+	var samples []history.Sample
+	if err := json.Unmarshal(recorder.Body.Bytes(), &samples); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("len = %d, want 3: %+v", len(samples), samples)
+	}
+	got := samples[2]
+	if got.Charge != 72 || got.PluggedIn || got.Charging || !got.AdapterEnabled || got.BatteryPower != -9.5 || got.SystemPower != 9.5 {
+		t.Fatalf("unexpected sample: %+v", got)
+	}
+
+	from := now.Add(-time.Hour).Format(time.RFC3339)
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "/history?from="+from, nil)
+	d.router.ServeHTTP(recorder, request)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &samples); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("len = %d, want 2 raw samples in the last hour", len(samples))
+	}
+
+	for _, query := range []string{"from=yesterday", "step=-1m", "from=200&to=100"} {
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest(http.MethodGet, "/history?"+query, nil)
+		d.router.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", query, recorder.Code)
+		}
+	}
+}
@@ -1,3 +1,5 @@
+//go:build darwin
+
 // https://developer.apple.com/library/archive/qa/qa1340/_index.html
 
 #include <ctype.h>
@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/events"
+)
+
+// maxHookOutput is how much of a hook's output is logged and reported.
+const maxHookOutput = 1024
+
+// hookInput is what a hook receives on stdin.
+type hookInput struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// startHooks runs the configured hooks for every event the daemon publishes
+// until ctx is done.
+func (d *Daemon) startHooks(ctx context.Context) {
+	ch := d.hub.Subscribe()
+	go func() {
+		for ev := range ch {
+			d.dispatchHooks(ev)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		d.hub.Unsubscribe(ch)
+	}()
+}
+
+// dispatchHooks starts the hooks configured for ev in the background. When
+// all hook slots are busy, the hook is skipped for this event, so a burst of
+// events cannot queue up hooks without bound.
+func (d *Daemon) dispatchHooks(ev events.Event) {
+	for _, command := range d.conf.Hooks()[ev.Name] {
+		if strings.TrimSpace(command) == "" {
+			continue
+		}
+		select {
+		case d.hookSlots <- struct{}{}:
+		default:
+			logrus.WithFields(logrus.Fields{"event": ev.Name, "hook": command}).Warn("skipping hook, too many hooks are running")
+			continue
+		}
+		go func() {
+			defer func() { <-d.hookSlots }()
+			d.runHook(ev, command)
+		}()
+	}
+}
+
+// runHook runs command with sh, passing the event as JSON on stdin and in
+// environment variables:
+//
+//	BATT_EVENT       the event name
+//	BATT_EVENT_DATA  the event payload as JSON
+//	BATT_EVENT_<KEY> each top-level payload field, e.g. BATT_EVENT_CHARGE
+func (d *Daemon) runHook(ev events.Event, command string) {
+	log := logrus.WithFields(logrus.Fields{"event": ev.Name, "hook": command})
+
+	input, err := json.Marshal(hookInput{Event: ev.Name, Data: ev.Data})
+	if err != nil {
+		log.WithError(err).Error("failed to marshal hook input")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.hookTimeout)
+	defer cancel()
+
+	output := &cappedBuffer{max: maxHookOutput}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = append(os.Environ(), hookEnv(ev)...)
+	// Kill the whole process group on timeout, not just the shell.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	out := output.buf.String()
+	if output.truncated {
+		out += "..."
+	}
+	out = strings.TrimSpace(out)
+
+	if err == nil {
+		log.WithFields(logrus.Fields{"duration": elapsed, "output": out}).Info("hook succeeded")
+		return
+	}
+
+	exitCode := -1
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = errors.New("timed out after " + d.hookTimeout.String())
+	case errors.As(err, &exitErr):
+		exitCode = exitErr.ExitCode()
+	}
+	log.WithError(err).WithFields(logrus.Fields{"duration": elapsed, "exitCode": exitCode, "output": out}).Error("hook failed")
+
+	// A failing hook.failed hook must not trigger itself.
+	if ev.Name == events.HookFailed {
+		return
+	}
+	d.hub.Publish(events.HookFailed, events.HookFailedEvent{
+		Event:    ev.Name,
+		Command:  command,
+		Error:    err.Error(),
+		ExitCode: exitCode,
+		Output:   out,
+		Ts:       d.clock.Now().Unix(),
+	})
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest,
+// so a chatty hook does not grow the daemon's memory.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.buf.Len(); n > room {
+		p, b.truncated = p[:max(room, 0)], true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+// hookEnv returns the environment variables describing ev.
+func hookEnv(ev events.Event) []string {
+	env := []string{
+		"BATT_EVENT=" + ev.Name,
+		"BATT_EVENT_DATA=" + string(ev.Data),
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(ev.Data, &fields); err != nil {
+		return env
+	}
+	for _, k := range slices.Sorted(maps.Keys(fields)) {
+		var v string
+		switch value := fields[k].(type) {
+		case string:
+			v = value
+		case float64, bool:
+			b, _ := json.Marshal(value)
+			v = string(b)
+		default:
+			continue
+		}
+		env = append(env, "BATT_EVENT_"+envName(k)+"="+v)
+	}
+	return env
+}
+
+// envName converts a camelCase JSON key to UPPER_SNAKE_CASE.
+func envName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if r >= 'A' && r <= 'Z' && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
@@ -0,0 +1,224 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/events"
+)
+
+func subscribe(t *testing.T, d *Daemon) chan events.Event {
+	t.Helper()
+	ch := d.hub.Subscribe()
+	t.Cleanup(func() { d.hub.Unsubscribe(ch) })
+	return ch
+}
+
+func limitReachedEvent(t *testing.T) events.Event {
+	t.Helper()
+	data, err := json.Marshal(events.ChargeLimitReachedEvent{Charge: 80, Limit: 80, Ts: 1700000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.Event{Name: events.ChargeLimitReached, Data: data}
+}
+
+func TestRunHookPassesEvent(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 75})
+	ch := subscribe(t, d)
+	dir := t.TempDir()
+	stdin, env := filepath.Join(dir, "stdin"), filepath.Join(dir, "env")
+
+	d.runHook(limitReachedEvent(t), "cat > "+stdin+"; env > "+env)
+
+	b, err := os.ReadFile(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var input struct {
+		Event string                         `json:"event"`
+		Data  events.ChargeLimitReachedEvent `json:"data"`
+	}
+	if err := json.Unmarshal(b, &input); err != nil {
+		t.Fatalf("stdin is not JSON: %v: %s", err, b)
+	}
+	if input.Event != events.ChargeLimitReached || input.Data.Charge != 80 || input.Data.Limit != 80 {
+		t.Fatalf("unexpected stdin: %s", b)
+	}
+
+	b, err = os.ReadFile(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := strings.Split(string(b), "\n")
+	for _, want := range []string{
+		"BATT_EVENT=charging.limit_reached",
+		`BATT_EVENT_DATA={"charge":80,"limit":80,"ts":1700000000}`,
+		"BATT_EVENT_CHARGE=80",
+		"BATT_EVENT_LIMIT=80",
+		"BATT_EVENT_TS=1700000000",
+	} {
+		if !slices.Contains(vars, want) {
+			t.Errorf("environment is missing %s", want)
+		}
+	}
+
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected event %s for a successful hook", ev.Name)
+	default:
+	}
+}
+
+func TestRunHookReportsFailure(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 75})
+	ch := subscribe(t, d)
+
+	d.runHook(limitReachedEvent(t), "echo oops; exit 3")
+
+	select {
+	case ev := <-ch:
+		payload, err := events.DecodeAs[events.HookFailedEvent](ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Name != events.HookFailed || payload.Event != events.ChargeLimitReached || payload.ExitCode != 3 || payload.Output != "oops" {
+			t.Fatalf("unexpected event %s: %+v", ev.Name, payload)
+		}
+	default:
+		t.Fatal("no hook.failed event published")
+	}
+
+	// Failures of hook.failed hooks are only logged.
+	d.runHook(events.Event{Name: events.HookFailed, Data: []byte("{}")}, "exit 1")
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected event %s", ev.Name)
+	default:
+	}
+}
+
+func TestRunHookTimesOut(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 75})
+	ch := subscribe(t, d)
+	d.hookTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	d.runHook(limitReachedEvent(t), "sleep 5")
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("hook was not killed after the timeout, ran for %s", elapsed)
+	}
+
+	select {
+	case ev := <-ch:
+		payload, err := events.DecodeAs[events.HookFailedEvent](ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload.ExitCode != -1 || !strings.Contains(payload.Error, "timed out") {
+			t.Fatalf("unexpected payload %+v", payload)
+		}
+	default:
+		t.Fatal("no hook.failed event published")
+	}
+}
+
+func TestRunHookCapsOutput(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 75})
+	ch := subscribe(t, d)
+	d.hookTimeout = 200 * time.Millisecond
+
+	d.runHook(limitReachedEvent(t), "yes")
+
+	select {
+	case ev := <-ch:
+		payload, err := events.DecodeAs[events.HookFailedEvent](ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payload.Output) > maxHookOutput+len("...") || !strings.HasSuffix(payload.Output, "...") {
+			t.Fatalf("output of %d bytes, want it cut at %d", len(payload.Output), maxHookOutput)
+		}
+	default:
+		t.Fatal("no hook.failed event published")
+	}
+}
+
+func TestDispatchHooksSkipsWhenBusy(t *testing.T) {
+	ran := filepath.Join(t.TempDir(), "ran")
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 75, hooks: map[string][]string{
+		events.ChargeLimitReached: {"touch " + ran},
+	}})
+	for range cap(d.hookSlots) {
+		d.hookSlots <- struct{}{}
+	}
+
+	d.dispatchHooks(limitReachedEvent(t))
+	// A queued hook would take the slot freed here.
+	<-d.hookSlots
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(ran); !os.IsNotExist(err) {
+		t.Fatalf("hook ran although all slots were busy: %v", err)
+	}
+	if n := len(d.hookSlots); n != cap(d.hookSlots)-1 {
+		t.Fatalf("%d slots taken, want %d", n, cap(d.hookSlots)-1)
+	}
+}
+
+func TestPublishPowerEvents(t *testing.T) {
+	backend, root := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "79",
+		"BAT0/status":                       "Charging",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+	d := newTestDaemon(t, backend, &mockConf{upper: 80, lower: 75})
+	ch := subscribe(t, d)
+
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := func() string {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			return ev.Name
+		default:
+			return ""
+		}
+	}
+
+	now := time.Now()
+	d.publishPowerEvents(now)
+	if name := next(); name != "" {
+		t.Fatalf("unexpected event %s on the first loop", name)
+	}
+
+	write("BAT0/capacity", "80")
+	write("BAT0/status", "Not charging")
+	d.publishPowerEvents(now)
+	if name := next(); name != events.ChargeLimitReached {
+		t.Fatalf("got event %q, want %s", name, events.ChargeLimitReached)
+	}
+
+	write("AC/online", "0")
+	write("BAT0/status", "Discharging")
+	d.publishPowerEvents(now)
+	if name := next(); name != events.PowerUnplugged {
+		t.Fatalf("got event %q, want %s", name, events.PowerUnplugged)
+	}
+
+	write("AC/online", "1")
+	d.publishPowerEvents(now)
+	if name := next(); name != events.PowerPlugged {
+		t.Fatalf("got event %q, want %s", name, events.PowerPlugged)
+	}
+}
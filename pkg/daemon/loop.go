@@ -1,50 +1,58 @@
 package daemon
 
 import (
+	"context"
 	"reflect"
-	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
 	"github.com/charlie0129/batt/pkg/calibration"
 	"github.com/charlie0129/batt/pkg/compatibility"
 	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
 	"github.com/charlie0129/batt/pkg/smc"
 )
 
-var (
-	maintainedChargingInProgress = false
-	maintainLoopInnerLock        = &sync.Mutex{}
-	// wg is used to skip several loops when system woke up or before sleep
-	wg                      = &sync.WaitGroup{}
+const (
 	loopInterval            = time.Duration(10) * time.Second
-	loopRecorder            = NewTimeSeriesRecorder(60)
 	continuousLoopThreshold = 1*time.Minute + 20*time.Second // add 20s to be sure
 )
 
-// infiniteLoop runs forever and maintains the battery charge,
-// which is called by the daemon.
-func infiniteLoop() {
+// infiniteLoop maintains the battery charge until ctx is done.
+func (d *Daemon) infiniteLoop(ctx context.Context) {
+	timer := d.clock.NewTimer(0)
+	defer timer.Stop()
 	for {
-		now := time.Now()
-		if restoreDisabledLimit(conf, now) {
-			maintainLoopForced()
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
 		}
-		if capabilities.AdapterControl {
-			maintainAdapterDisable(conf, now)
+		now := d.clock.Now()
+		d.mutationMu.Lock()
+		if d.restoreDisabledLimit(now) {
+			d.maintainLoopForced()
 		}
-		maintainLoop()
-		time.Sleep(loopInterval)
+		if d.capabilities.AdapterControl {
+			d.maintainAdapterDisable(now)
+		}
+		d.mutationMu.Unlock()
+		d.maintainLoop()
+		d.recordHistory(d.clock.Now())
+		timer.Reset(loopInterval)
 	}
 }
 
 // maintainAdapterDisable keeps a temporary adapter disable active across
 // daemon restarts and enables the adapter once its deadline has passed. It
 // reports whether an expired schedule was completed.
-func maintainAdapterDisable(conf config.Config, now time.Time) bool {
-	chargeControlTransitionMu.Lock()
-	defer chargeControlTransitionMu.Unlock()
+func (d *Daemon) maintainAdapterDisable(now time.Time) bool {
+	conf := d.auditing(d.conf, audit.ActorTimer)
+	d.chargeControlTransitionMu.Lock()
+	defer d.chargeControlTransitionMu.Unlock()
 
 	until := conf.AdapterDisableUntil()
 	if until.IsZero() {
@@ -52,11 +60,11 @@ func maintainAdapterDisable(conf config.Config, now time.Time) bool {
 	}
 	// Calibration owns the adapter throughout its workflow and restores its
 	// snapshot on completion or cancellation. Keep the timer pending until then.
-	if calibrationOwnsChargeLimit() {
+	if d.calibrationOwnsChargeLimit() {
 		return false
 	}
 
-	enabled, err := smcIsAdapterEnabled()
+	enabled, err := d.backend.IsAdapterEnabled()
 	if err != nil {
 		logrus.WithError(err).Error("failed to check power adapter for temporary disable")
 		return false
@@ -64,7 +72,7 @@ func maintainAdapterDisable(conf config.Config, now time.Time) bool {
 
 	if now.Before(until) {
 		if enabled {
-			if err := smcDisableAdapter(); err != nil {
+			if err := d.backend.DisableAdapter(); err != nil {
 				logrus.WithError(err).Error("failed to maintain temporary power adapter disable")
 			}
 		}
@@ -72,24 +80,27 @@ func maintainAdapterDisable(conf config.Config, now time.Time) bool {
 	}
 
 	if !enabled {
-		if err := smcEnableAdapter(); err != nil {
+		if err := d.backend.EnableAdapter(); err != nil {
 			logrus.WithError(err).Error("failed to enable power adapter after temporary disable")
 			return false
 		}
+		d.recordAudit(audit.ActorTimer, "adapter", false, true)
 	}
 	conf.ClearAdapterDisableTimer()
 	if err := conf.Save(); err != nil {
 		logrus.Errorf("saveConfig failed: %v", err)
 	}
 	logrus.Info("adapter disable duration elapsed, power adapter enabled")
+	d.hub.Publish(events.DisableExpired, events.DisableExpiredEvent{Target: "adapter", Ts: now.Unix()})
 	return true
 }
 
 // restoreDisabledLimit restores the upper limit saved by a "batt disable --for"
 // once its deadline has passed. It reports whether the limit was restored.
-func restoreDisabledLimit(conf config.Config, now time.Time) bool {
-	chargeControlTransitionMu.Lock()
-	defer chargeControlTransitionMu.Unlock()
+func (d *Daemon) restoreDisabledLimit(now time.Time) bool {
+	conf := d.auditing(d.conf, audit.ActorTimer)
+	d.chargeControlTransitionMu.Lock()
+	defer d.chargeControlTransitionMu.Unlock()
 
 	until := conf.DisableUntil()
 	if until.IsZero() || now.Before(until) {
@@ -100,7 +111,7 @@ func restoreDisabledLimit(conf config.Config, now time.Time) bool {
 	// which would silently undo the restore. This also resolves persisted
 	// conflicts after a daemon restart: calibration keeps ownership and the
 	// timer remains pending until calibration finishes or is cancelled.
-	if calibrationOwnsChargeLimit() {
+	if d.calibrationOwnsChargeLimit() {
 		return false
 	}
 
@@ -115,12 +126,19 @@ func restoreDisabledLimit(conf config.Config, now time.Time) bool {
 		return false
 	}
 
-	conf.SetUpperLimit(limit)
+	if err := conf.SetUpperLimit(limit); err != nil {
+		logrus.Warnf("failed to restore charge limit %d%%: %v", limit, err)
+		if err := conf.Save(); err != nil {
+			logrus.Errorf("saveConfig failed: %v", err)
+		}
+		return false
+	}
 	if err := conf.Save(); err != nil {
 		logrus.Errorf("saveConfig failed: %v", err)
 	}
 
 	logrus.WithField("limit", limit).Infof("disable duration elapsed, charge limit restored")
+	d.hub.Publish(events.DisableExpired, events.DisableExpiredEvent{Target: "limit", Limit: limit, Ts: now.Unix()})
 
 	return true
 }
@@ -129,11 +147,11 @@ func restoreDisabledLimit(conf config.Config, now time.Time) bool {
 // which could indicate that the system was in sleep mode or there is some issue
 // with the maintain loop execution.
 // It returns true if there are too many missed loops.
-func checkMissedMaintainLoops(logStatus bool) bool {
-	maintainLoopCount := loopRecorder.GetRecordsIn(continuousLoopThreshold)
+func (d *Daemon) checkMissedMaintainLoops(logStatus bool) bool {
+	maintainLoopCount := d.loopRecorder.GetRecordsIn(continuousLoopThreshold)
 	expectedMaintainLoopCount := int(continuousLoopThreshold / loopInterval)
 	minMaintainLoopCount := expectedMaintainLoopCount - 1
-	relativeTimes := loopRecorder.GetLastRecords(continuousLoopThreshold)
+	relativeTimes := d.loopRecorder.GetLastRecords(continuousLoopThreshold)
 
 	if maintainLoopCount < minMaintainLoopCount {
 		if logStatus {
@@ -141,7 +159,7 @@ func checkMissedMaintainLoops(logStatus bool) bool {
 				"maintainLoopCount":         maintainLoopCount,
 				"expectedMaintainLoopCount": expectedMaintainLoopCount,
 				"minMaintainLoopCount":      minMaintainLoopCount,
-				"recentRecords":             formatRelativeTimes(relativeTimes),
+				"recentRecords":             formatRelativeTimes(d.clock.Now(), relativeTimes),
 			}).Infof("Possibly missed maintain loop")
 		}
 
@@ -153,13 +171,13 @@ func checkMissedMaintainLoops(logStatus bool) bool {
 	// once when the maintain loop is stabilized, instead of printing
 	// every time when maintainLoopCount == minMaintainLoopCount (always
 	// this case if using maintainLoopCount), which could be very spammy.
-	if loopRecorder.GetRecordsIn(continuousLoopThreshold+loopInterval) == minMaintainLoopCount {
+	if d.loopRecorder.GetRecordsIn(continuousLoopThreshold+loopInterval) == minMaintainLoopCount {
 		if logStatus {
 			logrus.WithFields(logrus.Fields{
 				"maintainLoopCount":         maintainLoopCount,
 				"expectedMaintainLoopCount": expectedMaintainLoopCount,
 				"minMaintainLoopCount":      minMaintainLoopCount,
-				"recentRecords":             formatRelativeTimes(relativeTimes),
+				"recentRecords":             formatRelativeTimes(d.clock.Now(), relativeTimes),
 			}).Infof("Maintain loop has been stabilized")
 		}
 	}
@@ -170,64 +188,75 @@ func checkMissedMaintainLoops(logStatus bool) bool {
 // maintainLoop maintains the battery charge. It has the logic to
 // prevent parallel runs. So if one maintain loop is already running,
 // the next one will need to wait until the first one finishes.
-func maintainLoop() bool {
-	if capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
-		return maintainLoopForced()
+func (d *Daemon) maintainLoop() bool {
+	if d.capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
+		return d.maintainLoopSerialized(true)
 	}
 
-	defer loopRecorder.AddRecordNow()
+	defer d.loopRecorder.AddRecordNow()
 
-	if conf.PreventSystemSleep() {
+	if d.conf.PreventSystemSleep() {
 		// No need to keep track missed loops and wait post/before sleep delays, since
 		// prevent-system-sleep would prevent unexpected sleep during charging.
-		return maintainLoopInner(true)
+		return d.maintainLoopSerialized(true)
 	}
 
 	// See wg.Add() in sleepcallback.go for why we need to wait.
-	tsBeforeWait := time.Now()
-	wg.Wait()
-	tsAfterWait := time.Now()
+	tsBeforeWait := d.clock.Now()
+	d.wg.Wait()
+	tsAfterWait := d.clock.Now()
 	if tsAfterWait.Sub(tsBeforeWait) > time.Second*1 {
 		logrus.Debugf("this maintain loop waited %d seconds after being initiated, now ready to execute", int(tsAfterWait.Sub(tsBeforeWait).Seconds()))
 	}
 
-	// just log status, not doing anything, yet
-	_ = checkMissedMaintainLoops(true)
+	// just log status and count it, not doing anything, yet
+	if d.checkMissedMaintainLoops(true) {
+		d.maintainLoopMisses.Add(1)
+	}
 
 	// Missed-loop protection is the fallback for sleep transitions where macOS
 	// did not deliver a sleep notification. Disabling charging in that case is
 	// part of DisableChargingPreSleep's behavior, so honor the user's choice to
 	// let charging continue while the system is asleep.
-	return maintainLoopInner(!conf.DisableChargingPreSleep())
+	return d.maintainLoopSerialized(!d.conf.DisableChargingPreSleep())
 }
 
 // maintainLoopForced maintains the battery charge. It runs without waiting
 // for post/pre sleep delays, but yet has logic to prevent parallel runs.
 // It is mainly called by the HTTP APIs.
-func maintainLoopForced() bool {
-	return maintainLoopInner(true)
+func (d *Daemon) maintainLoopForced() bool {
+	return d.maintainLoopInner(true)
+}
+
+// maintainLoopSerialized is maintainLoopInner for callers that do not serve
+// an API request, which holds d.mutationMu already. The loop changes the
+// config when calibration moves on.
+func (d *Daemon) maintainLoopSerialized(ignoreMissedLoops bool) bool {
+	d.mutationMu.Lock()
+	defer d.mutationMu.Unlock()
+	return d.maintainLoopInner(ignoreMissedLoops)
 }
 
-func handleNoMaintain(isChargingEnabled bool) bool {
+func (d *Daemon) handleNoMaintain(isChargingEnabled bool) bool {
 	if !isChargingEnabled {
 		logrus.Debug("limit set to 100%, but charging is disabled, enabling")
-		err := smcConn.EnableCharging()
+		err := d.backend.EnableCharging()
 		if err != nil {
 			logrus.Errorf("EnableCharging failed: %v", err)
 			return false
 		}
 
-		if smcConn.CheckMagSafeExistence() {
-			switch conf.ControlMagSafeLED() {
+		if d.backend.CheckMagSafeExistence() {
+			switch d.conf.ControlMagSafeLED() {
 			case config.ControlMagSafeModeAlwaysOff:
-				err := smcConn.DisableMagSafeLed()
+				err := d.backend.DisableMagSafeLed()
 				if err != nil {
 					// no fail
 					logrus.Errorf("DisableMagSafeLed failed: %v", err)
 				}
 			default:
 				// Reset MagSafe LED to system state.
-				err = smcConn.SetMagSafeLedState(smc.LEDSystem)
+				err = d.backend.SetMagSafeLedState(smc.LEDSystem)
 				if err != nil {
 					// no fail
 					logrus.Errorf("SetMagSafeLedState(LEDSystem) failed: %v", err)
@@ -236,17 +265,17 @@ func handleNoMaintain(isChargingEnabled bool) bool {
 		}
 	}
 
-	if smcConn.CheckMagSafeExistence() {
+	if d.backend.CheckMagSafeExistence() {
 		// Set MagSafe LED according to config.
-		currentMagSafeLEDState, err := smcConn.GetMagSafeLedState()
+		currentMagSafeLEDState, err := d.backend.GetMagSafeLedState()
 		if err != nil {
 			// no fail
 			logrus.Errorf("GetMagSafeLedState failed: %v", err)
 		}
-		switch conf.ControlMagSafeLED() {
+		switch d.conf.ControlMagSafeLED() {
 		case config.ControlMagSafeModeAlwaysOff:
 			if currentMagSafeLEDState != smc.LEDOff {
-				err := smcConn.DisableMagSafeLed()
+				err := d.backend.DisableMagSafeLed()
 				if err != nil {
 					// no fail
 					logrus.Errorf("DisableMagSafeLed failed: %v", err)
@@ -258,7 +287,7 @@ func handleNoMaintain(isChargingEnabled bool) bool {
 			// in Enabled mode we want to show the system state (which is the same as
 			// apple's default behavior when limit=100%).
 			if currentMagSafeLEDState != smc.LEDSystem {
-				err := smcConn.SetMagSafeLedState(smc.LEDSystem)
+				err := d.backend.SetMagSafeLedState(smc.LEDSystem)
 				if err != nil {
 					// no fail
 					logrus.Errorf("SetMagSafeLedState(LEDSystem) failed: %v", err)
@@ -273,24 +302,24 @@ func handleNoMaintain(isChargingEnabled bool) bool {
 		logrus.Errorf("AllowSleepOnAC failed: %v", err)
 	}
 
-	cancelCalibrationForPermanentDisable()
+	d.cancelCalibrationForPermanentDisable()
 
-	maintainedChargingInProgress = false
+	d.maintainedChargingInProgress = false
 	return true
 }
 
-func cancelCalibrationForPermanentDisable() {
+func (d *Daemon) cancelCalibrationForPermanentDisable() {
 	// A persisted temporary-disable/calibration conflict can be loaded after a
 	// restart. Calibration is restored paused for safety, so keep it intact and
 	// let the disable timer wait for the user to resume or cancel calibration.
-	if !conf.DisableUntil().IsZero() {
+	if !d.conf.DisableUntil().IsZero() {
 		return
 	}
-	cancelCalibrationNoRestoreNoError()
+	d.cancelCalibrationNoRestoreNoError()
 }
 
-func handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn bool, batteryCharge, lower, upper int) bool {
-	maintainLoopsMissed := !ignoreMissedLoops && checkMissedMaintainLoops(false)
+func (d *Daemon) handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn bool, batteryCharge, lower, upper int) bool {
+	maintainLoopsMissed := !ignoreMissedLoops && d.checkMissedMaintainLoops(false)
 
 	// Fix for #123.
 	// Consider this case:
@@ -307,13 +336,14 @@ func handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn bool,
 			"lower":         lower,
 			"upper":         upper,
 		}).Infof("Too many missed maintain loops detected while charging is enabled. Disabling charging to prevent overcharging.")
-		err := smcConn.DisableCharging()
+		d.decide(api.RuleMissedLoops, "%s, so charging is disabled to prevent overcharging (#123)", d.missedLoopsSummary())
+		err := d.backend.DisableCharging()
 		if err != nil {
 			logrus.Errorf("DisableCharging failed: %v", err)
 			return false
 		}
 		isChargingEnabled = false
-		maintainedChargingInProgress = false
+		d.maintainedChargingInProgress = false
 	}
 
 	// Should enable charging.
@@ -330,6 +360,8 @@ func handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn bool,
 				"lower":         lower,
 				"upper":         upper,
 			}).Infof("Battery charge is below lower limit, but too many missed maintain loops are missed. Will wait until maintain loops are stable")
+			d.decide(api.RuleMissedLoops, "the charge %d%% is below the lower limit %d%%, but %s, so charging waits until the maintain loop runs regularly again", batteryCharge, lower, d.missedLoopsSummary())
+			d.trace.Charging = isChargingEnabled
 			return true
 		}
 
@@ -338,13 +370,14 @@ func handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn bool,
 			"lower":         lower,
 			"upper":         upper,
 		}).Infof("Battery charge is below lower limit, enabling charging")
-		err := smcConn.EnableCharging()
+		d.decide(api.RuleBelowLowerLimit, "the charge %d%% is below the lower limit %d%%, so charging is enabled", batteryCharge, lower)
+		err := d.backend.EnableCharging()
 		if err != nil {
 			logrus.Errorf("EnableCharging failed: %v", err)
 			return false
 		}
 		isChargingEnabled = true
-		maintainedChargingInProgress = true
+		d.maintainedChargingInProgress = true
 	}
 
 	// Should disable charging.
@@ -354,25 +387,31 @@ func handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn bool,
 			"lower":         lower,
 			"upper":         upper,
 		}).Infof("Battery charge is above upper limit, disabling charging")
-		err := smcConn.DisableCharging()
+		d.decide(api.RuleAboveUpperLimit, "the charge %d%% has reached the upper limit %d%%, so charging is disabled", batteryCharge, upper)
+		err := d.backend.DisableCharging()
 		if err != nil {
 			logrus.Errorf("DisableCharging failed: %v", err)
 			return false
 		}
 		isChargingEnabled = false
-		maintainedChargingInProgress = false
+		d.maintainedChargingInProgress = false
 	}
 
-	switch conf.ControlMagSafeLED() {
+	if d.trace.Rule == "" {
+		d.explainKeep(isChargingEnabled, batteryCharge, lower, upper)
+	}
+	d.trace.Charging = isChargingEnabled
+
+	switch d.conf.ControlMagSafeLED() {
 	case config.ControlMagSafeModeAlwaysOff:
-		_ = smcConn.DisableMagSafeLed()
+		_ = d.backend.DisableMagSafeLed()
 	case config.ControlMagSafeModeEnabled:
-		updateMagSafeLed(isChargingEnabled)
+		d.updateMagSafeLed(isChargingEnabled)
 	default:
 		// nothing
 	}
 
-	if conf.PreventSystemSleep() {
+	if d.conf.PreventSystemSleep() {
 		if isChargingEnabled {
 			err := PreventSleepOnAC()
 			if err != nil {
@@ -392,39 +431,58 @@ func handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn bool,
 	return true
 }
 
-func maintainLoopInner(ignoreMissedLoops bool) bool {
-	maintainLoopInnerLock.Lock()
-	defer maintainLoopInnerLock.Unlock()
-
-	switch capabilities.ChargeControlMode {
+func (d *Daemon) maintainLoopInner(ignoreMissedLoops bool) (ok bool) {
+	d.maintainLoopMu.Lock()
+	defer d.maintainLoopMu.Unlock()
+	d.maintainLoopRuns.Add(1)
+	now := d.clock.Now()
+	d.startTrace(now)
+	defer func() { d.finishTrace(ok) }()
+	d.updateLimitProfile(now)
+	if d.capabilities.ChargingControl {
+		upper, _ := d.profileLimits()
+		d.updateAdaptiveCharging(now, upper)
+		d.updateTemperatureGuard(now)
+	}
+
+	// Power events need the plug and charge state in every mode, including
+	// firmware mode where enforcing the limit does not.
+	defer d.publishPowerEvents(now)
+
+	switch d.capabilities.ChargeControlMode {
 	case compatibility.ChargeControlFirmware:
-		return maintainFirmwareChargeLimit()
+		return d.maintainFirmwareChargeLimit()
 	case compatibility.ChargeControlLegacy:
-		return maintainLegacyCharging(ignoreMissedLoops)
+		return d.maintainLegacyCharging(ignoreMissedLoops)
 	default:
-		maintainedChargingInProgress = false
+		d.decide(api.RuleUnsupported, "this Mac does not support charge control, so macOS decides")
+		d.maintainedChargingInProgress = false
 		return false
 	}
 }
 
 // maintainFirmwareChargeLimit delegates hysteresis enforcement to the
-// firmware. It deliberately does not read battery/charging state or interact
-// with sleep, MagSafe, adapter, or calibration features.
-func maintainFirmwareChargeLimit() bool {
-	if calibrationNeedsMaintainLoop() {
-		batteryCharge, err := smcConn.GetBatteryCharge()
+// firmware. Outside calibration it deliberately does not base decisions on
+// battery/charging state or interact with sleep, MagSafe or adapter
+// features. The state is still read afterwards to publish power events.
+func (d *Daemon) maintainFirmwareChargeLimit() bool {
+	if d.calibrationNeedsMaintainLoop() {
+		batteryCharge, err := d.backend.GetBatteryCharge()
 		if err != nil {
 			logrus.Errorf("GetBatteryCharge failed during calibration: %v", err)
 			return false
 		}
-		if applyCalibrationWithinLoop(batteryCharge) {
+		if d.applyCalibrationWithinLoop(batteryCharge) {
+			d.explainCalibration()
 			return true
 		}
 	}
 
-	upper := conf.UpperLimit()
+	upper, lower := d.effectiveLimits()
+	d.explainLimits(upper, lower)
 	if upper >= 100 {
-		changed, err := smcConn.EnsureFirmwareChargeLimitDisabled()
+		d.explainNoLimit("the firmware charge limit is off")
+		changed, err := d.backend.EnsureFirmwareChargeLimitDisabled()
 		if err != nil {
 			logrus.Errorf("failed to deactivate firmware charge limit: %v", err)
 			return false
@@ -432,12 +490,12 @@ func maintainFirmwareChargeLimit() bool {
 		if changed {
 			logrus.Info("deactivated firmware charge limit")
 		}
-		maintainedChargingInProgress = false
+		d.maintainedChargingInProgress = false
 		return true
 	}
 
-	lower := conf.LowerLimit()
-	changed, err := smcConn.EnsureFirmwareChargeLimit(lower, upper)
+	d.decide(api.RuleFirmware, "the firmware enforces the charge limit: it charges below %d%% and stops at %d%%", lower, upper)
+	changed, err := d.backend.EnsureFirmwareChargeLimit(lower, upper)
 	if err != nil {
 		logrus.Errorf("failed to reconcile firmware charge limit: %v", err)
 		return false
@@ -447,69 +505,79 @@ func maintainFirmwareChargeLimit() bool {
 	} else {
 		logrus.WithFields(logrus.Fields{"lower": lower, "upper": upper}).Trace("firmware charge limit is correct")
 	}
-	maintainedChargingInProgress = false
+	d.maintainedChargingInProgress = false
 	return true
 }
 
 // maintainLegacyCharging contains the original batt-managed charge loop.
-func maintainLegacyCharging(ignoreMissedLoops bool) bool {
+func (d *Daemon) maintainLegacyCharging(ignoreMissedLoops bool) bool {
 
-	upper := conf.UpperLimit()
-	lower := conf.LowerLimit()
+	upper, lower := d.effectiveLimits()
 	maintain := upper < 100
 
-	isChargingEnabled, err := smcConn.IsChargingEnabled()
+	isChargingEnabled, err := d.backend.IsChargingEnabled()
 	if err != nil {
 		logrus.Errorf("IsChargingEnabled failed: %v", err)
 		return false
 	}
 
 	// Always get current battery charge to possibly drive calibration first.
-	batteryCharge, err := smcConn.GetBatteryCharge()
+	batteryCharge, err := d.backend.GetBatteryCharge()
 	if err != nil {
 		logrus.Errorf("GetBatteryCharge failed: %v", err)
 		return false
 	}
 
-	isPluggedIn, err := smcConn.IsPluggedIn()
+	isPluggedIn, err := d.backend.IsPluggedIn()
 	if err != nil {
 		logrus.Errorf("IsPluggedIn failed: %v", err)
 		return false
 	}
 
-	maintainedChargingInProgress = isChargingEnabled && isPluggedIn && calibrationState.Phase == calibration.PhaseIdle
-	printStatus(batteryCharge, lower, upper, isChargingEnabled, isPluggedIn, maintainedChargingInProgress, calibrationState.Phase != calibration.PhaseIdle)
+	d.trace.Charge, d.trace.PluggedIn, d.trace.Charging = batteryCharge, isPluggedIn, isChargingEnabled
+	d.explainLimits(upper, lower)
+
+	d.maintainedChargingInProgress = isChargingEnabled && isPluggedIn && d.calibrationState.Phase == calibration.PhaseIdle
+	d.printStatus(batteryCharge, lower, upper, isChargingEnabled, isPluggedIn, d.maintainedChargingInProgress, d.calibrationState.Phase != calibration.PhaseIdle)
 
 	// If calibration is active, advance it and skip normal maintain logic.
-	if applyCalibrationWithinLoop(batteryCharge) {
-		switch conf.ControlMagSafeLED() {
+	if d.applyCalibrationWithinLoop(batteryCharge) {
+		d.explainCalibration()
+		switch d.conf.ControlMagSafeLED() {
 		case config.ControlMagSafeModeAlwaysOff:
-			_ = smcConn.DisableMagSafeLed()
+			_ = d.backend.DisableMagSafeLed()
 		case config.ControlMagSafeModeEnabled:
-			updateMagSafeLed(isChargingEnabled)
+			d.updateMagSafeLed(isChargingEnabled)
 		default:
 			// nothing
 		}
 		return true
 	}
 
+	// A hot battery must not charge, whatever the limits are.
+	if d.temperatureGuardPausesCharging() {
+		d.decide(api.RuleTemperature, "the battery is too hot, so charging is paused until it cools down")
+		d.trace.Charging = false
+		return d.handleTemperaturePause(isChargingEnabled)
+	}
+
 	// If maintain is disabled, we don't care about the battery charge, enable charging anyway.
 	if !maintain {
-		return handleNoMaintain(isChargingEnabled)
+		d.explainNoLimit("charging is enabled")
+		d.trace.Charging = true
+		return d.handleNoMaintain(isChargingEnabled)
 	}
 
-	return handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn, batteryCharge, lower, upper)
+	return d.handleChargingLogic(ignoreMissedLoops, isChargingEnabled, isPluggedIn, batteryCharge, lower, upper)
 }
 
-func updateMagSafeLed(isChargingEnabled bool) {
-	err := smcConn.SetMagSafeCharging(isChargingEnabled)
+func (d *Daemon) updateMagSafeLed(isChargingEnabled bool) {
+	err := d.backend.SetMagSafeCharging(isChargingEnabled)
 	if err != nil {
 		logrus.Errorf("SetMagSafeCharging failed: %v", err)
 	}
 }
 
-var lastPrintTime time.Time
-
 type loopStatus struct {
 	batteryCharge                int
 	lower                        int
@@ -520,9 +588,7 @@ type loopStatus struct {
 	calibrationInProgress        bool
 }
 
-var lastStatus loopStatus
-
-func printStatus(
+func (d *Daemon) printStatus(
 	batteryCharge int,
 	lower int,
 	upper int,
@@ -551,15 +617,16 @@ func printStatus(
 		"calibrationInProgress":        calibrationInProgress,
 	}
 
-	defer func() { lastPrintTime = time.Now() }()
+	now := d.clock.Now()
+	defer func() { d.lastPrintTime = now }()
 
 	// Skip printing if the last print was less than loopInterval+1 seconds ago and everything is the same.
-	if time.Since(lastPrintTime) < loopInterval+time.Second && reflect.DeepEqual(lastStatus, currentStatus) {
+	if now.Sub(d.lastPrintTime) < loopInterval+time.Second && reflect.DeepEqual(d.lastStatus, currentStatus) {
 		logrus.WithFields(fields).Trace("status")
 		return
 	}
 
 	logrus.WithFields(fields).Debug("status")
 
-	lastStatus = currentStatus
+	d.lastStatus = currentStatus
 }
@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -8,11 +9,13 @@ import (
 	"github.com/charlie0129/gosmc"
 
 	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/clock"
 	"github.com/charlie0129/batt/pkg/compatibility"
 	"github.com/charlie0129/batt/pkg/smc"
 )
 
 func TestMaintainLoopRecorder_GetRecordsIn(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
 	type fields struct {
 		MaxRecordCount        int
 		LastMaintainLoopTimes []time.Time
@@ -32,9 +35,9 @@ func TestMaintainLoopRecorder_GetRecordsIn(t *testing.T) {
 			fields: fields{
 				MaxRecordCount: 10,
 				LastMaintainLoopTimes: []time.Time{
-					time.Now().Add(-time.Second * 31).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 20).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 10).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 31).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 20).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 10).Add(-10 * time.Millisecond),
 				},
 				mu: &sync.Mutex{},
 			},
@@ -48,12 +51,12 @@ func TestMaintainLoopRecorder_GetRecordsIn(t *testing.T) {
 			fields: fields{
 				MaxRecordCount: 10,
 				LastMaintainLoopTimes: []time.Time{
-					time.Now().Add(-time.Second * 70).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 60).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 40).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 30).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 20).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 10).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 70).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 60).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 40).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 30).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 20).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 10).Add(-10 * time.Millisecond),
 				},
 				mu: &sync.Mutex{},
 			},
@@ -67,12 +70,12 @@ func TestMaintainLoopRecorder_GetRecordsIn(t *testing.T) {
 			fields: fields{
 				MaxRecordCount: 10,
 				LastMaintainLoopTimes: []time.Time{
-					time.Now().Add(-time.Second * 70).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 60).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 40).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 30).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 20).Add(-10 * time.Millisecond),
-					time.Now().Add(-time.Second * 15).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 70).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 60).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 40).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 30).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 20).Add(-10 * time.Millisecond),
+					now.Add(-time.Second * 15).Add(-10 * time.Millisecond),
 				},
 				mu: &sync.Mutex{},
 			},
@@ -83,12 +86,12 @@ func TestMaintainLoopRecorder_GetRecordsIn(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		loopInterval = time.Second * 10
 		t.Run(tt.name, func(t *testing.T) {
 			r := &TimeSeriesRecorder{
 				MaxRecordCount:        tt.fields.MaxRecordCount,
 				LastMaintainLoopTimes: tt.fields.LastMaintainLoopTimes,
 				mu:                    tt.fields.mu,
+				clock:                 clock.NewFake(now),
 			}
 			if got := r.GetRecordsIn(tt.args.last); got != tt.want {
 				t.Errorf("GetRecordsIn() = %v, want %v", got, tt.want)
@@ -117,25 +120,18 @@ func TestMaintainLoopHonorsDisabledPreSleepChargingProtection(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = mock.Close() })
 
-	previousSMC, previousConf, previousCapabilities := smcConn, conf, capabilities
-	previousRecorder := loopRecorder
-	t.Cleanup(func() {
-		smcConn, conf, capabilities = previousSMC, previousConf, previousCapabilities
-		loopRecorder = previousRecorder
-	})
-	smcConn = mock
-	conf = &mockConf{
+	d := newTestDaemon(t, mock, &mockConf{
 		upper: 80,
 		lower: 75,
-	}
-	capabilities = compatibility.Capabilities{
+	})
+	d.capabilities = compatibility.Capabilities{
 		ChargingControl:   true,
 		ChargeControlMode: compatibility.ChargeControlLegacy,
 	}
-	// No records represents the first maintain loop after a sleep interruption.
-	loopRecorder = NewTimeSeriesRecorder(60)
+	// A new daemon has no records, like the first maintain loop after a
+	// sleep interruption.
 
-	if !maintainLoop() {
+	if !d.maintainLoop() {
 		t.Fatal("legacy maintain loop failed")
 	}
 	charging, err := mock.IsChargingEnabled()
@@ -147,6 +143,32 @@ func TestMaintainLoopHonorsDisabledPreSleepChargingProtection(t *testing.T) {
 	}
 }
 
+func TestMissedMaintainLoops(t *testing.T) {
+	d, clk := newTestDaemonWithClock(t, newFakeSMC(60, 1, true), &mockConf{upper: 80, lower: 75})
+
+	// Until the daemon has run long enough, there are too few loops.
+	for range 9 {
+		d.maintainLoop()
+		clk.Advance(loopInterval)
+	}
+	if misses := d.maintainLoopMisses.Load(); misses != 7 {
+		t.Fatalf("misses = %d while starting up, want 7", misses)
+	}
+	if d.checkMissedMaintainLoops(false) {
+		t.Fatal("loops every 10s reported as missed")
+	}
+
+	// A sleep without a notification stops the loop.
+	clk.Advance(5 * time.Minute)
+	if !d.checkMissedMaintainLoops(false) {
+		t.Fatal("loops missed while asleep were not detected")
+	}
+	d.maintainLoop()
+	if misses := d.maintainLoopMisses.Load(); misses != 8 {
+		t.Fatalf("misses = %d after a sleep, want 8", misses)
+	}
+}
+
 func TestRestoreDisabledLimit(t *testing.T) {
 	now := time.Now()
 	tests := []struct {
@@ -222,23 +244,18 @@ func TestRestoreDisabledLimit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			phase := tt.calibrationPhase
-			if phase == "" {
-				phase = calibration.PhaseIdle
-			}
-			calibrationState = &calibration.State{Phase: phase}
-			t.Cleanup(func() {
-				calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-			})
-
 			c := &mockConf{
 				upper:           100,
 				lower:           98,
 				disableUntil:    tt.disableUntil,
 				preDisableLimit: tt.preDisableLimit,
 			}
+			d := newTestDaemon(t, nil, c)
+			if tt.calibrationPhase != "" {
+				d.calibrationState = &calibration.State{Phase: tt.calibrationPhase}
+			}
 
-			if got := restoreDisabledLimit(c, now); got != tt.want {
+			if got := d.restoreDisabledLimit(now); got != tt.want {
 				t.Errorf("restoreDisabledLimit() = %v, want %v", got, tt.want)
 			}
 			if c.upper != tt.wantUpper {
@@ -252,9 +269,6 @@ func TestRestoreDisabledLimit(t *testing.T) {
 }
 
 func TestRestoreDisabledLimitWaitsForPersistedCalibration(t *testing.T) {
-	previousState := calibrationState
-	t.Cleanup(func() { calibrationState = previousState })
-
 	now := time.Now()
 	configured := &mockConf{
 		upper:           100,
@@ -262,17 +276,18 @@ func TestRestoreDisabledLimitWaitsForPersistedCalibration(t *testing.T) {
 		disableUntil:    now.Add(-time.Hour),
 		preDisableLimit: 80,
 	}
-	calibrationState = &calibration.State{Phase: calibration.PhaseCharge}
+	d := newTestDaemon(t, nil, configured)
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseCharge}
 
-	if restoreDisabledLimit(configured, now) {
+	if d.restoreDisabledLimit(now) {
 		t.Fatal("temporary disable restored while calibration owned the charge limit")
 	}
 	if configured.disableUntil.IsZero() {
 		t.Fatal("temporary disable timer was cleared while calibration was active")
 	}
 
-	calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
-	if !restoreDisabledLimit(configured, now) {
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseIdle}
+	if !d.restoreDisabledLimit(now) {
 		t.Fatal("temporary disable was not restored after calibration finished")
 	}
 	if configured.upper != 80 || !configured.disableUntil.IsZero() {
@@ -280,6 +295,38 @@ func TestRestoreDisabledLimitWaitsForPersistedCalibration(t *testing.T) {
 	}
 }
 
+func TestInfiniteLoopRestoresDisabledLimit(t *testing.T) {
+	c := &mockConf{upper: 100, lower: 98, preDisableLimit: 80}
+	d, clk := newTestDaemonWithClock(t, newFakeSMC(60, 1, true), c)
+	c.disableUntil = clk.Now().Add(25 * time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.infiniteLoop(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Each loop waits for the clock once it is done.
+	clk.WaitForTimers(1)
+	for range 2 {
+		clk.Advance(loopInterval)
+		clk.WaitForTimers(1)
+	}
+	if c.upper != 100 {
+		t.Fatalf("upper limit = %d before the deadline, want 100", c.upper)
+	}
+	clk.Advance(loopInterval)
+	clk.WaitForTimers(1)
+	if c.upper != 80 || !c.disableUntil.IsZero() {
+		t.Fatalf("config = %+v after the deadline, want upper 80 with no timer", c)
+	}
+}
+
 func TestMaintainAdapterDisable(t *testing.T) {
 	now := time.Now()
 	tests := []struct {
@@ -325,37 +372,22 @@ func TestMaintainAdapterDisable(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			previousState := calibrationState
-			previousIsEnabled := smcIsAdapterEnabled
-			previousEnable := smcEnableAdapter
-			previousDisable := smcDisableAdapter
-			t.Cleanup(func() {
-				calibrationState = previousState
-				smcIsAdapterEnabled = previousIsEnabled
-				smcEnableAdapter = previousEnable
-				smcDisableAdapter = previousDisable
-			})
-
-			phase := tt.calibrationPhase
-			if phase == "" {
-				phase = calibration.PhaseIdle
-			}
-			calibrationState = &calibration.State{Phase: phase}
-			enabled := tt.adapterEnabled
-			smcIsAdapterEnabled = func() (bool, error) { return enabled, nil }
-			smcEnableAdapter = func() error { enabled = true; return nil }
-			smcDisableAdapter = func() error { enabled = false; return nil }
+			backend := newFakeSMC(60, 0, tt.adapterEnabled)
 			configured := &mockConf{
 				upper:               80,
 				lower:               78,
 				adapterDisableUntil: tt.until,
 			}
+			d := newTestDaemon(t, backend, configured)
+			if tt.calibrationPhase != "" {
+				d.calibrationState = &calibration.State{Phase: tt.calibrationPhase}
+			}
 
-			if got := maintainAdapterDisable(configured, now); got != tt.wantRestored {
+			if got := d.maintainAdapterDisable(now); got != tt.wantRestored {
 				t.Errorf("maintainAdapterDisable() = %v, want %v", got, tt.wantRestored)
 			}
-			if enabled != tt.wantEnabled {
-				t.Errorf("adapter enabled = %v, want %v", enabled, tt.wantEnabled)
+			if backend.adapter != tt.wantEnabled {
+				t.Errorf("adapter enabled = %v, want %v", backend.adapter, tt.wantEnabled)
 			}
 			if gotTimer := !configured.adapterDisableUntil.IsZero(); gotTimer != tt.wantTimer {
 				t.Errorf("timer set = %v, want %v", gotTimer, tt.wantTimer)
@@ -365,20 +397,17 @@ func TestMaintainAdapterDisable(t *testing.T) {
 }
 
 func TestTemporaryDisableDoesNotCancelRestartedCalibration(t *testing.T) {
-	previousConf, previousState := conf, calibrationState
-	t.Cleanup(func() { conf, calibrationState = previousConf, previousState })
-
-	conf = &mockConf{
+	d := newTestDaemon(t, nil, &mockConf{
 		upper:           100,
 		lower:           78,
 		disableUntil:    time.Now().Add(time.Hour),
 		preDisableLimit: 80,
-	}
-	calibrationState = &calibration.State{Phase: calibration.PhaseCharge, Paused: true}
+	})
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseCharge, Paused: true}
 
-	cancelCalibrationForPermanentDisable()
-	if calibrationState.Phase != calibration.PhaseCharge || !calibrationState.Paused {
-		t.Fatalf("persisted calibration was unexpectedly cancelled: %+v", calibrationState)
+	d.cancelCalibrationForPermanentDisable()
+	if d.calibrationState.Phase != calibration.PhaseCharge || !d.calibrationState.Paused {
+		t.Fatalf("persisted calibration was unexpectedly cancelled: %+v", d.calibrationState)
 	}
 }
 
@@ -401,18 +430,9 @@ func TestMaintainFirmwareChargeLimitWithoutLegacyPolling(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = mock.Close() })
 
-	previousSMC, previousConf, previousCapabilities := smcConn, conf, capabilities
-	t.Cleanup(func() {
-		smcConn, conf, capabilities = previousSMC, previousConf, previousCapabilities
-	})
-	smcConn = mock
-	conf = &mockConf{upper: 80, lower: 78}
-	capabilities = compatibility.Capabilities{
-		ChargingControl:   true,
-		ChargeControlMode: compatibility.ChargeControlFirmware,
-	}
+	d := newTestDaemon(t, mock, &mockConf{upper: 80, lower: 78})
 
-	if !maintainLoopForced() {
+	if !d.maintainLoopForced() {
 		t.Fatal("firmware maintain loop failed")
 	}
 	state, err := mock.GetFirmwareChargeLimit()
@@ -423,8 +443,10 @@ func TestMaintainFirmwareChargeLimitWithoutLegacyPolling(t *testing.T) {
 		t.Fatalf("unexpected firmware state: %+v", state)
 	}
 
-	conf.SetUpperLimit(100)
-	if !maintainLoopForced() {
+	if err := d.conf.SetUpperLimit(100); err != nil {
+		t.Fatal(err)
+	}
+	if !d.maintainLoopForced() {
 		t.Fatal("firmware disable loop failed")
 	}
 	state, err = mock.GetFirmwareChargeLimit()
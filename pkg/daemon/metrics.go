@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/metrics"
+	"github.com/charlie0129/batt/pkg/version"
+)
+
+var calibrationPhases = []calibration.Phase{
+	calibration.PhaseIdle,
+	calibration.PhaseDischarge,
+	calibration.PhaseCharge,
+	calibration.PhaseHold,
+	calibration.PhasePostHold,
+	calibration.PhaseRestore,
+	calibration.PhaseError,
+}
+
+// getMetrics serves /metrics in the Prometheus text exposition format.
+// Readings that are unavailable are left out rather than reported as zero.
+func (d *Daemon) getMetrics(c *gin.Context) {
+	var buf bytes.Buffer
+	d.writeMetrics(metrics.NewWriter(&buf))
+	c.Data(http.StatusOK, metrics.ContentType, buf.Bytes())
+}
+
+// newMetricsServer returns a server exposing only /metrics on localhost.
+func (d *Daemon) newMetricsServer(port int) *http.Server {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(ginLogger(logrus.StandardLogger()))
+	router.GET("/metrics", d.getMetrics)
+
+	return &http.Server{
+		Addr:              net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		Handler:           router,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+func (d *Daemon) writeMetrics(w *metrics.Writer) {
+	w.Family("batt_info", "Static information about the running daemon.", metrics.Gauge)
+	w.Sample("batt_info", metrics.Labels{
+		"version":             version.Version,
+		"charge_control_mode": string(d.capabilities.ChargeControlMode),
+	}, 1)
+
+	if charge, err := d.backend.GetBatteryCharge(); err == nil {
+		w.Gauge("batt_battery_charge_percent", "Current battery charge.", float64(charge))
+	}
+	upper, lower := d.effectiveLimits()
+	w.Gauge("batt_upper_limit_percent", "Upper charge limit in effect, including limit profiles.", float64(upper))
+	w.Gauge("batt_lower_limit_percent", "Lower charge limit in effect, including limit profiles.", float64(lower))
+
+	if d.conf.AdaptiveCharging() {
+		w.Gauge("batt_adaptive_topping_up", "Whether adaptive charging is topping up to 100%.", metrics.Bool(d.adaptiveChargingToppingUp()))
+	}
+
+	temp := d.getTemperatureStatus()
+	if temp.Battery > 0 {
+		w.Gauge("batt_battery_temperature_celsius", "Battery temperature.", temp.Battery)
+	}
+	if temp.Guard.Enabled {
+		w.Gauge("batt_temperature_guard_active", "Whether the temperature guard is pausing or limiting charging.", metrics.Bool(temp.Guard.Active))
+	}
+
+	if pluggedIn, err := d.backend.IsPluggedIn(); err == nil {
+		w.Gauge("batt_plugged_in", "Whether external power is connected.", metrics.Bool(pluggedIn))
+	}
+	if charging, err := d.readCharging(); err == nil {
+		w.Gauge("batt_charging", "Whether the battery is charging.", metrics.Bool(charging))
+	}
+	if d.capabilities.AdapterControl {
+		if enabled, err := d.backend.IsAdapterEnabled(); err == nil {
+			w.Gauge("batt_adapter_enabled", "Whether power input from the adapter is enabled.", metrics.Bool(enabled))
+		}
+	}
+
+	if t, err := d.readPowerTelemetry(); err == nil {
+		w.Gauge("batt_adapter_input_voltage_volts", "Adapter input voltage.", t.Adapter.InputVoltage)
+		w.Gauge("batt_adapter_input_current_amperes", "Adapter input current.", t.Adapter.InputAmperage)
+		w.Gauge("batt_ac_power_watts", "Power drawn from the adapter.", t.Calculations.ACPower)
+		w.Gauge("batt_battery_power_watts", "Battery power, negative when discharging.", t.Calculations.BatteryPower)
+		w.Gauge("batt_system_power_watts", "Power consumed by the system.", t.Calculations.SystemPower)
+		w.Gauge("batt_battery_cycle_count", "Battery cycle count.", float64(t.Battery.CycleCount))
+		w.Gauge("batt_battery_health_percent", "Maximum capacity relative to design capacity.", float64(t.Calculations.HealthByMaxCapacity))
+	}
+
+	if d.capabilities.Calibration {
+		d.calibrationMu.Lock()
+		current := d.calibrationState.Phase
+		d.calibrationMu.Unlock()
+
+		w.Family("batt_calibration_phase", "Current calibration phase, 1 for the active phase.", metrics.Gauge)
+		for _, phase := range calibrationPhases {
+			w.Sample("batt_calibration_phase", metrics.Labels{"phase": string(phase)}, metrics.Bool(phase == current))
+		}
+	}
+
+	if ec, ok := d.backend.(errorCounter); ok {
+		reads, writes := ec.ErrorCounts()
+		w.Counter("batt_smc_read_errors_total", "Failed SMC (or sysfs) reads.", reads)
+		w.Counter("batt_smc_write_errors_total", "Failed SMC (or sysfs) writes.", writes)
+	}
+	w.Counter("batt_maintain_loop_runs_total", "Maintain loop executions.", d.maintainLoopRuns.Load())
+	w.Counter("batt_maintain_loop_misses_total", "Maintain loops that detected missed runs, e.g. after sleep.", d.maintainLoopMisses.Load())
+}
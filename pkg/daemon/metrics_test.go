@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/metrics"
+)
+
+func TestGetMetrics(t *testing.T) {
+	backend, _ := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "64",
+		"BAT0/status":                       "Charging",
+		"BAT0/power_now":                    "12000000",
+		"BAT0/cycle_count":                  "321",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+
+	d := newTestDaemon(t, backend, &mockConf{upper: 80, lower: 78})
+	d.capabilities = compatibility.Capabilities{
+		ChargingControl:   true,
+		ChargeControlMode: compatibility.ChargeControlFirmware,
+		Calibration:       true,
+	}
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseHold}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	d.router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d; body=%s", recorder.Code, recorder.Body.String())
+	}
+	if got := recorder.Header().Get("Content-Type"); got != metrics.ContentType {
+		t.Fatalf("Content-Type = %q", got)
+	}
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE batt_battery_charge_percent gauge",
+		"batt_battery_charge_percent 64",
+		"batt_upper_limit_percent 80",
+		"batt_lower_limit_percent 78",
+		"batt_plugged_in 1",
+		"batt_charging 1",
+		"batt_battery_power_watts 12",
+		"batt_battery_cycle_count 321",
+		`batt_calibration_phase{phase="HoldAfterFull"} 1`,
+		`batt_calibration_phase{phase="Idle"} 0`,
+		"# TYPE batt_smc_read_errors_total counter",
+		"batt_smc_write_errors_total 0",
+		"# TYPE batt_maintain_loop_runs_total counter",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics output is missing %q", line)
+		}
+	}
+	if strings.Contains(body, "batt_adapter_enabled") {
+		t.Error("batt_adapter_enabled must be omitted without adapter control")
+	}
+}
@@ -0,0 +1,388 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/audit"
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/mqtt"
+	"github.com/charlie0129/batt/pkg/version"
+)
+
+var (
+	// mqttStateInterval is how often state topics are refreshed.
+	mqttStateInterval = 30 * time.Second
+	// mqttMaxBackoff caps the delay between reconnection attempts.
+	mqttMaxBackoff = time.Minute
+)
+
+const (
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+)
+
+// mqttSettings is a config.MQTT with the host-dependent defaults applied.
+type mqttSettings struct {
+	config.MQTT
+	// node identifies this Mac in topics and discovery IDs.
+	node string
+}
+
+func newMQTTSettings(m config.MQTT) mqttSettings {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "mac"
+	}
+	node := mqttNodeID(host)
+	if m.ClientID == "" {
+		m.ClientID = "batt-" + node
+	}
+	m.TopicPrefix = strings.TrimSuffix(m.TopicPrefix, "/")
+	if m.TopicPrefix == "" {
+		m.TopicPrefix = "batt/" + node
+	}
+	return mqttSettings{MQTT: m, node: node}
+}
+
+// mqttNodeID turns a host name into something usable in topics and Home
+// Assistant IDs.
+func mqttNodeID(host string) string {
+	host, _, _ = strings.Cut(host, ".")
+	var b strings.Builder
+	for _, r := range strings.ToLower(host) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func (s mqttSettings) topic(name string) string { return s.TopicPrefix + "/" + name }
+
+// reloadMQTT starts, restarts or stops the MQTT subsystem to match the
+// config.
+func (d *Daemon) reloadMQTT() {
+	m := d.conf.MQTT()
+
+	d.mqttMu.Lock()
+	defer d.mqttMu.Unlock()
+
+	if d.mqttStop != nil && d.mqttRunning == m {
+		return
+	}
+	d.stopMQTTLocked()
+	d.mqttRunning = m
+	if m.Broker == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	d.mqttStop, d.mqttDone = cancel, done
+	go func() {
+		defer close(done)
+		d.runMQTT(ctx, d.hub, newMQTTSettings(m))
+	}()
+}
+
+// stopMQTT stops the MQTT subsystem, marking this Mac offline.
+func (d *Daemon) stopMQTT() {
+	d.mqttMu.Lock()
+	defer d.mqttMu.Unlock()
+	d.stopMQTTLocked()
+}
+
+func (d *Daemon) stopMQTTLocked() {
+	if d.mqttStop == nil {
+		return
+	}
+	d.mqttStop()
+	select {
+	case <-d.mqttDone:
+	case <-time.After(5 * time.Second):
+		logrus.Warn("timed out waiting for the MQTT connection to close")
+	}
+	d.mqttStop, d.mqttDone = nil, nil
+}
+
+// runMQTT keeps a connection to the broker until ctx is done, reconnecting
+// with exponential backoff.
+func (d *Daemon) runMQTT(ctx context.Context, hub *events.EventHub, s mqttSettings) {
+	log := logrus.WithField("broker", s.Broker)
+	backoff := time.Second
+	for {
+		dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		client, err := mqtt.Dial(dialCtx, mqtt.Options{
+			Broker:    s.Broker,
+			ClientID:  s.ClientID,
+			Username:  s.Username,
+			Password:  s.Password,
+			KeepAlive: time.Minute,
+			Will:      &mqtt.Message{Topic: s.topic("status"), Payload: []byte(mqttOffline), Retain: true},
+		})
+		cancel()
+		if err == nil {
+			log.Info("connected to MQTT broker")
+			backoff = time.Second
+			err = d.mqttSession(ctx, client, hub, s)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.WithError(err).Warnf("MQTT connection failed, retrying in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, mqttMaxBackoff)
+	}
+}
+
+// mqttSession publishes state and events and handles commands until the
+// connection is lost or ctx is done.
+func (d *Daemon) mqttSession(ctx context.Context, client *mqtt.Client, hub *events.EventHub, s mqttSettings) error {
+	defer func() { _ = client.Close() }()
+
+	p := &mqttPublisher{d: d, client: client, s: s, last: map[string]string{}}
+	if err := client.Publish(s.topic("status"), []byte(mqttOnline), true); err != nil {
+		return err
+	}
+	if !s.DisableDiscovery {
+		if err := p.publishDiscovery(); err != nil {
+			return err
+		}
+	}
+
+	commands := make(chan mqtt.Message, 8)
+	handler := func(m mqtt.Message) {
+		select {
+		case commands <- m:
+		default:
+			logrus.WithField("topic", m.Topic).Warn("dropping MQTT command, too many pending")
+		}
+	}
+	for _, name := range []string{"limit/set", "calibration/start"} {
+		if err := client.Subscribe(ctx, s.topic(name), handler); err != nil {
+			return err
+		}
+	}
+
+	ch := hub.Subscribe()
+	defer hub.Unsubscribe(ch)
+
+	ticker := time.NewTicker(mqttStateInterval)
+	defer ticker.Stop()
+
+	if err := p.publishState(); err != nil {
+		return err
+	}
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			// Close skips the will, so go offline explicitly.
+			_ = client.Publish(s.topic("status"), []byte(mqttOffline), true)
+			return nil
+		case <-client.Done():
+			return client.Err()
+		case m := <-commands:
+			// The command has released mutationMu by now, so a broker that
+			// stops reading does not hold up the API.
+			d.handleMQTTCommand(s, m)
+			err = p.publishState()
+		case ev := <-ch:
+			err = client.Publish(s.topic("event/"+ev.Name), ev.Data, false)
+			if err == nil {
+				err = p.publishState()
+			}
+		case <-ticker.C:
+			err = p.publishState()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleMQTTCommand runs a command through the same code paths as the HTTP
+// API.
+func (d *Daemon) handleMQTTCommand(s mqttSettings, m mqtt.Message) {
+	payload := strings.TrimSpace(string(m.Payload))
+	log := logrus.WithFields(logrus.Fields{"topic": m.Topic, "payload": payload})
+
+	switch m.Topic {
+	case s.topic("limit/set"):
+		if !d.capabilities.Supports(compatibility.FeatureChargingControl) {
+			log.Warnf("ignoring MQTT command: %s is not supported on this Mac", compatibility.FeatureChargingControl)
+			return
+		}
+		l, err := strconv.Atoi(payload)
+		if err != nil {
+			f, ferr := strconv.ParseFloat(payload, 64)
+			if ferr != nil {
+				log.WithError(err).Warn("ignoring MQTT command: invalid limit")
+				return
+			}
+			l = int(f)
+		}
+		// Commands change the config like mutating API requests do.
+		d.mutationMu.Lock()
+		msg, err := d.applyLimit(audit.ActorMQTT, l)
+		d.mutationMu.Unlock()
+		if err != nil {
+			log.WithError(err).Warn("MQTT command failed")
+			return
+		}
+		log.Info(msg)
+	case s.topic("calibration/start"):
+		if !d.capabilities.Supports(compatibility.FeatureCalibration) {
+			log.Warnf("ignoring MQTT command: %s is not supported on this Mac", compatibility.FeatureCalibration)
+			return
+		}
+		d.mutationMu.Lock()
+		err := d.startCalibration(audit.ActorMQTT, d.conf.CalibrationDischargeThreshold(), d.conf.CalibrationHoldDurationMinutes())
+		d.mutationMu.Unlock()
+		if err != nil {
+			log.WithError(err).Warn("MQTT command failed")
+			return
+		}
+		log.Info("calibration started from MQTT")
+	}
+}
+
+// mqttPublisher publishes retained state topics when their value changes.
+type mqttPublisher struct {
+	d      *Daemon
+	client *mqtt.Client
+	s      mqttSettings
+	last   map[string]string
+}
+
+func (p *mqttPublisher) publish(name, value string) error {
+	if p.last[name] == value {
+		return nil
+	}
+	if err := p.client.Publish(p.s.topic(name), []byte(value), true); err != nil {
+		return err
+	}
+	p.last[name] = value
+	return nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func (p *mqttPublisher) publishState() error {
+	state := map[string]string{
+		"limit": strconv.Itoa(p.d.conf.UpperLimit()),
+	}
+	if charge, err := p.d.backend.GetBatteryCharge(); err == nil {
+		state["charge"] = strconv.Itoa(charge)
+	}
+	if charging, err := p.d.readCharging(); err == nil {
+		state["charging"] = onOff(charging)
+	}
+	if pluggedIn, err := p.d.backend.IsPluggedIn(); err == nil {
+		state["plugged_in"] = onOff(pluggedIn)
+	}
+	for _, name := range []string{"charge", "limit", "charging", "plugged_in"} {
+		if value, ok := state[name]; ok {
+			if err := p.publish(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// publishDiscovery publishes Home Assistant MQTT discovery configs.
+func (p *mqttPublisher) publishDiscovery() error {
+	s := p.s
+	host, _ := os.Hostname()
+	device := map[string]any{
+		"identifiers":  []string{"batt_" + s.node},
+		"name":         host,
+		"manufacturer": "batt",
+		"model":        "batt",
+		"sw_version":   version.Version,
+	}
+	entity := func(name, id string, extra map[string]any) map[string]any {
+		m := map[string]any{
+			"name":               name,
+			"unique_id":          "batt_" + s.node + "_" + id,
+			"object_id":          "batt_" + s.node + "_" + id,
+			"availability_topic": s.topic("status"),
+			"device":             device,
+		}
+		for k, v := range extra {
+			m[k] = v
+		}
+		return m
+	}
+
+	configs := map[string]map[string]any{
+		"sensor/" + s.node + "/charge": entity("Battery charge", "charge", map[string]any{
+			"state_topic":         s.topic("charge"),
+			"device_class":        "battery",
+			"state_class":         "measurement",
+			"unit_of_measurement": "%",
+		}),
+		"binary_sensor/" + s.node + "/charging": entity("Charging", "charging", map[string]any{
+			"state_topic":  s.topic("charging"),
+			"device_class": "battery_charging",
+		}),
+		"binary_sensor/" + s.node + "/plugged_in": entity("Plugged in", "plugged_in", map[string]any{
+			"state_topic":  s.topic("plugged_in"),
+			"device_class": "plug",
+		}),
+	}
+	limit := map[string]any{
+		"state_topic":         s.topic("limit"),
+		"unit_of_measurement": "%",
+	}
+	if p.d.capabilities.Supports(compatibility.FeatureChargingControl) {
+		limit["command_topic"] = s.topic("limit/set")
+		limit["min"] = 10
+		limit["max"] = 100
+		limit["step"] = 1
+		limit["mode"] = "slider"
+		configs["number/"+s.node+"/limit"] = entity("Charge limit", "limit", limit)
+	} else {
+		configs["sensor/"+s.node+"/limit"] = entity("Charge limit", "limit", limit)
+	}
+	if p.d.capabilities.Supports(compatibility.FeatureCalibration) {
+		configs["button/"+s.node+"/calibrate"] = entity("Start calibration", "calibrate", map[string]any{
+			"command_topic": s.topic("calibration/start"),
+			"payload_press": "PRESS",
+		})
+	}
+
+	for path, cfg := range configs {
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		if err := p.client.Publish(s.DiscoveryPrefix+"/"+path+"/config", b, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package daemon
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/mqtt"
+	"github.com/charlie0129/batt/pkg/mqtt/mqtttest"
+)
+
+// waitForMessage waits until the broker has received a message matching ok.
+func waitForMessage(t *testing.T, b *mqtttest.Broker, what string, ok func(mqtt.Message) bool) mqtt.Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		changed := b.Changed()
+		for _, m := range b.Messages() {
+			if ok(m) {
+				return m
+			}
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func waitForRetained(t *testing.T, b *mqtttest.Broker, topic, payload string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		changed := b.Changed()
+		if m, ok := b.Retained(topic); ok && string(m.Payload) == payload {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			m, _ := b.Retained(topic)
+			t.Fatalf("retained %s = %q, want %q", topic, m.Payload, payload)
+		}
+	}
+}
+
+func TestMQTTPublishesStateAndHandlesCommands(t *testing.T) {
+	broker, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(broker.Close)
+
+	backend, _ := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "60",
+		"BAT0/status":                       "Charging",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+	mc := &mockConf{upper: 80, lower: 75, mqtt: config.MQTT{
+		Broker:          broker.Addr,
+		TopicPrefix:     "batt/test",
+		DiscoveryPrefix: "homeassistant",
+	}}
+	d := newTestDaemon(t, backend, mc)
+
+	d.reloadMQTT()
+	t.Cleanup(d.stopMQTT)
+
+	waitForRetained(t, broker, "batt/test/status", "online")
+	waitForRetained(t, broker, "batt/test/charge", "60")
+	waitForRetained(t, broker, "batt/test/limit", "80")
+	waitForRetained(t, broker, "batt/test/charging", "ON")
+	waitForRetained(t, broker, "batt/test/plugged_in", "ON")
+
+	node := newMQTTSettings(mc.mqtt).node
+	m, ok := broker.Retained("homeassistant/number/" + node + "/limit/config")
+	if !ok {
+		t.Fatal("no discovery config for the limit")
+	}
+	var discovery map[string]any
+	if err := json.Unmarshal(m.Payload, &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if discovery["command_topic"] != "batt/test/limit/set" || discovery["availability_topic"] != "batt/test/status" {
+		t.Fatalf("unexpected discovery config %s", m.Payload)
+	}
+	if _, ok := broker.Retained("homeassistant/button/" + node + "/calibrate/config"); ok {
+		t.Fatal("calibration button advertised without calibration support")
+	}
+
+	broker.Publish(mqtt.Message{Topic: "batt/test/limit/set", Payload: []byte("70")})
+	waitForRetained(t, broker, "batt/test/limit", "70")
+	if mc.upper != 70 {
+		t.Fatalf("upper limit = %d, want 70", mc.upper)
+	}
+
+	// Out-of-range limits are rejected like they are over HTTP.
+	broker.Publish(mqtt.Message{Topic: "batt/test/limit/set", Payload: []byte("5")})
+	d.hub.Publish(events.PowerUnplugged, events.PowerEvent{PluggedIn: false, Charge: 60})
+	waitForMessage(t, broker, "forwarded event", func(m mqtt.Message) bool {
+		return m.Topic == "batt/test/event/"+events.PowerUnplugged && strings.Contains(string(m.Payload), `"charge":60`)
+	})
+	if mc.upper != 70 {
+		t.Fatalf("upper limit = %d after an invalid command, want 70", mc.upper)
+	}
+
+	d.stopMQTT()
+	waitForRetained(t, broker, "batt/test/status", "offline")
+}
+
+func TestMQTTNodeID(t *testing.T) {
+	for host, want := range map[string]string{
+		"Charlies-MacBook-Pro.local": "charlies_macbook_pro",
+		"studio":                     "studio",
+	} {
+		if got := mqttNodeID(host); got != want {
+			t.Errorf("mqttNodeID(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package daemon
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/adaptive"
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/history"
+	"github.com/charlie0129/batt/pkg/metrics"
+	"github.com/charlie0129/batt/pkg/powerinfo"
+	"github.com/charlie0129/batt/pkg/version"
+	"github.com/charlie0129/batt/pkg/webhook"
+)
+
+// routeDoc documents a route in the OpenAPI document. request and response
+// are values of the body types, nil if there is no body.
+type routeDoc struct {
+	summary  string
+	query    []api.Parameter
+	request  any
+	response any
+	// status is the success status, 200 if zero.
+	status int
+	// contentType is the response media type, JSON if empty.
+	contentType string
+	deprecated  bool
+}
+
+type okResponse struct {
+	OK bool `json:"ok"`
+}
+
+type legacyScheduleResponse struct {
+	OK       bool        `json:"ok"`
+	NextRuns []time.Time `json:"next_runs,omitempty"`
+}
+
+var (
+	telemetryQuery = []api.Parameter{
+		{Name: "power", In: "query", Description: "0 to leave out power telemetry", Schema: &api.Schema{Type: "string"}},
+		{Name: "calibration", In: "query", Description: "0 to leave out the calibration status", Schema: &api.Schema{Type: "string"}},
+		{Name: "temperature", In: "query", Description: "0 to leave out the temperature guard status", Schema: &api.Schema{Type: "string"}},
+	}
+	ifMatchParameter = api.Parameter{
+		Name: "If-Match", In: "header", Schema: &api.Schema{Type: "string"},
+		Description: "ETag of the config as last read; the request fails with 412 if the config has changed since",
+	}
+	historyQuery = []api.Parameter{
+		{Name: "from", In: "query", Description: "RFC 3339 time or unix seconds, 24 hours before to by default", Schema: &api.Schema{Type: "string"}},
+		{Name: "to", In: "query", Description: "RFC 3339 time or unix seconds, now by default", Schema: &api.Schema{Type: "string"}},
+		{Name: "step", In: "query", Description: "aggregate samples into buckets of this duration, for example 10m", Schema: &api.Schema{Type: "string"}},
+	}
+	configQuery = []api.Parameter{
+		{Name: "explain", In: "query", Description: "1 to return an object of api.ConfigSetting by setting name instead, telling where each value comes from", Schema: &api.Schema{Type: "string"}},
+	}
+	auditQuery = []api.Parameter{
+		{Name: "since", In: "query", Description: "RFC 3339 time or unix seconds, all entries by default", Schema: &api.Schema{Type: "string"}},
+		{Name: "limit", In: "query", Description: "return at most this many of the newest entries, 100 by default, 0 for all", Schema: &api.Schema{Type: "integer"}},
+	}
+)
+
+// routeDocs documents every route setupRoutes registers, keyed by method
+// and path. TestOpenAPICoversRoutes fails if one is missing.
+var routeDocs = map[string]routeDoc{
+	"GET /openapi.json": {summary: "This document", response: map[string]any{}},
+	"GET /metrics":      {summary: "Prometheus metrics", response: "", contentType: metrics.ContentType},
+
+	"GET /v1/version":                        {summary: "Daemon version", response: api.Version{}},
+	"GET /v1/config":                         {summary: "Current config, with secrets redacted", query: configQuery, response: api.Config{}},
+	"PATCH /v1/config":                       {summary: "Change the fields of the config set in the body at once", request: config.RawFileConfig{}, response: api.Config{}},
+	"GET /v1/compatibility":                  {summary: "Features supported on this Mac", response: compatibility.Capabilities{}},
+	"GET /v1/limit":                          {summary: "Charge limits", response: api.Limit{}},
+	"PUT /v1/limit":                          {summary: "Set the upper charge limit", request: api.SetLimitRequest{}, response: api.Limit{}},
+	"PUT /v1/limit/lower-delta":              {summary: "Set the gap between the upper and lower limit", request: api.SetLowerLimitDeltaRequest{}, response: api.Limit{}},
+	"POST /v1/limit/disable":                 {summary: "Disable the charge limit for a while", request: api.DurationRequest{}, response: api.Limit{}},
+	"GET /v1/adapter":                        {summary: "Power adapter state", response: api.Adapter{}},
+	"PUT /v1/adapter":                        {summary: "Enable or disable the power adapter", request: api.SetAdapterRequest{}, response: api.Adapter{}},
+	"POST /v1/adapter/disable":               {summary: "Disable the power adapter for a while", request: api.DurationRequest{}, response: api.Adapter{}},
+	"GET /v1/battery":                        {summary: "Battery state", response: api.Battery{}},
+	"GET /v1/settings":                       {summary: "Settings", response: api.Settings{}},
+	"PUT /v1/settings":                       {summary: "Change the settings that are set", request: api.Settings{}, response: api.Settings{}},
+	"GET /v1/telemetry":                      {summary: "Power, calibration and temperature telemetry", query: telemetryQuery, response: api.Telemetry{}},
+	"GET /v1/events":                         {summary: "Server-sent events, named as in pkg/events", response: "", contentType: "text/event-stream"},
+	"GET /v1/history":                        {summary: "Recorded battery history", query: historyQuery, response: []history.Sample{}},
+	"GET /v1/audit":                          {summary: "Recorded configuration and control changes", query: auditQuery, response: []audit.Entry{}},
+	"GET /v1/explain":                        {summary: "Why the battery is charging or not", response: api.Explanation{}},
+	"GET /v1/adaptive":                       {summary: "Adaptive charging status", response: adaptive.Status{}},
+	"POST /v1/adaptive/reset":                {summary: "Forget learned unplug times", response: adaptive.Status{}},
+	"GET /v1/webhooks":                       {summary: "Webhooks and their delivery status", response: []webhook.Status{}},
+	"PUT /v1/webhooks":                       {summary: "Replace the webhooks", request: []config.Webhook{}, response: []webhook.Status{}},
+	"GET /v1/calibration":                    {summary: "Calibration status", response: calibration.Status{}},
+	"POST /v1/calibration/start":             {summary: "Start calibration", response: calibration.Status{}},
+	"POST /v1/calibration/pause":             {summary: "Pause calibration", response: calibration.Status{}},
+	"POST /v1/calibration/resume":            {summary: "Resume calibration", response: calibration.Status{}},
+	"POST /v1/calibration/cancel":            {summary: "Cancel calibration", response: calibration.Status{}},
+	"GET /v1/calibration/settings":           {summary: "Calibration settings", response: api.CalibrationSettings{}},
+	"PUT /v1/calibration/settings":           {summary: "Change the calibration settings that are set", request: api.CalibrationSettings{}, response: api.CalibrationSettings{}},
+	"GET /v1/calibration/schedule":           {summary: "Calibration schedule", response: api.Schedule{}},
+	"PUT /v1/calibration/schedule":           {summary: "Set the calibration schedule, empty to clear it", request: api.Schedule{}, response: api.Schedule{}},
+	"POST /v1/calibration/schedule/postpone": {summary: "Postpone the next scheduled calibration, by 1h if there is no body", request: api.DurationRequest{}, response: api.Schedule{}},
+	"POST /v1/calibration/schedule/skip":     {summary: "Skip the next scheduled calibration", response: api.Schedule{}},
+
+	"GET /config":                          {summary: "Current config", query: configQuery, response: api.Config{}, deprecated: true},
+	"GET /limit":                           {summary: "Upper charge limit", response: 0, deprecated: true},
+	"PUT /limit":                           {summary: "Set the upper charge limit", request: 0, response: "", status: http.StatusCreated, deprecated: true},
+	"PUT /disable":                         {summary: "Disable the charge limit for a duration", request: "", response: "", status: http.StatusCreated, deprecated: true},
+	"PUT /lower-limit-delta":               {summary: "Set the gap between the upper and lower limit", request: 0, response: "", status: http.StatusCreated, deprecated: true},
+	"PUT /prevent-idle-sleep":              {summary: "Set preventIdleSleep", request: false, response: "", status: http.StatusCreated, deprecated: true},
+	"PUT /disable-charging-pre-sleep":      {summary: "Set disableChargingPreSleep", request: false, response: "", status: http.StatusCreated, deprecated: true},
+	"PUT /prevent-system-sleep":            {summary: "Set preventSystemSleep", request: false, response: "", status: http.StatusCreated, deprecated: true},
+	"PUT /adapter":                         {summary: "Enable or disable the power adapter", request: false, response: "", status: http.StatusCreated, deprecated: true},
+	"PUT /adapter/disable":                 {summary: "Disable the power adapter for a duration", request: "", response: "", status: http.StatusCreated, deprecated: true},
+	"GET /adapter":                         {summary: "Whether the power adapter is enabled", response: false, deprecated: true},
+	"GET /charging":                        {summary: "Whether the battery is charging", response: false, deprecated: true},
+	"GET /battery-info":                    {summary: "Battery information", response: powerinfo.Battery{}, deprecated: true},
+	"PUT /magsafe-led":                     {summary: "Set controlMagSafeLED", request: config.ControlMagSafeMode(""), response: "", status: http.StatusCreated, deprecated: true},
+	"GET /current-charge":                  {summary: "Battery charge in percent", response: 0, deprecated: true},
+	"GET /plugged-in":                      {summary: "Whether the power adapter is plugged in", response: false, deprecated: true},
+	"GET /charging-control-capable":        {summary: "Whether charging can be controlled", response: false, deprecated: true},
+	"GET /compatibility":                   {summary: "Features supported on this Mac", response: compatibility.Capabilities{}, deprecated: true},
+	"GET /version":                         {summary: "Daemon version", response: "", deprecated: true},
+	"GET /power-telemetry":                 {summary: "Power telemetry", response: powerinfo.PowerTelemetry{}, deprecated: true},
+	"GET /telemetry":                       {summary: "Power, calibration and temperature telemetry", query: telemetryQuery, response: api.Telemetry{}, deprecated: true},
+	"GET /event":                           {summary: "Server-sent events", response: "", contentType: "text/event-stream", deprecated: true},
+	"GET /history":                         {summary: "Recorded battery history", query: historyQuery, response: []history.Sample{}, deprecated: true},
+	"GET /adaptive":                        {summary: "Adaptive charging status", response: adaptive.Status{}, deprecated: true},
+	"PUT /adaptive":                        {summary: "Set adaptiveCharging", request: false, response: "", status: http.StatusCreated, deprecated: true},
+	"POST /adaptive/reset":                 {summary: "Forget learned unplug times", response: "", status: http.StatusCreated, deprecated: true},
+	"GET /webhooks":                        {summary: "Webhooks and their delivery status", response: []webhook.Status{}, deprecated: true},
+	"PUT /webhooks":                        {summary: "Replace the webhooks", request: []config.Webhook{}, response: "", status: http.StatusCreated, deprecated: true},
+	"POST /calibration/start":              {summary: "Start calibration", response: okResponse{}, status: http.StatusCreated, deprecated: true},
+	"POST /calibration/pause":              {summary: "Pause calibration", response: okResponse{}, deprecated: true},
+	"POST /calibration/resume":             {summary: "Resume calibration", response: okResponse{}, deprecated: true},
+	"POST /calibration/cancel":             {summary: "Cancel calibration", response: okResponse{}, deprecated: true},
+	"PUT /schedule":                        {summary: "Set the calibration cron expression", request: "", response: legacyScheduleResponse{}, status: http.StatusCreated, deprecated: true},
+	"PUT /schedule/postpone":               {summary: "Postpone the next scheduled calibration", request: "", response: okResponse{}, deprecated: true},
+	"PUT /schedule/skip":                   {summary: "Skip the next scheduled calibration", response: okResponse{}, deprecated: true},
+	"PUT /calibration/discharge-threshold": {summary: "Set the calibration discharge threshold", request: 0, response: "", status: http.StatusCreated, deprecated: true},
+	"PUT /calibration/hold-duration":       {summary: "Set the calibration hold duration in minutes", request: 0, response: "", status: http.StatusCreated, deprecated: true},
+}
+
+var ginParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// newOpenAPI builds the OpenAPI document for routes. Routes without a
+// routeDoc are left out.
+func newOpenAPI(routes gin.RoutesInfo) *api.OpenAPI {
+	d := api.NewOpenAPI(api.Info{
+		Title:       "batt",
+		Description: "API of the batt daemon, served on its unix socket. Unversioned routes are deprecated in favour of /v1.",
+		Version:     version.Version,
+	})
+	for _, r := range routes {
+		doc, ok := routeDocs[r.Method+" "+r.Path]
+		if !ok {
+			logrus.Warnf("route %s %s is missing from the OpenAPI document", r.Method, r.Path)
+			continue
+		}
+		path := ginParam.ReplaceAllString(r.Path, "{$1}")
+		if d.Paths[path] == nil {
+			d.Paths[path] = api.PathItem{}
+		}
+		op := newOperation(d, r.Path, doc)
+		if r.Method != http.MethodGet {
+			op.Parameters = append(slices.Clone(op.Parameters), ifMatchParameter)
+		}
+		d.Paths[path][strings.ToLower(r.Method)] = op
+	}
+	return d
+}
+
+func newOperation(d *api.OpenAPI, path string, doc routeDoc) *api.Operation {
+	op := &api.Operation{
+		Summary:    doc.summary,
+		Deprecated: doc.deprecated,
+		Parameters: doc.query,
+		Responses:  map[string]api.Response{},
+	}
+	if doc.request != nil {
+		op.RequestBody = &api.RequestBody{
+			Required: true,
+			Content:  map[string]api.MediaType{"application/json": {Schema: d.SchemaFor(doc.request)}},
+		}
+	}
+
+	status := doc.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok := api.Response{Description: http.StatusText(status)}
+	if doc.response != nil {
+		contentType := doc.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		ok.Content = map[string]api.MediaType{contentType: {Schema: d.SchemaFor(doc.response)}}
+	}
+	op.Responses[strconv.Itoa(status)] = ok
+
+	// Legacy routes report errors as a bare message.
+	errorBody := d.SchemaFor("")
+	if strings.HasPrefix(path, "/v1/") {
+		errorBody = d.SchemaFor(api.Error{})
+	}
+	op.Responses["default"] = api.Response{
+		Description: "Error",
+		Content:     map[string]api.MediaType{"application/json": {Schema: errorBody}},
+	}
+	return op
+}
+
+// getOpenAPI serves the OpenAPI document of router.
+func getOpenAPI(router *gin.Engine) gin.HandlerFunc {
+	doc := sync.OnceValue(func() *api.OpenAPI { return newOpenAPI(router.Routes()) })
+	return func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, doc())
+	}
+}
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/api"
+)
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	router := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78}).router
+	doc := newOpenAPI(router.Routes())
+
+	registered := map[string]bool{}
+	for _, r := range router.Routes() {
+		registered[r.Method+" "+r.Path] = true
+		if doc.Paths[r.Path][strings.ToLower(r.Method)] == nil {
+			t.Errorf("%s %s is missing from the OpenAPI document, add it to routeDocs", r.Method, r.Path)
+		}
+	}
+	for key := range routeDocs {
+		if !registered[key] {
+			t.Errorf("routeDocs documents %s, which is not a route", key)
+		}
+	}
+}
+
+func TestGetOpenAPI(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78})
+
+	response := serveV1(t, d, http.MethodGet, "/openapi.json", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d; body: %s", response.Code, response.Body.String())
+	}
+	var doc api.OpenAPI
+	if err := json.Unmarshal(response.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Fatalf("openapi = %q", doc.OpenAPI)
+	}
+
+	put := doc.Paths["/v1/limit"]["put"]
+	if put == nil || put.RequestBody == nil {
+		t.Fatalf("PUT /v1/limit = %+v", put)
+	}
+	if ref := put.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/api.Limit" {
+		t.Fatalf("PUT /v1/limit response = %q", ref)
+	}
+	if ref := put.Responses["default"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/api.Error" {
+		t.Fatalf("PUT /v1/limit error = %q", ref)
+	}
+
+	limit := doc.Components.Schemas["api.Limit"]
+	if limit == nil || limit.Properties["upper"].Type != "integer" || limit.Properties["disabledUntil"].Format != "date-time" {
+		t.Fatalf("api.Limit = %+v", limit)
+	}
+	for _, name := range []string{"config.RawFileConfig", "calibration.Status", "compatibility.Capabilities", "powerinfo.Battery", "powerinfo.PowerTelemetry"} {
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+	if !doc.Paths["/limit"]["put"].Deprecated {
+		t.Error("legacy PUT /limit is not deprecated")
+	}
+}
@@ -0,0 +1,34 @@
+package daemon
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials returns the credentials of the process on the other end
+// of conn.
+func peerCredentials(conn *net.UnixConn) (peerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+	var xucred *unix.Xucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		xucred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return peerCred{}, err
+	}
+	if credErr != nil {
+		return peerCred{}, credErr
+	}
+	cred := peerCred{uid: xucred.Uid}
+	groups := xucred.Groups[:max(0, min(int(xucred.Ngroups), len(xucred.Groups)))]
+	if len(groups) > 0 {
+		cred.gid = groups[0]
+		cred.groups = groups[1:]
+	}
+	return cred, nil
+}
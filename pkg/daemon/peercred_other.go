@@ -0,0 +1,12 @@
+//go:build !darwin && !linux
+
+package daemon
+
+import (
+	"errors"
+	"net"
+)
+
+func peerCredentials(*net.UnixConn) (peerCred, error) {
+	return peerCred{}, errors.New("peer credentials are not supported on this platform")
+}
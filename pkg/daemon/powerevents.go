@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/events"
+)
+
+// publishPowerEvents publishes plug/unplug events and charging.limit_reached
+// when charging stops at the upper limit. It is called by the maintain loop
+// after the limits have been enforced.
+func (d *Daemon) publishPowerEvents(now time.Time) {
+	pluggedIn, err := d.backend.IsPluggedIn()
+	if err != nil {
+		logrus.WithError(err).Trace("skipping power events, plug state unavailable")
+		return
+	}
+	charge, err := d.backend.GetBatteryCharge()
+	if err != nil {
+		logrus.WithError(err).Trace("skipping power events, battery charge unavailable")
+		return
+	}
+
+	if d.lastPluggedIn != nil && *d.lastPluggedIn != pluggedIn {
+		name := events.PowerUnplugged
+		if pluggedIn {
+			name = events.PowerPlugged
+		}
+		d.hub.Publish(name, events.PowerEvent{PluggedIn: pluggedIn, Charge: charge, Ts: now.Unix()})
+	}
+	d.lastPluggedIn = &pluggedIn
+
+	charging, err := d.readCharging()
+	if err != nil {
+		d.lastCharging = nil
+		return
+	}
+	if upper, _ := d.effectiveLimits(); d.lastCharging != nil && *d.lastCharging && !charging && pluggedIn && upper < 100 && charge >= upper {
+		d.hub.Publish(events.ChargeLimitReached, events.ChargeLimitReachedEvent{Charge: charge, Limit: upper, Ts: now.Unix()})
+	}
+	d.lastCharging = &charging
+}
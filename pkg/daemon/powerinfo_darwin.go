@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"errors"
+	"math"
+
+	"github.com/peterneutron/powerkit-go/pkg/powerkit"
+
+	"github.com/charlie0129/batt/pkg/powerinfo"
+)
+
+func platformBatteryInfo() (*powerinfo.Battery, error) {
+	// Use powerkit-go to retrieve current system info (IOKit only is sufficient here)
+	info, err := powerkit.GetSystemInfo(powerkit.FetchOptions{QueryIOKit: true, QuerySMC: false})
+	if err != nil || info == nil || info.IOKit == nil {
+		if err == nil {
+			err = errors.New("no IOKit data available")
+		}
+		return nil, err
+	}
+
+	// Map powerkit-go data to our backwards-compatible Battery structure
+	var state powerinfo.BatteryState
+	switch {
+	case info.IOKit.State.FullyCharged:
+		state = powerinfo.Full
+	case info.IOKit.State.IsCharging:
+		state = powerinfo.Charging
+	default:
+		state = powerinfo.Discharging
+	}
+
+	// Compute charge rate (mW) using native amperage sign from IOKit
+	powerW := info.IOKit.Battery.Voltage * info.IOKit.Battery.Amperage
+	chargeRateMilliW := int(math.Round(powerW * 1000.0))
+
+	return &powerinfo.Battery{
+		State:          state,
+		DesignCapacity: info.IOKit.Battery.DesignCapacity,
+		MaxCapacity:    info.IOKit.Battery.MaxCapacity,
+		ChargeRate:     chargeRateMilliW,
+		DesignVoltage:  info.IOKit.Battery.Voltage,
+	}, nil
+}
+
+func platformPowerTelemetry() (*powerinfo.PowerTelemetry, error) {
+	// Use powerkit-go to fetch a snapshot of system power state
+	info, err := powerkit.GetSystemInfo(powerkit.FetchOptions{QueryIOKit: true, QuerySMC: false})
+	if err != nil || info == nil || info.IOKit == nil {
+		if err == nil {
+			err = errors.New("failed to fetch IOKit power data")
+		}
+		return nil, err
+	}
+
+	// Build simplified telemetry expected by the GUI
+	var snapshot powerinfo.PowerTelemetry
+	snapshot.Adapter.InputVoltage = info.IOKit.Adapter.InputVoltage
+	snapshot.Adapter.InputAmperage = info.IOKit.Adapter.InputAmperage
+
+	snapshot.Battery.CycleCount = info.IOKit.Battery.CycleCount
+
+	snapshot.Calculations.ACPower = info.IOKit.Calculations.AdapterPower
+	snapshot.Calculations.BatteryPower = info.IOKit.Calculations.BatteryPower
+	snapshot.Calculations.SystemPower = info.IOKit.Calculations.SystemPower
+	snapshot.Calculations.HealthByMaxCapacity = info.IOKit.Calculations.HealthByMaxCapacity
+
+	return &snapshot, nil
+}
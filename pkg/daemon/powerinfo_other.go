@@ -0,0 +1,19 @@
+//go:build !darwin
+
+package daemon
+
+import (
+	"errors"
+
+	"github.com/charlie0129/batt/pkg/powerinfo"
+)
+
+var errNoPlatformPowerInfo = errors.New("power info is not available from this charge backend")
+
+func platformBatteryInfo() (*powerinfo.Battery, error) {
+	return nil, errNoPlatformPowerInfo
+}
+
+func platformPowerTelemetry() (*powerinfo.PowerTelemetry, error) {
+	return nil, errNoPlatformPowerInfo
+}
@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/config"
+)
+
+// limitProfileWindow is a parsed config.LimitProfile.
+type limitProfileWindow struct {
+	profile  config.LimitProfile
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+func parseLimitProfile(p config.LimitProfile) (*limitProfileWindow, error) {
+	if p.Name == "" {
+		return nil, fmt.Errorf("limit profile must have a name")
+	}
+	if p.Limit < 10 || p.Limit > 100 {
+		return nil, fmt.Errorf("limit profile %q: limit must be between 10 and 100, got %d", p.Name, p.Limit)
+	}
+	schedule, err := cronParser.Parse(p.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("limit profile %q: invalid schedule %q: %w", p.Name, p.Schedule, err)
+	}
+	d, err := time.ParseDuration(p.Duration)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("limit profile %q: invalid duration %q: expected a positive duration such as 9h", p.Name, p.Duration)
+	}
+	return &limitProfileWindow{profile: p, schedule: schedule, duration: d}, nil
+}
+
+// activeAt reports whether a window started in (now-duration, now].
+func (w *limitProfileWindow) activeAt(now time.Time) bool {
+	return !w.schedule.Next(now.Add(-w.duration)).After(now)
+}
+
+// resolveLimitProfile returns the first profile whose window covers now, or
+// nil if none does. Invalid profiles are skipped.
+func resolveLimitProfile(profiles []config.LimitProfile, now time.Time) *config.LimitProfile {
+	for _, p := range profiles {
+		w, err := parseLimitProfile(p)
+		if err != nil {
+			logrus.WithError(err).Debug("skipping invalid limit profile")
+			continue
+		}
+		if w.activeAt(now) {
+			return &w.profile
+		}
+	}
+	return nil
+}
+
+// updateLimitProfile resolves the profile active at now and logs transitions.
+func (d *Daemon) updateLimitProfile(now time.Time) {
+	p := resolveLimitProfile(d.conf.LimitProfiles(), now)
+
+	d.limitProfileMu.Lock()
+	defer d.limitProfileMu.Unlock()
+
+	switch {
+	case p == nil && d.activeLimitProfile != nil:
+		logrus.WithField("profile", d.activeLimitProfile.Name).Info("limit profile ended, using configured limit")
+	case p != nil && (d.activeLimitProfile == nil || *d.activeLimitProfile != *p):
+		logrus.WithFields(logrus.Fields{"profile": p.Name, "limit": p.Limit}).Info("limit profile activated")
+	}
+	d.activeLimitProfile = p
+}
+
+// currentLimitProfile returns the profile whose window is active, or nil.
+func (d *Daemon) currentLimitProfile() *config.LimitProfile {
+	d.limitProfileMu.Lock()
+	defer d.limitProfileMu.Unlock()
+	return d.activeLimitProfile
+}
+
+// enforcedLimitProfile returns the active profile unless a temporary
+// disable, which takes precedence over profiles, is pending.
+func (d *Daemon) enforcedLimitProfile() *config.LimitProfile {
+	if !d.conf.DisableUntil().IsZero() {
+		return nil
+	}
+	return d.currentLimitProfile()
+}
+
+// profileLimits returns the upper and lower limit after applying the
+// enforced profile, which replaces the upper limit and keeps the configured
+// lower limit delta.
+func (d *Daemon) profileLimits() (upper, lower int) {
+	upper, lower = d.conf.UpperLimit(), d.conf.LowerLimit()
+	p := d.enforcedLimitProfile()
+	if p == nil {
+		return upper, lower
+	}
+	return p.Limit, max(p.Limit-(upper-lower), 0)
+}
+
+// effectiveLimits returns the upper and lower limit to enforce: the profile
+// limits, raised to 100% while adaptive charging tops up, and capped while
+// the temperature guard is active.
+func (d *Daemon) effectiveLimits() (upper, lower int) {
+	upper, lower = d.profileLimits()
+	delta := upper - lower
+	if d.adaptiveChargingToppingUp() {
+		upper = 100
+	}
+	if limit, ok := d.temperatureGuardCap(); ok && limit < upper {
+		upper, lower = limit, max(limit-delta, 0)
+	}
+	return upper, lower
+}
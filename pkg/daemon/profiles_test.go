@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/config"
+)
+
+var testLimitProfiles = []config.LimitProfile{
+	{Name: "desk", Limit: 60, Schedule: "0 9 * * MON-FRI", Duration: "9h"},
+	{Name: "travel", Limit: 100, Schedule: "0 18 * * SUN", Duration: "8h"},
+}
+
+func TestResolveLimitProfile(t *testing.T) {
+	// 2026-10-19 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		profiles []config.LimitProfile
+		now      time.Time
+		want     string
+	}{
+		{name: "weekday morning", profiles: testLimitProfiles, now: at(19, 8, 59), want: ""},
+		{name: "window start", profiles: testLimitProfiles, now: at(19, 9, 0), want: "desk"},
+		{name: "weekday afternoon", profiles: testLimitProfiles, now: at(23, 17, 59), want: "desk"},
+		{name: "window end is exclusive", profiles: testLimitProfiles, now: at(19, 18, 0), want: ""},
+		{name: "saturday", profiles: testLimitProfiles, now: at(24, 12, 0), want: ""},
+		{name: "sunday evening", profiles: testLimitProfiles, now: at(18, 21, 0), want: "travel"},
+		{name: "window crosses midnight", profiles: testLimitProfiles, now: at(19, 0, 30), want: "travel"},
+		{
+			name: "first match wins",
+			profiles: []config.LimitProfile{
+				{Name: "all-day", Limit: 70, Schedule: "@daily", Duration: "24h"},
+				testLimitProfiles[0],
+			},
+			now:  at(19, 10, 0),
+			want: "all-day",
+		},
+		{
+			name: "invalid profiles are skipped",
+			profiles: []config.LimitProfile{
+				{Name: "bad-schedule", Limit: 70, Schedule: "every day", Duration: "24h"},
+				{Name: "bad-duration", Limit: 70, Schedule: "@daily", Duration: "-1h"},
+				{Name: "bad-limit", Limit: 5, Schedule: "@daily", Duration: "24h"},
+				testLimitProfiles[0],
+			},
+			now:  at(19, 10, 0),
+			want: "desk",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if p := resolveLimitProfile(tt.profiles, tt.now); p != nil {
+				got = p.Name
+			}
+			if got != tt.want {
+				t.Fatalf("resolveLimitProfile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEffectiveLimits(t *testing.T) {
+	mc := &mockConf{upper: 80, lower: 75, limitProfiles: testLimitProfiles}
+	d := newTestDaemon(t, nil, mc)
+
+	// Monday 10:00 local time.
+	d.updateLimitProfile(time.Date(2026, time.October, 19, 10, 0, 0, 0, time.Local))
+	if upper, lower := d.effectiveLimits(); upper != 60 || lower != 55 {
+		t.Fatalf("effectiveLimits() = %d/%d, want 60/55", upper, lower)
+	}
+
+	recorder := httptest.NewRecorder()
+	d.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/config", nil))
+	var raw api.Config
+	if err := json.Unmarshal(recorder.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.ActiveLimitProfile == nil || *raw.ActiveLimitProfile != "desk" || len(raw.LimitProfiles) != 2 {
+		t.Fatalf("unexpected /config profiles: active=%v profiles=%+v", raw.ActiveLimitProfile, raw.LimitProfiles)
+	}
+
+	// A temporary disable takes precedence over the profile.
+	mc.disableUntil = time.Now().Add(time.Hour)
+	if upper, lower := d.effectiveLimits(); upper != 80 || lower != 75 {
+		t.Fatalf("effectiveLimits() while disabled = %d/%d, want 80/75", upper, lower)
+	}
+	mc.disableUntil = time.Time{}
+
+	d.updateLimitProfile(time.Date(2026, time.October, 19, 20, 0, 0, 0, time.Local))
+	if upper, lower := d.effectiveLimits(); upper != 80 || lower != 75 {
+		t.Fatalf("effectiveLimits() outside windows = %d/%d, want 80/75", upper, lower)
+	}
+}
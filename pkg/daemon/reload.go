@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
+)
+
+// configWatchInterval is how often the config file is checked for changes.
+const configWatchInterval = 2 * time.Second
+
+const (
+	reloadTriggerFile   = "file"
+	reloadTriggerSignal = "signal"
+)
+
+// watchConfigFile reloads the config whenever the file at path changes,
+// until ctx is done. The daemon's own saves are not changes, see
+// config.File.Modified.
+func (d *Daemon) watchConfigFile(ctx context.Context, f *config.File, path string) {
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if f.Modified() {
+				d.reloadConfig(path, reloadTriggerFile)
+			}
+		}
+	}
+}
+
+// reloadConfig loads the config file at path again and applies it. If the
+// file cannot be loaded or is not valid, the current config is kept. Either
+// way, the outcome is published as an event.
+func (d *Daemon) reloadConfig(path, trigger string) {
+	// Do not interleave with API requests changing the config.
+	d.mutationMu.Lock()
+	defer d.mutationMu.Unlock()
+
+	before, _ := d.conf.Raw()
+	if err := d.conf.Load(); err != nil {
+		logReloadError(err)
+		ev := events.ConfigReloadFailedEvent{Path: path, Trigger: trigger, Error: err.Error(), Ts: d.clock.Now().Unix()}
+		var errs config.FieldErrors
+		if errors.As(err, &errs) {
+			ev.Invalid = map[string]string{}
+			for _, e := range errs {
+				ev.Invalid[e.Path] = e.Reason
+			}
+		}
+		d.hub.Publish(events.ConfigReloadFailed, ev)
+		return
+	}
+
+	d.disableUnsupportedConfiguredFeatures()
+	d.checkAccess()
+	after, _ := d.conf.Raw()
+	logrus.WithField("trigger", trigger).WithFields(d.conf.LogrusFields()).Info("config reloaded")
+	d.hub.Publish(events.ConfigReloaded, events.ConfigReloadedEvent{Path: path, Trigger: trigger, Ts: d.clock.Now().Unix()})
+	d.reloadChangedConfig(before, after)
+}
+
+// logReloadError logs why the config was not reloaded, with a line for every
+// invalid setting.
+func logReloadError(err error) {
+	var errs config.FieldErrors
+	if !errors.As(err, &errs) {
+		logrus.Errorf("failed to reload config: %v", err)
+		return
+	}
+	for _, e := range errs {
+		logrus.WithField("path", e.Path).Errorf("invalid setting: %s", e.Reason)
+	}
+	logrus.Error("config file is not valid, keeping the current config")
+}
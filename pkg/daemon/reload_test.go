@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
+)
+
+func TestReloadConfig(t *testing.T) {
+	backend, _ := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "60",
+		"BAT0/status":                       "Charging",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+	path := filepath.Join(t.TempDir(), "batt.json")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"version": 2, "limit": 80}`)
+	file, err := config.NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDaemon(t, backend, file)
+	ch := subscribe(t, d)
+	// reloadEvent returns the config event published by a reload, skipping
+	// the ones of the maintain loop.
+	reloadEvent := func() events.Event {
+		t.Helper()
+		for {
+			select {
+			case ev := <-ch:
+				if ev.Name == events.ConfigReloaded || ev.Name == events.ConfigReloadFailed {
+					return ev
+				}
+			default:
+				t.Fatal("no config event published")
+			}
+		}
+	}
+
+	if file.Modified() {
+		t.Fatal("Modified() right after loading")
+	}
+	write(`{"version": 2, "limit": 70}`)
+	if !file.Modified() {
+		t.Fatal("Modified() = false after the file changed")
+	}
+	d.reloadConfig(path, reloadTriggerFile)
+	ev := reloadEvent()
+	payload, err := events.DecodeAs[events.ConfigReloadedEvent](ev)
+	if err != nil || ev.Name != events.ConfigReloaded || payload.Trigger != reloadTriggerFile || payload.Path != path {
+		t.Fatalf("event = %s %s, %v", ev.Name, ev.Data, err)
+	}
+	if d.conf.UpperLimit() != 70 {
+		t.Fatalf("limit = %d after reload, want 70", d.conf.UpperLimit())
+	}
+
+	// The daemon's own writes do not trigger a reload.
+	if err := d.conf.SetUpperLimit(75); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.conf.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if file.Modified() {
+		t.Fatal("Modified() = true after Save()")
+	}
+
+	write(`{"version": 2, "limit": 5}`)
+	d.reloadConfig(path, reloadTriggerSignal)
+	ev = reloadEvent()
+	failed, err := events.DecodeAs[events.ConfigReloadFailedEvent](ev)
+	if err != nil || ev.Name != events.ConfigReloadFailed || failed.Invalid["limit"] == "" {
+		t.Fatalf("event = %s %s, %v", ev.Name, ev.Data, err)
+	}
+	if d.conf.UpperLimit() != 75 {
+		t.Fatalf("limit = %d after an invalid reload, want 75", d.conf.UpperLimit())
+	}
+	if file.Modified() {
+		t.Fatal("Modified() = true for a file that failed to load")
+	}
+}
+
+func TestReloadConfigReschedulesCalibration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batt.json")
+	if err := os.WriteFile(path, []byte(`{"version": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := config.NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, clk := newTestDaemonWithClock(t, newFakeSMC(60, 1, true), file)
+	t.Cleanup(d.scheduler.Stop)
+
+	if err := os.WriteFile(path, []byte(`{"version": 2, "cron": "0 10 1 * *"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.reloadConfig(path, reloadTriggerSignal)
+
+	next, running := d.scheduler.Status()
+	want := time.Date(2026, time.August, 1, 10, 0, 0, 0, clk.Now().Location())
+	if !running || !next.Equal(want) {
+		t.Fatalf("scheduler next run = %s, running = %t after reload, want %s", next, running, want)
+	}
+}
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/smc"
+)
+
+// replaySMCTrace returns a daemon with the settings of c whose charge backend
+// replays the trace in testdata/name.
+func replaySMCTrace(t *testing.T, name string, c config.Config) (*Daemon, *smc.Replay) {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	trace, err := smc.ReadTrace(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The traces are from Apple Silicon Macs. Tests also run elsewhere, where
+	// some keys have other names.
+	arm64Keys := map[string]string{"BUIC": smc.BatteryChargeKey, "CH0I": smc.AdapterKey1}
+	for i := range trace {
+		if key, ok := arm64Keys[trace[i].Key]; ok {
+			trace[i].Key = key
+		}
+	}
+
+	replay := smc.NewReplay(trace)
+	backend := replay.SMC()
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return newTestDaemon(t, backend, c), replay
+}
+
+// runReplay runs the maintain loop until the trace is played back.
+func runReplay(t *testing.T, d *Daemon, replay *smc.Replay) {
+	t.Helper()
+	for i := 0; !replay.Done(); i++ {
+		if i == 100 {
+			t.Fatal("the trace is not played back after 100 loops")
+		}
+		d.maintainLoopInner(true)
+	}
+}
+
+// TestReplayLegacyCharging replays a session on a Mac with legacy charge
+// control, limited to 80-75%: the charge rises to 80% while plugged in,
+// charging is disabled, then it is unplugged and drops to 74%, where
+// charging is enabled again before it is plugged back in.
+func TestReplayLegacyCharging(t *testing.T) {
+	d, replay := replaySMCTrace(t, "legacy-charging.smctrace.jsonl", &mockConf{upper: 80, lower: 75})
+	ch := subscribe(t, d)
+
+	runReplay(t, d, replay)
+	if err := replay.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for len(ch) > 0 {
+		names = append(names, (<-ch).Name)
+	}
+	want := []string{events.ChargeLimitReached, events.PowerUnplugged, events.PowerPlugged}
+	if !slices.Equal(names, want) {
+		t.Fatalf("events = %v, want %v", names, want)
+	}
+}
+
+func TestReplayReportsOtherWrites(t *testing.T) {
+	// With a higher limit, charging is never disabled at 80%.
+	d, replay := replaySMCTrace(t, "legacy-charging.smctrace.jsonl", &mockConf{upper: 90, lower: 85})
+
+	runReplay(t, d, replay)
+	err := replay.Verify()
+	if err == nil || !strings.Contains(err.Error(), "missing write of 02 to CH0B") {
+		t.Fatalf("Verify() = %v, want the missing write that disables charging", err)
+	}
+}
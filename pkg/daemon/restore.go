@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/persist"
+)
+
+// restoreReporter publishes a file.restored event whenever the config or a
+// state file of a daemon is read from its backup. The events from startup are
+// held back until hooks and webhooks can receive them.
+type restoreReporter struct {
+	mu sync.Mutex
+	// held holds the events until publish is called. It is nil afterwards.
+	held []events.FileRestoredEvent
+	// hub is nil until publish is called.
+	hub *events.EventHub
+}
+
+// readOptions returns the options to read the files of the daemon with.
+func (r *restoreReporter) readOptions() persist.ReadOptions {
+	return persist.ReadOptions{OnRestore: r.report}
+}
+
+func (r *restoreReporter) report(path string, err error) {
+	ev := events.FileRestoredEvent{Path: path, Error: err.Error(), Ts: time.Now().Unix()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hub == nil {
+		r.held = append(r.held, ev)
+		return
+	}
+	r.hub.Publish(events.FileRestored, ev)
+}
+
+// publish publishes the events held back so far on hub, and later ones as
+// they happen. Call it once hooks and webhooks subscribe to hub.
+func (r *restoreReporter) publish(hub *events.EventHub) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hub = hub
+	for _, ev := range r.held {
+		hub.Publish(events.FileRestored, ev)
+	}
+	r.held = nil
+}
@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/persist"
+	"github.com/charlie0129/batt/pkg/smc"
+)
+
+func TestRestoredFilesArePerDaemon(t *testing.T) {
+	// persist reports the path with symlinks resolved.
+	damaged, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	intact := t.TempDir()
+	st, err := json.Marshal(calibration.State{Phase: calibration.PhaseIdle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(damaged, "batt.state.json")
+	if err := persist.WriteFile(statePath, st, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(statePath, []byte(`{"phase":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(Options{Backend: smc.NewMock(nil), Config: &mockConf{upper: 80, lower: 75}, StateDir: damaged})
+	b := New(Options{Backend: smc.NewMock(nil), Config: &mockConf{upper: 80, lower: 75}, StateDir: intact})
+	chA, chB := subscribe(t, a), subscribe(t, b)
+	// Publishing on one daemon must not take the events of the other.
+	b.restored.publish(b.hub)
+	a.restored.publish(a.hub)
+
+	select {
+	case ev := <-chA:
+		var restored events.FileRestoredEvent
+		if err := json.Unmarshal(ev.Data, &restored); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Name != events.FileRestored || restored.Path != statePath {
+			t.Fatalf("event = %s %+v, want %s for %s", ev.Name, restored, events.FileRestored, statePath)
+		}
+	default:
+		t.Fatal("the daemon with the damaged file did not report it")
+	}
+	select {
+	case ev := <-chB:
+		t.Fatalf("the other daemon got %s %s", ev.Name, ev.Data)
+	default:
+	}
+}
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/api"
+)
+
+// configETag returns the ETag of config revision rev.
+func (d *Daemon) configETag(rev uint64) string {
+	return `"` + d.etagPrefix + "-" + strconv.FormatUint(rev, 10) + `"`
+}
+
+// ifMatch reports whether the If-Match header of c, if any, matches config
+// revision rev.
+func (d *Daemon) ifMatch(c *gin.Context, rev uint64) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	etag := d.configETag(rev)
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed is the error for a request whose If-Match is stale.
+func (d *Daemon) preconditionFailed(rev uint64) error {
+	return api.Errorf(api.CodePreconditionFailed, "the config has changed since it was read, read it again and retry").
+		WithDetail("etag", d.configETag(rev))
+}
+
+// checkRevision implements optimistic concurrency for mutating requests.
+// Requests with a stale If-Match get a 412, and every response carries the
+// ETag of the config revision after the change.
+func (d *Daemon) checkRevision(c *gin.Context) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return
+	}
+
+	d.mutationMu.Lock()
+	defer d.mutationMu.Unlock()
+
+	c.Writer = &etagWriter{ResponseWriter: c.Writer, d: d}
+	if rev := d.conf.Revision(); !d.ifMatch(c, rev) {
+		logrus.WithFields(logrus.Fields{
+			"method":  c.Request.Method,
+			"path":    c.Request.URL.Path,
+			"ifMatch": c.GetHeader("If-Match"),
+		}).Info("rejected API request with a stale If-Match")
+		abortWithError(c, d.preconditionFailed(rev))
+		return
+	}
+	c.Next()
+}
+
+// etagWriter sets the ETag of the config revision when the response status
+// is written, i.e. after the handler made its changes.
+type etagWriter struct {
+	gin.ResponseWriter
+	d *Daemon
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	w.Header().Set("ETag", w.d.configETag(w.d.conf.Revision()))
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -7,6 +7,10 @@ import (
 
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/clock"
+	"github.com/charlie0129/batt/pkg/config"
 )
 
 const (
@@ -16,6 +20,9 @@ const (
 	preCheckInterval = time.Second * 10
 )
 
+// cronParser parses calibration schedules and limit profile windows.
+var cronParser = config.CronParser
+
 type NotifyFunc func(data any)
 
 // TaskFunc represents a runnable task.
@@ -28,6 +35,7 @@ type Scheduler struct {
 	PreCheck   TaskFunc   // health / condition check callback
 
 	parser cron.Parser
+	clock  clock.Clock
 
 	schedule cron.Schedule
 	nextRun  time.Time
@@ -54,7 +62,9 @@ type controlMsg struct {
 	data any
 }
 
-func NewScheduler(task, preCheck TaskFunc, onUpcoming, onError NotifyFunc) *Scheduler {
+// NewScheduler returns a stopped scheduler that runs task on the schedule,
+// timed by c.
+func NewScheduler(c clock.Clock, task, preCheck TaskFunc, onUpcoming, onError NotifyFunc) *Scheduler {
 	if task == nil {
 		panic("task function cannot be nil")
 	}
@@ -64,7 +74,8 @@ func NewScheduler(task, preCheck TaskFunc, onUpcoming, onError NotifyFunc) *Sche
 		OnError:    onError,
 		Task:       task,
 		PreCheck:   preCheck,
-		parser:     cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor),
+		parser:     cronParser,
+		clock:      c,
 		controlCh:  make(chan controlMsg, 4),
 		stopCh:     make(chan struct{}),
 	}
@@ -99,7 +110,7 @@ func (s *Scheduler) Schedule(cronExpr string) error {
 	running := s.running
 	if !running {
 		s.schedule = sh
-		s.nextRun = sh.Next(time.Now())
+		s.nextRun = sh.Next(s.clock.Now())
 	}
 	s.mu.Unlock()
 
@@ -118,13 +129,13 @@ func (s *Scheduler) HandleWakeUp() {
 // Postpone postpones the next scheduled run by the given duration.
 func (s *Scheduler) Postpone(d time.Duration) error {
 	if d <= 0 {
-		return fmt.Errorf("postpone duration must be positive")
+		return api.Errorf(api.CodeInvalidArgument, "postpone duration must be positive")
 	}
 
 	s.mu.Lock()
 	if s.schedule == nil || s.nextRun.IsZero() {
 		s.mu.Unlock()
-		return fmt.Errorf("no active schedule to postpone")
+		return api.Errorf(api.CodeConflict, "no active schedule to postpone")
 	}
 	orig := s.nextRun
 	next := s.schedule.Next(orig).Truncate(time.Second)
@@ -132,12 +143,12 @@ func (s *Scheduler) Postpone(d time.Duration) error {
 	s.mu.Unlock()
 
 	if !running {
-		return fmt.Errorf("no active schedule to postpone")
+		return api.Errorf(api.CodeConflict, "no active schedule to postpone")
 	}
 
 	pp := orig.Add(d).Truncate(time.Second)
 	if pp.Compare(next) >= 0 {
-		return fmt.Errorf("postpone duration too long")
+		return api.Errorf(api.CodeInvalidArgument, "postpone duration too long")
 	}
 
 	s.trySendControl(ctrlPostpone, pp)
@@ -149,7 +160,7 @@ func (s *Scheduler) Skip() error {
 	s.mu.Lock()
 	if s.schedule == nil || s.nextRun.IsZero() {
 		s.mu.Unlock()
-		return fmt.Errorf("no active schedule to skip")
+		return api.Errorf(api.CodeConflict, "no active schedule to skip")
 	}
 	next := s.schedule.Next(s.nextRun)
 	if !s.running {
@@ -189,20 +200,20 @@ func (s *Scheduler) runScheduled() {
 		var precheckErr error
 
 		schedule, nextRun := s.snapshot()
-		var timer *time.Timer
+		var timer clock.Timer
 		if schedule == nil || nextRun.IsZero() {
-			timer = time.NewTimer(time.Hour * 10000)
+			timer = s.clock.NewTimer(time.Hour * 10000)
 		} else {
-			wait := time.Until(nextRun) - leadDuration
+			wait := nextRun.Sub(s.clock.Now()) - leadDuration
 			if wait < 0 {
 				wait = 0
 			}
-			timer = time.NewTimer(wait)
+			timer = s.clock.NewTimer(wait)
 		}
 
 		for {
 			select {
-			case <-timer.C:
+			case <-timer.C():
 				if schedule == nil || nextRun.IsZero() {
 					break
 				}
@@ -210,13 +221,13 @@ func (s *Scheduler) runScheduled() {
 				if leading {
 					logrus.Debugf("upcoming scheduled task at %s", nextRun.Format(time.DateTime))
 					leading = false
-					runWait := time.Until(nextRun)
+					runWait := nextRun.Sub(s.clock.Now())
 					if runWait <= 0 {
 						runWait = overdueTaskDelay
 						logrus.Debugf("task overdue/due, delaying %s", runWait)
 					}
 					timer.Reset(runWait)
-					s.sendNotify(time.Now().Add(runWait))
+					s.sendNotify(s.clock.Now().Add(runWait))
 					continue
 				}
 
@@ -268,18 +279,18 @@ func (s *Scheduler) runScheduled() {
 					sh := msg.data.(cron.Schedule)
 					s.mu.Lock()
 					s.schedule = sh
-					s.nextRun = sh.Next(time.Now())
+					s.nextRun = sh.Next(s.clock.Now())
 					s.mu.Unlock()
 				case ctrlPostpone: // only postpone current run
 					pp := msg.data.(time.Time)
-					timer.Reset(time.Until(pp))
+					timer.Reset(pp.Sub(s.clock.Now()))
 					continue
 				case ctrlSkip:
 					timer.Stop()
 				case ctrlWake:
 					if !timer.Stop() {
 						select {
-						case <-timer.C:
+						case <-timer.C():
 						default:
 						}
 					}
@@ -293,7 +304,7 @@ func (s *Scheduler) runScheduled() {
 					} else {
 						target = nextRun
 					}
-					wait := time.Until(target)
+					wait := target.Sub(s.clock.Now())
 					if wait <= 0 {
 						wait = overdueTaskDelay
 						logrus.Debugf("system woke up, task overdue/due, delaying %s", wait)
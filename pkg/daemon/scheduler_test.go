@@ -2,11 +2,14 @@ package daemon
 
 import (
 	"errors"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/robfig/cron/v3"
+
+	"github.com/charlie0129/batt/pkg/clock"
 )
 
 func TestCronParse(t *testing.T) {
@@ -28,7 +31,7 @@ func TestCronParse(t *testing.T) {
 }
 
 func TestSchedulerScheduleStatus(t *testing.T) {
-	s := NewScheduler(func() error { return nil }, nil, nil, nil)
+	s := NewScheduler(clock.Real(), func() error { return nil }, nil, nil, nil)
 
 	if err := s.Schedule("@every 1m"); err != nil {
 		t.Fatalf("Schedule returned error: %v", err)
@@ -44,7 +47,7 @@ func TestSchedulerScheduleStatus(t *testing.T) {
 }
 
 func TestSchedulerSkip(t *testing.T) {
-	s := NewScheduler(func() error { return nil }, nil, nil, nil)
+	s := NewScheduler(clock.Real(), func() error { return nil }, nil, nil, nil)
 	if err := s.Schedule("@every 10m"); err != nil {
 		t.Fatalf("Schedule returned error: %v", err)
 	}
@@ -64,11 +67,26 @@ func TestSchedulerSkip(t *testing.T) {
 	}
 }
 
+// newFakeClockScheduler returns a scheduler of task, set to run daily at
+// 10:00, on a fake clock at 9:00.
+func newFakeClockScheduler(t *testing.T, task, preCheck TaskFunc, onUpcoming, onError NotifyFunc) (*Scheduler, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, time.July, 20, 9, 0, 0, 0, time.Local))
+	s := NewScheduler(clk, task, preCheck, onUpcoming, onError)
+	if err := s.Schedule("0 10 * * *"); err != nil {
+		t.Fatalf("Schedule returned error: %v", err)
+	}
+	s.Start()
+	t.Cleanup(s.Stop)
+	clk.WaitForTimers(1)
+	return s, clk
+}
+
 func TestSchedulerRunCycle(t *testing.T) {
-	notifyCh := make(chan struct{}, 1)
+	notifyCh := make(chan time.Time, 1)
 	taskCh := make(chan struct{}, 1)
 	errCh := make(chan error, 1)
-	var preChecks int32
+	var preChecks atomic.Int32
 
 	task := func() error {
 		taskCh <- struct{}{}
@@ -76,12 +94,12 @@ func TestSchedulerRunCycle(t *testing.T) {
 	}
 
 	preCheck := func() error {
-		atomic.AddInt32(&preChecks, 1)
+		preChecks.Add(1)
 		return nil
 	}
 
 	beforeRun := func(data any) {
-		notifyCh <- struct{}{}
+		notifyCh <- data.(time.Time)
 	}
 
 	onError := func(data any) {
@@ -90,32 +108,24 @@ func TestSchedulerRunCycle(t *testing.T) {
 		}
 	}
 
-	s := NewScheduler(task, preCheck, beforeRun, onError)
-	if err := s.Schedule("@every 1s"); err != nil {
-		t.Fatalf("Schedule returned error: %v", err)
-	}
+	s, clk := newFakeClockScheduler(t, task, preCheck, beforeRun, onError)
+	runAt := clk.Now().Add(time.Hour)
 
-	s.mu.Lock()
-	s.nextRun = time.Now().Add(50 * time.Millisecond)
-	s.mu.Unlock()
-
-	s.Start()
-	defer s.Stop()
-
-	select {
-	case <-notifyCh:
-	case <-time.After(time.Second):
-		t.Fatalf("did not receive before-run notification in time")
+	clk.Advance(time.Hour - leadDuration)
+	if got := <-notifyCh; !got.Equal(runAt) {
+		t.Fatalf("before-run notification for %s, want %s", got, runAt)
 	}
 
-	select {
-	case <-taskCh:
-	case <-time.After(2 * time.Second):
-		t.Fatalf("task did not execute in time")
-	}
+	clk.WaitForTimer(runAt)
+	clk.Advance(leadDuration)
+	<-taskCh
 
-	if atomic.LoadInt32(&preChecks) == 0 {
-		t.Fatalf("precheck should have been executed")
+	if preChecks.Load() != 1 {
+		t.Fatalf("prechecks = %d, want 1", preChecks.Load())
+	}
+	clk.WaitForTimer(runAt.Add(24*time.Hour - leadDuration))
+	if next, _ := s.Status(); !next.Equal(runAt.Add(24 * time.Hour)) {
+		t.Fatalf("next run = %s, want the next day", next)
 	}
 
 	select {
@@ -126,48 +136,82 @@ func TestSchedulerRunCycle(t *testing.T) {
 }
 
 func TestSchedulerPreCheckFailure(t *testing.T) {
-	taskCh := make(chan struct{}, 1)
-	errCh := make(chan error, 2)
+	var tasks, preChecks atomic.Int32
+	errCh := make(chan error, 1)
 
 	task := func() error {
-		taskCh <- struct{}{}
+		tasks.Add(1)
 		return nil
 	}
 
 	preCheck := func() error {
+		preChecks.Add(1)
 		return errors.New("boom")
 	}
 
 	onError := func(data any) {
 		if err, ok := data.(error); ok {
-			errCh <- err
+			select {
+			case errCh <- err:
+			default:
+			}
 		}
 	}
 
-	s := NewScheduler(task, preCheck, nil, onError)
-	if err := s.Schedule("@every 1s"); err != nil {
-		t.Fatalf("Schedule returned error: %v", err)
+	s, clk := newFakeClockScheduler(t, task, preCheck, nil, onError)
+	runAt := clk.Now().Add(time.Hour)
+
+	clk.Advance(time.Hour - leadDuration)
+	clk.WaitForTimer(runAt)
+	clk.Advance(leadDuration)
+	if err := <-errCh; !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("error = %v, want the precheck error", err)
 	}
 
-	forcedNext := time.Now().Add(50 * time.Millisecond)
+	// The precheck is retried, then the run is given up.
+	for range preCheckMaxTimes {
+		clk.WaitForTimer(clk.Now().Add(preCheckInterval))
+		clk.Advance(preCheckInterval)
+	}
+	clk.WaitForTimer(runAt.Add(24*time.Hour - leadDuration))
+	if got := preChecks.Load(); got != preCheckMaxTimes+1 {
+		t.Fatalf("prechecks = %d, want %d", got, preCheckMaxTimes+1)
+	}
+	if tasks.Load() != 0 {
+		t.Fatal("task should not execute when precheck fails")
+	}
+	if next, _ := s.Status(); !next.Equal(runAt.Add(24 * time.Hour)) {
+		t.Fatalf("next run = %s, want the next day", next)
+	}
+}
 
-	s.mu.Lock()
-	s.nextRun = forcedNext
-	s.mu.Unlock()
+func TestSchedulerPostpone(t *testing.T) {
+	taskCh := make(chan struct{}, 1)
+	task := func() error {
+		taskCh <- struct{}{}
+		return nil
+	}
 
-	s.Start()
-	defer s.Stop()
+	s, clk := newFakeClockScheduler(t, task, nil, nil, nil)
+	runAt := clk.Now().Add(time.Hour)
 
-	select {
-	case <-errCh:
-	case <-time.After(time.Second):
-		t.Fatalf("expected error callback from failed precheck")
+	clk.Advance(time.Hour - leadDuration)
+	clk.WaitForTimer(runAt)
+	if err := s.Postpone(30 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Postpone(24 * time.Hour); err == nil {
+		t.Fatal("postponed past the next run")
 	}
 
+	clk.WaitForTimer(runAt.Add(30 * time.Minute))
+	clk.Advance(leadDuration)
 	select {
 	case <-taskCh:
-		t.Fatalf("task should not execute when precheck fails")
+		t.Fatal("postponed task ran at its scheduled time")
 	default:
 	}
 
+	clk.Advance(30 * time.Minute)
+	<-taskCh
 }
@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/charlie0129/batt/pkg/audit"
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/clock"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/powerinfo"
+	"github.com/charlie0129/batt/pkg/simulator"
+	"github.com/charlie0129/batt/pkg/smc"
+)
+
+// simulatedBackend is the SMC of a simulated battery, which also reports
+// the battery info that IOKit would.
+type simulatedBackend struct {
+	*smc.AppleSMC
+	battery *simulator.Battery
+}
+
+var _ powerInfoReader = (*simulatedBackend)(nil)
+
+func (b *simulatedBackend) BatteryInfo() (*powerinfo.Battery, error) {
+	return b.battery.BatteryInfo()
+}
+
+func (b *simulatedBackend) PowerTelemetry() (*powerinfo.PowerTelemetry, error) {
+	return b.battery.PowerTelemetry()
+}
+
+// simulatedConfig keeps a simulation from writing the config file and from
+// holding sleep assertions.
+type simulatedConfig struct {
+	config.Config
+}
+
+func (simulatedConfig) Save() error              { return nil }
+func (simulatedConfig) PreventSystemSleep() bool { return false }
+
+// Simulate runs the maintain loop and calibration with the settings of c
+// against the simulated battery of sc, on a virtual clock that advances a
+// step of sc per loop, and returns the timeline. Calibrations start at the
+// times of sc and of the cron schedule of c. Changes to the settings, such
+// as those calibration makes, are not saved.
+func Simulate(c config.Config, sc *simulator.Scenario) ([]simulator.Sample, error) {
+	battery, err := simulator.NewBattery(sc.Battery)
+	if err != nil {
+		return nil, err
+	}
+	backend := &simulatedBackend{AppleSMC: smc.NewWithConn(battery), battery: battery}
+	if err := backend.Open(); err != nil {
+		return nil, err
+	}
+	var schedule cron.Schedule
+	if expr := c.Cron(); expr != "" {
+		if schedule, err = config.CronParser.Parse(expr); err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %w", err)
+		}
+	}
+
+	clk := clock.NewFake(sc.Start)
+	hub := events.NewEventHub()
+	ch := hub.Subscribe()
+	d := New(Options{
+		Backend: backend,
+		Config:  simulatedConfig{c},
+		Clock:   clk,
+		Hub:     hub,
+	})
+	d.preventCalibrationSleep = func() error { return nil }
+	d.allowCalibrationSleep = func() error { return nil }
+
+	starts := make([]time.Time, 0, len(sc.Calibrate))
+	for _, at := range sc.Calibrate {
+		starts = append(starts, sc.Start.Add(at))
+	}
+	slices.SortFunc(starts, time.Time.Compare)
+	var nextScheduled time.Time
+	if schedule != nil {
+		nextScheduled = schedule.Next(sc.Start)
+	}
+
+	var (
+		timeline []simulator.Sample
+		last     simulator.Sample
+		sampled  time.Time
+	)
+	end := sc.Start.Add(sc.Duration)
+	for now := sc.Start; !now.After(end); now = now.Add(sc.Step) {
+		clk.Set(now)
+		battery.SetPluggedIn(sc.PluggedIn(now.Sub(sc.Start)))
+
+		var notes []string
+		for len(starts) > 0 && !starts[0].After(now) {
+			starts = starts[1:]
+			notes = append(notes, d.simulateCalibrationStart(false))
+		}
+		if !nextScheduled.IsZero() && !nextScheduled.After(now) {
+			nextScheduled = schedule.Next(now)
+			notes = append(notes, d.simulateCalibrationStart(true))
+		}
+
+		d.maintainLoopInner(true)
+
+		for len(ch) > 0 {
+			if note := describeSimulatedEvent(<-ch); note != "" {
+				notes = append(notes, note)
+			}
+		}
+
+		state := battery.State()
+		s := simulator.Sample{
+			Time:           now,
+			Charge:         state.Charge,
+			PluggedIn:      state.PluggedIn,
+			AdapterEnabled: state.AdapterEnabled,
+			Charging:       state.Charging,
+			Phase:          d.calibrationState.Phase,
+		}
+		if len(timeline) > 0 {
+			notes = append(describeSimulatedChanges(last, s), notes...)
+		}
+		if len(timeline) == 0 || len(notes) > 0 || now.Sub(sampled) >= sc.Sample || now.Equal(end) {
+			s.Events = notes
+			timeline = append(timeline, s)
+			sampled = now
+		}
+		last = s
+
+		battery.Step(sc.Step)
+	}
+	return timeline, nil
+}
+
+// simulateCalibrationStart starts a calibration like the API or, if
+// scheduled, the scheduler does, and describes the outcome.
+func (d *Daemon) simulateCalibrationStart(scheduled bool) string {
+	what, actor := "calibration", audit.ActorDaemon
+	if scheduled {
+		what, actor = "scheduled calibration", audit.ActorScheduler
+	}
+	if !d.capabilities.Calibration {
+		return what + " not started: calibration is not supported"
+	}
+	if plugged, _ := d.backend.IsPluggedIn(); scheduled && !plugged {
+		return what + " not started: the Mac must be plugged in to start calibration"
+	}
+	if err := d.startCalibration(actor, d.conf.CalibrationDischargeThreshold(), d.conf.CalibrationHoldDurationMinutes()); err != nil {
+		return fmt.Sprintf("%s not started: %v", what, err)
+	}
+	return what + " started"
+}
+
+// describeSimulatedEvent describes an event the daemon published during a
+// simulation. Plugging in and unplugging are left to the state changes.
+func describeSimulatedEvent(e events.Event) string {
+	switch e.Name {
+	case events.PowerPlugged, events.PowerUnplugged, events.CalibrationAction:
+		return ""
+	case events.CalibrationPhase:
+		ev, err := events.DecodeAs[events.CalibrationPhaseEvent](e)
+		switch {
+		case err != nil:
+			return e.Name
+		case ev.To == string(calibration.PhaseIdle):
+			return "calibration finished"
+		case ev.Message == "":
+			return "calibration phase " + ev.To
+		}
+		return ev.Message
+	case events.ChargeLimitReached:
+		ev, err := events.DecodeAs[events.ChargeLimitReachedEvent](e)
+		if err != nil {
+			return e.Name
+		}
+		return fmt.Sprintf("charge limit of %d%% reached", ev.Limit)
+	default:
+		return e.Name
+	}
+}
+
+// describeSimulatedChanges describes what changed from before to after.
+func describeSimulatedChanges(before, after simulator.Sample) []string {
+	var changes []string
+	describe := func(changed, now bool, on, off string) {
+		switch {
+		case !changed:
+		case now:
+			changes = append(changes, on)
+		default:
+			changes = append(changes, off)
+		}
+	}
+	describe(before.PluggedIn != after.PluggedIn, after.PluggedIn, "plugged in", "unplugged")
+	describe(before.AdapterEnabled != after.AdapterEnabled, after.AdapterEnabled, "adapter enabled", "adapter disabled")
+	describe(before.Charging != after.Charging, after.Charging, "charging started", "charging stopped")
+	if before.Charge > 0 && after.Charge == 0 {
+		changes = append(changes, "battery empty")
+	}
+	return changes
+}
@@ -0,0 +1,128 @@
+package daemon
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/simulator"
+	"github.com/charlie0129/batt/pkg/utils/ptr"
+)
+
+func simulate(t *testing.T, c *config.RawFileConfig, scenario string) []simulator.Sample {
+	t.Helper()
+	sc, err := simulator.ParseScenario([]byte(scenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeline, err := Simulate(config.NewFileFromConfig(c, ""), sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return timeline
+}
+
+// eventTimes returns the times of the samples with an event containing s.
+func eventTimes(timeline []simulator.Sample, s string) []time.Time {
+	var times []time.Time
+	for _, sample := range timeline {
+		if slices.ContainsFunc(sample.Events, func(e string) bool { return strings.Contains(e, s) }) {
+			times = append(times, sample.Time)
+		}
+	}
+	return times
+}
+
+func TestSimulateLimits(t *testing.T) {
+	for _, mode := range []simulator.ChargeControl{simulator.ChargeControlLegacy, simulator.ChargeControlTahoe, simulator.ChargeControlFirmware} {
+		t.Run(string(mode), func(t *testing.T) {
+			timeline := simulate(t, &config.RawFileConfig{Limit: ptr.To(80), LowerLimitDelta: ptr.To(5)}, `
+start: 2026-07-20T00:00:00Z
+duration: 12h
+battery: {capacityWh: 60, charge: 50, chargeWatts: 30, loadWatts: 6, chargeControl: `+string(mode)+`}
+power:
+  - {at: 0h, pluggedIn: true}
+  - {at: 6h, pluggedIn: false}
+  - {at: 8h, pluggedIn: true}
+`)
+			start := timeline[0].Time
+			// 50% to 80% at 30 W of 60 Wh takes 36 minutes.
+			stopped := eventTimes(timeline, "charging stopped")
+			if len(stopped) < 2 || stopped[0].Sub(start) < 30*time.Minute || stopped[0].Sub(start) > 40*time.Minute {
+				t.Fatalf("charging stopped at %v", stopped)
+			}
+			for _, s := range timeline {
+				if s.Charge > 81 {
+					t.Fatalf("charged to %.1f%% at %s", s.Charge, s.Time)
+				}
+			}
+			// Unplugged for 2 hours, it drains to 60% and charges to the
+			// limit again once plugged in.
+			started := eventTimes(timeline, "charging started")
+			if len(started) != 1 || !started[0].Equal(start.Add(8*time.Hour)) {
+				t.Fatalf("charging started at %v", started)
+			}
+			if last := timeline[len(timeline)-1]; last.Charge < 79 || last.Charging {
+				t.Fatalf("last sample %+v, want held at 80%%", last)
+			}
+		})
+	}
+}
+
+func TestSimulateCalibration(t *testing.T) {
+	timeline := simulate(t, &config.RawFileConfig{Limit: ptr.To(80), CalibrationHoldDurationMinutes: ptr.To(60)}, `
+start: 2026-07-20T00:00:00Z
+duration: 24h
+battery: {capacityWh: 60, charge: 80, chargeWatts: 30, loadWatts: 12}
+power: [{at: 0h, pluggedIn: true}]
+calibrate: [1h]
+`)
+	var phases []calibration.Phase
+	for _, s := range timeline {
+		if len(phases) == 0 || phases[len(phases)-1] != s.Phase {
+			phases = append(phases, s.Phase)
+		}
+	}
+	want := []calibration.Phase{
+		calibration.PhaseIdle,
+		calibration.PhaseDischarge,
+		calibration.PhaseCharge,
+		calibration.PhaseHold,
+		calibration.PhasePostHold,
+		calibration.PhaseRestore,
+		calibration.PhaseIdle,
+	}
+	if !slices.Equal(phases, want) {
+		t.Fatalf("phases = %v, want %v", phases, want)
+	}
+	if times := eventTimes(timeline, "calibration finished"); len(times) != 1 {
+		t.Fatalf("calibration finished at %v", times)
+	}
+	for _, s := range timeline {
+		if s.Phase == calibration.PhaseHold && s.Charge < 99 {
+			t.Fatalf("holding at %.1f%%", s.Charge)
+		}
+	}
+	if last := timeline[len(timeline)-1]; last.Charge > 81 || !last.AdapterEnabled {
+		t.Fatalf("last sample %+v, want the limit and the adapter restored", last)
+	}
+}
+
+func TestSimulateScheduledCalibration(t *testing.T) {
+	// The first scheduled run is unplugged and skipped.
+	timeline := simulate(t, &config.RawFileConfig{Limit: ptr.To(80), Cron: ptr.To("0 10 * * *")}, `
+start: 2026-07-20T00:00:00Z
+duration: 36h
+power:
+  - {at: 9h, pluggedIn: false}
+  - {at: 12h, pluggedIn: true}
+`)
+	notStarted := eventTimes(timeline, "scheduled calibration not started: the Mac must be plugged in")
+	started := eventTimes(timeline, "scheduled calibration started")
+	if len(notStarted) != 1 || len(started) != 1 || started[0].Hour() != 10 || started[0].Day() != 21 {
+		t.Fatalf("not started at %v, started at %v", notStarted, started)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !darwin
+
+package daemon
+
+import "errors"
+
+// Sleep assertions and sleep notifications are macOS-only. Sleep hooks are
+// never reported as a capability elsewhere, so these are not reached in
+// normal operation.
+
+func PreventSleepOnAC() error {
+	return nil
+}
+
+func AllowSleepOnAC() error {
+	return nil
+}
+
+func PreventCalibrationSleep() error {
+	return nil
+}
+
+func AllowCalibrationSleep() error {
+	return nil
+}
+
+func listenNotifications(*Daemon) error {
+	return errors.New("system sleep notifications are not supported on this platform")
+}
+
+func stopListeningNotifications() {}
@@ -1,3 +1,5 @@
+//go:build darwin
+
 package daemon
 
 /*
@@ -22,6 +24,9 @@ var (
 
 var (
 	lastWakeTime = time.Now()
+	// notifiedDaemon is the daemon that receives the sleep notifications.
+	// IOKit delivers them to the process, so only one daemon can listen.
+	notifiedDaemon *Daemon
 )
 
 //export canSystemSleepCallback
@@ -36,23 +41,24 @@ func canSystemSleepCallback() {
 	   seconds then go to sleep.
 	*/
 	logrus.Debugln("received kIOMessageCanSystemSleep notification, idle sleep is about to kick in")
-	if capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
+	d := notifiedDaemon
+	if d.capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
 		C.AllowPowerChange()
 		return
 	}
 
-	if !conf.PreventIdleSleep() {
+	if !d.conf.PreventIdleSleep() {
 		logrus.Debugln("PreventIdleSleep is disabled, allow idle sleep")
 		C.AllowPowerChange()
 		return
-	} else if conf.PreventSystemSleep() {
+	} else if d.conf.PreventSystemSleep() {
 		logrus.Warningln("prevent-system-sleep is active, no need in prevent-idle-sleep. Please disable it")
 		C.AllowPowerChange()
 		return
 	}
 
 	// We won't allow idle sleep if the system has just waked up,
-	// because there may still be a maintain loop waiting (see the wg.Wait() in loop.go).
+	// because there may still be a maintain loop waiting (see the d.wg.Wait() in loop.go).
 	// So decisions may not be made yet. We need to wait.
 	// Actually, we wait the larger of preSleepLoopDelaySeconds and postSleepLoopDelaySeconds. This is not implemented yet.
 	if timeAfterWokenUp := time.Since(lastWakeTime); timeAfterWokenUp < time.Duration(preSleepLoopDelaySeconds)*time.Second {
@@ -61,10 +67,10 @@ func canSystemSleepCallback() {
 		return
 	}
 
-	// Run a loop immediately to update `maintainedChargingInProgress` variable.
-	maintainLoopInner(false)
+	// Run a loop immediately to update `d.maintainedChargingInProgress` variable.
+	d.maintainLoopSerialized(false)
 
-	if maintainedChargingInProgress {
+	if d.maintainedChargingInProgress {
 		logrus.Debugln("maintained charging is in progress, deny idle sleep")
 		C.CancelPowerChange()
 		return
@@ -84,16 +90,17 @@ func systemWillSleepCallback() {
 	   kIOReturnSuccess, however the system WILL still go to sleep.
 	*/
 	logrus.Debugln("received kIOMessageSystemWillSleep notification, system will go to sleep")
-	if capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
+	d := notifiedDaemon
+	if d.capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
 		C.AllowPowerChange()
 		return
 	}
 
-	if !conf.DisableChargingPreSleep() {
+	if !d.conf.DisableChargingPreSleep() {
 		logrus.Debugln("DisableChargingPreSleep is disabled, allow sleep")
 		C.AllowPowerChange()
 		return
-	} else if conf.PreventSystemSleep() {
+	} else if d.conf.PreventSystemSleep() {
 		logrus.Warningln("prevent-system-sleep is active, no need in disable-charging-pre-sleep. Please disable it")
 		C.AllowPowerChange()
 		return
@@ -106,27 +113,27 @@ func systemWillSleepCallback() {
 	// charging will not cause any problem.
 	// By always disabling charging before sleep (if charge limit is enabled), we can prevent
 	// some rare cases.
-	if conf.UpperLimit() < 100 {
+	if upper, _ := d.effectiveLimits(); upper < 100 {
 		logrus.Infof("charge limit is enabled, disabling charging, and allowing sleep")
 		// Delay next loop to prevent charging to be re-enabled after we disabled it.
 		// macOS will wait 30s before going to sleep, there is a chance that a maintain loop is
 		// executed during that time and it enables charging.
 		// So we delay more than that, just to be sure.
 		// No need to prevent duplicated runs.
-		wg.Add(1)
+		d.wg.Add(1)
 		go func() {
 			// Use sleep instead of time.After because when the computer sleeps, we
 			// actually want the sleep to prolong as well.
 			sleep(preSleepLoopDelaySeconds)
-			wg.Done()
+			d.wg.Done()
 		}()
-		err := smcConn.DisableCharging()
+		err := d.backend.DisableCharging()
 		if err != nil {
 			logrus.Errorf("DisableCharging failed: %v", err)
 			return
 		}
-		if conf.ControlMagSafeLED() != config.ControlMagSafeModeDisabled {
-			err = smcConn.DisableMagSafeLed()
+		if d.conf.ControlMagSafeLED() != config.ControlMagSafeModeDisabled {
+			err = d.backend.DisableMagSafeLed()
 			if err != nil {
 				logrus.Errorf("DisableMagSafeLed failed: %v", err)
 			}
@@ -147,17 +154,16 @@ func systemWillPowerOnCallback() {
 func systemHasPoweredOnCallback() {
 	// System has finished waking up...
 	logrus.Debugln("received kIOMessageSystemHasPoweredOn notification, system has finished waking up")
-	if capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
+	d := notifiedDaemon
+	if d.capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
 		return
 	}
 	lastWakeTime = time.Now()
 
-	if scheduler != nil {
-		scheduler.HandleWakeUp()
-	}
+	d.scheduler.HandleWakeUp()
 
-	if conf.UpperLimit() < 100 {
-		if conf.PreventSystemSleep() {
+	if upper, _ := d.effectiveLimits(); upper < 100 {
+		if d.conf.PreventSystemSleep() {
 			logrus.Debugf("prevent-system-sleep is active, so next loop is not delayed")
 			// System will wake up on charger connection for short period of time,
 			// so we are checking if battery needs charging, and if so, enabling charging
@@ -165,13 +171,13 @@ func systemHasPoweredOnCallback() {
 			//
 			// This is required only in case laptop discharged below limit during sleep.
 			// If charging was already enabled before entering sleep, this will just update mag-safe state.
-			maintainLoopForced()
+			d.maintainLoopSerialized(true)
 		} else {
 			logrus.Debugf("delaying next loop by %d seconds", postSleepLoopDelaySeconds)
-			wg.Add(1)
+			d.wg.Add(1)
 			go func() {
-				if conf.DisableChargingPreSleep() && conf.ControlMagSafeLED() != config.ControlMagSafeModeDisabled {
-					err := smcConn.DisableMagSafeLed()
+				if d.conf.DisableChargingPreSleep() && d.conf.ControlMagSafeLED() != config.ControlMagSafeModeDisabled {
+					err := d.backend.DisableMagSafeLed()
 					if err != nil {
 						logrus.Errorf("DisableMagSafeLed failed: %v", err)
 					}
@@ -181,7 +187,7 @@ func systemHasPoweredOnCallback() {
 				// actually want the sleep to prolong as well.
 				sleep(postSleepLoopDelaySeconds)
 
-				wg.Done()
+				d.wg.Done()
 			}()
 		}
 	}
@@ -203,7 +209,9 @@ func sleep(seconds int) {
 	t.Stop()
 }
 
-func listenNotifications() error {
+// listenNotifications delivers the system sleep notifications to d.
+func listenNotifications(d *Daemon) error {
+	notifiedDaemon = d
 	logrus.Info("registered and listening system sleep notifications")
 	if int(C.ListenNotifications()) != 0 {
 		return fmt.Errorf("IORegisterForSystemPower failed")
@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/powerinfo"
+)
+
+// updateTemperatureGuard reads the battery temperature and activates the
+// guard at MaxChargingTemperature. It releases once the battery has cooled
+// down to MaxChargingTemperature - TemperatureHysteresis, or when the guard is
+// disabled. A failing sensor keeps the current state.
+func (d *Daemon) updateTemperatureGuard(now time.Time) {
+	threshold := d.conf.MaxChargingTemperature()
+	resume := threshold - d.conf.TemperatureHysteresis()
+
+	temp, err := d.readBatteryTemperature()
+	if err != nil {
+		logrus.WithError(err).Trace("battery temperature unavailable")
+	}
+
+	d.temperatureMu.Lock()
+	defer d.temperatureMu.Unlock()
+
+	if err != nil {
+		d.batteryTemperature = 0
+	} else {
+		d.batteryTemperature = temp
+	}
+
+	switch {
+	case d.temperatureGuardActive && (threshold <= 0 || (err == nil && temp <= resume)):
+		d.temperatureGuardActive = false
+		d.temperatureGuardPaused = false
+		d.temperatureGuardLimit = 0
+		d.temperatureGuardSince = time.Time{}
+
+		msg := "battery cooled down, resuming normal charging"
+		if threshold <= 0 {
+			msg = "temperature guard disabled, resuming normal charging"
+		}
+		logrus.WithField("temperature", temp).Info(msg)
+		d.publishTemperatureGuard(now, false, temp, threshold, 0, msg)
+	case !d.temperatureGuardActive && threshold > 0 && err == nil && temp >= threshold:
+		limit := d.conf.TemperatureGuardLimit()
+		paused := limit == 0
+		if paused && d.capabilities.ChargeControlMode != compatibility.ChargeControlLegacy {
+			// Firmware limits cannot pause charging directly, so hold the
+			// battery where it is.
+			charge, err := d.backend.GetBatteryCharge()
+			if err != nil {
+				logrus.WithError(err).Warn("GetBatteryCharge failed, temperature guard falls back to a 10% limit")
+			}
+			limit = max(charge, 10)
+		}
+		d.temperatureGuardActive = true
+		d.temperatureGuardPaused = paused
+		d.temperatureGuardLimit = limit
+		d.temperatureGuardSince = now
+
+		msg := fmt.Sprintf("battery is at %.1f°C, pausing charging", temp)
+		reported := 0
+		if !paused {
+			msg = fmt.Sprintf("battery is at %.1f°C, limiting charge to %d%%", temp, limit)
+			reported = limit
+		}
+		logrus.WithFields(logrus.Fields{
+			"temperature": temp,
+			"threshold":   threshold,
+			"resumeAt":    resume,
+		}).Warn(msg)
+		d.publishTemperatureGuard(now, true, temp, threshold, reported, msg)
+	}
+}
+
+func (d *Daemon) publishTemperatureGuard(now time.Time, active bool, temp, threshold float64, limit int, msg string) {
+	d.hub.Publish(events.TemperatureGuard, events.TemperatureGuardEvent{
+		Active:      active,
+		Temperature: temp,
+		Threshold:   threshold,
+		Limit:       limit,
+		Message:     msg,
+		Ts:          now.Unix(),
+	})
+}
+
+// temperatureGuardCap returns the upper limit the guard enforces, if any. A
+// guard that pauses charging in legacy mode has no cap, see
+// temperatureGuardPausesCharging.
+func (d *Daemon) temperatureGuardCap() (int, bool) {
+	d.temperatureMu.Lock()
+	defer d.temperatureMu.Unlock()
+	if !d.temperatureGuardActive || d.temperatureGuardLimit == 0 {
+		return 0, false
+	}
+	return d.temperatureGuardLimit, true
+}
+
+// temperatureGuardPausesCharging reports whether the legacy loop must keep
+// charging disabled regardless of the charge limits.
+func (d *Daemon) temperatureGuardPausesCharging() bool {
+	d.temperatureMu.Lock()
+	defer d.temperatureMu.Unlock()
+	return d.temperatureGuardActive && d.temperatureGuardLimit == 0
+}
+
+// handleTemperaturePause keeps charging disabled while the guard is active in
+// legacy mode.
+func (d *Daemon) handleTemperaturePause(isChargingEnabled bool) bool {
+	if isChargingEnabled {
+		logrus.Info("battery is too hot, disabling charging")
+		if err := d.backend.DisableCharging(); err != nil {
+			logrus.Errorf("DisableCharging failed: %v", err)
+			return false
+		}
+	}
+	d.maintainedChargingInProgress = false
+
+	switch d.conf.ControlMagSafeLED() {
+	case config.ControlMagSafeModeAlwaysOff:
+		_ = d.backend.DisableMagSafeLed()
+	case config.ControlMagSafeModeEnabled:
+		d.updateMagSafeLed(false)
+	default:
+		// nothing
+	}
+
+	if d.conf.PreventSystemSleep() {
+		if err := AllowSleepOnAC(); err != nil {
+			logrus.Errorf("AllowSleepOnAC failed: %v", err)
+		}
+	}
+
+	return true
+}
+
+func (d *Daemon) getTemperatureStatus() powerinfo.Temperature {
+	threshold := d.conf.MaxChargingTemperature()
+
+	d.temperatureMu.Lock()
+	defer d.temperatureMu.Unlock()
+
+	st := powerinfo.Temperature{
+		Battery: d.batteryTemperature,
+		Guard: powerinfo.TemperatureGuard{
+			Enabled: threshold > 0,
+			Active:  d.temperatureGuardActive,
+		},
+	}
+	if threshold > 0 {
+		st.Guard.MaxTemperature = threshold
+		st.Guard.ResumeTemperature = threshold - d.conf.TemperatureHysteresis()
+	}
+	if d.temperatureGuardActive {
+		if !d.temperatureGuardPaused {
+			st.Guard.Limit = d.temperatureGuardLimit
+		}
+		since := d.temperatureGuardSince
+		st.Guard.Since = &since
+	}
+	return st
+}
@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/client"
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/events"
+)
+
+func useTemperatureGuard(t *testing.T, files map[string]string, mode compatibility.ChargeControlMode) (*Daemon, *mockConf, string) {
+	t.Helper()
+	backend, root := newFakeSysfsBackend(t, files)
+	mc := &mockConf{upper: 80, lower: 75, maxTemperature: 40}
+	d := newTestDaemon(t, backend, mc)
+	d.capabilities = compatibility.Capabilities{ChargingControl: true, ChargeControlMode: mode}
+	return d, mc, root
+}
+
+func setBatteryTemperature(t *testing.T, root string, tenths string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(root, "BAT0", "temp"), []byte(tenths), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemperatureGuardLimitsCharging(t *testing.T) {
+	d, mc, root := useTemperatureGuard(t, map[string]string{
+		"BAT0/capacity":                     "60",
+		"BAT0/status":                       "Charging",
+		"BAT0/temp":                         "350",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	}, compatibility.ChargeControlFirmware)
+	mc.guardLimit = 50
+
+	ch := d.hub.Subscribe()
+	defer d.hub.Unsubscribe(ch)
+
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.Local)
+	d.updateTemperatureGuard(now)
+	if upper, lower := d.effectiveLimits(); upper != 80 || lower != 75 {
+		t.Fatalf("limits = %d/%d while cool, want 80/75", upper, lower)
+	}
+
+	setBatteryTemperature(t, root, "405")
+	d.updateTemperatureGuard(now.Add(time.Minute))
+	if upper, lower := d.effectiveLimits(); upper != 50 || lower != 45 {
+		t.Fatalf("limits = %d/%d while hot, want 50/45", upper, lower)
+	}
+	select {
+	case ev := <-ch:
+		payload, err := events.DecodeAs[events.TemperatureGuardEvent](ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Name != events.TemperatureGuard || !payload.Active || payload.Limit != 50 || payload.Temperature != 40.5 {
+			t.Fatalf("unexpected event %s: %+v", ev.Name, payload)
+		}
+	default:
+		t.Fatal("no event published on activation")
+	}
+
+	// Still hot within the hysteresis band.
+	setBatteryTemperature(t, root, "380")
+	d.updateTemperatureGuard(now.Add(2 * time.Minute))
+	if upper, _ := d.effectiveLimits(); upper != 50 {
+		t.Fatalf("guard released at 38.0°C, want it held until 37.0°C")
+	}
+
+	recorder := httptest.NewRecorder()
+	d.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/telemetry?power=0&calibration=0", nil))
+	var tr client.TelemetryResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &tr); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Temperature == nil || tr.Temperature.Battery != 38 || !tr.Temperature.Guard.Active ||
+		tr.Temperature.Guard.ResumeTemperature != 37 || tr.Temperature.Guard.Limit != 50 {
+		t.Fatalf("unexpected telemetry: %s", recorder.Body.String())
+	}
+
+	setBatteryTemperature(t, root, "370")
+	d.updateTemperatureGuard(now.Add(3 * time.Minute))
+	if upper, _ := d.effectiveLimits(); upper != 80 {
+		t.Fatalf("upper limit = %d after cooling down, want 80", upper)
+	}
+	select {
+	case ev := <-ch:
+		payload, err := events.DecodeAs[events.TemperatureGuardEvent](ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload.Active {
+			t.Fatalf("expected a release event, got %+v", payload)
+		}
+	default:
+		t.Fatal("no event published on release")
+	}
+}
+
+func TestTemperatureGuardPausesFirmwareAtCurrentCharge(t *testing.T) {
+	d, _, _ := useTemperatureGuard(t, map[string]string{
+		"BAT0/capacity":                     "62",
+		"BAT0/status":                       "Charging",
+		"BAT0/temp":                         "420",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	}, compatibility.ChargeControlFirmware)
+
+	d.updateTemperatureGuard(time.Now())
+	if upper, lower := d.effectiveLimits(); upper != 62 || lower != 57 {
+		t.Fatalf("limits = %d/%d, want charging held at 62/57", upper, lower)
+	}
+	if st := d.getTemperatureStatus(); !st.Guard.Active || st.Guard.Limit != 0 {
+		t.Fatalf("status = %+v, want an active guard that pauses charging", st.Guard)
+	}
+	if d.temperatureGuardPausesCharging() {
+		t.Fatal("firmware mode must enforce the pause through the charge limit")
+	}
+}
+
+func TestTemperatureGuardPausesLegacyCharging(t *testing.T) {
+	d, _, _ := useTemperatureGuard(t, map[string]string{
+		"BAT0/capacity": "60",
+		"BAT0/temp":     "450",
+	}, compatibility.ChargeControlLegacy)
+
+	d.updateTemperatureGuard(time.Now())
+	if !d.temperatureGuardPausesCharging() {
+		t.Fatal("legacy mode must pause charging while hot")
+	}
+	if _, ok := d.temperatureGuardCap(); ok {
+		t.Fatal("a paused guard must not cap the limits")
+	}
+}
+
+func TestTemperatureGuardDisabled(t *testing.T) {
+	d, mc, _ := useTemperatureGuard(t, map[string]string{
+		"BAT0/capacity": "60",
+		"BAT0/temp":     "450",
+	}, compatibility.ChargeControlFirmware)
+	mc.maxTemperature = 0
+
+	d.updateTemperatureGuard(time.Now())
+	st := d.getTemperatureStatus()
+	if st.Guard.Enabled || st.Guard.Active {
+		t.Fatalf("guard = %+v, want disabled", st.Guard)
+	}
+	if st.Battery != 45 {
+		t.Fatalf("temperature = %v, want 45", st.Battery)
+	}
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/clock"
 )
 
 // Logger is the logrus logger handler
@@ -92,14 +94,17 @@ type TimeSeriesRecorder struct {
 	MaxRecordCount        int
 	LastMaintainLoopTimes []time.Time
 	mu                    *sync.Mutex
+	clock                 clock.Clock
 }
 
-// NewTimeSeriesRecorder returns a new TimeSeriesRecorder.
-func NewTimeSeriesRecorder(maxRecordCount int) *TimeSeriesRecorder {
+// NewTimeSeriesRecorder returns a new TimeSeriesRecorder that reads the time
+// from c.
+func NewTimeSeriesRecorder(c clock.Clock, maxRecordCount int) *TimeSeriesRecorder {
 	return &TimeSeriesRecorder{
 		MaxRecordCount:        maxRecordCount,
 		LastMaintainLoopTimes: make([]time.Time, 0),
 		mu:                    &sync.Mutex{},
+		clock:                 c,
 	}
 }
 
@@ -112,8 +117,8 @@ func (r *TimeSeriesRecorder) AddRecordNow() {
 		r.LastMaintainLoopTimes = r.LastMaintainLoopTimes[1:]
 	}
 	// Round to strip monotonic clock reading.
-	// This will prevent time.Since from returning values that are not accurate (especially when the system is in sleep mode).
-	r.LastMaintainLoopTimes = append(r.LastMaintainLoopTimes, time.Now().Round(0))
+	// This will prevent durations from the records from being inaccurate (especially when the system is in sleep mode).
+	r.LastMaintainLoopTimes = append(r.LastMaintainLoopTimes, r.clock.Now().Round(0))
 }
 
 // ClearRecords clears all records.
@@ -161,8 +166,9 @@ func (r *TimeSeriesRecorder) GetRecordsIn(last time.Duration) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	now := r.clock.Now()
 	// The last record must be within the last duration.
-	if len(r.LastMaintainLoopTimes) > 0 && time.Since(r.LastMaintainLoopTimes[len(r.LastMaintainLoopTimes)-1]) >= loopInterval+time.Second {
+	if len(r.LastMaintainLoopTimes) > 0 && now.Sub(r.LastMaintainLoopTimes[len(r.LastMaintainLoopTimes)-1]) >= loopInterval+time.Second {
 		return 0
 	}
 
@@ -172,7 +178,7 @@ func (r *TimeSeriesRecorder) GetRecordsIn(last time.Duration) int {
 	count := 0
 	for i := len(r.LastMaintainLoopTimes) - 1; i >= 0; i-- {
 		record := r.LastMaintainLoopTimes[i]
-		if time.Since(record) > last {
+		if now.Sub(record) > last {
 			break
 		}
 
@@ -199,10 +205,11 @@ func (r *TimeSeriesRecorder) GetLastRecords(last time.Duration) []time.Time {
 		return nil
 	}
 
+	now := r.clock.Now()
 	var records []time.Time
 	for i := len(r.LastMaintainLoopTimes) - 1; i >= 0; i-- {
 		record := r.LastMaintainLoopTimes[i]
-		if time.Since(record) > last {
+		if now.Sub(record) > last {
 			break
 		}
 		records = append(records, record)
@@ -220,10 +227,10 @@ func formatTimes(times []time.Time) []string {
 	return timesString
 }
 
-func formatRelativeTimes(times []time.Time) []string {
+func formatRelativeTimes(now time.Time, times []time.Time) []string {
 	var timesString []string
 	for _, t := range times {
-		timesString = append(timesString, fmt.Sprintf("%.2fs", time.Since(t).Seconds()))
+		timesString = append(timesString, fmt.Sprintf("%.2fs", now.Sub(t).Seconds()))
 	}
 	return timesString
 }
@@ -0,0 +1,409 @@
+package daemon
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/audit"
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/version"
+)
+
+// setupV1Routes registers the versioned API. Every request and response
+// body is a JSON object, mutations respond 200 with the updated resource,
+// and errors are an api.Error.
+func (d *Daemon) setupV1Routes(router *gin.Engine) {
+	v1 := router.Group("/v1")
+	v1.GET("/version", getVersionV1)
+	v1.GET("/config", d.getConfig)
+	v1.PATCH("/config", d.patchConfig)
+	v1.GET("/compatibility", d.getCompatibility)
+	v1.GET("/limit", d.getLimitV1)
+	v1.PUT("/limit", d.setLimitV1)
+	v1.PUT("/limit/lower-delta", d.setLowerLimitDeltaV1)
+	v1.POST("/limit/disable", d.postDisableLimitV1)
+	v1.GET("/adapter", d.getAdapterV1)
+	v1.PUT("/adapter", d.setAdapterV1)
+	v1.POST("/adapter/disable", d.postDisableAdapterV1)
+	v1.GET("/battery", d.getBatteryV1)
+	v1.GET("/settings", d.getSettingsV1)
+	v1.PUT("/settings", d.setSettingsV1)
+	v1.GET("/telemetry", d.getUnifiedTelemetry)
+	v1.GET("/events", d.getEventStream)
+	v1.GET("/history", d.getHistory)
+	v1.GET("/audit", d.getAudit)
+	v1.GET("/explain", d.getExplain)
+	v1.GET("/adaptive", d.getAdaptive)
+	v1.POST("/adaptive/reset", d.postResetAdaptiveV1)
+	v1.GET("/webhooks", d.getWebhooks)
+	v1.PUT("/webhooks", d.setWebhooksV1)
+
+	v1.GET("/calibration", d.getCalibrationV1)
+	v1.POST("/calibration/start", d.calibrationActionV1(func(a audit.Actor) error {
+		return d.startCalibration(a, d.conf.CalibrationDischargeThreshold(), d.conf.CalibrationHoldDurationMinutes())
+	}))
+	v1.POST("/calibration/pause", d.calibrationActionV1(d.pauseCalibration))
+	v1.POST("/calibration/resume", d.calibrationActionV1(d.resumeCalibration))
+	v1.POST("/calibration/cancel", d.calibrationActionV1(d.cancelCalibration))
+	v1.GET("/calibration/settings", d.getCalibrationSettingsV1)
+	v1.PUT("/calibration/settings", d.setCalibrationSettingsV1)
+	v1.GET("/calibration/schedule", d.getScheduleV1)
+	v1.PUT("/calibration/schedule", d.setScheduleV1)
+	v1.POST("/calibration/schedule/postpone", d.postPostponeScheduleV1)
+	v1.POST("/calibration/schedule/skip", d.postSkipScheduleV1)
+
+	router.NoRoute(noRoute)
+}
+
+// bindJSON decodes the request body into v, responding with an
+// invalid_argument error if it cannot.
+func bindJSON(c *gin.Context, v any) bool {
+	if err := c.ShouldBindJSON(v); err != nil {
+		abortWithError(c, invalidArgument(err))
+		return false
+	}
+	return true
+}
+
+func getVersionV1(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, api.Version{Version: version.Version})
+}
+
+func (d *Daemon) getLimitResource() api.Limit {
+	upper, lower := d.effectiveLimits()
+	l := api.Limit{
+		Upper:          d.conf.UpperLimit(),
+		Lower:          d.conf.LowerLimit(),
+		EffectiveUpper: upper,
+		EffectiveLower: lower,
+	}
+	if until := d.conf.DisableUntil(); !until.IsZero() {
+		l.DisabledUntil = &until
+		l.RestoreLimit = d.conf.PreDisableLimit()
+	}
+	return l
+}
+
+func (d *Daemon) getLimitV1(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, d.getLimitResource())
+}
+
+func (d *Daemon) setLimitV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureChargingControl) {
+		return
+	}
+	var req api.SetLimitRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	msg, err := d.applyLimit(requestActor(c), req.Upper)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	l := d.getLimitResource()
+	l.Message = msg
+	c.IndentedJSON(http.StatusOK, l)
+}
+
+func (d *Daemon) setLowerLimitDeltaV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureChargingControl) {
+		return
+	}
+	var req api.SetLowerLimitDeltaRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	msg, err := d.applyLowerLimitDelta(requestActor(c), req.Delta)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	l := d.getLimitResource()
+	l.Message = msg
+	c.IndentedJSON(http.StatusOK, l)
+}
+
+func (d *Daemon) postDisableLimitV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureChargingControl) {
+		return
+	}
+	var req api.DurationRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	duration, err := parseDuration(req.Duration)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	prevLimit, until, err := d.applyDisableFor(requestActor(c), duration)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	l := d.getLimitResource()
+	l.Message = disableMessage(prevLimit, until)
+	c.IndentedJSON(http.StatusOK, l)
+}
+
+func (d *Daemon) getAdapterResource() (api.Adapter, error) {
+	enabled, err := d.backend.IsAdapterEnabled()
+	if err != nil {
+		logrus.Errorf("getAdapter failed: %v", err)
+		return api.Adapter{}, err
+	}
+	a := api.Adapter{Enabled: enabled}
+	if until := d.conf.AdapterDisableUntil(); !until.IsZero() {
+		a.DisabledUntil = &until
+	}
+	return a, nil
+}
+
+func (d *Daemon) respondAdapter(c *gin.Context, msg string) {
+	a, err := d.getAdapterResource()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	a.Message = msg
+	c.IndentedJSON(http.StatusOK, a)
+}
+
+func (d *Daemon) getAdapterV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureAdapterControl) {
+		return
+	}
+	d.respondAdapter(c, "")
+}
+
+func (d *Daemon) setAdapterV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureAdapterControl) {
+		return
+	}
+	var req api.SetAdapterRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if err := d.applyAdapter(requestActor(c), req.Enabled); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	d.respondAdapter(c, "")
+}
+
+func (d *Daemon) postDisableAdapterV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureAdapterControl) {
+		return
+	}
+	var req api.DurationRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	duration, err := parseDuration(req.Duration)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	until, err := d.applyAdapterDisableFor(requestActor(c), duration)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	d.respondAdapter(c, adapterDisableMessage(until))
+}
+
+func (d *Daemon) getBatteryV1(c *gin.Context) {
+	charge, err := d.backend.GetBatteryCharge()
+	if err != nil {
+		logrus.Errorf("getCurrentCharge failed: %v", err)
+		abortWithError(c, err)
+		return
+	}
+	pluggedIn, err := d.backend.IsPluggedIn()
+	if err != nil {
+		logrus.Errorf("getPluggedIn failed: %v", err)
+		abortWithError(c, err)
+		return
+	}
+	charging, err := d.readCharging()
+	if err != nil {
+		logrus.Errorf("getCharging failed: %v", err)
+		abortWithError(c, err)
+		return
+	}
+	b := api.Battery{
+		Charge:                 charge,
+		PluggedIn:              pluggedIn,
+		Charging:               charging,
+		ChargingControlCapable: d.backend.IsChargingControlCapable(),
+	}
+	if info, err := d.readBatteryInfo(); err != nil {
+		logrus.WithError(err).Warn("battery info unavailable")
+	} else {
+		b.Info = info
+	}
+	c.IndentedJSON(http.StatusOK, b)
+}
+
+func (d *Daemon) getSettingsV1(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, d.getSettings())
+}
+
+func (d *Daemon) setSettingsV1(c *gin.Context) {
+	var req api.Settings
+	if !bindJSON(c, &req) {
+		return
+	}
+	msg, err := d.applySettings(requestActor(c), req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	s := d.getSettings()
+	s.Message = msg
+	c.IndentedJSON(http.StatusOK, s)
+}
+
+func (d *Daemon) postResetAdaptiveV1(c *gin.Context) {
+	d.resetAdaptiveModel()
+	c.IndentedJSON(http.StatusOK, d.getAdaptiveStatus(d.clock.Now()))
+}
+
+func (d *Daemon) setWebhooksV1(c *gin.Context) {
+	var webhooks []config.Webhook
+	if !bindJSON(c, &webhooks) {
+		return
+	}
+	if err := d.applyWebhooks(c, webhooks); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, d.webhookDispatcher.Status())
+}
+
+func (d *Daemon) getCalibrationV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, d.getCalibrationStatus())
+}
+
+// calibrationActionV1 runs action and responds with the calibration status.
+func (d *Daemon) calibrationActionV1(action func(audit.Actor) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !d.requireCapability(c, compatibility.FeatureCalibration) {
+			return
+		}
+		if err := action(requestActor(c)); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, d.getCalibrationStatus())
+	}
+}
+
+func (d *Daemon) getCalibrationSettingsV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, d.getCalibrationSettings())
+}
+
+func (d *Daemon) setCalibrationSettingsV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
+		return
+	}
+	var req api.CalibrationSettings
+	if !bindJSON(c, &req) {
+		return
+	}
+	msg, err := d.applyCalibrationSettings(requestActor(c), req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	s := d.getCalibrationSettings()
+	s.Message = msg
+	c.IndentedJSON(http.StatusOK, s)
+}
+
+// getSchedule returns the calibration schedule and its next three runs,
+// taking postponed and skipped runs into account.
+func (d *Daemon) getSchedule() api.Schedule {
+	s := api.Schedule{Cron: d.conf.Cron()}
+	if s.Cron == "" {
+		return s
+	}
+	sched, err := cronParser.Parse(s.Cron)
+	if err != nil {
+		return s
+	}
+	next, running := d.scheduler.Status()
+	if !running || next.IsZero() {
+		return s
+	}
+	for range 3 {
+		s.NextRuns = append(s.NextRuns, next)
+		next = sched.Next(next)
+	}
+	return s
+}
+
+func (d *Daemon) getScheduleV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, d.getSchedule())
+}
+
+func (d *Daemon) setScheduleV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
+		return
+	}
+	var req api.Schedule
+	if !bindJSON(c, &req) {
+		return
+	}
+	nextRuns, err := d.schedule(requestActor(c), req.Cron)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, api.Schedule{Cron: d.conf.Cron(), NextRuns: nextRuns})
+}
+
+func (d *Daemon) postPostponeScheduleV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
+		return
+	}
+	req := api.DurationRequest{Duration: "1h"}
+	if c.Request.ContentLength != 0 && !bindJSON(c, &req) {
+		return
+	}
+	duration, err := parseDuration(req.Duration)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := d.postpone(requestActor(c), duration); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, d.getSchedule())
+}
+
+func (d *Daemon) postSkipScheduleV1(c *gin.Context) {
+	if !d.requireCapability(c, compatibility.FeatureCalibration) {
+		return
+	}
+	if err := d.skipNextSchedule(requestActor(c)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, d.getSchedule())
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/calibration"
+	"github.com/charlie0129/batt/pkg/compatibility"
+)
+
+func serveV1(t *testing.T, d *Daemon, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	d.router.ServeHTTP(response, request)
+	return response
+}
+
+func decodeAPIError(t *testing.T, response *httptest.ResponseRecorder, status int, code api.ErrorCode) *api.Error {
+	t.Helper()
+	if response.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", response.Code, status, response.Body.String())
+	}
+	var e api.Error
+	if err := json.Unmarshal(response.Body.Bytes(), &e); err != nil {
+		t.Fatalf("body is not an api.Error: %v: %s", err, response.Body.String())
+	}
+	if e.Code != code || e.Message == "" {
+		t.Fatalf("error = %+v, want code %q", e, code)
+	}
+	return &e
+}
+
+func TestV1Limit(t *testing.T) {
+	backend, _ := newFakeSysfsBackend(t, map[string]string{
+		"BAT0/capacity":                     "60",
+		"BAT0/status":                       "Charging",
+		"BAT0/charge_control_end_threshold": "80",
+		"AC/online":                         "1",
+	})
+	configured := &mockConf{upper: 80, lower: 78}
+	d := newTestDaemon(t, backend, configured)
+
+	response := serveV1(t, d, http.MethodPut, "/v1/limit", `{"upper":70}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d; body: %s", response.Code, response.Body.String())
+	}
+	var l api.Limit
+	if err := json.Unmarshal(response.Body.Bytes(), &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Upper != 70 || configured.upper != 70 || l.Message == "" {
+		t.Fatalf("unexpected limit %+v", l)
+	}
+
+	e := decodeAPIError(t, serveV1(t, d, http.MethodPut, "/v1/limit", `{"upper":5}`), http.StatusBadRequest, api.CodeInvalidArgument)
+	if e.Details["min"] != float64(10) || e.Details["max"] != float64(100) {
+		t.Fatalf("details = %v", e.Details)
+	}
+	decodeAPIError(t, serveV1(t, d, http.MethodPut, "/v1/limit", `"70"`), http.StatusBadRequest, api.CodeInvalidArgument)
+
+	d.calibrationState = &calibration.State{Phase: calibration.PhaseCharge}
+	decodeAPIError(t, serveV1(t, d, http.MethodPut, "/v1/limit", `{"upper":90}`), http.StatusConflict, api.CodeCalibrationConflict)
+	if configured.upper != 70 {
+		t.Fatalf("upper limit = %d after rejection, want 70", configured.upper)
+	}
+}
+
+func TestV1CapabilityMissing(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78})
+	d.capabilities = compatibility.Capabilities{}
+
+	e := decodeAPIError(t, serveV1(t, d, http.MethodPost, "/v1/calibration/start", ""), http.StatusNotImplemented, api.CodeCapabilityMissing)
+	if e.Details["feature"] != string(compatibility.FeatureCalibration) {
+		t.Fatalf("details = %v", e.Details)
+	}
+
+	// Legacy routes keep their status codes and bare messages.
+	response := serveV1(t, d, http.MethodPost, "/calibration/start", "")
+	if response.Code != http.StatusConflict || strings.Contains(response.Body.String(), "capability_missing") {
+		t.Fatalf("legacy response = %d %s", response.Code, response.Body.String())
+	}
+}
+
+func TestLegacyStatusCodes(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78})
+	d.capabilities = compatibility.Capabilities{MagSafeLED: true, ChargeControlMode: compatibility.ChargeControlFirmware}
+
+	decodeAPIError(t, serveV1(t, d, http.MethodPut, "/v1/settings", `{"controlMagSafeLED":"enabled"}`), http.StatusNotImplemented, api.CodeCapabilityMissing)
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPut, "/magsafe-led", `"enabled"`, http.StatusInternalServerError},
+		{http.MethodGet, "/charging", "", http.StatusConflict},
+	} {
+		if response := serveV1(t, d, tc.method, tc.path, tc.body); response.Code != tc.want {
+			t.Errorf("%s %s = %d %s, want %d", tc.method, tc.path, response.Code, response.Body.String(), tc.want)
+		}
+	}
+}
+
+func TestV1NotFound(t *testing.T) {
+	d := newTestDaemon(t, nil, &mockConf{upper: 80, lower: 78})
+
+	decodeAPIError(t, serveV1(t, d, http.MethodGet, "/v1/nope", ""), http.StatusNotFound, api.CodeNotFound)
+	if response := serveV1(t, d, http.MethodGet, "/nope", ""); response.Code != http.StatusNotFound {
+		t.Fatalf("status = %d", response.Code)
+	}
+}
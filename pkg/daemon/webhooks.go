@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/webhook"
+)
+
+// redactedSecret replaces webhook secrets in /config. Sending it back to
+// PUT /webhooks keeps the stored secret.
+const redactedSecret = "********"
+
+// startWebhooks delivers every event the daemon publishes to the configured
+// webhooks until ctx is done.
+func (d *Daemon) startWebhooks(ctx context.Context) {
+	ch := d.hub.Subscribe()
+	go func() {
+		for ev := range ch {
+			d.webhookDispatcher.Enqueue(ev)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		d.hub.Unsubscribe(ch)
+	}()
+	go d.webhookDispatcher.Run(ctx)
+}
+
+// reloadWebhooks applies the configured webhooks. Invalid ones are skipped.
+func (d *Daemon) reloadWebhooks() {
+	var targets []webhook.Target
+	for _, w := range d.conf.Webhooks() {
+		t := webhookTarget(w)
+		if err := t.Validate(); err != nil {
+			logrus.WithError(err).Warn("ignoring invalid webhook")
+			continue
+		}
+		targets = append(targets, t)
+	}
+	d.webhookDispatcher.SetTargets(targets)
+}
+
+func webhookTarget(w config.Webhook) webhook.Target {
+	return webhook.Target{URL: w.URL, Secret: w.Secret, Events: w.Events}
+}
+
+// keepWebhookSecrets replaces secrets set to redactedSecret with the ones
+// in previous for the same URL.
+func keepWebhookSecrets(webhooks, previous []config.Webhook) {
+	for i, w := range webhooks {
+		if w.Secret != redactedSecret {
+			continue
+		}
+		webhooks[i].Secret = ""
+		for _, p := range previous {
+			if p.URL == w.URL {
+				webhooks[i].Secret = p.Secret
+			}
+		}
+	}
+}
+
+func (d *Daemon) getWebhooks(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, d.webhookDispatcher.Status())
+}
+
+// setWebhooks replaces all webhooks.
+func (d *Daemon) setWebhooks(c *gin.Context) {
+	var webhooks []config.Webhook
+	if err := c.ShouldBindJSON(&webhooks); err != nil {
+		abortWithError(c, invalidArgument(err))
+		return
+	}
+	if err := d.applyWebhooks(c, webhooks); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, "ok")
+}
+
+// applyWebhooks validates and saves webhooks. A secret set to
+// redactedSecret keeps the one stored for the same URL. Only root and the
+// user running the daemon may change where events are sent.
+func (d *Daemon) applyWebhooks(c *gin.Context, webhooks []config.Webhook) error {
+	if !callerPrivileged(c) {
+		return api.Errorf(api.CodePermissionDenied, "permission denied: only root can change webhooks").WithDetail("field", "webhooks")
+	}
+	cfg := d.auditing(d.conf, requestActor(c))
+
+	keepWebhookSecrets(webhooks, d.conf.Webhooks())
+	for _, w := range webhooks {
+		if err := webhookTarget(w).Validate(); err != nil {
+			return invalidArgument(err)
+		}
+	}
+
+	cfg.SetWebhooks(webhooks)
+	if err := cfg.Save(); err != nil {
+		logrus.Errorf("saveConfig failed: %v", err)
+		return err
+	}
+	d.reloadWebhooks()
+
+	logrus.Infof("set %d webhooks", len(webhooks))
+	return nil
+}
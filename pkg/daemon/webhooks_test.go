@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/api"
+	"github.com/charlie0129/batt/pkg/config"
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/webhook"
+)
+
+func TestWebhooksEndpoints(t *testing.T) {
+	signatures := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signatures <- webhook.Verify("s3cret", r.Header.Get(webhook.HeaderTimestamp), body, r.Header.Get(webhook.HeaderSignature))
+	}))
+	defer srv.Close()
+
+	mc := &mockConf{upper: 80, lower: 75}
+	d := newTestDaemon(t, nil, mc)
+
+	putAs := func(cred peerCred, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), peerCredKey{}, cred))
+		recorder := httptest.NewRecorder()
+		d.router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		return putAs(peerCred{uid: 0, gid: 0}, "/webhooks", body)
+	}
+
+	// Only root and the daemon user may send events elsewhere.
+	stranger := peerCred{uid: 4242, gid: 4242}
+	decodeAPIError(t, putAs(stranger, "/v1/webhooks", `[{"url": "https://example.com/hook"}]`), http.StatusForbidden, api.CodePermissionDenied)
+	if rec := putAs(stranger, "/webhooks", `[{"url": "https://example.com/hook"}]`); rec.Code != http.StatusForbidden {
+		t.Fatalf("unprivileged PUT /webhooks: status = %d, want 403", rec.Code)
+	}
+	if len(mc.webhooks) != 0 {
+		t.Fatalf("webhooks = %+v after refused requests", mc.webhooks)
+	}
+
+	if rec := put(`[{"url": "ftp://example.com"}]`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid URL: status = %d, want 400", rec.Code)
+	}
+	if rec := put(`[{"url": "` + srv.URL + `", "secret": "s3cret"}]`); rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	d.webhookDispatcher.Enqueue(events.Event{Name: events.PowerPlugged, Data: []byte(`{"pluggedIn":true}`)})
+	d.webhookDispatcher.DeliverDue(context.Background())
+	if !<-signatures {
+		t.Fatal("delivery signature does not verify")
+	}
+
+	recorder := httptest.NewRecorder()
+	d.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	var st []webhook.Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != 1 || st[0].URL != srv.URL || !st[0].Signed || st[0].Delivered != 1 {
+		t.Fatalf("unexpected status %s", recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "s3cret") {
+		t.Fatal("GET /webhooks exposes the secret")
+	}
+
+	// /config hides the secret, and sending the placeholder back keeps it.
+	recorder = httptest.NewRecorder()
+	d.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/config", nil))
+	var raw config.RawFileConfig
+	if err := json.Unmarshal(recorder.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw.Webhooks) != 1 || raw.Webhooks[0].Secret != redactedSecret {
+		t.Fatalf("unexpected webhooks in /config: %+v", raw.Webhooks)
+	}
+	b, _ := json.Marshal(raw.Webhooks)
+	if rec := put(string(b)); rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := mc.webhooks[0].Secret; got != "s3cret" {
+		t.Fatalf("secret = %q after a round trip, want it kept", got)
+	}
+}
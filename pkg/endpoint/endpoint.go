@@ -0,0 +1,55 @@
+// Package endpoint parses the addresses of the services the daemon talks to,
+// so that the config can be validated the same way they are dialed.
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ParseMQTTBroker parses an MQTT broker address: "host:port",
+// "tcp://host:port" or "tls://host:port" (also "ssl://" and "mqtts://"). The
+// port defaults to 1883, or 8883 with TLS. It returns the TCP address to dial
+// and whether to use TLS.
+func ParseMQTTBroker(broker string) (addr string, useTLS bool, err error) {
+	if broker == "" {
+		return "", false, errors.New("no MQTT broker configured")
+	}
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid MQTT broker %q: %w", broker, err)
+	}
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "tls", "ssl", "mqtts":
+		useTLS, port = true, "8883"
+	default:
+		return "", false, fmt.Errorf("invalid MQTT broker %q: unsupported scheme %q", broker, u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	if u.Hostname() == "" {
+		return "", false, fmt.Errorf("invalid MQTT broker %q: missing host", broker)
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// ValidateWebhookURL checks that raw is an absolute http(s) URL.
+func ValidateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q: expected an http or https URL", raw)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package endpoint
+
+import "testing"
+
+func TestParseMQTTBroker(t *testing.T) {
+	for _, tt := range []struct {
+		broker string
+		addr   string
+		tls    bool
+	}{
+		{"broker.local", "broker.local:1883", false},
+		{"broker.local:1884", "broker.local:1884", false},
+		{"mqtt://broker.local", "broker.local:1883", false},
+		{"tls://broker.local", "broker.local:8883", true},
+		{"mqtts://broker.local:9000", "broker.local:9000", true},
+	} {
+		addr, useTLS, err := ParseMQTTBroker(tt.broker)
+		if err != nil || addr != tt.addr || useTLS != tt.tls {
+			t.Errorf("ParseMQTTBroker(%q) = %q, %t, %v, want %q, %t", tt.broker, addr, useTLS, err, tt.addr, tt.tls)
+		}
+	}
+	for _, broker := range []string{"", "http://example.com", "tcp://:1883"} {
+		if _, _, err := ParseMQTTBroker(broker); err == nil {
+			t.Errorf("ParseMQTTBroker(%q) succeeded", broker)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	for url, valid := range map[string]bool{
+		"https://example.com/hook": true,
+		"http://127.0.0.1:8080":    true,
+		"ftp://example.com":        false,
+		"example.com/hook":         false,
+		"":                         false,
+	} {
+		if err := ValidateWebhookURL(url); (err == nil) != valid {
+			t.Errorf("ValidateWebhookURL(%q) = %v, want valid=%t", url, err, valid)
+		}
+	}
+}
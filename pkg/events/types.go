@@ -4,10 +4,34 @@ import "encoding/json"
 
 // Event name constants
 const (
-	CalibrationPhase  = "calibration.phase"
-	CalibrationAction = "calibration.action"
+	CalibrationPhase   = "calibration.phase"
+	CalibrationAction  = "calibration.action"
+	TemperatureGuard   = "temperature.guard"
+	PowerPlugged       = "power.plugged"
+	PowerUnplugged     = "power.unplugged"
+	ChargeLimitReached = "charging.limit_reached"
+	DisableExpired     = "disable.expired"
+	HookFailed         = "hook.failed"
+	FileRestored       = "file.restored"
+	ConfigReloaded     = "config.reloaded"
+	ConfigReloadFailed = "config.reload_failed"
 )
 
+// Names lists every event name the daemon publishes.
+var Names = []string{
+	CalibrationPhase,
+	CalibrationAction,
+	TemperatureGuard,
+	PowerPlugged,
+	PowerUnplugged,
+	ChargeLimitReached,
+	DisableExpired,
+	HookFailed,
+	FileRestored,
+	ConfigReloaded,
+	ConfigReloadFailed,
+}
+
 // Event is a generic SSE event from daemon.
 type Event struct {
 	Name string          // SSE event name
@@ -29,6 +53,86 @@ type CalibrationActionEvent struct {
 	Ts      int64  `json:"ts"`
 }
 
+// TemperatureGuardEvent is the typed payload for temperature.guard. It is
+// published when the guard activates and when it releases.
+type TemperatureGuardEvent struct {
+	Active      bool    `json:"active"`
+	Temperature float64 `json:"temperature"`
+	Threshold   float64 `json:"threshold"`
+	// Limit is the charge limit while active. Zero means charging is paused.
+	Limit   int    `json:"limit,omitempty"`
+	Message string `json:"message,omitempty"`
+	Ts      int64  `json:"ts"`
+}
+
+// PowerEvent is the typed payload for power.plugged and power.unplugged.
+type PowerEvent struct {
+	PluggedIn bool  `json:"pluggedIn"`
+	Charge    int   `json:"charge"`
+	Ts        int64 `json:"ts"`
+}
+
+// ChargeLimitReachedEvent is the typed payload for charging.limit_reached. It
+// is published when charging stops at the upper limit.
+type ChargeLimitReachedEvent struct {
+	Charge int   `json:"charge"`
+	Limit  int   `json:"limit"`
+	Ts     int64 `json:"ts"`
+}
+
+// DisableExpiredEvent is the typed payload for disable.expired.
+type DisableExpiredEvent struct {
+	// Target is "limit" when the charge limit was restored after
+	// "batt disable --for", or "adapter" when the power adapter was
+	// re-enabled.
+	Target string `json:"target"`
+	// Limit is the restored charge limit, set for the "limit" target.
+	Limit int   `json:"limit,omitempty"`
+	Ts    int64 `json:"ts"`
+}
+
+// HookFailedEvent is the typed payload for hook.failed.
+type HookFailedEvent struct {
+	Event   string `json:"event"`
+	Command string `json:"command"`
+	Error   string `json:"error"`
+	// ExitCode is -1 when the command did not exit by itself, e.g. it timed
+	// out or could not be started.
+	ExitCode int    `json:"exitCode"`
+	Output   string `json:"output,omitempty"`
+	Ts       int64  `json:"ts"`
+}
+
+// FileRestoredEvent is the typed payload for file.restored. It is published
+// when the config or a state file was damaged and its backup was used.
+type FileRestoredEvent struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+	Ts    int64  `json:"ts"`
+}
+
+// ConfigReloadedEvent is the typed payload for config.reloaded. It is
+// published when the config file was loaded again and applied.
+type ConfigReloadedEvent struct {
+	Path string `json:"path"`
+	// Trigger is "file" when the file was changed, or "signal" for SIGHUP.
+	Trigger string `json:"trigger"`
+	Ts      int64  `json:"ts"`
+}
+
+// ConfigReloadFailedEvent is the typed payload for config.reload_failed. It
+// is published when the config file could not be loaded or is not valid.
+// The daemon keeps its current config then.
+type ConfigReloadFailedEvent struct {
+	Path    string `json:"path"`
+	Trigger string `json:"trigger"`
+	Error   string `json:"error"`
+	// Invalid maps the path of every invalid setting to the reason, e.g.
+	// "limit" to "must be between 10 and 100, got 5".
+	Invalid map[string]string `json:"invalid,omitempty"`
+	Ts      int64             `json:"ts"`
+}
+
 // DecodeAs decodes the event payload into the caller-specified generic type T.
 // It ignores the event name and simply unmarshals Data into T. If Data is empty,
 // it returns the zero value of T with a nil error.
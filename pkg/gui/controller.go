@@ -1,3 +1,5 @@
+//go:build darwin
+
 package gui
 
 import (
@@ -49,7 +51,7 @@ func (c *menuController) refreshCompatibility() {
 		c.setCompatibility(false, compatibility.Permissive(), false)
 		return
 	}
-	conf := config.NewFileFromConfig(rawConfig, "")
+	conf := config.NewFileFromConfig(&rawConfig.RawFileConfig, "")
 	logrus.WithFields(conf.LogrusFields()).Info("Got config")
 
 	logrus.Info("Getting hardware compatibility")
@@ -83,7 +85,7 @@ func (c *menuController) refreshOnOpen() {
 		c.setCompatibility(false, compatibility.Permissive(), false)
 		return
 	}
-	conf := config.NewFileFromConfig(rawConfig, "")
+	conf := config.NewFileFromConfig(&rawConfig.RawFileConfig, "")
 	logrus.WithFields(conf.LogrusFields()).Info("Got config")
 	c.updateDisableSchedules(conf)
 
@@ -174,7 +176,7 @@ func (c *menuController) refreshDisableSchedules() {
 		logrus.WithError(err).Debug("Failed to refresh temporary disable schedule")
 		return
 	}
-	c.updateDisableSchedules(config.NewFileFromConfig(rawConfig, ""))
+	c.updateDisableSchedules(config.NewFileFromConfig(&rawConfig.RawFileConfig, ""))
 	if c.capabilities.AdapterControl {
 		if adapter, err := c.api.GetAdapter(); err == nil {
 			c.updateAdapterState(adapter)
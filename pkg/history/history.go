@@ -0,0 +1,331 @@
+// Package history implements the on-disk battery history kept by the daemon.
+//
+// Samples are appended as JSON lines to a single file. When the file grows
+// beyond its size budget it is compacted: samples older than the raw
+// retention window are downsampled into fixed buckets and, if that is not
+// enough, the oldest samples are dropped.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/persist"
+)
+
+// Sample is a single reading of the battery state.
+type Sample struct {
+	// Ts is the unix timestamp in seconds. For downsampled data it is the
+	// start of the bucket.
+	Ts             int64 `json:"ts"`
+	Charge         int   `json:"charge"`
+	PluggedIn      bool  `json:"pluggedIn"`
+	Charging       bool  `json:"charging"`
+	AdapterEnabled bool  `json:"adapterEnabled"`
+	// SystemPower and BatteryPower are in watts. BatteryPower is negative
+	// while discharging.
+	SystemPower  float64 `json:"systemPower"`
+	BatteryPower float64 `json:"batteryPower"`
+}
+
+// Time returns the sample timestamp.
+func (s Sample) Time() time.Time {
+	return time.Unix(s.Ts, 0)
+}
+
+// Options controls the size of the store.
+type Options struct {
+	// MaxBytes is the file size that triggers a compaction.
+	MaxBytes int64
+	// RawRetention is how long samples are kept at full resolution.
+	RawRetention time.Duration
+	// DownsampleStep is the bucket size for samples past RawRetention.
+	DownsampleStep time.Duration
+}
+
+// DefaultOptions keeps a day of raw samples and about 110 days of 10-minute
+// averages in 4 MiB. With a sample every 10 seconds, a day of raw samples
+// takes about 1 MiB and a day of averages about 18 KiB.
+var DefaultOptions = Options{
+	MaxBytes:       4 << 20,
+	RawRetention:   24 * time.Hour,
+	DownsampleStep: 10 * time.Minute,
+}
+
+// Store is an append-only, size-bounded history file.
+type Store struct {
+	path string
+	opts Options
+
+	mu   sync.Mutex
+	size int64
+}
+
+// Open opens the history file at path, creating its directory if needed.
+// Zero fields in opts are taken from DefaultOptions.
+func Open(path string, opts Options) (*Store, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultOptions.MaxBytes
+	}
+	if opts.RawRetention <= 0 {
+		opts.RawRetention = DefaultOptions.RawRetention
+	}
+	if opts.DownsampleStep <= 0 {
+		opts.DownsampleStep = DefaultOptions.DownsampleStep
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create history directory: %w", err)
+	}
+
+	s := &Store{path: path, opts: opts}
+	fi, err := os.Stat(path)
+	switch {
+	case err == nil:
+		s.size = fi.Size()
+	case os.IsNotExist(err):
+	default:
+		return nil, fmt.Errorf("stat history file: %w", err)
+	}
+	return s, nil
+}
+
+// Path returns the location of the history file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Append writes a sample to the end of the file, compacting it first if it
+// has outgrown its budget.
+func (s *Store) Append(sample Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size >= s.opts.MaxBytes {
+		if err := s.compactLocked(sample.Time()); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("open history file: %w", err)
+	}
+	n, err := f.Write(b)
+	s.size += int64(n)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("append history sample: %w", err)
+	}
+	return nil
+}
+
+// Query returns samples with from <= Ts <= to in chronological order. A zero
+// from or to leaves that end open. If step is positive, samples are
+// aggregated into buckets of that size.
+func (s *Store) Query(from, to time.Time, step time.Duration) ([]Sample, error) {
+	s.mu.Lock()
+	all, err := s.readLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Sample, 0, len(all))
+	for _, sample := range all {
+		if !from.IsZero() && sample.Ts < from.Unix() {
+			continue
+		}
+		if !to.IsZero() && sample.Ts > to.Unix() {
+			continue
+		}
+		ret = append(ret, sample)
+	}
+	if step > 0 {
+		ret = Downsample(ret, step)
+	}
+	return ret, nil
+}
+
+// Compact downsamples samples older than the raw retention window relative
+// to now and drops the oldest samples until the file fits in its budget.
+func (s *Store) Compact(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compactLocked(now)
+}
+
+func (s *Store) compactLocked(now time.Time) error {
+	all, err := s.readLocked()
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Add(-s.opts.RawRetention).Unix()
+	split := sort.Search(len(all), func(i int) bool { return all[i].Ts >= cutoff })
+	kept := append(Downsample(all[:split], s.opts.DownsampleStep), all[split:]...)
+
+	lines := make([][]byte, 0, len(kept))
+	var total int64
+	for _, sample := range kept {
+		b, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, b)
+		total += int64(len(b)) + 1
+	}
+
+	// Leave some headroom so that we do not compact on every append once the
+	// budget is reached.
+	budget := s.opts.MaxBytes * 3 / 4
+	first := 0
+	for total > budget && first < len(lines) {
+		total -= int64(len(lines[first])) + 1
+		first++
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines[first:] {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	// Appends make a backup stale right away, so the file is only replaced
+	// atomically.
+	if err := persist.Replace(s.path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write compacted history: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"before":  len(all),
+		"after":   len(lines) - first,
+		"dropped": first,
+		"bytes":   buf.Len(),
+	}).Debug("compacted battery history")
+
+	s.size = int64(buf.Len())
+	return nil
+}
+
+func (s *Store) readLocked() ([]Sample, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open history file: %w", err)
+	}
+	defer f.Close()
+
+	var ret []Sample
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var sample Sample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			// A crash in the middle of an append leaves a partial line.
+			logrus.WithError(err).WithField("line", line).Warn("skipping malformed history sample")
+			continue
+		}
+		ret = append(ret, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history file: %w", err)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Ts < ret[j].Ts })
+	return ret, nil
+}
+
+// Downsample aggregates chronologically ordered samples into buckets of
+// step aligned to the unix epoch. Charge and power are averaged, and the
+// boolean fields take the majority value.
+func Downsample(samples []Sample, step time.Duration) []Sample {
+	stepSecs := int64(step / time.Second)
+	if stepSecs <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	var ret []Sample
+	var bucket []Sample
+	flush := func() {
+		if len(bucket) > 0 {
+			ret = append(ret, aggregate(bucket, bucket[0].Ts-mod(bucket[0].Ts, stepSecs)))
+			bucket = bucket[:0]
+		}
+	}
+	for _, sample := range samples {
+		if len(bucket) > 0 && floorDiv(sample.Ts, stepSecs) != floorDiv(bucket[0].Ts, stepSecs) {
+			flush()
+		}
+		bucket = append(bucket, sample)
+	}
+	flush()
+	return ret
+}
+
+func aggregate(samples []Sample, ts int64) Sample {
+	var charge, system, battery float64
+	var plugged, charging, adapter int
+	for _, s := range samples {
+		charge += float64(s.Charge)
+		system += s.SystemPower
+		battery += s.BatteryPower
+		if s.PluggedIn {
+			plugged++
+		}
+		if s.Charging {
+			charging++
+		}
+		if s.AdapterEnabled {
+			adapter++
+		}
+	}
+	n := float64(len(samples))
+	majority := func(count int) bool { return count*2 >= len(samples) }
+	return Sample{
+		Ts:             ts,
+		Charge:         int(math.Round(charge / n)),
+		PluggedIn:      majority(plugged),
+		Charging:       majority(charging),
+		AdapterEnabled: majority(adapter),
+		SystemPower:    round2(system / n),
+		BatteryPower:   round2(battery / n),
+	}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func floorDiv(a, b int64) int64 {
+	return (a - mod(a, b)) / b
+}
+
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
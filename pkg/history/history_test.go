@@ -0,0 +1,142 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T, opts Options) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "history", "batt.history.jsonl"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	s := openStore(t, Options{})
+	base := time.Unix(1_700_000_000, 0)
+	for i := 0; i < 6; i++ {
+		err := s.Append(Sample{
+			Ts:           base.Add(time.Duration(i) * 10 * time.Second).Unix(),
+			Charge:       50 + i,
+			PluggedIn:    true,
+			Charging:     i < 4,
+			SystemPower:  float64(i),
+			BatteryPower: 10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.Query(time.Time{}, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 6 {
+		t.Fatalf("len = %d, want 6", len(got))
+	}
+
+	got, err = s.Query(base.Add(20*time.Second), base.Add(40*time.Second), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Charge != 52 || got[2].Charge != 54 {
+		t.Fatalf("unexpected range query result: %+v", got)
+	}
+
+	got, err = s.Query(time.Time{}, time.Time{}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// base is 20s into its minute, so the six samples span two buckets.
+	if len(got) != 2 {
+		t.Fatalf("len = %d, want 2: %+v", len(got), got)
+	}
+	if got[0].Ts != base.Unix()-20 || got[0].Charge != 52 || !got[0].Charging {
+		t.Fatalf("unexpected first bucket: %+v", got[0])
+	}
+	if got[1].Charge != 55 || got[1].Charging || got[1].SystemPower != 4.5 {
+		t.Fatalf("unexpected second bucket: %+v", got[1])
+	}
+}
+
+func TestQuerySkipsMalformedLines(t *testing.T) {
+	s := openStore(t, Options{})
+	if err := s.Append(Sample{Ts: 100, Charge: 80}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(s.Path(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"ts":110,"char`); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	got, err := s.Query(time.Time{}, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Charge != 80 {
+		t.Fatalf("unexpected samples: %+v", got)
+	}
+}
+
+func TestCompactDownsamplesAndBoundsSize(t *testing.T) {
+	s := openStore(t, Options{
+		MaxBytes:       8 << 10,
+		RawRetention:   time.Hour,
+		DownsampleStep: 10 * time.Minute,
+	})
+	now := time.Unix(1_700_000_000, 0)
+	start := now.Add(-6 * time.Hour)
+	for ts := start; ts.Before(now); ts = ts.Add(10 * time.Second) {
+		if err := s.Append(Sample{Ts: ts.Unix(), Charge: 80, PluggedIn: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fi, err := os.Stat(s.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > 8<<10+200 {
+		t.Fatalf("history file is %d bytes, want about 8 KiB at most", fi.Size())
+	}
+
+	if err := s.Compact(now); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Query(time.Time{}, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 {
+		t.Fatal("compaction dropped everything")
+	}
+	cutoff := now.Add(-time.Hour).Unix()
+	for i, sample := range got {
+		if sample.Ts < cutoff && sample.Ts%600 != 0 {
+			t.Fatalf("sample %d at %d is older than the raw window but not downsampled", i, sample.Ts)
+		}
+		if i > 0 && got[i-1].Ts >= sample.Ts {
+			t.Fatalf("samples out of order at %d", i)
+		}
+	}
+	if last := got[len(got)-1]; last.Ts != now.Add(-10*time.Second).Unix() {
+		t.Fatalf("newest sample = %d, want it kept at full resolution", last.Ts)
+	}
+}
+
+func TestDownsampleNegativeTimestamps(t *testing.T) {
+	got := Downsample([]Sample{{Ts: -5, Charge: 10}, {Ts: -1, Charge: 20}, {Ts: 1, Charge: 30}}, 10*time.Second)
+	if len(got) != 2 || got[0].Ts != -10 || got[0].Charge != 15 || got[1].Ts != 0 {
+		t.Fatalf("unexpected buckets: %+v", got)
+	}
+}
@@ -0,0 +1,136 @@
+// Package metrics writes metrics in the Prometheus text exposition format.
+//
+// batt exports a few dozen values that are computed on every scrape, so it
+// does not need a client library with registries and collectors. Writer
+// emits HELP/TYPE headers once per metric family and escapes label values.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the Content-Type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Type is a metric family type.
+type Type string
+
+const (
+	Gauge   Type = "gauge"
+	Counter Type = "counter"
+)
+
+// Labels are the labels of a single sample.
+type Labels map[string]string
+
+// Writer writes metric families to an io.Writer. The first write error is
+// kept and returned by Err; later writes are skipped.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Err returns the first error encountered while writing.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Family writes the HELP and TYPE lines of a metric family. Call Sample for
+// each of its samples afterwards.
+func (w *Writer) Family(name, help string, typ Type) {
+	w.printf("# HELP %s %s\n", name, escapeHelp(help))
+	w.printf("# TYPE %s %s\n", name, typ)
+}
+
+// Sample writes one sample of the current family.
+func (w *Writer) Sample(name string, labels Labels, value float64) {
+	w.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+// Gauge writes a gauge family with a single unlabelled sample.
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.Family(name, help, Gauge)
+	w.Sample(name, nil, value)
+}
+
+// Counter writes a counter family with a single unlabelled sample.
+func (w *Writer) Counter(name, help string, value uint64) {
+	w.Family(name, help, Counter)
+	w.Sample(name, nil, float64(value))
+}
+
+// Bool converts a boolean to a gauge value.
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (w *Writer) printf(format string, a ...any) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, a...)
+}
+
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
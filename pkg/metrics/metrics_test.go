@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var b strings.Builder
+	w := NewWriter(&b)
+	w.Gauge("batt_charge_percent", "Battery charge.", 81)
+	w.Counter("batt_loop_runs_total", "Loop runs.\nSecond line.", 12)
+	w.Family("batt_phase", "Phase.", Gauge)
+	w.Sample("batt_phase", Labels{"phase": `Idle "x"`, "a": `b\c`}, Bool(true))
+	w.Gauge("batt_power_watts", "Power.", -2.25)
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP batt_charge_percent Battery charge.
+# TYPE batt_charge_percent gauge
+batt_charge_percent 81
+# HELP batt_loop_runs_total Loop runs.\nSecond line.
+# TYPE batt_loop_runs_total counter
+batt_loop_runs_total 12
+# HELP batt_phase Phase.
+# TYPE batt_phase gauge
+batt_phase{a="b\\c",phase="Idle \"x\""} 1
+# HELP batt_power_watts Power.
+# TYPE batt_power_watts gauge
+batt_power_watts -2.25
+`
+	if got := b.String(); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.n++
+	return 0, errors.New("broken pipe")
+}
+
+func TestWriterKeepsFirstError(t *testing.T) {
+	fw := &failingWriter{}
+	w := NewWriter(fw)
+	w.Gauge("a", "a", 1)
+	w.Gauge("b", "b", 2)
+	if w.Err() == nil || fw.n != 1 {
+		t.Fatalf("err = %v after %d writes, want the first error only", w.Err(), fw.n)
+	}
+}
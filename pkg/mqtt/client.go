@@ -0,0 +1,297 @@
+// Package mqtt is a minimal MQTT 3.1.1 client. It supports what the daemon
+// needs to talk to a home automation broker: QoS 0 publishing with the
+// retain flag, QoS 0 subscriptions, a last will, and keep-alive pings.
+//
+// It is not a general purpose client. The daemon runs as root, so it keeps
+// its dependencies few, and eclipse/paho.mqtt.golang would bring in a
+// websocket stack, QoS 1/2 persistence and its own reconnect logic that the
+// daemon does not use: runMQTT already reconnects with backoff. QoS 0 over a
+// single connection is small enough to keep here, and mqtttest covers it.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/endpoint"
+)
+
+// Options configure a connection.
+type Options struct {
+	// Broker is the broker address: "host:port", "tcp://host:port", or
+	// "tls://host:port" (also "ssl://" and "mqtts://"). The port defaults to
+	// 1883, or 8883 with TLS.
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	// Will is published by the broker when the connection is lost.
+	Will *Message
+	// TLSConfig is used for TLS brokers. Nil uses the system defaults.
+	TLSConfig *tls.Config
+	// WriteTimeout bounds each write, so a broker that stops reading cannot
+	// block publishers. It defaults to DefaultWriteTimeout.
+	WriteTimeout time.Duration
+}
+
+// DefaultWriteTimeout is the WriteTimeout used when none is set.
+const DefaultWriteTimeout = 10 * time.Second
+
+// Handler is called for each message received on a subscription. It runs on
+// the connection's read loop, so it must not block.
+type Handler func(Message)
+
+type subscription struct {
+	filter  string
+	handler Handler
+}
+
+// Client is a connection to a broker.
+type Client struct {
+	conn net.Conn
+	opts Options
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	subs    []subscription
+	nextID  uint16
+	pending map[uint16]chan struct{}
+
+	done chan struct{}
+	err  error
+}
+
+// ErrConnectionRefused is returned by Dial when the broker rejects the
+// connection.
+var ErrConnectionRefused = errors.New("MQTT connection refused")
+
+// Dial connects to the broker and completes the MQTT handshake.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	addr, useTLS, err := endpoint.ParseMQTTBroker(opts.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if useTLS {
+		cfg := opts.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	c := &Client{
+		conn:    conn,
+		opts:    opts,
+		pending: map[uint16]chan struct{}{},
+		done:    make(chan struct{}),
+	}
+	r := bufio.NewReader(conn)
+	if err := c.handshake(ctx, r); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop(r)
+	if opts.KeepAlive > 0 {
+		go c.pingLoop()
+	}
+	return c, nil
+}
+
+func (c *Client) handshake(ctx context.Context, r *bufio.Reader) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+		defer func() { _ = c.conn.SetDeadline(time.Time{}) }()
+	}
+	if err := c.write(encodeConnect(ConnectOptions{
+		ClientID:     c.opts.ClientID,
+		Username:     c.opts.Username,
+		Password:     c.opts.Password,
+		KeepAlive:    uint16(c.opts.KeepAlive / time.Second),
+		CleanSession: true,
+		Will:         c.opts.Will,
+	})); err != nil {
+		return err
+	}
+	p, err := ReadPacket(r)
+	if err != nil {
+		return err
+	}
+	if p.Type() != TypeConnack || len(p.Body) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", p.Type())
+	}
+	if code := p.Body[1]; code != ConnackAccepted {
+		return fmt.Errorf("%w: return code %d", ErrConnectionRefused, code)
+	}
+	return nil
+}
+
+// write sends p. A failed write may have sent part of p, so it closes the
+// connection.
+func (c *Client) write(p Packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	timeout := c.opts.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err := WritePacket(c.conn, p); err != nil {
+		c.close(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		p, err := ReadPacket(r)
+		if err != nil {
+			c.close(err)
+			return
+		}
+		switch p.Type() {
+		case TypePublish:
+			m, _, err := DecodePublish(p)
+			if err != nil {
+				c.close(err)
+				return
+			}
+			c.dispatch(m)
+		case TypeSuback:
+			if len(p.Body) >= 2 {
+				c.ack(uint16(p.Body[0])<<8 | uint16(p.Body[1]))
+			}
+		case TypePingresp:
+		default:
+			c.close(fmt.Errorf("unexpected MQTT packet type %d", p.Type()))
+			return
+		}
+	}
+}
+
+func (c *Client) pingLoop() {
+	t := time.NewTicker(c.opts.KeepAlive / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if err := c.write(pingreq); err != nil {
+				c.close(err)
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) dispatch(m Message) {
+	c.mu.Lock()
+	var handlers []Handler
+	for _, s := range c.subs {
+		if MatchTopic(s.filter, m.Topic) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	c.mu.Unlock()
+	for _, h := range handlers {
+		h(m)
+	}
+}
+
+func (c *Client) ack(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch, ok := c.pending[id]; ok {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// Publish sends a QoS 0 message.
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+	return c.write(EncodePublish(Message{Topic: topic, Payload: payload, Retain: retain}))
+}
+
+// Subscribe subscribes to filter and waits for the broker to acknowledge.
+func (c *Client) Subscribe(ctx context.Context, filter string, h Handler) error {
+	c.mu.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	id := c.nextID
+	acked := make(chan struct{})
+	c.pending[id] = acked
+	c.subs = append(c.subs, subscription{filter: filter, handler: h})
+	c.mu.Unlock()
+
+	if err := c.write(encodeSubscribe(id, filter)); err != nil {
+		return err
+	}
+	select {
+	case <-acked:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed when the connection is lost or closed.
+func (c *Client) Done() <-chan struct{} { return c.done }
+
+// Err returns why the connection ended, or nil while it is open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close disconnects cleanly. The broker does not publish the will.
+func (c *Client) Close() error {
+	_ = c.write(disconnect)
+	c.close(net.ErrClosed)
+	return nil
+}
+
+func (c *Client) close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	_ = c.conn.Close()
+	close(c.done)
+}
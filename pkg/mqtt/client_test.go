@@ -0,0 +1,194 @@
+package mqtt_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/mqtt"
+	"github.com/charlie0129/batt/pkg/mqtt/mqtttest"
+)
+
+func newBroker(t *testing.T) *mqtttest.Broker {
+	t.Helper()
+	b, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b
+}
+
+func dial(t *testing.T, opts mqtt.Options) *mqtt.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := mqtt.Dial(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// waitRetained waits until topic holds a retained message with payload.
+func waitRetained(t *testing.T, b *mqtttest.Broker, topic, payload string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		changed := b.Changed()
+		if m, ok := b.Retained(topic); ok && string(m.Payload) == payload {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			m, _ := b.Retained(topic)
+			t.Fatalf("retained %s = %q, want %q", topic, m.Payload, payload)
+		}
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b := newBroker(t)
+	b.Username, b.Password = "user", "pass"
+
+	pub := dial(t, mqtt.Options{Broker: b.Addr, ClientID: "pub", Username: "user", Password: "pass", KeepAlive: time.Minute})
+	if err := pub.Publish("batt/state", []byte("80"), true); err != nil {
+		t.Fatal(err)
+	}
+	waitRetained(t, b, "batt/state", "80")
+
+	sub := dial(t, mqtt.Options{Broker: "tcp://" + b.Addr, ClientID: "sub", Username: "user", Password: "pass"})
+	got := make(chan mqtt.Message, 4)
+	if err := sub.Subscribe(context.Background(), "batt/#", func(m mqtt.Message) { got <- m }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retained message is delivered on subscribe, then live ones.
+	if err := pub.Publish("batt/limit/set", []byte("70"), false); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"batt/state=80", "batt/limit/set=70"} {
+		select {
+		case m := <-got:
+			if s := m.Topic + "=" + string(m.Payload); s != want {
+				t.Fatalf("got %s, want %s", s, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive %s", want)
+		}
+	}
+}
+
+func TestWill(t *testing.T) {
+	b := newBroker(t)
+	c := dial(t, mqtt.Options{
+		Broker:   b.Addr,
+		ClientID: "batt",
+		Will:     &mqtt.Message{Topic: "batt/status", Payload: []byte("offline"), Retain: true},
+	})
+	if err := c.Publish("batt/status", []byte("online"), true); err != nil {
+		t.Fatal(err)
+	}
+	waitRetained(t, b, "batt/status", "online")
+
+	b.Disconnect()
+	waitRetained(t, b, "batt/status", "offline")
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not notice the lost connection")
+	}
+	if c.Err() == nil {
+		t.Fatal("Err() = nil after the connection was lost")
+	}
+}
+
+func TestPublishToStalledBroker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	// The broker accepts the connection and then never reads again.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		if _, err := mqtt.ReadPacket(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		_ = mqtt.WritePacket(conn, mqtt.EncodeConnack(mqtt.ConnackAccepted))
+	}()
+
+	c := dial(t, mqtt.Options{Broker: ln.Addr().String(), ClientID: "batt", WriteTimeout: 100 * time.Millisecond})
+	failed := make(chan error, 1)
+	go func() {
+		payload := make([]byte, 64<<10)
+		for {
+			if err := c.Publish("batt/state", payload, false); err != nil {
+				failed <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-failed:
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			t.Fatalf("err = %v, want a timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Publish blocked on a broker that does not read")
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("the connection is still open after a failed write")
+	}
+}
+
+func TestDialBadCredentials(t *testing.T) {
+	b := newBroker(t)
+	b.Username, b.Password = "user", "pass"
+
+	_, err := mqtt.Dial(context.Background(), mqtt.Options{Broker: b.Addr, ClientID: "batt", Username: "user", Password: "wrong"})
+	if !errors.Is(err, mqtt.ErrConnectionRefused) {
+		t.Fatalf("err = %v, want ErrConnectionRefused", err)
+	}
+}
+
+func TestDialInvalidBroker(t *testing.T) {
+	for _, broker := range []string{"", "http://example.com", "tcp://:1883"} {
+		if _, err := mqtt.Dial(context.Background(), mqtt.Options{Broker: broker}); err == nil {
+			t.Errorf("Dial(%q) succeeded", broker)
+		}
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	for _, tt := range []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"batt/state", "batt/state", true},
+		{"batt/state", "batt/states", false},
+		{"batt/+/set", "batt/limit/set", true},
+		{"batt/+/set", "batt/limit/get", false},
+		{"batt/+", "batt/limit/set", false},
+		{"batt/#", "batt/limit/set", true},
+		{"batt/#", "batt", true},
+		{"#", "batt/limit", true},
+		{"batt/limit", "batt", false},
+	} {
+		if got := mqtt.MatchTopic(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("MatchTopic(%q, %q) = %t, want %t", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
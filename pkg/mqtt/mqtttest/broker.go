@@ -0,0 +1,227 @@
+// Package mqtttest provides an in-process MQTT broker for tests.
+package mqtttest
+
+import (
+	"bufio"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/charlie0129/batt/pkg/mqtt"
+)
+
+// Broker is a minimal MQTT 3.1.1 broker listening on localhost. It supports
+// QoS 0, retained messages, wildcards, wills and optional authentication.
+type Broker struct {
+	// Addr is the "host:port" the broker listens on.
+	Addr string
+
+	// Username and Password, if set, are required from clients.
+	Username string
+	Password string
+
+	ln net.Listener
+
+	mu       sync.Mutex
+	clients  map[*conn]struct{}
+	retained map[string]mqtt.Message
+	received []mqtt.Message
+	notify   chan struct{}
+	wg       sync.WaitGroup
+}
+
+type conn struct {
+	net.Conn
+	writeMu sync.Mutex
+	filters []string
+	will    *mqtt.Message
+}
+
+func (c *conn) write(p mqtt.Packet) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = mqtt.WritePacket(c.Conn, p)
+}
+
+// NewBroker starts a broker. Call Close when done.
+func NewBroker() (*Broker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		Addr:     ln.Addr().String(),
+		ln:       ln,
+		clients:  map[*conn]struct{}{},
+		retained: map[string]mqtt.Message{},
+		notify:   make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// Close stops the broker and drops all connections without publishing wills.
+func (b *Broker) Close() {
+	_ = b.ln.Close()
+	b.mu.Lock()
+	for c := range b.clients {
+		c.will = nil
+		_ = c.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// Retained returns the retained message on topic.
+func (b *Broker) Retained(topic string) (mqtt.Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.retained[topic]
+	return m, ok
+}
+
+// Messages returns every message published to the broker so far.
+func (b *Broker) Messages() []mqtt.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.received)
+}
+
+// Changed returns a channel that is closed on the next publish.
+func (b *Broker) Changed() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.notify
+}
+
+// Publish publishes m as if it came from a client.
+func (b *Broker) Publish(m mqtt.Message) {
+	b.route(m)
+}
+
+// Disconnect drops all client connections, which publishes their wills.
+func (b *Broker) Disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		_ = c.Close()
+	}
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		nc, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: nc}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serve(c)
+		}()
+	}
+}
+
+func (b *Broker) serve(c *conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	p, err := mqtt.ReadPacket(r)
+	if err != nil || p.Type() != mqtt.TypeConnect {
+		return
+	}
+	opts, err := mqtt.DecodeConnect(p)
+	if err != nil {
+		return
+	}
+	if b.Username != "" && (opts.Username != b.Username || opts.Password != b.Password) {
+		c.write(mqtt.EncodeConnack(mqtt.ConnackBadCredentials))
+		return
+	}
+	c.will = opts.Will
+	c.write(mqtt.EncodeConnack(mqtt.ConnackAccepted))
+
+	b.mu.Lock()
+	b.clients[c] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, c)
+		will := c.will
+		b.mu.Unlock()
+		if will != nil {
+			b.route(*will)
+		}
+	}()
+
+	for {
+		p, err := mqtt.ReadPacket(r)
+		if err != nil {
+			return
+		}
+		switch p.Type() {
+		case mqtt.TypePublish:
+			m, _, err := mqtt.DecodePublish(p)
+			if err != nil {
+				return
+			}
+			b.route(m)
+		case mqtt.TypeSubscribe:
+			id, filters, err := mqtt.DecodeSubscribe(p)
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			c.filters = append(c.filters, filters...)
+			var retained []mqtt.Message
+			for _, m := range b.retained {
+				if slices.ContainsFunc(filters, func(f string) bool { return mqtt.MatchTopic(f, m.Topic) }) {
+					retained = append(retained, m)
+				}
+			}
+			b.mu.Unlock()
+			c.write(mqtt.EncodeSuback(id, len(filters)))
+			for _, m := range retained {
+				c.write(mqtt.EncodePublish(m))
+			}
+		case mqtt.TypePingreq:
+			c.write(mqtt.Pingresp)
+		case mqtt.TypeDisconnect:
+			b.mu.Lock()
+			c.will = nil
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+// route records m and forwards it to matching subscribers.
+func (b *Broker) route(m mqtt.Message) {
+	b.mu.Lock()
+	b.received = append(b.received, m)
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	var targets []*conn
+	for c := range b.clients {
+		if slices.ContainsFunc(c.filters, func(f string) bool { return mqtt.MatchTopic(f, m.Topic) }) {
+			targets = append(targets, c)
+		}
+	}
+	close(b.notify)
+	b.notify = make(chan struct{})
+	b.mu.Unlock()
+
+	// Forwarded messages are not retained, like a live publish.
+	fwd := mqtt.EncodePublish(mqtt.Message{Topic: m.Topic, Payload: m.Payload})
+	for _, c := range targets {
+		c.write(fwd)
+	}
+}
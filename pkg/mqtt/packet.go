@@ -0,0 +1,317 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Control packet types, MQTT 3.1.1 section 2.2.1.
+const (
+	TypeConnect    byte = 1
+	TypeConnack    byte = 2
+	TypePublish    byte = 3
+	TypeSubscribe  byte = 8
+	TypeSuback     byte = 9
+	TypePingreq    byte = 12
+	TypePingresp   byte = 13
+	TypeDisconnect byte = 14
+)
+
+// maxRemainingLength is the largest remaining length MQTT can encode.
+const maxRemainingLength = 268435455
+
+var errMalformed = errors.New("malformed MQTT packet")
+
+// Packet is a raw control packet: the first header byte and the bytes
+// following the remaining length.
+type Packet struct {
+	Header byte
+	Body   []byte
+}
+
+func (p Packet) Type() byte  { return p.Header >> 4 }
+func (p Packet) Flags() byte { return p.Header & 0x0f }
+
+// ReadPacket reads one control packet.
+func ReadPacket(r *bufio.Reader) (Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return Packet{}, err
+	}
+	length, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return Packet{}, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return Packet{}, err
+		}
+		length += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		mult *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Packet{}, err
+	}
+	return Packet{Header: header, Body: body}, nil
+}
+
+// WritePacket writes one control packet.
+func WritePacket(w io.Writer, p Packet) error {
+	if len(p.Body) > maxRemainingLength {
+		return fmt.Errorf("MQTT packet too large: %d bytes", len(p.Body))
+	}
+	buf := make([]byte, 0, 5+len(p.Body))
+	buf = append(buf, p.Header)
+	n := len(p.Body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	buf = append(buf, p.Body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, v []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+	return append(b, v...)
+}
+
+// reader decodes packet bodies.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = errMalformed
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.b) < 2 {
+		r.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if r.err != nil || len(r.b) < n {
+		r.err = errMalformed
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) string() string { return string(r.bytes()) }
+
+// Message is an application message.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// ConnectOptions is the content of a CONNECT packet.
+type ConnectOptions struct {
+	ClientID     string
+	Username     string
+	Password     string
+	KeepAlive    uint16
+	CleanSession bool
+	Will         *Message
+}
+
+func encodeConnect(o ConnectOptions) Packet {
+	var flags byte
+	if o.CleanSession {
+		flags |= 0x02
+	}
+	if o.Will != nil {
+		flags |= 0x04
+		if o.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if o.Password != "" {
+		flags |= 0x40
+	}
+	if o.Username != "" {
+		flags |= 0x80
+	}
+
+	b := appendString(nil, "MQTT")
+	b = append(b, 4, flags)
+	b = binary.BigEndian.AppendUint16(b, o.KeepAlive)
+	b = appendString(b, o.ClientID)
+	if o.Will != nil {
+		b = appendString(b, o.Will.Topic)
+		b = appendBytes(b, o.Will.Payload)
+	}
+	if o.Username != "" {
+		b = appendString(b, o.Username)
+	}
+	if o.Password != "" {
+		b = appendString(b, o.Password)
+	}
+	return Packet{Header: TypeConnect << 4, Body: b}
+}
+
+// DecodeConnect parses a CONNECT packet body.
+func DecodeConnect(p Packet) (ConnectOptions, error) {
+	r := &reader{b: p.Body}
+	if name := r.string(); name != "MQTT" || r.byte() != 4 {
+		return ConnectOptions{}, fmt.Errorf("unsupported MQTT protocol")
+	}
+	flags := r.byte()
+	o := ConnectOptions{
+		KeepAlive:    r.uint16(),
+		CleanSession: flags&0x02 != 0,
+	}
+	o.ClientID = r.string()
+	if flags&0x04 != 0 {
+		o.Will = &Message{Topic: r.string(), Payload: r.bytes(), Retain: flags&0x20 != 0}
+	}
+	if flags&0x80 != 0 {
+		o.Username = r.string()
+	}
+	if flags&0x40 != 0 {
+		o.Password = r.string()
+	}
+	return o, r.err
+}
+
+// Connack return codes.
+const (
+	ConnackAccepted       byte = 0
+	ConnackBadCredentials byte = 4
+	ConnackNotAuthorized  byte = 5
+)
+
+func EncodeConnack(code byte) Packet {
+	return Packet{Header: TypeConnack << 4, Body: []byte{0, code}}
+}
+
+// EncodePublish encodes a QoS 0 PUBLISH packet.
+func EncodePublish(m Message) Packet {
+	header := TypePublish << 4
+	if m.Retain {
+		header |= 0x01
+	}
+	b := appendString(nil, m.Topic)
+	b = append(b, m.Payload...)
+	return Packet{Header: header, Body: b}
+}
+
+// DecodePublish parses a PUBLISH packet. For QoS 1 and 2 it also returns the
+// packet identifier.
+func DecodePublish(p Packet) (Message, uint16, error) {
+	r := &reader{b: p.Body}
+	m := Message{Topic: r.string(), Retain: p.Flags()&0x01 != 0}
+	var id uint16
+	if qos := (p.Flags() >> 1) & 0x03; qos > 0 {
+		id = r.uint16()
+	}
+	if r.err != nil {
+		return Message{}, 0, r.err
+	}
+	m.Payload = append([]byte(nil), r.b...)
+	return m, id, nil
+}
+
+func encodeSubscribe(id uint16, filters ...string) Packet {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	for _, f := range filters {
+		b = appendString(b, f)
+		b = append(b, 0) // QoS 0
+	}
+	return Packet{Header: TypeSubscribe<<4 | 0x02, Body: b}
+}
+
+// DecodeSubscribe parses a SUBSCRIBE packet.
+func DecodeSubscribe(p Packet) (uint16, []string, error) {
+	r := &reader{b: p.Body}
+	id := r.uint16()
+	var filters []string
+	for r.err == nil && len(r.b) > 0 {
+		filters = append(filters, r.string())
+		r.byte()
+	}
+	if len(filters) == 0 {
+		return 0, nil, errMalformed
+	}
+	return id, filters, r.err
+}
+
+// EncodeSuback grants QoS 0 to n filters.
+func EncodeSuback(id uint16, n int) Packet {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = append(b, make([]byte, n)...)
+	return Packet{Header: TypeSuback << 4, Body: b}
+}
+
+var (
+	pingreq    = Packet{Header: TypePingreq << 4}
+	Pingresp   = Packet{Header: TypePingresp << 4}
+	disconnect = Packet{Header: TypeDisconnect << 4}
+)
+
+// MatchTopic reports whether topic matches filter, which may contain the
+// + and # wildcards.
+func MatchTopic(filter, topic string) bool {
+	for {
+		fi, ti := indexSlash(filter), indexSlash(topic)
+		fl, tl := filter[:fi], topic[:ti]
+		if fl == "#" {
+			return true
+		}
+		if fl != "+" && fl != tl {
+			return false
+		}
+		if fi == len(filter) || ti == len(topic) {
+			// "a/#" also matches "a".
+			return fi == len(filter) && ti == len(topic) || filter[fi:] == "/#"
+		}
+		filter, topic = filter[fi+1:], topic[ti+1:]
+	}
+}
+
+// indexSlash returns the index of the first '/' in s, or len(s).
+func indexSlash(s string) int {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		return i
+	}
+	return len(s)
+}
@@ -0,0 +1,148 @@
+// Package persist writes small state files so that a crash or power loss
+// never leaves them half-written, and reads them back from a backup when they
+// are damaged anyway.
+package persist
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BackupSuffix is appended to the path of a file to get its backup.
+const BackupSuffix = ".bak"
+
+// ReadOptions configure ReadFile.
+type ReadOptions struct {
+	// OnRestore, if not nil, is called when ReadFile falls back to the backup
+	// of path, with the reason path could not be used.
+	OnRestore func(path string, err error)
+}
+
+// WriteFile replaces the file at path with data atomically: the data is
+// written to a temporary file in the same directory, synced to disk and
+// renamed over path. A backup with the same content is then written next to
+// it the same way. An existing file keeps its permissions, otherwise perm is
+// used. Symlinks are followed, so the target is replaced, not the link.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return writeFile(path, data, perm, 0)
+}
+
+// WritePrivateFile is WriteFile for data with secrets in it. The file and its
+// backup are only accessible by their owner, even if the file was not.
+func WritePrivateFile(path string, data []byte) error {
+	return writeFile(path, data, 0600, 0077)
+}
+
+// writeFile is WriteFile, with the permissions in mask removed from those
+// of an existing file.
+func writeFile(path string, data []byte, perm, mask os.FileMode) error {
+	path = resolve(path)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm() &^ mask
+	}
+
+	if err := Replace(path, data, perm); err != nil {
+		return err
+	}
+	// The backup is only written once the file itself is on disk, so at any
+	// time at least one of them is complete.
+	if err := Replace(path+BackupSuffix, data, perm); err != nil {
+		return pkgerrors.Wrapf(err, "failed to back up %s", path)
+	}
+	return nil
+}
+
+// ReadFile reads the file at path and passes its content to decode. If the
+// file cannot be read, or decode fails, the backup written by WriteFile is
+// decoded instead and the fallback is logged and reported to opts.OnRestore.
+// If the backup fails too, the error for path is returned.
+//
+// A missing file is not restored from its backup, because it was most likely
+// removed on purpose; an error satisfying errors.Is(err, fs.ErrNotExist) is
+// returned instead. decode must not keep state from a failed call.
+func ReadFile(path string, decode func([]byte) error, opts ReadOptions) error {
+	path = resolve(path)
+	err := readFile(path, decode)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	backup := path + BackupSuffix
+	if backupErr := readFile(backup, decode); backupErr != nil {
+		logrus.WithError(backupErr).WithField("path", backup).Debug("backup is not usable either")
+		return err
+	}
+	logrus.WithError(err).WithField("path", path).Warn("file is damaged, using the last good version from its backup")
+	if opts.OnRestore != nil {
+		opts.OnRestore(path, err)
+	}
+	return nil
+}
+
+func readFile(path string, decode func([]byte) error) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := decode(b); err != nil {
+		return pkgerrors.Wrapf(err, "failed to decode %s", path)
+	}
+	return nil
+}
+
+// Replace atomically replaces the file at path with data, like WriteFile,
+// but without a backup. perm is used as is, and a symlink at path is
+// replaced itself.
+func Replace(path string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to create temporary file for %s", path)
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return pkgerrors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return pkgerrors.Wrapf(err, "failed to set permissions of %s", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		return pkgerrors.Wrapf(err, "failed to sync %s", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return pkgerrors.Wrapf(err, "failed to close %s", tmp.Name())
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return pkgerrors.Wrapf(err, "failed to rename %s to %s", tmp.Name(), path)
+	}
+
+	// Sync the directory too, so that the rename itself survives a crash.
+	d, err := os.Open(dir)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to open %s", dir)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return pkgerrors.Wrapf(err, "failed to sync %s", dir)
+	}
+	return nil
+}
+
+// resolve follows symlinks in path, if it exists.
+func resolve(path string) string {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		return p
+	}
+	return path
+}
@@ -0,0 +1,115 @@
+package persist
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	var restored []string
+	opts := ReadOptions{OnRestore: func(path string, _ error) { restored = append(restored, path) }}
+
+	decode := func(v *map[string]int) func([]byte) error {
+		return func(b []byte) error {
+			*v = nil
+			return json.Unmarshal(b, v)
+		}
+	}
+
+	var got map[string]int
+	if err := ReadFile(path, decode(&got), opts); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ReadFile() of a missing file = %v, want fs.ErrNotExist", err)
+	}
+
+	if err := WriteFile(path, []byte(`{"limit":80}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(path, []byte(`{"limit":70}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("mode = %v (%v), want the existing 0600 kept", fi.Mode(), err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("files left behind: %v", entries)
+	}
+
+	if err := ReadFile(path, decode(&got), opts); err != nil || got["limit"] != 70 {
+		t.Fatalf("ReadFile() = %v, %v", got, err)
+	}
+	if len(restored) != 0 {
+		t.Fatalf("restored %v from an intact file", restored)
+	}
+
+	// A torn write falls back to the backup.
+	if err := os.WriteFile(path, []byte(`{"lim`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReadFile(path, decode(&got), opts); err != nil || got["limit"] != 70 {
+		t.Fatalf("ReadFile() of a damaged file = %v, %v", got, err)
+	}
+	if len(restored) != 1 || restored[0] != path {
+		t.Fatalf("restored = %v, want %s", restored, path)
+	}
+
+	// Without a usable backup, the error for the file itself is returned.
+	if err := os.WriteFile(path+BackupSuffix, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReadFile(path, decode(&got), opts); err == nil {
+		t.Fatal("ReadFile() succeeded without a usable file or backup")
+	}
+}
+
+func TestWriteFileFollowsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.json")
+	link := filepath.Join(dir, "link.json")
+	if err := os.WriteFile(target, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(link, []byte(`{"limit":60}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("link was replaced: %v, %v", fi, err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != `{"limit":60}` {
+		t.Fatalf("target = %s", b)
+	}
+	if _, err := os.Stat(target + BackupSuffix); err != nil {
+		t.Fatalf("backup not next to the target: %v", err)
+	}
+}
+
+func TestWritePrivateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batt.json")
+	if err := WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WritePrivateFile(path, []byte(`{"secret":"s"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, path + BackupSuffix} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Fatalf("%s has permissions %o, want 600", p, perm)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package powerinfo
 
+import "time"
+
 // BatteryState represents the charging state of the battery.
 type BatteryState int
 
@@ -43,3 +45,25 @@ type PowerTelemetry struct {
 		HealthByMaxCapacity int     `json:"HealthByMaxCapacity"`
 	} `json:"Calculations"`
 }
+
+// Temperature holds the battery temperature and the state of the charging
+// temperature guard.
+type Temperature struct {
+	// Battery is the battery temperature in °C. It is zero when unknown.
+	Battery float64          `json:"battery"`
+	Guard   TemperatureGuard `json:"guard"`
+}
+
+// TemperatureGuard describes the guard that stops charging a hot battery.
+type TemperatureGuard struct {
+	Enabled bool `json:"enabled"`
+	Active  bool `json:"active"`
+	// MaxTemperature is the temperature in °C the guard activates at.
+	MaxTemperature float64 `json:"maxTemperature,omitempty"`
+	// ResumeTemperature is the temperature in °C the guard releases at.
+	ResumeTemperature float64 `json:"resumeTemperature,omitempty"`
+	// Limit is the charge limit enforced while the guard is active. Zero
+	// means charging is paused.
+	Limit int        `json:"limit,omitempty"`
+	Since *time.Time `json:"since,omitempty"`
+}
@@ -0,0 +1,368 @@
+// Package simulator models a MacBook battery behind the SMC, so that the
+// daemon can be run against it without the hardware: Battery implements
+// smc.Conn, and a Scenario describes what happens to it over time.
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/charlie0129/gosmc"
+
+	"github.com/charlie0129/batt/pkg/powerinfo"
+	"github.com/charlie0129/batt/pkg/smc"
+)
+
+// ChargeControl is the charge-control mechanism of the simulated SMC.
+type ChargeControl string
+
+const (
+	// ChargeControlLegacy has CH0B and CH0C, which inhibit charging at 02,
+	// and CH0I, which disables the adapter at 01.
+	ChargeControlLegacy ChargeControl = "legacy"
+	// ChargeControlTahoe has CHTE, which inhibits charging at 01000000, and
+	// CHIE, which disables the adapter at 08.
+	ChargeControlTahoe ChargeControl = "tahoe"
+	// ChargeControlFirmware has bfF0, bfD0 and bfE0: while bfF0 is 02, the
+	// firmware stops charging at the upper limit in bfD0 and resumes below
+	// the lower one in bfE0. The adapter is CH0I, as in legacy.
+	ChargeControlFirmware ChargeControl = "firmware"
+)
+
+// Spec describes a simulated battery.
+type Spec struct {
+	// CapacityWh is the full charge capacity. Defaults to 70 Wh.
+	CapacityWh float64 `yaml:"capacityWh" json:"capacityWh"`
+	// Charge is the initial charge, in percent. Defaults to 50%.
+	Charge float64 `yaml:"charge" json:"charge"`
+	// ChargeWatts is the maximum charging power. Charging tapers off above
+	// 80%. Defaults to 60 W.
+	ChargeWatts float64 `yaml:"chargeWatts" json:"chargeWatts"`
+	// LoadWatts is the power the system draws. Defaults to 8 W.
+	LoadWatts float64 `yaml:"loadWatts" json:"loadWatts"`
+	// AdapterWatts is the power the adapter delivers, first to the system
+	// and the rest to the battery. Defaults to 96 W.
+	AdapterWatts float64 `yaml:"adapterWatts" json:"adapterWatts"`
+	// ChargeControl defaults to legacy.
+	ChargeControl ChargeControl `yaml:"chargeControl" json:"chargeControl"`
+}
+
+func (s *Spec) setDefaults() {
+	if s.CapacityWh == 0 {
+		s.CapacityWh = 70
+	}
+	if s.Charge == 0 {
+		s.Charge = 50
+	}
+	if s.ChargeWatts == 0 {
+		s.ChargeWatts = 60
+	}
+	if s.LoadWatts == 0 {
+		s.LoadWatts = 8
+	}
+	if s.AdapterWatts == 0 {
+		s.AdapterWatts = 96
+	}
+	if s.ChargeControl == "" {
+		s.ChargeControl = ChargeControlLegacy
+	}
+}
+
+// Validate sets the defaults of s and checks it.
+func (s *Spec) Validate() error {
+	s.setDefaults()
+	switch {
+	case s.CapacityWh < 0:
+		return fmt.Errorf("capacityWh must be positive")
+	case s.Charge < 0 || s.Charge > 100:
+		return fmt.Errorf("charge must be between 0 and 100")
+	case s.ChargeWatts < 0 || s.LoadWatts < 0 || s.AdapterWatts < 0:
+		return fmt.Errorf("chargeWatts, loadWatts and adapterWatts must not be negative")
+	}
+	switch s.ChargeControl {
+	case ChargeControlLegacy, ChargeControlTahoe, ChargeControlFirmware:
+	default:
+		return fmt.Errorf("chargeControl must be %s, %s or %s", ChargeControlLegacy, ChargeControlTahoe, ChargeControlFirmware)
+	}
+	return nil
+}
+
+// key is an SMC key of the simulated battery.
+type key struct {
+	typ  gosmc.DataType
+	data []byte
+	// readOnly keys are computed from the battery state.
+	readOnly bool
+}
+
+// Battery is a simulated battery, adapter and SMC. It implements smc.Conn:
+// the SMC keys control charging and the adapter, and report the charge and
+// whether power comes from the wall, as on a Mac with the charge control of
+// its Spec. Time only passes in Step.
+type Battery struct {
+	mu      sync.Mutex
+	spec    Spec
+	keys    map[string]*key
+	charge  float64
+	plugged bool
+	// power is what flows into the battery, negative when discharging.
+	power float64
+	// holding is set while firmware charge control holds the charge at the
+	// upper limit.
+	holding bool
+}
+
+var _ smc.Conn = (*Battery)(nil)
+
+// NewBattery returns a Battery of spec, unplugged.
+func NewBattery(spec Spec) (*Battery, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	b := &Battery{
+		spec:   spec,
+		charge: spec.Charge,
+		keys: map[string]*key{
+			smc.BatteryChargeKey: {typ: gosmc.TypeUInt8, data: []byte{0}, readOnly: true},
+			smc.ACPowerKey:       {typ: gosmc.TypeSInt8, data: []byte{0}, readOnly: true},
+		},
+	}
+	switch spec.ChargeControl {
+	case ChargeControlLegacy:
+		b.keys[smc.ChargingKey1] = &key{typ: gosmc.TypeUInt8, data: []byte{0}}
+		b.keys[smc.ChargingKey2] = &key{typ: gosmc.TypeUInt8, data: []byte{0}}
+		b.keys[smc.AdapterKey1] = &key{typ: gosmc.TypeUInt8, data: []byte{0}}
+	case ChargeControlTahoe:
+		b.keys[smc.ChargingKey3] = &key{typ: gosmc.TypeUInt32, data: []byte{0, 0, 0, 0}}
+		b.keys[smc.AdapterKey3] = &key{typ: gosmc.TypeUInt8, data: []byte{0}}
+	case ChargeControlFirmware:
+		b.keys[smc.FirmwareChargeLimitActivationKey] = &key{typ: gosmc.TypeUInt8, data: []byte{0}}
+		b.keys[smc.FirmwareChargeLimitUpperKey] = &key{typ: gosmc.TypeUInt32, data: []byte{100, 0, 0, 0}}
+		b.keys[smc.FirmwareChargeLimitLowerKey] = &key{typ: gosmc.TypeUInt32, data: []byte{0, 0, 0, 0}}
+		b.keys[smc.AdapterKey1] = &key{typ: gosmc.TypeUInt8, data: []byte{0}}
+	}
+	b.update()
+	return b, nil
+}
+
+// Spec returns the spec of b, with the defaults set.
+func (b *Battery) Spec() Spec {
+	return b.spec
+}
+
+// SetPluggedIn plugs the adapter in or unplugs it.
+func (b *Battery) SetPluggedIn(plugged bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.plugged = plugged
+	b.update()
+}
+
+// Step lets d pass. Charging, the adapter and the load stay the same
+// throughout, so steps should be short compared to how long charging takes.
+func (b *Battery) Step(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.charge += b.power * d.Hours() / b.spec.CapacityWh * 100
+	b.charge = min(max(b.charge, 0), 100)
+	b.update()
+}
+
+// State is the state of a simulated battery.
+type State struct {
+	// Charge is in percent. The SMC reports it rounded.
+	Charge float64
+	// PluggedIn reports whether the adapter is plugged in, whether or not it
+	// is enabled.
+	PluggedIn      bool
+	AdapterEnabled bool
+	Charging       bool
+	// Power flows into the battery, in W. It is negative when discharging.
+	Power float64
+}
+
+// State returns the state of b.
+func (b *Battery) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return State{
+		Charge:         b.charge,
+		PluggedIn:      b.plugged,
+		AdapterEnabled: b.adapterEnabled(),
+		Charging:       b.power > 0,
+		Power:          b.power,
+	}
+}
+
+// BatteryInfo returns what IOKit would report about b.
+func (b *Battery) BatteryInfo() (*powerinfo.Battery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	const voltage = 11.4
+	capacity := int(math.Round(b.spec.CapacityWh / voltage * 1000))
+	state := powerinfo.Discharging
+	switch {
+	case b.power > 0:
+		state = powerinfo.Charging
+	case b.charge >= 100 && b.powered():
+		state = powerinfo.Full
+	}
+	return &powerinfo.Battery{
+		State:          state,
+		DesignCapacity: capacity,
+		MaxCapacity:    capacity,
+		ChargeRate:     int(math.Round(b.power * 1000)),
+		DesignVoltage:  voltage,
+	}, nil
+}
+
+// PowerTelemetry returns what IOKit would report about the power flows of b.
+func (b *Battery) PowerTelemetry() (*powerinfo.PowerTelemetry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var t powerinfo.PowerTelemetry
+	t.Calculations.BatteryPower = b.power
+	t.Calculations.SystemPower = b.spec.LoadWatts
+	if b.powered() {
+		t.Calculations.ACPower = b.spec.LoadWatts + b.power
+	}
+	t.Calculations.HealthByMaxCapacity = 100
+	return &t, nil
+}
+
+func (b *Battery) Open() error  { return nil }
+func (b *Battery) Close() error { return nil }
+
+func (b *Battery) KeyInfo(name string) (gosmc.KeyInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k, ok := b.keys[name]
+	if !ok {
+		return gosmc.KeyInfo{}, fmt.Errorf("%w: %q", gosmc.ErrNoData, name)
+	}
+	return gosmc.KeyInfo{Key: name, DataSize: len(k.data), DataType: k.typ}, nil
+}
+
+func (b *Battery) Read(name string) (gosmc.Value, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k, ok := b.keys[name]
+	if !ok {
+		return gosmc.Value{}, fmt.Errorf("%w: %q", gosmc.ErrNoData, name)
+	}
+	return gosmc.NewValue(name, k.typ, k.data)
+}
+
+func (b *Battery) WriteBytes(name string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k, ok := b.keys[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", gosmc.ErrNoData, name)
+	}
+	if k.readOnly {
+		return fmt.Errorf("%w: %q is read-only", gosmc.ErrUnsupported, name)
+	}
+	if len(data) != len(k.data) {
+		return fmt.Errorf("%w: %q has %d bytes, got %d", gosmc.ErrInvalidData, name, len(k.data), len(data))
+	}
+	k.data = bytes.Clone(data)
+	b.update()
+	return nil
+}
+
+func (b *Battery) WriteUint32(name string, value uint32) error {
+	b.mu.Lock()
+	k, ok := b.keys[name]
+	b.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", gosmc.ErrNoData, name)
+	}
+	data, err := gosmc.EncodeUint(k.typ, len(k.data), uint64(value))
+	if err != nil {
+		return err
+	}
+	return b.WriteBytes(name, data)
+}
+
+// isSet reports whether the key name exists and is not all zeros.
+func (b *Battery) isSet(name string) bool {
+	k, ok := b.keys[name]
+	return ok && !bytes.Equal(k.data, make([]byte, len(k.data)))
+}
+
+func (b *Battery) adapterEnabled() bool {
+	return !b.isSet(smc.AdapterKey1) && !b.isSet(smc.AdapterKey3)
+}
+
+// powered reports whether power comes from the wall.
+func (b *Battery) powered() bool {
+	return b.plugged && b.adapterEnabled()
+}
+
+// firmwareLimit reads the little-endian percentage in a firmware limit key.
+func (b *Battery) firmwareLimit(name string) int {
+	v, _ := gosmc.DecodeUint(b.keys[name].typ, b.keys[name].data)
+	return int(bits.ReverseBytes32(uint32(v)))
+}
+
+// chargingInhibited reports whether the SMC keeps the battery from charging.
+func (b *Battery) chargingInhibited() bool {
+	switch b.spec.ChargeControl {
+	case ChargeControlLegacy:
+		return b.isSet(smc.ChargingKey1) || b.isSet(smc.ChargingKey2)
+	case ChargeControlTahoe:
+		return b.isSet(smc.ChargingKey3)
+	case ChargeControlFirmware:
+		if b.keys[smc.FirmwareChargeLimitActivationKey].data[0] != 0x02 {
+			b.holding = false
+			return false
+		}
+		charge := int(math.Round(b.charge))
+		switch {
+		case charge >= b.firmwareLimit(smc.FirmwareChargeLimitUpperKey):
+			b.holding = true
+		case charge < b.firmwareLimit(smc.FirmwareChargeLimitLowerKey):
+			b.holding = false
+		}
+		return b.holding
+	}
+	return false
+}
+
+// update recomputes the power and the read-only keys. It must be called
+// with mu held whenever the state changes.
+func (b *Battery) update() {
+	inhibited := b.chargingInhibited()
+	switch {
+	case !b.powered():
+		b.power = -b.spec.LoadWatts
+	case b.spec.AdapterWatts < b.spec.LoadWatts:
+		// The adapter cannot keep up and the battery makes up the rest.
+		b.power = b.spec.AdapterWatts - b.spec.LoadWatts
+	case inhibited || b.charge >= 100:
+		b.power = 0
+	default:
+		b.power = min(b.spec.ChargeWatts, b.spec.AdapterWatts-b.spec.LoadWatts) * taper(b.charge)
+	}
+
+	b.keys[smc.BatteryChargeKey].data = []byte{byte(math.Round(b.charge))}
+	ac := byte(0)
+	if b.powered() {
+		ac = 1
+	}
+	b.keys[smc.ACPowerKey].data = []byte{ac}
+}
+
+// taper is the share of the charging power the battery takes at charge:
+// all of it up to 80%, then less and less, down to a tenth when full.
+func taper(charge float64) float64 {
+	if charge <= 80 {
+		return 1
+	}
+	return 1 - 0.9*(charge-80)/20
+}
@@ -0,0 +1,148 @@
+package simulator
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/charlie0129/batt/pkg/calibration"
+)
+
+// Scenario describes a simulation: the battery, when it is plugged in and
+// when calibrations are started. Times in it are offsets from Start.
+type Scenario struct {
+	// Start is the time on the virtual clock the simulation starts at.
+	// Defaults to the last midnight.
+	Start time.Time `yaml:"start"`
+	// Duration is how long to simulate.
+	Duration time.Duration `yaml:"duration"`
+	// Step is how far the virtual clock advances per maintain loop. Defaults
+	// to the loop interval of the daemon, 10s.
+	Step time.Duration `yaml:"step"`
+	// Sample is how often the timeline reports the state when nothing
+	// changes. Defaults to 1h.
+	Sample  time.Duration `yaml:"sample"`
+	Battery Spec          `yaml:"battery"`
+	// Power is when the adapter is plugged in and unplugged. It is unplugged
+	// until the first change.
+	Power []PowerChange `yaml:"power"`
+	// Repeat repeats Power at this interval, for example 24h for the same
+	// day every day.
+	Repeat time.Duration `yaml:"repeat"`
+	// Calibrate starts a calibration at each of these offsets, in addition
+	// to those the cron schedule of the config starts.
+	Calibrate []time.Duration `yaml:"calibrate"`
+}
+
+// PowerChange plugs the adapter in or unplugs it.
+type PowerChange struct {
+	At        time.Duration `yaml:"at"`
+	PluggedIn bool          `yaml:"pluggedIn"`
+}
+
+// LoadScenario reads a scenario from a YAML file.
+func LoadScenario(path string) (*Scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScenario(b)
+}
+
+// ParseScenario parses a YAML scenario and checks it.
+func ParseScenario(b []byte) (*Scenario, error) {
+	var s Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	return &s, nil
+}
+
+// Validate sets the defaults of s and checks it.
+func (s *Scenario) Validate() error {
+	if s.Start.IsZero() {
+		y, m, d := time.Now().Date()
+		s.Start = time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	}
+	if s.Step == 0 {
+		s.Step = 10 * time.Second
+	}
+	if s.Sample == 0 {
+		s.Sample = time.Hour
+	}
+	switch {
+	case s.Duration <= 0:
+		return errors.New("duration must be positive")
+	case s.Step < 0 || s.Step > time.Hour:
+		return errors.New("step must be positive and at most 1h")
+	case s.Duration/s.Step > 10_000_000:
+		return errors.New("duration is too long for the step")
+	case s.Sample < 0:
+		return errors.New("sample must be positive")
+	case s.Repeat < 0:
+		return errors.New("repeat must be positive")
+	}
+	if err := s.Battery.Validate(); err != nil {
+		return fmt.Errorf("battery: %w", err)
+	}
+	for i, c := range s.Power {
+		if c.At < 0 || (s.Repeat > 0 && c.At >= s.Repeat) {
+			return fmt.Errorf("power[%d].at must be between 0 and repeat", i)
+		}
+	}
+	slices.SortStableFunc(s.Power, func(a, b PowerChange) int { return cmp.Compare(a.At, b.At) })
+	for i, at := range s.Calibrate {
+		if at < 0 || at >= s.Duration {
+			return fmt.Errorf("calibrate[%d] must be within duration", i)
+		}
+	}
+	return nil
+}
+
+// PluggedIn reports whether the adapter is plugged in at offset.
+func (s *Scenario) PluggedIn(offset time.Duration) bool {
+	last := func(within time.Duration) (bool, bool) {
+		i := len(s.Power) - 1
+		for i >= 0 && s.Power[i].At > within {
+			i--
+		}
+		if i < 0 {
+			return false, false
+		}
+		return s.Power[i].PluggedIn, true
+	}
+	if s.Repeat <= 0 || offset < s.Repeat {
+		plugged, _ := last(offset)
+		return plugged
+	}
+	if plugged, ok := last(offset % s.Repeat); ok {
+		return plugged
+	}
+	// Early in a repetition, the last change of the previous one holds.
+	plugged, _ := last(s.Repeat)
+	return plugged
+}
+
+// Sample is the state of a simulation at a point in time.
+type Sample struct {
+	Time           time.Time         `json:"time"`
+	Charge         float64           `json:"charge"`
+	PluggedIn      bool              `json:"pluggedIn"`
+	AdapterEnabled bool              `json:"adapterEnabled"`
+	Charging       bool              `json:"charging"`
+	Phase          calibration.Phase `json:"phase"`
+	// Events are what happened since the previous sample, for example
+	// "charging stopped" or the events the daemon published.
+	Events []string `json:"events,omitempty"`
+}
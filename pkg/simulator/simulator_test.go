@@ -0,0 +1,201 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/smc"
+)
+
+func openBattery(t *testing.T, spec Spec) (*Battery, *smc.AppleSMC) {
+	t.Helper()
+	b, err := NewBattery(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := smc.NewWithConn(b)
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return b, c
+}
+
+func TestBatteryLegacy(t *testing.T) {
+	b, c := openBattery(t, Spec{CapacityWh: 60, Charge: 50, ChargeWatts: 30, LoadWatts: 6, AdapterWatts: 96})
+	if c.ChargeControlMode() != compatibility.ChargeControlLegacy || !c.IsAdapterControlCapable() {
+		t.Fatalf("mode %s, adapter control %t", c.ChargeControlMode(), c.IsAdapterControlCapable())
+	}
+
+	// Unplugged, the load drains 6 W of 60 Wh: 10% an hour.
+	b.Step(time.Hour)
+	if charge, err := c.GetBatteryCharge(); err != nil || charge != 40 {
+		t.Fatalf("GetBatteryCharge() = %d, %v, want 40", charge, err)
+	}
+	if plugged, err := c.IsPluggedIn(); err != nil || plugged {
+		t.Fatalf("IsPluggedIn() = %t, %v", plugged, err)
+	}
+
+	// Plugged in, it charges at 30 W: 50% an hour.
+	b.SetPluggedIn(true)
+	b.Step(30 * time.Minute)
+	if st := b.State(); st.Charge != 65 || !st.Charging {
+		t.Fatalf("State() = %+v, want charging at 65%%", st)
+	}
+
+	if err := c.DisableCharging(); err != nil {
+		t.Fatal(err)
+	}
+	b.Step(time.Hour)
+	if st := b.State(); st.Charge != 65 || st.Charging {
+		t.Fatalf("State() = %+v, want holding at 65%% with charging disabled", st)
+	}
+
+	// Disabling the adapter is like unplugging it.
+	if err := c.DisableAdapter(); err != nil {
+		t.Fatal(err)
+	}
+	if plugged, _ := c.IsPluggedIn(); plugged {
+		t.Fatal("IsPluggedIn() with the adapter disabled")
+	}
+	b.Step(time.Hour)
+	if st := b.State(); st.Charge != 55 || !st.PluggedIn || st.AdapterEnabled {
+		t.Fatalf("State() = %+v, want discharging at 55%%", st)
+	}
+
+	// Charging slows down above 80%.
+	if err := c.EnableAdapter(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableCharging(); err != nil {
+		t.Fatal(err)
+	}
+	for range 60 {
+		b.Step(time.Minute)
+	}
+	if st := b.State(); st.Charge <= 80 || st.Charge >= 100 {
+		t.Fatalf("State() = %+v, want a tapered charge between 80%% and 100%%", st)
+	}
+}
+
+func TestBatteryTahoe(t *testing.T) {
+	b, c := openBattery(t, Spec{ChargeControl: ChargeControlTahoe})
+	if c.ChargeControlMode() != compatibility.ChargeControlLegacy || !c.HasKey(smc.ChargingKey3) || c.HasKey(smc.ChargingKey1) {
+		t.Fatalf("mode %s, keys do not match Tahoe firmware", c.ChargeControlMode())
+	}
+	b.SetPluggedIn(true)
+	if err := c.DisableCharging(); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := c.IsChargingEnabled(); err != nil || enabled || b.State().Charging {
+		t.Fatalf("IsChargingEnabled() = %t, %v, charging %t", enabled, err, b.State().Charging)
+	}
+	if err := c.DisableAdapter(); err != nil {
+		t.Fatal(err)
+	}
+	if b.State().AdapterEnabled {
+		t.Fatal("the adapter is enabled after writing 08 to CHIE")
+	}
+}
+
+func TestBatteryFirmware(t *testing.T) {
+	b, c := openBattery(t, Spec{CapacityWh: 60, Charge: 70, ChargeWatts: 60, LoadWatts: 6, ChargeControl: ChargeControlFirmware})
+	if c.ChargeControlMode() != compatibility.ChargeControlFirmware {
+		t.Fatalf("mode %s", c.ChargeControlMode())
+	}
+	if changed, err := c.EnsureFirmwareChargeLimit(75, 80); err != nil || !changed {
+		t.Fatalf("EnsureFirmwareChargeLimit() = %t, %v", changed, err)
+	}
+	limit, err := c.GetFirmwareChargeLimit()
+	if err != nil || limit != (smc.FirmwareChargeLimit{Active: true, Lower: 75, Upper: 80}) {
+		t.Fatalf("GetFirmwareChargeLimit() = %+v, %v", limit, err)
+	}
+
+	// The firmware stops at the upper limit...
+	b.SetPluggedIn(true)
+	for range 60 {
+		b.Step(time.Minute)
+	}
+	if st := b.State(); st.Charging || st.Charge < 80 || st.Charge > 81 {
+		t.Fatalf("State() = %+v, want stopped at 80%%", st)
+	}
+	// ...and resumes below the lower one.
+	b.SetPluggedIn(false)
+	for range 60 {
+		b.Step(time.Minute)
+	}
+	b.SetPluggedIn(true)
+	if st := b.State(); !st.Charging {
+		t.Fatalf("State() = %+v, want charging below the lower limit", st)
+	}
+	info, err := b.BatteryInfo()
+	if err != nil || info.ChargeRate <= 0 {
+		t.Fatalf("BatteryInfo() = %+v, %v", info, err)
+	}
+
+	if _, err := c.EnsureFirmwareChargeLimitDisabled(); err != nil {
+		t.Fatal(err)
+	}
+	for range 120 {
+		b.Step(time.Minute)
+	}
+	if st := b.State(); st.Charge != 100 {
+		t.Fatalf("State() = %+v, want full without a limit", st)
+	}
+}
+
+func TestParseScenario(t *testing.T) {
+	sc, err := ParseScenario([]byte(`
+start: 2026-07-20T00:00:00Z
+duration: 48h
+battery:
+  charge: 40
+power:
+  - {at: 18h, pluggedIn: false}
+  - {at: 9h, pluggedIn: true}
+repeat: 24h
+calibrate: [30h]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Step != 10*time.Second || sc.Sample != time.Hour || sc.Battery.CapacityWh != 70 || sc.Battery.ChargeControl != ChargeControlLegacy {
+		t.Fatalf("defaults not set: %+v", sc)
+	}
+	for _, tt := range []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, false},
+		{9 * time.Hour, true},
+		{17 * time.Hour, true},
+		{18 * time.Hour, false},
+		{26 * time.Hour, false},
+		{33 * time.Hour, true},
+		{47 * time.Hour, false},
+	} {
+		if got := sc.PluggedIn(tt.at); got != tt.want {
+			t.Errorf("PluggedIn(%s) = %t, want %t", tt.at, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		yaml, want string
+	}{
+		{`duration: 1h
+batery: {}`, "field batery not found"},
+		{`step: 1m`, "duration must be positive"},
+		{`duration: 1h
+battery: {chargeControl: smart}`, "battery: chargeControl"},
+		{`duration: 1h
+repeat: 24h
+power: [{at: 25h, pluggedIn: true}]`, "power[0].at"},
+		{`duration: 1h
+calibrate: [2h]`, "calibrate[0]"},
+	} {
+		if _, err := ParseScenario([]byte(tt.yaml)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseScenario(%q) error = %v, want one with %q", tt.yaml, err, tt.want)
+		}
+	}
+}
@@ -23,6 +23,9 @@ const (
 	BatteryCurrentKey                = "B0AC"
 	BatteryVoltageKey                = "B0AV"
 	BatteryPowerKey                  = "PPBR"
+	BatteryTemperatureKey1           = "TB0T"
+	BatteryTemperatureKey2           = "TB1T"
+	BatteryTemperatureKey3           = "TB2T"
 )
 
 var allKeys = []string{
@@ -44,4 +47,7 @@ var allKeys = []string{
 	BatteryCurrentKey,
 	BatteryVoltageKey,
 	BatteryPowerKey,
+	BatteryTemperatureKey1,
+	BatteryTemperatureKey2,
+	BatteryTemperatureKey3,
 }
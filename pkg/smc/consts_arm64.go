@@ -26,6 +26,11 @@ const (
 	BatteryCurrentKey = "B0AC"
 	BatteryVoltageKey = "B0AV"
 	BatteryPowerKey   = "PPBR"
+
+	// Battery temperature sensors. Not every model has all of them.
+	BatteryTemperatureKey1 = "TB0T"
+	BatteryTemperatureKey2 = "TB1T"
+	BatteryTemperatureKey3 = "TB2T"
 )
 
 var allKeys = []string{
@@ -47,4 +52,7 @@ var allKeys = []string{
 	BatteryCurrentKey,
 	BatteryVoltageKey,
 	BatteryPowerKey,
+	BatteryTemperatureKey1,
+	BatteryTemperatureKey2,
+	BatteryTemperatureKey3,
 }
@@ -1,16 +1,33 @@
 package smc
 
 import (
+	"sync/atomic"
+
 	"github.com/charlie0129/gosmc"
 	"github.com/sirupsen/logrus"
 )
 
+// Conn is the part of gosmc.Client that AppleSMC uses. Other
+// implementations record and replay traces, see trace.go, or simulate a
+// battery.
+type Conn interface {
+	Open() error
+	Close() error
+	KeyInfo(key string) (gosmc.KeyInfo, error)
+	Read(key string) (gosmc.Value, error)
+	WriteBytes(key string, data []byte) error
+	WriteUint32(key string, value uint32) error
+}
+
 // AppleSMC is a wrapper of gosmc.Client.
 type AppleSMC struct {
-	conn *gosmc.Client
+	conn Conn
 	// capabilities is a map of SMC keys and their availability. Cached
 	// after Open() call to avoid unnecessary SMC reads.
 	capabilities map[string]bool
+
+	readErrors  atomic.Uint64
+	writeErrors atomic.Uint64
 }
 
 // New returns a new AppleSMC.
@@ -21,6 +38,14 @@ func New() *AppleSMC {
 	}
 }
 
+// NewWithConn returns an AppleSMC that talks to the SMC through conn.
+func NewWithConn(conn Conn) *AppleSMC {
+	return &AppleSMC{
+		conn:         conn,
+		capabilities: make(map[string]bool),
+	}
+}
+
 // NewMock returns a new mocked AppleSMC with prefill values.
 func NewMock(prefillValues map[string][]byte) *AppleSMC {
 	values := make([]gosmc.Value, 0, len(prefillValues))
@@ -73,6 +98,7 @@ func (c *AppleSMC) Read(key string) (gosmc.Value, error) {
 
 	v, err := c.conn.Read(key)
 	if err != nil {
+		c.readErrors.Add(1)
 		return v, err
 	}
 
@@ -106,6 +132,7 @@ func (c *AppleSMC) Write(key string, value []byte) error {
 
 	err := c.conn.WriteBytes(key, value)
 	if err != nil {
+		c.writeErrors.Add(1)
 		return err
 	}
 
@@ -125,6 +152,7 @@ func (c *AppleSMC) WriteUint32(key string, value uint32) error {
 	}).Trace("Trying to write uint32 to SMC")
 
 	if err := c.conn.WriteUint32(key, value); err != nil {
+		c.writeErrors.Add(1)
 		return err
 	}
 
@@ -134,3 +162,9 @@ func (c *AppleSMC) WriteUint32(key string, value uint32) error {
 	}).Trace("Write uint32 to SMC succeeded")
 	return nil
 }
+
+// ErrorCounts returns how many SMC reads and writes have failed since the
+// connection was created.
+func (c *AppleSMC) ErrorCounts() (reads, writes uint64) {
+	return c.readErrors.Load(), c.writeErrors.Load()
+}
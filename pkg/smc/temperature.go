@@ -0,0 +1,61 @@
+package smc
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrNoTemperatureSensor = errors.New("no battery temperature sensor found")
+
+var batteryTemperatureKeys = []string{
+	BatteryTemperatureKey1,
+	BatteryTemperatureKey2,
+	BatteryTemperatureKey3,
+}
+
+// GetBatteryTemperature returns the battery temperature in °C, which is the
+// highest reading among the battery temperature sensors.
+func (c *AppleSMC) GetBatteryTemperature() (float64, error) {
+	logrus.Tracef("GetBatteryTemperature called")
+
+	var (
+		found   bool
+		hottest float64
+		lastErr error
+	)
+	for _, key := range batteryTemperatureKeys {
+		if !c.HasKey(key) {
+			continue
+		}
+		v, err := c.Read(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		t, err := v.Float64()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// Disconnected sensors read as zero or garbage.
+		if t <= 0 || t >= 150 {
+			continue
+		}
+		if !found || t > hottest {
+			hottest = t
+		}
+		found = true
+	}
+
+	if !found {
+		if lastErr != nil {
+			return 0, lastErr
+		}
+		return 0, ErrNoTemperatureSensor
+	}
+
+	logrus.Tracef("GetBatteryTemperature returned %.1f", hottest)
+
+	return hottest, nil
+}
@@ -0,0 +1,37 @@
+package smc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/charlie0129/gosmc"
+)
+
+func float32Bytes(f float32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, math.Float32bits(f))
+	return b
+}
+
+func TestGetBatteryTemperature(t *testing.T) {
+	c := openMockSMC(t,
+		smcValue(t, BatteryTemperatureKey1, gosmc.TypeFloat32, float32Bytes(31.5)...),
+		smcValue(t, BatteryTemperatureKey2, gosmc.TypeFloat32, float32Bytes(34.25)...),
+		// A disconnected sensor must not win.
+		smcValue(t, BatteryTemperatureKey3, gosmc.TypeFloat32, float32Bytes(0)...),
+	)
+	got, err := c.GetBatteryTemperature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 34.25 {
+		t.Fatalf("GetBatteryTemperature() = %v, want 34.25", got)
+	}
+
+	c = openMockSMC(t, smcValue(t, BatteryChargeKey, gosmc.TypeUInt8, 50))
+	if _, err := c.GetBatteryTemperature(); !errors.Is(err, ErrNoTemperatureSensor) {
+		t.Fatalf("GetBatteryTemperature() error = %v, want ErrNoTemperatureSensor", err)
+	}
+}
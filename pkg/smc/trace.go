@@ -0,0 +1,295 @@
+package smc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/charlie0129/gosmc"
+	"github.com/sirupsen/logrus"
+)
+
+// TraceOp is the kind of an SMC call in a trace.
+type TraceOp string
+
+const (
+	// TraceKeyInfo looks up whether a key exists and its type, see Open.
+	TraceKeyInfo TraceOp = "info"
+	TraceRead    TraceOp = "read"
+	TraceWrite   TraceOp = "write"
+)
+
+// TraceEntry is an SMC call in a trace. Traces are written as JSON lines.
+type TraceEntry struct {
+	Time time.Time `json:"time"`
+	Op   TraceOp   `json:"op"`
+	Key  string    `json:"key"`
+	// Type is the data type of the key, for info and read.
+	Type gosmc.DataType `json:"type,omitempty"`
+	// Size is the data size of the key, for info.
+	Size int `json:"size,omitempty"`
+	// Data are the bytes read or written, in hex.
+	Data string `json:"data,omitempty"`
+	// Error is the error of a failed call.
+	Error string `json:"error,omitempty"`
+}
+
+// ReadTrace reads a trace written by AppleSMC.Record.
+func ReadTrace(r io.Reader) ([]TraceEntry, error) {
+	var trace []TraceEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, err := hex.DecodeString(e.Data); err != nil {
+			return nil, fmt.Errorf("line %d: invalid data: %w", line, err)
+		}
+		trace = append(trace, e)
+	}
+	return trace, scanner.Err()
+}
+
+// Record writes every SMC call of c to w as a trace, which NewReplay can
+// play back. Call it before Open, so that the trace tells which keys exist.
+// If w is an io.Closer, Close closes it.
+func (c *AppleSMC) Record(w io.Writer) {
+	c.conn = &recorder{Conn: c.conn, w: w, enc: json.NewEncoder(w)}
+}
+
+// recorder is a Conn that writes the calls to the conn it wraps to a trace.
+type recorder struct {
+	Conn
+
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+func (r *recorder) record(e TraceEntry, err error) {
+	e.Time = time.Now()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(e); err != nil {
+		logrus.WithError(err).Warn("failed to write SMC trace")
+	}
+}
+
+func (r *recorder) Close() error {
+	err := r.Conn.Close()
+	if c, ok := r.w.(io.Closer); ok {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
+
+func (r *recorder) KeyInfo(key string) (gosmc.KeyInfo, error) {
+	info, err := r.Conn.KeyInfo(key)
+	r.record(TraceEntry{Op: TraceKeyInfo, Key: key, Type: info.DataType, Size: info.DataSize}, err)
+	return info, err
+}
+
+func (r *recorder) Read(key string) (gosmc.Value, error) {
+	v, err := r.Conn.Read(key)
+	r.record(TraceEntry{Op: TraceRead, Key: key, Type: v.DataType, Data: v.Hex()}, err)
+	return v, err
+}
+
+func (r *recorder) WriteBytes(key string, data []byte) error {
+	err := r.Conn.WriteBytes(key, data)
+	r.record(TraceEntry{Op: TraceWrite, Key: key, Data: hex.EncodeToString(data)}, err)
+	return err
+}
+
+func (r *recorder) WriteUint32(key string, value uint32) error {
+	err := r.Conn.WriteUint32(key, value)
+	// Record the bytes, like WriteBytes, so that both compare the same.
+	data, _ := encodeUint32(r.Conn, key, value)
+	r.record(TraceEntry{Op: TraceWrite, Key: key, Data: hex.EncodeToString(data)}, err)
+	return err
+}
+
+// encodeUint32 encodes value the way gosmc.Client.WriteUint32 does.
+func encodeUint32(c Conn, key string, value uint32) ([]byte, error) {
+	info, err := c.KeyInfo(key)
+	if err != nil {
+		return nil, err
+	}
+	return gosmc.EncodeUint(info.DataType, info.DataSize, uint64(value))
+}
+
+// Replay plays back a trace recorded by AppleSMC.Record, for tests that
+// reproduce a real session. The SMC of a Replay, see SMC, serves the
+// recorded values of each key in order: every read returns the next
+// recorded one, and the last one again once they are used up. Writes must
+// be the recorded ones, in order; Verify reports those that are not.
+//
+// A Replay has a virtual clock, which is at the time of the last recorded
+// call that was played back.
+type Replay struct {
+	mu     sync.Mutex
+	info   map[string]TraceEntry
+	reads  map[string][]TraceEntry
+	next   map[string]int
+	writes []TraceEntry
+	// written is how many of writes were made.
+	written int
+	now     time.Time
+	errs    []error
+}
+
+// NewReplay returns a Replay of trace.
+func NewReplay(trace []TraceEntry) *Replay {
+	r := &Replay{
+		info:  map[string]TraceEntry{},
+		reads: map[string][]TraceEntry{},
+		next:  map[string]int{},
+	}
+	for _, e := range trace {
+		switch e.Op {
+		case TraceKeyInfo:
+			r.info[e.Key] = e
+		case TraceRead:
+			r.reads[e.Key] = append(r.reads[e.Key], e)
+		case TraceWrite:
+			r.writes = append(r.writes, e)
+		}
+	}
+	if len(trace) > 0 {
+		r.now = trace[0].Time
+	}
+	return r
+}
+
+// SMC returns an AppleSMC that plays back r. It must be opened like one
+// from New.
+func (r *Replay) SMC() *AppleSMC {
+	return NewWithConn(replayConn{r})
+}
+
+// Now returns the virtual clock of r.
+func (r *Replay) Now() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.now
+}
+
+// Done reports whether every recorded read has been played back, which is
+// the end of the session. See Verify for the writes.
+func (r *Replay) Done() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, reads := range r.reads {
+		if r.next[key] < len(reads) {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify returns an error for every write that was not the recorded one,
+// and for the recorded writes up to the virtual clock that were not made.
+func (r *Replay) Verify() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	errs := r.errs
+	for _, w := range r.writes[r.written:] {
+		if w.Time.After(r.now) {
+			break
+		}
+		errs = append(errs, fmt.Errorf("missing write of %s to %s at %s", w.Data, w.Key, w.Time.Format(time.RFC3339Nano)))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Replay) advance(t time.Time) {
+	if t.After(r.now) {
+		r.now = t
+	}
+}
+
+// replayConn is the Conn of the SMC of a Replay.
+type replayConn struct {
+	r *Replay
+}
+
+func (c replayConn) Open() error  { return nil }
+func (c replayConn) Close() error { return nil }
+
+func (c replayConn) KeyInfo(key string) (gosmc.KeyInfo, error) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	e, ok := c.r.info[key]
+	if !ok {
+		// Traces recorded after Open have no info, but the reads tell.
+		reads := c.r.reads[key]
+		if len(reads) == 0 {
+			return gosmc.KeyInfo{}, fmt.Errorf("%q is not in the trace", key)
+		}
+		e = TraceEntry{Type: reads[0].Type, Size: len(reads[0].Data) / 2}
+	}
+	if e.Error != "" {
+		return gosmc.KeyInfo{}, errors.New(e.Error)
+	}
+	return gosmc.KeyInfo{Key: key, DataSize: e.Size, DataType: e.Type}, nil
+}
+
+func (c replayConn) Read(key string) (gosmc.Value, error) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	reads := c.r.reads[key]
+	if len(reads) == 0 {
+		return gosmc.Value{}, fmt.Errorf("no read of %q in the trace", key)
+	}
+	i := min(c.r.next[key], len(reads)-1)
+	c.r.next[key] = i + 1
+	e := reads[i]
+	c.r.advance(e.Time)
+	if e.Error != "" {
+		return gosmc.Value{}, errors.New(e.Error)
+	}
+	data, _ := hex.DecodeString(e.Data)
+	return gosmc.NewValue(key, e.Type, data)
+}
+
+func (c replayConn) WriteBytes(key string, data []byte) error {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	got := hex.EncodeToString(data)
+	if c.r.written == len(c.r.writes) {
+		c.r.errs = append(c.r.errs, fmt.Errorf("unexpected write of %s to %s after the trace", got, key))
+		return nil
+	}
+	want := c.r.writes[c.r.written]
+	if want.Key != key || want.Data != got {
+		c.r.errs = append(c.r.errs, fmt.Errorf("write of %s to %s, want %s to %s at %s", got, key, want.Data, want.Key, want.Time.Format(time.RFC3339Nano)))
+		return nil
+	}
+	c.r.written++
+	c.r.advance(want.Time)
+	if want.Error != "" {
+		return errors.New(want.Error)
+	}
+	return nil
+}
+
+func (c replayConn) WriteUint32(key string, value uint32) error {
+	data, err := encodeUint32(c, key, value)
+	if err != nil {
+		return err
+	}
+	return c.WriteBytes(key, data)
+}
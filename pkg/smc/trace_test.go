@@ -0,0 +1,82 @@
+package smc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/charlie0129/gosmc"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var buf bytes.Buffer
+	recorded := NewMockValues(
+		smcValue(t, ChargingKey1, gosmc.TypeUInt8, 0),
+		smcValue(t, ChargingKey2, gosmc.TypeUInt8, 0),
+		smcValue(t, BatteryChargeKey, gosmc.TypeUInt8, 79),
+		smcValue(t, FirmwareChargeLimitUpperKey, gosmc.TypeUInt32, 0, 0, 0, 0),
+	)
+	recorded.Record(&buf)
+	if err := recorded.Open(); err != nil {
+		t.Fatal(err)
+	}
+	session := func(c *AppleSMC) {
+		t.Helper()
+		if charge, err := c.GetBatteryCharge(); err != nil || charge != 79 {
+			t.Fatalf("GetBatteryCharge() = %d, %v", charge, err)
+		}
+		if err := c.DisableCharging(); err != nil {
+			t.Fatal(err)
+		}
+		if enabled, err := c.IsChargingEnabled(); err != nil || enabled {
+			t.Fatalf("IsChargingEnabled() = %t, %v", enabled, err)
+		}
+		if err := c.WriteUint32(FirmwareChargeLimitUpperKey, 80); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session(recorded)
+	if err := recorded.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := NewReplay(trace)
+	c := replay.SMC()
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if c.ChargeControlMode() != recorded.ChargeControlMode() || c.HasKey(AdapterKey1) {
+		t.Fatalf("replayed keys differ: mode %s", c.ChargeControlMode())
+	}
+	session(c)
+	if err := replay.Verify(); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if !replay.Done() || !replay.Now().Equal(trace[len(trace)-1].Time) {
+		t.Fatalf("Done() = %t, Now() = %s", replay.Done(), replay.Now())
+	}
+
+	// A different write is reported, and a missing one too.
+	replay = NewReplay(trace)
+	c = replay.SMC()
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBatteryCharge(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableCharging(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.IsChargingEnabled(); err != nil {
+		t.Fatal(err)
+	}
+	err = replay.Verify()
+	if err == nil || !strings.Contains(err.Error(), "write of 00 to CH0B, want 02 to CH0B") || !strings.Contains(err.Error(), "missing write") {
+		t.Fatalf("Verify() = %v", err)
+	}
+}
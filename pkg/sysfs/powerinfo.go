@@ -0,0 +1,143 @@
+package sysfs
+
+import (
+	"math"
+	"path/filepath"
+
+	"github.com/charlie0129/batt/pkg/powerinfo"
+)
+
+// Optional attributes used for battery info. Units are µV, µA, µAh, µW and
+// µWh respectively, as documented in the kernel's sysfs-class-power ABI.
+const (
+	VoltageNowFile       = "voltage_now"
+	VoltageMinDesignFile = "voltage_min_design"
+	CurrentNowFile       = "current_now"
+	PowerNowFile         = "power_now"
+	ChargeFullFile       = "charge_full"
+	ChargeFullDesignFile = "charge_full_design"
+	EnergyFullFile       = "energy_full"
+	EnergyFullDesignFile = "energy_full_design"
+	CycleCountFile       = "cycle_count"
+	// TempFile is in tenths of a degree Celsius.
+	TempFile = "temp"
+)
+
+// BatteryInfo returns a battery snapshot in the units used by powerinfo.
+// Attributes the driver does not provide are left as zero.
+func (p *PowerSupply) BatteryInfo() (*powerinfo.Battery, error) {
+	status, err := p.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var state powerinfo.BatteryState
+	switch status {
+	case StatusFull:
+		state = powerinfo.Full
+	case StatusCharging:
+		state = powerinfo.Charging
+	default:
+		state = powerinfo.Discharging
+	}
+
+	voltage := p.readOptional(VoltageNowFile)
+	designVoltage := p.readOptional(VoltageMinDesignFile)
+	if designVoltage == 0 {
+		designVoltage = voltage
+	}
+
+	return &powerinfo.Battery{
+		State:          state,
+		DesignCapacity: p.capacityMilliAmpHours(ChargeFullDesignFile, EnergyFullDesignFile, designVoltage),
+		MaxCapacity:    p.capacityMilliAmpHours(ChargeFullFile, EnergyFullFile, designVoltage),
+		ChargeRate:     int(math.Round(p.batteryPowerWatts(status) * 1000)),
+		DesignVoltage:  float64(designVoltage) / 1e6,
+	}, nil
+}
+
+// PowerTelemetry returns the subset of power telemetry sysfs can provide.
+// Adapter input is not exposed by the power_supply class.
+func (p *PowerSupply) PowerTelemetry() (*powerinfo.PowerTelemetry, error) {
+	status, err := p.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var t powerinfo.PowerTelemetry
+	t.Battery.CycleCount = p.readOptional(CycleCountFile)
+
+	batteryPower := p.batteryPowerWatts(status)
+	t.Calculations.BatteryPower = batteryPower
+	if batteryPower < 0 {
+		t.Calculations.SystemPower = -batteryPower
+	}
+
+	full := p.readOptional(ChargeFullFile)
+	design := p.readOptional(ChargeFullDesignFile)
+	if full == 0 || design == 0 {
+		full = p.readOptional(EnergyFullFile)
+		design = p.readOptional(EnergyFullDesignFile)
+	}
+	if design > 0 {
+		t.Calculations.HealthByMaxCapacity = int(math.Round(float64(full) * 100 / float64(design)))
+	}
+
+	return &t, nil
+}
+
+// GetBatteryTemperature returns the battery temperature in °C.
+func (p *PowerSupply) GetBatteryTemperature() (float64, error) {
+	if p.battery == "" {
+		return 0, ErrNoBattery
+	}
+	path := filepath.Join(p.battery, TempFile)
+	if !fileExists(path) {
+		return 0, ErrNoTemperature
+	}
+	v, err := p.readInt(path)
+	if err != nil {
+		return 0, err
+	}
+	return float64(v) / 10, nil
+}
+
+// batteryPowerWatts returns the battery power, negative when discharging.
+// Drivers disagree on the sign of current_now, so the status decides.
+func (p *PowerSupply) batteryPowerWatts(status string) float64 {
+	watts := float64(p.readOptional(PowerNowFile)) / 1e6
+	if watts == 0 {
+		volts := float64(p.readOptional(VoltageNowFile)) / 1e6
+		amps := float64(p.readOptional(CurrentNowFile)) / 1e6
+		watts = volts * amps
+	}
+	watts = math.Abs(watts)
+	if status == StatusDischarging {
+		return -watts
+	}
+	return watts
+}
+
+// capacityMilliAmpHours reads a charge attribute, falling back to the energy
+// attribute divided by the voltage.
+func (p *PowerSupply) capacityMilliAmpHours(chargeFile, energyFile string, microVolts int) int {
+	if v := p.readOptional(chargeFile); v > 0 {
+		return v / 1000
+	}
+	energy := p.readOptional(energyFile)
+	if energy == 0 || microVolts == 0 {
+		return 0
+	}
+	return int(float64(energy) / float64(microVolts) * 1000)
+}
+
+func (p *PowerSupply) readOptional(name string) int {
+	if p.battery == "" {
+		return 0
+	}
+	v, err := readFileInt(filepath.Join(p.battery, name))
+	if err != nil {
+		return 0
+	}
+	return v
+}
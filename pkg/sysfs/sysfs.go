@@ -0,0 +1,363 @@
+package sysfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/smc"
+)
+
+// DefaultRoot is where the kernel exposes power supplies.
+const DefaultRoot = "/sys/class/power_supply"
+
+// Attribute names below a power supply directory.
+const (
+	EndThresholdFile   = "charge_control_end_threshold"
+	StartThresholdFile = "charge_control_start_threshold"
+	CapacityFile       = "capacity"
+	StatusFile         = "status"
+	OnlineFile         = "online"
+)
+
+// Battery status values reported in StatusFile.
+const (
+	StatusCharging    = "Charging"
+	StatusDischarging = "Discharging"
+	StatusNotCharging = "Not charging"
+	StatusFull        = "Full"
+)
+
+var (
+	ErrNoBattery                = errors.New("no battery found in sysfs")
+	ErrNotLegacyChargeControl   = errors.New("direct charging control is unavailable")
+	ErrNotFirmwareChargeControl = errors.New("charge threshold control is unavailable")
+	ErrNoAdapterCapability      = errors.New("no adapter capability found")
+	ErrNoMagSafe                = errors.New("there is no MagSafe LED on this device")
+	ErrNoTemperature            = errors.New("the battery does not report its temperature")
+)
+
+// PowerSupply drives the charge thresholds exposed by Linux power_supply
+// drivers (thinkpad_acpi, asus-wmi, dell-laptop, ...). The kernel enforces
+// the thresholds itself, so it behaves like the firmware-managed mode on
+// Macs.
+type PowerSupply struct {
+	root    string
+	battery string
+	adapter string
+
+	readErrors  atomic.Uint64
+	writeErrors atomic.Uint64
+}
+
+// New returns a PowerSupply reading from root, which is normally DefaultRoot.
+func New(root string) *PowerSupply {
+	if root == "" {
+		root = DefaultRoot
+	}
+	return &PowerSupply{root: root}
+}
+
+// Open discovers the battery (BAT*) and the adapter (AC*) below root.
+func (p *PowerSupply) Open() error {
+	entries, err := os.ReadDir(p.root)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", p.root, err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	var batteries []string
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, "BAT"):
+			batteries = append(batteries, filepath.Join(p.root, name))
+		case strings.HasPrefix(name, "AC") && p.adapter == "":
+			p.adapter = filepath.Join(p.root, name)
+		}
+	}
+	if len(batteries) == 0 {
+		return ErrNoBattery
+	}
+
+	// Prefer the first battery that can actually be limited.
+	p.battery = batteries[0]
+	for _, dir := range batteries {
+		if fileExists(filepath.Join(dir, EndThresholdFile)) {
+			p.battery = dir
+			break
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"battery": p.battery,
+		"adapter": p.adapter,
+	}).Debug("discovered power supplies")
+
+	return nil
+}
+
+// Close is a no-op. It exists to mirror AppleSMC.
+func (p *PowerSupply) Close() error {
+	return nil
+}
+
+// ChargeControlMode reports firmware mode when the battery accepts an end
+// threshold, since the kernel driver enforces the hysteresis.
+func (p *PowerSupply) ChargeControlMode() compatibility.ChargeControlMode {
+	if p.battery != "" && fileExists(filepath.Join(p.battery, EndThresholdFile)) {
+		return compatibility.ChargeControlFirmware
+	}
+	return compatibility.ChargeControlUnsupported
+}
+
+func (p *PowerSupply) IsChargingControlCapable() bool {
+	return p.ChargeControlMode() != compatibility.ChargeControlUnsupported
+}
+
+// IsAdapterControlCapable always reports false. Power input cannot be cut
+// through the power_supply class.
+func (p *PowerSupply) IsAdapterControlCapable() bool {
+	return false
+}
+
+// CheckMagSafeExistence always reports false.
+func (p *PowerSupply) CheckMagSafeExistence() bool {
+	return false
+}
+
+// GetBatteryCharge returns the battery charge.
+func (p *PowerSupply) GetBatteryCharge() (int, error) {
+	logrus.Trace("GetBatteryCharge called")
+	if p.battery == "" {
+		return 0, ErrNoBattery
+	}
+	return p.readInt(filepath.Join(p.battery, CapacityFile))
+}
+
+// GetStatus returns the raw battery status, e.g. StatusCharging.
+func (p *PowerSupply) GetStatus() (string, error) {
+	if p.battery == "" {
+		return "", ErrNoBattery
+	}
+	return p.readString(filepath.Join(p.battery, StatusFile))
+}
+
+// IsPluggedIn returns whether the device is plugged in. Without an AC
+// supply, a battery that is not discharging is assumed to be on external
+// power.
+func (p *PowerSupply) IsPluggedIn() (bool, error) {
+	logrus.Trace("IsPluggedIn called")
+	if p.adapter != "" {
+		v, err := p.readInt(filepath.Join(p.adapter, OnlineFile))
+		if err != nil {
+			return false, err
+		}
+		return v > 0, nil
+	}
+
+	status, err := p.GetStatus()
+	if err != nil {
+		return false, err
+	}
+	return status != StatusDischarging, nil
+}
+
+// IsChargingEnabled is not supported. The kernel decides when to charge.
+func (p *PowerSupply) IsChargingEnabled() (bool, error) {
+	return false, ErrNotLegacyChargeControl
+}
+
+// EnableCharging is not supported. The kernel decides when to charge.
+func (p *PowerSupply) EnableCharging() error {
+	return ErrNotLegacyChargeControl
+}
+
+// DisableCharging is not supported. The kernel decides when to charge.
+func (p *PowerSupply) DisableCharging() error {
+	return ErrNotLegacyChargeControl
+}
+
+// GetChargeThresholds returns the start and end thresholds. start is 0 when
+// the driver only supports an end threshold.
+func (p *PowerSupply) GetChargeThresholds() (start, end int, err error) {
+	if p.ChargeControlMode() != compatibility.ChargeControlFirmware {
+		return 0, 0, ErrNotFirmwareChargeControl
+	}
+	end, err = p.readInt(filepath.Join(p.battery, EndThresholdFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	if !p.hasStartThreshold() {
+		return 0, end, nil
+	}
+	start, err = p.readInt(filepath.Join(p.battery, StartThresholdFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// EnsureFirmwareChargeLimit writes the thresholds only when they differ from
+// the requested state.
+func (p *PowerSupply) EnsureFirmwareChargeLimit(lower, upper int) (bool, error) {
+	if lower < 0 || upper > 100 || lower >= upper {
+		return false, fmt.Errorf("invalid charge thresholds %d/%d", lower, upper)
+	}
+	if !p.hasStartThreshold() {
+		lower = 0
+	}
+	return p.setThresholds(lower, upper)
+}
+
+// EnsureFirmwareChargeLimitDisabled lets the battery charge to full.
+func (p *PowerSupply) EnsureFirmwareChargeLimitDisabled() (bool, error) {
+	return p.setThresholds(0, 100)
+}
+
+// ResetChargeControl restores the platform's default charging behavior.
+func (p *PowerSupply) ResetChargeControl() error {
+	_, err := p.EnsureFirmwareChargeLimitDisabled()
+	return err
+}
+
+func (p *PowerSupply) setThresholds(start, end int) (bool, error) {
+	curStart, curEnd, err := p.GetChargeThresholds()
+	if err != nil {
+		return false, err
+	}
+	if curStart == start && curEnd == end {
+		return false, nil
+	}
+
+	endPath := filepath.Join(p.battery, EndThresholdFile)
+	startPath := filepath.Join(p.battery, StartThresholdFile)
+	if !p.hasStartThreshold() {
+		return true, p.writeInt(endPath, end)
+	}
+
+	// Drivers reject a start threshold at or above the end threshold, so the
+	// order depends on the direction we are moving in.
+	if start >= curEnd {
+		if err := p.writeInt(endPath, end); err != nil {
+			return false, err
+		}
+		return true, p.writeInt(startPath, start)
+	}
+	if err := p.writeInt(startPath, start); err != nil {
+		return false, err
+	}
+	return true, p.writeInt(endPath, end)
+}
+
+func (p *PowerSupply) hasStartThreshold() bool {
+	return p.battery != "" && fileExists(filepath.Join(p.battery, StartThresholdFile))
+}
+
+func (p *PowerSupply) IsAdapterEnabled() (bool, error) {
+	return false, ErrNoAdapterCapability
+}
+
+func (p *PowerSupply) EnableAdapter() error {
+	return ErrNoAdapterCapability
+}
+
+func (p *PowerSupply) DisableAdapter() error {
+	return ErrNoAdapterCapability
+}
+
+func (p *PowerSupply) GetMagSafeLedState() (smc.MagSafeLedState, error) {
+	return smc.LEDSystem, ErrNoMagSafe
+}
+
+func (p *PowerSupply) SetMagSafeLedState(smc.MagSafeLedState) error {
+	return ErrNoMagSafe
+}
+
+func (p *PowerSupply) DisableMagSafeLed() error {
+	return ErrNoMagSafe
+}
+
+func (p *PowerSupply) SetMagSafeCharging(bool) error {
+	return ErrNoMagSafe
+}
+
+// ErrorCounts returns how many required attribute reads and threshold writes
+// have failed. Missing optional attributes are not counted.
+func (p *PowerSupply) ErrorCounts() (reads, writes uint64) {
+	return p.readErrors.Load(), p.writeErrors.Load()
+}
+
+func (p *PowerSupply) readString(path string) (string, error) {
+	v, err := readFileString(path)
+	if err != nil {
+		p.readErrors.Add(1)
+	}
+	return v, err
+}
+
+func (p *PowerSupply) readInt(path string) (int, error) {
+	v, err := readFileInt(path)
+	if err != nil {
+		p.readErrors.Add(1)
+	}
+	return v, err
+}
+
+func (p *PowerSupply) writeInt(path string, v int) error {
+	err := writeFileInt(path, v)
+	if err != nil {
+		p.writeErrors.Add(1)
+	}
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readFileString(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	logrus.WithFields(logrus.Fields{
+		"path": path,
+		"val":  strings.TrimSpace(string(b)),
+	}).Trace("Load from sysfs succeed")
+	return strings.TrimSpace(string(b)), nil
+}
+
+func readFileInt(path string) (int, error) {
+	s, err := readFileString(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return v, nil
+}
+
+func writeFileInt(path string, v int) error {
+	logrus.WithFields(logrus.Fields{
+		"path": path,
+		"val":  v,
+	}).Trace("Trying to write to sysfs")
+	// sysfs attributes must be written in place; they cannot be replaced.
+	return os.WriteFile(path, []byte(strconv.Itoa(v)), 0644)
+}
@@ -0,0 +1,268 @@
+package sysfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charlie0129/batt/pkg/compatibility"
+	"github.com/charlie0129/batt/pkg/powerinfo"
+)
+
+// fakeTree writes files below a temporary power_supply root. Keys are paths
+// relative to the root, e.g. "BAT0/capacity".
+func fakeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func openTree(t *testing.T, files map[string]string) (*PowerSupply, string) {
+	t.Helper()
+	root := fakeTree(t, files)
+	p := New(root)
+	if err := p.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return p, root
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	v, err := readFileString(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestOpenWithoutBattery(t *testing.T) {
+	root := fakeTree(t, map[string]string{"AC/online": "1"})
+	if err := New(root).Open(); !errors.Is(err, ErrNoBattery) {
+		t.Fatalf("Open() error = %v, want ErrNoBattery", err)
+	}
+}
+
+func TestOpenPrefersBatteryWithThreshold(t *testing.T) {
+	p, root := openTree(t, map[string]string{
+		"BAT0/capacity":                       "40",
+		"BAT1/capacity":                       "60",
+		"BAT1/charge_control_end_threshold":   "100",
+		"ACAD/online":                         "1",
+		"hidpp_battery_0/capacity":            "90",
+		"hidpp_battery_0/charge_control_type": "x",
+	})
+	if p.battery != filepath.Join(root, "BAT1") {
+		t.Fatalf("battery = %s, want BAT1", p.battery)
+	}
+	if p.adapter != filepath.Join(root, "ACAD") {
+		t.Fatalf("adapter = %s, want ACAD", p.adapter)
+	}
+	charge, err := p.GetBatteryCharge()
+	if err != nil || charge != 60 {
+		t.Fatalf("GetBatteryCharge() = %d, %v; want 60", charge, err)
+	}
+}
+
+func TestChargeControlMode(t *testing.T) {
+	p, _ := openTree(t, map[string]string{"BAT0/capacity": "50"})
+	if got := p.ChargeControlMode(); got != compatibility.ChargeControlUnsupported {
+		t.Fatalf("ChargeControlMode() = %s, want unsupported", got)
+	}
+	if _, err := p.EnsureFirmwareChargeLimit(70, 80); !errors.Is(err, ErrNotFirmwareChargeControl) {
+		t.Fatalf("EnsureFirmwareChargeLimit() error = %v", err)
+	}
+
+	p, _ = openTree(t, map[string]string{
+		"BAT0/capacity":                     "50",
+		"BAT0/charge_control_end_threshold": "100",
+	})
+	if got := p.ChargeControlMode(); got != compatibility.ChargeControlFirmware {
+		t.Fatalf("ChargeControlMode() = %s, want firmware", got)
+	}
+	if p.IsAdapterControlCapable() || p.CheckMagSafeExistence() {
+		t.Fatal("sysfs must not report adapter or MagSafe control")
+	}
+	if err := p.DisableCharging(); !errors.Is(err, ErrNotLegacyChargeControl) {
+		t.Fatalf("DisableCharging() error = %v", err)
+	}
+}
+
+func TestIsPluggedIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  bool
+	}{
+		{
+			name:  "adapter online",
+			files: map[string]string{"BAT0/status": "Discharging", "AC/online": "1"},
+			want:  true,
+		},
+		{
+			name:  "adapter offline",
+			files: map[string]string{"BAT0/status": "Not charging", "AC/online": "0"},
+			want:  false,
+		},
+		{
+			name:  "no adapter, not charging",
+			files: map[string]string{"BAT0/status": "Not charging"},
+			want:  true,
+		},
+		{
+			name:  "no adapter, discharging",
+			files: map[string]string{"BAT0/status": "Discharging"},
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := openTree(t, tt.files)
+			got, err := p.IsPluggedIn()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("IsPluggedIn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureFirmwareChargeLimit(t *testing.T) {
+	p, root := openTree(t, map[string]string{
+		"BAT0/capacity":                       "50",
+		"BAT0/charge_control_start_threshold": "0",
+		"BAT0/charge_control_end_threshold":   "100",
+	})
+	start := filepath.Join(root, "BAT0", StartThresholdFile)
+	end := filepath.Join(root, "BAT0", EndThresholdFile)
+
+	changed, err := p.EnsureFirmwareChargeLimit(70, 80)
+	if err != nil || !changed {
+		t.Fatalf("EnsureFirmwareChargeLimit() = %v, %v; want changed", changed, err)
+	}
+	if readFile(t, start) != "70" || readFile(t, end) != "80" {
+		t.Fatalf("thresholds = %s/%s, want 70/80", readFile(t, start), readFile(t, end))
+	}
+
+	changed, err = p.EnsureFirmwareChargeLimit(70, 80)
+	if err != nil || changed {
+		t.Fatalf("EnsureFirmwareChargeLimit() = %v, %v; want unchanged", changed, err)
+	}
+
+	// Raising the start above the current end must write the end first.
+	changed, err = p.EnsureFirmwareChargeLimit(85, 90)
+	if err != nil || !changed {
+		t.Fatalf("EnsureFirmwareChargeLimit() = %v, %v; want changed", changed, err)
+	}
+	if readFile(t, start) != "85" || readFile(t, end) != "90" {
+		t.Fatalf("thresholds = %s/%s, want 85/90", readFile(t, start), readFile(t, end))
+	}
+
+	if _, err := p.EnsureFirmwareChargeLimit(80, 80); err == nil {
+		t.Fatal("expected an error for lower >= upper")
+	}
+
+	if err := p.ResetChargeControl(); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, start) != "0" || readFile(t, end) != "100" {
+		t.Fatalf("thresholds = %s/%s, want 0/100", readFile(t, start), readFile(t, end))
+	}
+}
+
+func TestEnsureFirmwareChargeLimitEndOnly(t *testing.T) {
+	p, root := openTree(t, map[string]string{
+		"BAT0/capacity":                     "50",
+		"BAT0/charge_control_end_threshold": "100",
+	})
+	changed, err := p.EnsureFirmwareChargeLimit(70, 80)
+	if err != nil || !changed {
+		t.Fatalf("EnsureFirmwareChargeLimit() = %v, %v; want changed", changed, err)
+	}
+	if got := readFile(t, filepath.Join(root, "BAT0", EndThresholdFile)); got != "80" {
+		t.Fatalf("end threshold = %s, want 80", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "BAT0", StartThresholdFile)); !os.IsNotExist(err) {
+		t.Fatalf("start threshold must not be created, stat error = %v", err)
+	}
+
+	changed, err = p.EnsureFirmwareChargeLimitDisabled()
+	if err != nil || !changed {
+		t.Fatalf("EnsureFirmwareChargeLimitDisabled() = %v, %v; want changed", changed, err)
+	}
+}
+
+func TestBatteryInfo(t *testing.T) {
+	p, _ := openTree(t, map[string]string{
+		"BAT0/status":             "Discharging",
+		"BAT0/voltage_now":        "12000000",
+		"BAT0/current_now":        "1500000",
+		"BAT0/energy_full":        "48000000",
+		"BAT0/energy_full_design": "60000000",
+		"BAT0/cycle_count":        "123",
+		"BAT0/temp":               "315",
+	})
+
+	info, err := p.BatteryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := powerinfo.Battery{
+		State:          powerinfo.Discharging,
+		DesignCapacity: 5000,
+		MaxCapacity:    4000,
+		ChargeRate:     -18000,
+		DesignVoltage:  12,
+	}
+	if *info != want {
+		t.Fatalf("BatteryInfo() = %+v, want %+v", *info, want)
+	}
+
+	telemetry, err := p.PowerTelemetry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if telemetry.Battery.CycleCount != 123 {
+		t.Fatalf("CycleCount = %d, want 123", telemetry.Battery.CycleCount)
+	}
+	if telemetry.Calculations.HealthByMaxCapacity != 80 {
+		t.Fatalf("HealthByMaxCapacity = %d, want 80", telemetry.Calculations.HealthByMaxCapacity)
+	}
+	if telemetry.Calculations.BatteryPower != -18 || telemetry.Calculations.SystemPower != 18 {
+		t.Fatalf("unexpected power calculations: %+v", telemetry.Calculations)
+	}
+
+	temp, err := p.GetBatteryTemperature()
+	if err != nil || temp != 31.5 {
+		t.Fatalf("GetBatteryTemperature() = %v, %v; want 31.5", temp, err)
+	}
+}
+
+func TestErrorCounts(t *testing.T) {
+	p, _ := openTree(t, map[string]string{"BAT0/capacity": "50"})
+	if _, err := p.GetStatus(); err == nil {
+		t.Fatal("expected an error for a missing status attribute")
+	}
+	// Optional attributes are allowed to be missing.
+	_ = p.readOptional(CycleCountFile)
+	if err := p.writeInt(filepath.Join(t.TempDir(), "missing", EndThresholdFile), 80); err == nil {
+		t.Fatal("expected an error writing to a missing directory")
+	}
+
+	reads, writes := p.ErrorCounts()
+	if reads != 1 || writes != 1 {
+		t.Fatalf("ErrorCounts() = %d, %d; want 1, 1", reads, writes)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !darwin
+
+package osver
+
+// Get returns the zero Version on platforms other than macOS.
+func Get() Version {
+	return Version{}
+}
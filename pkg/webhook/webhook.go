@@ -0,0 +1,461 @@
+// Package webhook delivers daemon events to HTTP endpoints. Deliveries are
+// kept in a bounded on-disk outbox and retried with exponential backoff, so
+// events survive daemon restarts and endpoints that are temporarily down.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/charlie0129/batt/pkg/clock"
+	"github.com/charlie0129/batt/pkg/endpoint"
+	"github.com/charlie0129/batt/pkg/events"
+	"github.com/charlie0129/batt/pkg/persist"
+)
+
+// Headers set on every delivery.
+const (
+	HeaderEvent     = "X-Batt-Event"
+	HeaderDelivery  = "X-Batt-Delivery"
+	HeaderTimestamp = "X-Batt-Timestamp"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of
+	// "<timestamp>.<body>", keyed with the target secret. It is only set
+	// when the target has a secret.
+	HeaderSignature = "X-Batt-Signature"
+)
+
+// Target is an endpoint that receives events.
+type Target struct {
+	URL    string
+	Secret string
+	// Events limits the target to these event names. Empty means all.
+	Events []string
+}
+
+// Validate checks that the target URL is an absolute http(s) URL.
+func (t Target) Validate() error {
+	return endpoint.ValidateWebhookURL(t.URL)
+}
+
+func (t Target) wants(event string) bool {
+	return len(t.Events) == 0 || slices.Contains(t.Events, event)
+}
+
+// Options tune delivery.
+type Options struct {
+	// MaxPending bounds the outbox. The oldest deliveries are dropped when
+	// it is full.
+	MaxPending int
+	// MaxAttempts is how often a delivery is tried before it is dropped.
+	MaxAttempts int
+	// InitialBackoff is the delay after the first failed attempt. It doubles
+	// with every further attempt, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout bounds a single request.
+	Timeout time.Duration
+	// Outbox is used to read the persisted outbox.
+	Outbox persist.ReadOptions
+}
+
+var DefaultOptions = Options{
+	MaxPending:     500,
+	MaxAttempts:    10,
+	InitialBackoff: 5 * time.Second,
+	MaxBackoff:     10 * time.Minute,
+	Timeout:        10 * time.Second,
+}
+
+// Payload is the JSON body POSTed to targets.
+type Payload struct {
+	ID    string          `json:"id"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+	Ts    int64           `json:"ts"`
+}
+
+// delivery is an outbox entry.
+type delivery struct {
+	URL         string    `json:"url"`
+	Payload     Payload   `json:"payload"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// Status is the delivery status of a target.
+type Status struct {
+	URL       string   `json:"url"`
+	Events    []string `json:"events,omitempty"`
+	Signed    bool     `json:"signed"`
+	Pending   int      `json:"pending"`
+	Delivered uint64   `json:"delivered"`
+	// Failed counts deliveries dropped after MaxAttempts.
+	Failed        uint64     `json:"failed"`
+	Dropped       uint64     `json:"dropped"`
+	LastAttempt   *time.Time `json:"lastAttempt,omitempty"`
+	LastDelivered *time.Time `json:"lastDelivered,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttempt   *time.Time `json:"nextAttempt,omitempty"`
+}
+
+type stats struct {
+	delivered, failed, dropped uint64
+	lastAttempt, lastDelivered time.Time
+	lastError                  string
+}
+
+// Dispatcher queues events for the configured targets and delivers them.
+type Dispatcher struct {
+	mu      sync.Mutex
+	path    string
+	opts    Options
+	targets []Target
+	outbox  []*delivery
+	stats   map[string]*stats
+
+	client *http.Client
+	wake   chan struct{}
+	clock  clock.Clock
+}
+
+// NewDispatcher returns a dispatcher on clock c whose outbox is persisted at
+// path. An existing outbox is loaded. An empty path keeps the outbox in
+// memory.
+func NewDispatcher(c clock.Clock, path string, opts Options) *Dispatcher {
+	d := &Dispatcher{
+		path:   path,
+		opts:   opts,
+		stats:  map[string]*stats{},
+		client: &http.Client{Timeout: opts.Timeout},
+		wake:   make(chan struct{}, 1),
+		clock:  c,
+	}
+	if path == "" {
+		return d
+	}
+	err := persist.ReadFile(path, func(b []byte) error {
+		d.outbox = nil
+		return json.Unmarshal(b, &d.outbox)
+	}, opts.Outbox)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logrus.WithError(err).Warn("failed to read webhook outbox")
+	}
+	return d
+}
+
+// SetTargets replaces the targets. Pending deliveries to removed targets are
+// dropped.
+func (d *Dispatcher) SetTargets(targets []Target) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.targets = slices.Clone(targets)
+	n := len(d.outbox)
+	d.outbox = slices.DeleteFunc(d.outbox, func(dl *delivery) bool {
+		_, ok := d.target(dl.URL)
+		return !ok
+	})
+	if len(d.outbox) != n {
+		d.persist()
+	}
+	d.notify()
+}
+
+// Enqueue queues ev for every target that wants it.
+func (d *Dispatcher) Enqueue(ev events.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	queued := false
+	for _, t := range d.targets {
+		if !t.wants(ev.Name) {
+			continue
+		}
+		d.outbox = append(d.outbox, &delivery{
+			URL: t.URL,
+			Payload: Payload{
+				ID:    newID(),
+				Event: ev.Name,
+				Data:  ev.Data,
+				Ts:    now.Unix(),
+			},
+			NextAttempt: now,
+		})
+		queued = true
+	}
+	if !queued {
+		return
+	}
+	if excess := len(d.outbox) - d.opts.MaxPending; excess > 0 {
+		for _, dl := range d.outbox[:excess] {
+			d.statsFor(dl.URL).dropped++
+		}
+		logrus.WithField("dropped", excess).Warn("webhook outbox is full, dropping the oldest deliveries")
+		d.outbox = slices.Delete(d.outbox, 0, excess)
+	}
+	d.persist()
+	d.notify()
+}
+
+// Run delivers queued events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		wait := d.DeliverDue(ctx)
+		timer := d.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C():
+		}
+		timer.Stop()
+	}
+}
+
+// idleWait is how long Run sleeps when nothing is pending.
+const idleWait = time.Hour
+
+// DeliverDue attempts every delivery whose backoff has elapsed and returns
+// how long to wait until the next one is due. Deliveries to the same target
+// are sent in order: a delivery waits while an earlier one to the same
+// target is backing off.
+func (d *Dispatcher) DeliverDue(ctx context.Context) time.Duration {
+	blocked := map[string]bool{}
+	for {
+		d.mu.Lock()
+		now := d.clock.Now()
+		var next *delivery
+		for _, dl := range d.outbox {
+			if blocked[dl.URL] {
+				continue
+			}
+			if dl.NextAttempt.After(now) {
+				blocked[dl.URL] = true
+				continue
+			}
+			next = dl
+			break
+		}
+		if next == nil {
+			// Only the first delivery to each target decides when it is
+			// tried next.
+			wait, seen := idleWait, map[string]bool{}
+			for _, dl := range d.outbox {
+				if !seen[dl.URL] {
+					seen[dl.URL] = true
+					wait = min(wait, max(dl.NextAttempt.Sub(now), 0))
+				}
+			}
+			d.mu.Unlock()
+			return wait
+		}
+		target, ok := d.target(next.URL)
+		if !ok {
+			d.remove(next)
+			d.persist()
+			d.mu.Unlock()
+			continue
+		}
+		d.mu.Unlock()
+
+		err := d.send(ctx, target, next.Payload)
+		if ctx.Err() != nil {
+			return 0
+		}
+
+		d.mu.Lock()
+		d.finish(next, err)
+		if err != nil {
+			blocked[next.URL] = true
+		}
+		d.mu.Unlock()
+	}
+}
+
+// finish records the outcome of an attempt. It must be called with d.mu
+// held.
+func (d *Dispatcher) finish(dl *delivery, err error) {
+	now := d.clock.Now()
+	st := d.statsFor(dl.URL)
+	st.lastAttempt = now
+	dl.Attempts++
+
+	log := logrus.WithFields(logrus.Fields{"url": dl.URL, "event": dl.Payload.Event, "attempt": dl.Attempts})
+	switch {
+	case err == nil:
+		st.delivered++
+		st.lastDelivered = now
+		st.lastError = ""
+		log.Debug("webhook delivered")
+		d.remove(dl)
+	case dl.Attempts >= d.opts.MaxAttempts:
+		st.failed++
+		st.lastError = err.Error()
+		log.WithError(err).Error("webhook delivery failed, giving up")
+		d.remove(dl)
+	default:
+		st.lastError = err.Error()
+		dl.NextAttempt = now.Add(d.backoff(dl.Attempts))
+		log.WithError(err).WithField("nextAttempt", dl.NextAttempt.Format(time.DateTime)).Warn("webhook delivery failed, will retry")
+	}
+	d.persist()
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.opts.InitialBackoff
+	for i := 1; i < attempts && b < d.opts.MaxBackoff; i++ {
+		b *= 2
+	}
+	return min(b, d.opts.MaxBackoff)
+}
+
+func (d *Dispatcher) send(ctx context.Context, t Target, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(d.clock.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "batt-webhook")
+	req.Header.Set(HeaderEvent, p.Event)
+	req.Header.Set(HeaderDelivery, p.ID)
+	req.Header.Set(HeaderTimestamp, ts)
+	if t.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(t.Secret, ts, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Status returns the delivery status of every target.
+func (d *Dispatcher) Status() []Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ret := make([]Status, 0, len(d.targets))
+	for _, t := range d.targets {
+		st := d.statsFor(t.URL)
+		s := Status{
+			URL:       t.URL,
+			Events:    t.Events,
+			Signed:    t.Secret != "",
+			Delivered: st.delivered,
+			Failed:    st.failed,
+			Dropped:   st.dropped,
+			LastError: st.lastError,
+		}
+		if !st.lastAttempt.IsZero() {
+			s.LastAttempt = &st.lastAttempt
+		}
+		if !st.lastDelivered.IsZero() {
+			s.LastDelivered = &st.lastDelivered
+		}
+		for _, dl := range d.outbox {
+			if dl.URL != t.URL {
+				continue
+			}
+			if s.Pending == 0 {
+				next := dl.NextAttempt
+				s.NextAttempt = &next
+			}
+			s.Pending++
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// Sign returns the signature header value for body sent at ts.
+func Sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is valid for body sent at ts.
+func Verify(secret, ts string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature))
+}
+
+func (d *Dispatcher) target(u string) (Target, bool) {
+	for _, t := range d.targets {
+		if t.URL == u {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
+
+func (d *Dispatcher) statsFor(u string) *stats {
+	st, ok := d.stats[u]
+	if !ok {
+		st = &stats{}
+		d.stats[u] = st
+	}
+	return st
+}
+
+func (d *Dispatcher) remove(dl *delivery) {
+	d.outbox = slices.DeleteFunc(d.outbox, func(x *delivery) bool { return x == dl })
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// persist writes the outbox. It must be called with d.mu held.
+func (d *Dispatcher) persist() {
+	if d.path == "" {
+		return
+	}
+	b, err := json.Marshal(d.outbox)
+	if err != nil {
+		logrus.WithError(err).Error("marshal webhook outbox")
+		return
+	}
+	if err := persist.WriteFile(d.path, b, 0644); err != nil {
+		logrus.WithError(err).Error("write webhook outbox")
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/batt/pkg/clock"
+	"github.com/charlie0129/batt/pkg/events"
+)
+
+type received struct {
+	header  http.Header
+	body    []byte
+	payload Payload
+}
+
+// newReceiver returns a server that answers with the given status codes in
+// order, and 200 once they are used up.
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, func() []received) {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		reqs []received
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var p Payload
+		_ = json.Unmarshal(body, &p)
+
+		mu.Lock()
+		defer mu.Unlock()
+		reqs = append(reqs, received{header: r.Header.Clone(), body: body, payload: p})
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), reqs...)
+	}
+}
+
+func newTestDispatcher(path string, opts Options) (*Dispatcher, *clock.Fake) {
+	clk := clock.NewFake(time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC))
+	return NewDispatcher(clk, path, opts), clk
+}
+
+func event(t *testing.T, name string, payload any) events.Event {
+	t.Helper()
+	b, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.Event{Name: name, Data: b}
+}
+
+func TestDeliverSignsPayload(t *testing.T) {
+	srv, requests := newReceiver(t)
+	d, _ := newTestDispatcher("", DefaultOptions)
+	d.SetTargets([]Target{{URL: srv.URL, Secret: "s3cret"}})
+
+	d.Enqueue(event(t, events.PowerPlugged, events.PowerEvent{PluggedIn: true, Charge: 42}))
+	d.DeliverDue(context.Background())
+
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+	r := reqs[0]
+	if r.payload.Event != events.PowerPlugged || r.header.Get(HeaderEvent) != events.PowerPlugged {
+		t.Fatalf("unexpected event: %s", r.body)
+	}
+	if r.payload.ID == "" || r.header.Get(HeaderDelivery) != r.payload.ID {
+		t.Fatalf("delivery ID %q does not match header %q", r.payload.ID, r.header.Get(HeaderDelivery))
+	}
+	data, err := events.DecodeAs[events.PowerEvent](events.Event{Data: r.payload.Data})
+	if err != nil || data.Charge != 42 {
+		t.Fatalf("unexpected data %s: %v", r.payload.Data, err)
+	}
+	if !Verify("s3cret", r.header.Get(HeaderTimestamp), r.body, r.header.Get(HeaderSignature)) {
+		t.Fatal("signature does not verify")
+	}
+	if Verify("other", r.header.Get(HeaderTimestamp), r.body, r.header.Get(HeaderSignature)) {
+		t.Fatal("signature verifies with the wrong secret")
+	}
+
+	st := d.Status()
+	if len(st) != 1 || st[0].Delivered != 1 || st[0].Pending != 0 || !st[0].Signed {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	srv, requests := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	opts := DefaultOptions
+	opts.InitialBackoff = time.Minute
+	d, clk := newTestDispatcher("", opts)
+	d.SetTargets([]Target{{URL: srv.URL}})
+
+	d.Enqueue(event(t, events.PowerUnplugged, events.PowerEvent{}))
+	d.Enqueue(event(t, events.PowerPlugged, events.PowerEvent{PluggedIn: true}))
+
+	if wait := d.DeliverDue(context.Background()); wait != time.Minute {
+		t.Fatalf("wait = %s after the first failure, want 1m", wait)
+	}
+	if n := len(requests()); n != 1 {
+		t.Fatalf("got %d requests, want the second event held back", n)
+	}
+
+	clk.Advance(time.Minute)
+	if wait := d.DeliverDue(context.Background()); wait != 2*time.Minute {
+		t.Fatalf("wait = %s after the second failure, want 2m", wait)
+	}
+	if st := d.Status()[0]; st.Pending != 2 || st.LastError == "" || st.NextAttempt == nil {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	clk.Advance(2 * time.Minute)
+	if wait := d.DeliverDue(context.Background()); wait != idleWait {
+		t.Fatalf("wait = %s with an empty outbox, want %s", wait, idleWait)
+	}
+	reqs := requests()
+	if len(reqs) != 4 || reqs[2].payload.Event != events.PowerUnplugged || reqs[3].payload.Event != events.PowerPlugged {
+		t.Fatalf("unexpected requests %+v", reqs)
+	}
+	if st := d.Status()[0]; st.Delivered != 2 || st.Pending != 0 || st.LastError != "" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	srv, _ := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	opts := DefaultOptions
+	opts.MaxAttempts = 2
+	d, clk := newTestDispatcher("", opts)
+	d.SetTargets([]Target{{URL: srv.URL}})
+
+	d.Enqueue(event(t, events.PowerPlugged, events.PowerEvent{}))
+	d.DeliverDue(context.Background())
+	clk.Advance(opts.InitialBackoff)
+	d.DeliverDue(context.Background())
+
+	if st := d.Status()[0]; st.Failed != 1 || st.Pending != 0 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	srv, requests := newReceiver(t, http.StatusServiceUnavailable)
+	targets := []Target{{URL: srv.URL}}
+
+	d, _ := newTestDispatcher(path, DefaultOptions)
+	d.SetTargets(targets)
+	d.Enqueue(event(t, events.ChargeLimitReached, events.ChargeLimitReachedEvent{Charge: 80, Limit: 80}))
+	d.DeliverDue(context.Background())
+
+	restarted, clk := newTestDispatcher(path, DefaultOptions)
+	restarted.SetTargets(targets)
+	if st := restarted.Status()[0]; st.Pending != 1 {
+		t.Fatalf("pending = %d after restart, want 1", st.Pending)
+	}
+	clk.Advance(time.Hour)
+	restarted.DeliverDue(context.Background())
+
+	reqs := requests()
+	if len(reqs) != 2 || reqs[0].payload.ID != reqs[1].payload.ID {
+		t.Fatalf("want the same delivery retried after restart, got %+v", reqs)
+	}
+	if st := restarted.Status()[0]; st.Pending != 0 || st.Delivered != 1 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestOutboxIsBounded(t *testing.T) {
+	opts := DefaultOptions
+	opts.MaxPending = 2
+	d, _ := newTestDispatcher("", opts)
+	d.SetTargets([]Target{{URL: "http://127.0.0.1:0/hook", Events: []string{events.PowerPlugged}}})
+
+	for i := range 3 {
+		d.Enqueue(event(t, events.PowerPlugged, events.PowerEvent{Charge: i}))
+	}
+	// Filtered out by Events.
+	d.Enqueue(event(t, events.PowerUnplugged, events.PowerEvent{}))
+
+	st := d.Status()[0]
+	if st.Pending != 2 || st.Dropped != 1 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	if got := d.outbox[0].Payload.Data; string(got) != `{"pluggedIn":false,"charge":1,"ts":0}` {
+		t.Fatalf("oldest delivery was not dropped, first is %s", got)
+	}
+}
+
+func TestTargetValidate(t *testing.T) {
+	for url, valid := range map[string]bool{
+		"https://example.com/hook": true,
+		"http://127.0.0.1:8080":    true,
+		"ftp://example.com":        false,
+		"example.com/hook":         false,
+		"":                         false,
+	} {
+		if err := (Target{URL: url}).Validate(); (err == nil) != valid {
+			t.Errorf("Validate(%q) = %v, want valid=%t", url, err, valid)
+		}
+	}
+}